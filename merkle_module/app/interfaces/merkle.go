@@ -10,8 +10,18 @@ type Merkle interface {
 	// This function is used to get proof for the tree in database
 	GetProof(ctx context.Context, treeID, nodeID int) ([][]byte, error)
 	GetRoot(ctx context.Context, treeID int) ([]byte, error)
-	// This function is used to get the proof that has been synced
-	GetSyncedProof(ctx context.Context, treeID, nodeID int) ([][]byte, error)
-	// This function is used to get the root that has been synced
-	GetSyncedRoot(ctx context.Context, treeID int) ([]byte, error)
+	// This function is used to get the root of the tree once it held size leaves
+	GetRootAt(ctx context.Context, treeID, size int) ([]byte, error)
+	// This function is used to get the proof against the root of the tree once it held size leaves
+	GetProofAt(ctx context.Context, treeID, nodeID, size int) ([][]byte, error)
+	// This function is used to get the proof that has been synced to the chain
+	GetSyncedProof(ctx context.Context, treeID, nodeID int, chainID uint64) ([][]byte, error)
+	// This function is used to get the root that has been synced to the chain
+	GetSyncedRoot(ctx context.Context, treeID int, chainID uint64) ([]byte, error)
+	// This function is used to get the proof from the synced root to the super-root of the checkpoint
+	// it was synced in, nil when the root was anchored itself
+	GetCheckpointProof(ctx context.Context, treeID int, chainID uint64) (*entities.CheckpointProof, error)
+	// This function is used to get the super-root of a checkpoint confirmed on the chain, nil when
+	// the checkpoint is not on that chain
+	GetCheckpointRoot(ctx context.Context, checkpointID int, chainID uint64) ([]byte, error)
 }
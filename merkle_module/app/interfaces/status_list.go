@@ -0,0 +1,16 @@
+package interfaces
+
+import (
+	"context"
+	"merkle_module/domain/entities"
+)
+
+type StatusList interface {
+	// This function is used to reserve a status index for a credential at issuance
+	AllocateStatus(ctx context.Context, issuerDID string) (*entities.StatusEntry, error)
+	// This function is used to revoke or reinstate a credential
+	SetRevoked(ctx context.Context, statusListID, index int, revoked bool) error
+	IsRevoked(ctx context.Context, statusListID, index int) (bool, error)
+	// This function is used to get the encodedList value of the status list credential
+	GetEncodedList(ctx context.Context, statusListID int) (string, error)
+}
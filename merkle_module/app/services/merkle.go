@@ -1,61 +1,220 @@
 package services
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"math"
 	"merkle_module/app/interfaces"
+	"merkle_module/cache"
+	"merkle_module/did"
 	"merkle_module/domain/entities"
 	"merkle_module/domain/repo"
+	"merkle_module/infra/model"
 	"merkle_module/merkletree"
 	"merkle_module/utils"
 	"sync"
-
-	"github.com/ethereum/go-ethereum/common/lru"
 )
 
 type MerkleService struct {
-	repo               repo.Merkle
-	cacheTrees         *lru.Cache[int, *merkletree.MerkleTree] // cache the Merkle trees
-	cacheActiveTreeIDs *lru.Cache[string, int]                 // cache the active Merkle tree IDs
+	repo    repo.Merkle
+	trees   cache.TreeCache // cache the Merkle trees and the active Merkle tree IDs
+	issuers did.Resolver    // resolves the issuer DIDs, nil accepts any issuer
+	mutexes sync.Map        // map to hold mutexes for each issuer DID
 }
 
-var muxtexes sync.Map // map to hold mutexes for each issuer DID
+// lastSize reads the nodes saved for the last size of a tree
+const lastSize = math.MaxInt32
 
-func getMutex(issuerDID string) *sync.Mutex {
-	actual, _ := muxtexes.LoadOrStore(issuerDID, &sync.Mutex{})
+// helper function to get the mutex of an issuer DID, it only orders the leaves added by this
+// service and not those of other replicas sharing the cache
+func (s *MerkleService) getMutex(issuerDID string) *sync.Mutex {
+	actual, _ := s.mutexes.LoadOrStore(issuerDID, &sync.Mutex{})
 	return actual.(*sync.Mutex)
 }
 
-func NewMerkleService(repo repo.Merkle, cacheTrees *lru.Cache[int, *merkletree.MerkleTree], cacheActiveTreeIDs *lru.Cache[string, int]) interfaces.Merkle {
-	return &MerkleService{repo: repo, cacheTrees: cacheTrees, cacheActiveTreeIDs: cacheActiveTreeIDs}
+func NewMerkleService(repo repo.Merkle, trees cache.TreeCache, issuers did.Resolver) interfaces.Merkle {
+	return &MerkleService{repo: repo, trees: trees, issuers: issuers}
 }
 
-// helper function to build a new Merkle tree from the database
-func (s *MerkleService) buildTree(ctx context.Context, treeID int) (*merkletree.MerkleTree, error) {
+// helper function to build a new Merkle tree from the database. A reserved node not filled yet
+// ends the tree, the nodes past it are left out until it is filled, and false is returned.
+func (s *MerkleService) buildTree(ctx context.Context, treeID int) (*merkletree.MerkleTree, bool, error) {
 	// Get nodes of tree id from database
 	nodes, err := s.repo.GetNodesByTreeID(ctx, treeID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get nodes by tree ID: %w", err)
+		return nil, false, fmt.Errorf("failed to get nodes by tree ID: %w", err)
 	}
+	leaves, complete := utils.LeadingLeaves(nodes)
 
 	// Create a new Merkle tree
-	tree, err := merkletree.NewMerkleTree(nodes, treeID)
+	tree, err := merkletree.NewMerkleTree(leaves, treeID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create new merkle tree: %w", err)
+		return nil, false, fmt.Errorf("failed to create new merkle tree: %w", err)
 	}
 
 	// After creating the tree, it should be initialized
 	if tree == nil {
-		return nil, fmt.Errorf("failed to create new merkle tree: tree is nil")
+		return nil, false, fmt.Errorf("failed to create new merkle tree: tree is nil")
+	}
+
+	// Save its nodes so that the next proofs are read from the database, the roots of a tree
+	// with a gap are not those it will have once the gap is filled
+	if complete {
+		s.saveNodes(ctx, tree, 1)
+	}
+
+	return tree, complete, nil
+}
+
+// helper function to save the nodes of a tree holding a leaf from position from on, at the
+// current size of the tree, and to record its root in the history of the tree. A failure is
+// only logged, the proofs are then built from the leaves.
+func (s *MerkleService) saveNodes(ctx context.Context, tree *merkletree.MerkleTree, from int) {
+	hashes := tree.GetNodeHashes(from)
+	if err := s.repo.SaveNodeHashes(ctx, hashes); err != nil {
+		fmt.Printf("Failed to save the nodes of tree ID %d: %v\n", tree.GetTreeID(), err)
+	}
+	for _, hash := range hashes {
+		if hash.Index != 1 {
+			continue
+		}
+		root := &entities.TreeRoot{TreeID: hash.TreeID, Size: hash.Size, Root: hash.Hash}
+		if err := s.repo.AddTreeRoots(ctx, []*entities.TreeRoot{root}); err != nil {
+			fmt.Printf("Failed to record the root of tree ID %d: %v\n", tree.GetTreeID(), err)
+		}
+	}
+}
+
+// helper function to build a tree from its first size leaves, as it was once it held size leaves.
+// The nodes of sealed trees are not saved again, nor those of trees with an unfilled node.
+func (s *MerkleService) buildTreeAt(ctx context.Context, treeID, size int) (*merkletree.MerkleTree, error) {
+	seal, err := s.repo.GetTreeSeal(ctx, treeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tree seal: %w", err)
+	}
+	leaves, complete := [][]byte(nil), true
+	if seal != nil {
+		leaves = seal.Leaves
+	} else {
+		nodes, err := s.repo.GetNodesByTreeID(ctx, treeID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get nodes by tree ID: %w", err)
+		}
+		leaves, complete = utils.LeadingLeaves(nodes)
+	}
+	if len(leaves) < size {
+		return nil, fmt.Errorf("tree ID %d holds %d leaves before its first unfilled node, fewer than %d", treeID, len(leaves), size)
+	}
+
+	tree, err := merkletree.NewMerkleTree(leaves[:size], treeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create new merkle tree: %w", err)
+	}
+	if seal == nil && complete {
+		s.saveNodes(ctx, tree, 1)
+	}
+
+	return tree, nil
+}
+
+// helper function to build a sealed tree from its archived leaves, nil when the tree is not
+// sealed. Sealed trees never change and are rebuilt from a single row, they are not cached.
+func (s *MerkleService) getSealedTree(ctx context.Context, treeID int) (*merkletree.MerkleTree, error) {
+	seal, err := s.repo.GetTreeSeal(ctx, treeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tree seal: %w", err)
+	}
+	if seal == nil {
+		return nil, nil
+	}
+
+	tree, err := merkletree.NewMerkleTree(seal.Leaves, treeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create new merkle tree: %w", err)
+	}
+	if !bytes.Equal(tree.GetMerkleRoot(), seal.Root) {
+		return nil, fmt.Errorf("archived leaves of tree ID %d do not lead to its sealed root", treeID)
 	}
 
 	return tree, nil
 }
 
+// helper function to tell whether nodes saved at size are those of the last size of the tree.
+// They are not once nodes were added without saving theirs: while a reserved node is unfilled,
+// by the reaper, by an import, or when saving them failed.
+func (s *MerkleService) savedAtLastSize(ctx context.Context, treeID, size int) (bool, error) {
+	count, last, err := s.repo.CountNodes(ctx, treeID)
+	if err != nil {
+		return false, fmt.Errorf("failed to count nodes: %w", err)
+	}
+	return count == size && last == size, nil
+}
+
+// helper function to read the proof of a leaf from the nodes saved once the tree held size
+// leaves, O(depth) rows. The proof must lead to root, or to the saved root when root is nil.
+// It returns nil when the saved nodes do not make a valid proof, for trees whose nodes were
+// not saved for that size, or for lastSize not saved at the last size of the tree.
+func (s *MerkleService) readProof(ctx context.Context, treeID, nodeID, size int, root []byte) ([][]byte, error) {
+	if nodeID <= 0 || nodeID > utils.MAX_LEAFS {
+		return nil, nil
+	}
+
+	proofIndexes := merkletree.ProofIndexes(nodeID)
+	leafIndex := merkletree.LeafIndex(nodeID)
+	hashes, err := s.repo.GetNodeHashes(ctx, treeID, size, append([]int{1, leafIndex}, proofIndexes...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node hashes: %w", err)
+	}
+	saved := make(map[int]*entities.NodeHash, len(hashes))
+	for _, hash := range hashes {
+		saved[hash.Index] = hash
+	}
+
+	leaf, savedRoot := saved[leafIndex], saved[1]
+	if leaf == nil || savedRoot == nil || savedRoot.Size < nodeID {
+		return nil, nil
+	}
+	if size == lastSize {
+		current, err := s.savedAtLastSize(ctx, treeID, savedRoot.Size)
+		if err != nil || !current {
+			return nil, err
+		}
+	}
+	if root == nil {
+		root = savedRoot.Hash
+	}
+
+	// Nodes without a saved hash have no leaf in their subtree yet
+	proof := make([][]byte, len(proofIndexes))
+	for i, index := range proofIndexes {
+		if hash := saved[index]; hash != nil {
+			proof[i] = hash.Hash
+		} else {
+			proof[i] = merkletree.EmptyNode(index)
+		}
+	}
+	if !bytes.Equal(merkletree.RootFromProof(leaf.Hash, proof), root) {
+		return nil, nil
+	}
+
+	return proof, nil
+}
+
+// helper function to check whether the nodes of a tree were saved once it held the leaves
+// before nodeID, by the replica which added the previous leaf when the cache is shared
+func (s *MerkleService) savedBefore(ctx context.Context, treeID, nodeID int) bool {
+	if !s.trees.Shared() || nodeID == 1 {
+		return false
+	}
+	hashes, err := s.repo.GetNodeHashes(ctx, treeID, lastSize, []int{1})
+	return err == nil && len(hashes) == 1 && hashes[0].Size == nodeID-1
+}
+
 // helper function to get tree from cache or build it from database
 func (s *MerkleService) getTree(ctx context.Context, treeID int) (*merkletree.MerkleTree, error) {
 	// Get the tree from the cache
-	tree, exists := s.cacheTrees.Get(treeID)
+	tree, exists := s.trees.GetTree(ctx, treeID)
 
 	// If the tree exists in the cache, return it
 	if exists && tree != nil {
@@ -64,7 +223,7 @@ func (s *MerkleService) getTree(ctx context.Context, treeID int) (*merkletree.Me
 
 	// If the tree is not found in the cache, create a new one
 	// Build the tree from the database
-	tree, err := s.buildTree(ctx, treeID)
+	tree, complete, err := s.buildTree(ctx, treeID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to build tree: %w", err)
 	}
@@ -73,8 +232,10 @@ func (s *MerkleService) getTree(ctx context.Context, treeID int) (*merkletree.Me
 		return nil, fmt.Errorf("failed to build tree: tree is nil")
 	}
 
-	// set the tree in the cache
-	_ = s.cacheTrees.Add(treeID, tree)
+	// set the tree in the cache, unless it stops at an unfilled node and misses the next ones
+	if complete {
+		s.trees.AddTree(ctx, tree)
+	}
 
 	return tree, nil
 }
@@ -83,7 +244,7 @@ func (s *MerkleService) getTree(ctx context.Context, treeID int) (*merkletree.Me
 // for tree full, it remove the current active tree from the cache and return false
 func (s *MerkleService) getActiveTree(ctx context.Context, issuerDID string) (*merkletree.MerkleTree, bool) {
 	// Check if the active tree ID of the issuer DID is cached
-	activeTreeID, exists := s.cacheActiveTreeIDs.Get(issuerDID)
+	activeTreeID, exists := s.trees.GetActiveTreeID(ctx, issuerDID)
 	if !exists {
 		return nil, false
 	}
@@ -98,82 +259,149 @@ func (s *MerkleService) getActiveTree(ctx context.Context, issuerDID string) (*m
 	// If the tree is full, remove it from the cache and return false
 	if tree.IsFull() {
 		fmt.Printf("Active tree %d for issuer DID %s is full, removing from cache...\n", tree.GetTreeID(), issuerDID)
-		s.cacheActiveTreeIDs.Remove(issuerDID)
+		s.trees.RemoveActiveTreeID(ctx, issuerDID)
 		return nil, false
 	}
 
 	return tree, true
 }
 
-// helper function to get the active tree for inserting, return tree and boolean indicating if we need to load it from the database
-func (s *MerkleService) getActiveTreeForInserting(ctx context.Context, issuerDID string) (*merkletree.MerkleTree, bool, error) {
-	// Check if the active tree ID of the issuer DID is cached
-	tree, exists := s.getActiveTree(ctx, issuerDID)
+// helper function to get the active tree for inserting, return tree and the tree reserved from the database when it
+// had to be loaded. The tree is nil when nodes reserved before the reserved one are not filled yet.
+func (s *MerkleService) getActiveTreeForInserting(ctx context.Context, issuerDID string) (*merkletree.MerkleTree, *model.ActiveTree, error) {
+	// Check if the active tree ID of the issuer DID is cached. The replicas sharing the cache
+	// would all take the next node ID from the same cached tree, they reserve it in the database.
+	var tree *merkletree.MerkleTree
+	exists := false
+	if !s.trees.Shared() {
+		tree, exists = s.getActiveTree(ctx, issuerDID)
+	}
 
 	if !exists {
-		fmt.Printf("Active tree for issuer DID %s not found in cache, loading from database...\n", issuerDID)
+		if !s.trees.Shared() {
+			fmt.Printf("Active tree for issuer DID %s not found in cache, loading from database...\n", issuerDID)
+		}
 		// If not cached, get the active tree for inserting
 		activeTree, err := s.repo.GetActiveTreeForInserting(ctx, issuerDID)
 		if err != nil {
-			return nil, true, fmt.Errorf("failed to get active tree for inserting: %w", err)
+			return nil, nil, fmt.Errorf("failed to get active tree for inserting: %w", err)
+		}
+
+		// The leaf goes to the reserved node, which is only the next leaf of the tree once the
+		// nodes reserved before it are filled
+		if len(activeTree.Nodes) != activeTree.NodeCount-1 {
+			fmt.Printf("Tree ID %d holds %d nodes below reserved node %d, not caching it\n", activeTree.TreeID, len(activeTree.Nodes), activeTree.NodeCount)
+			s.trees.RemoveTree(ctx, activeTree.TreeID)
+			return nil, activeTree, nil
 		}
 
 		// Create a new Merkle tree
 		tree, err = merkletree.NewMerkleTree(activeTree.Nodes, activeTree.TreeID)
 		if err != nil {
-			return nil, true, fmt.Errorf("failed to create new merkle tree: %w", err)
+			return nil, nil, fmt.Errorf("failed to create new merkle tree: %w", err)
 		}
 
-		s.cacheTrees.Add(tree.GetTreeID(), tree)
-		s.cacheActiveTreeIDs.Add(issuerDID, tree.GetTreeID())
-
-		return tree, true, nil
+		return tree, activeTree, nil
 	}
 
-	return tree, false, nil
+	return tree, nil, nil
 }
 
 func (s *MerkleService) AddLeaf(ctx context.Context, issuerDID string, data []byte) (*entities.MerkleNode, error) {
+	// Reject unknown and deactivated issuers before reserving a leaf
+	if s.issuers != nil {
+		if _, err := did.ResolveActive(ctx, s.issuers, issuerDID); err != nil {
+			return nil, fmt.Errorf("invalid issuer %s: %w", issuerDID, err)
+		}
+	}
+
 	// make a copy of the data
 	dataCopy := make([]byte, len(data))
 	copy(dataCopy, data)
-	mutex := getMutex(issuerDID)
+	mutex := s.getMutex(issuerDID)
 	mutex.Lock()
+	defer mutex.Unlock()
 
-	tree, needToLoad, err := s.getActiveTreeForInserting(ctx, issuerDID)
+	tree, reserved, err := s.getActiveTreeForInserting(ctx, issuerDID)
 	if err != nil {
-		mutex.Unlock()
 		return nil, fmt.Errorf("failed to get active tree for inserting: %w", err)
 	}
 
+	// Fill the reservation without the tree, it is rebuilt from its nodes once the nodes
+	// reserved before are filled, by their leaf or by the reapReservations job
+	if tree == nil {
+		node, err := s.repo.AddNode(ctx, reserved.TreeID, reserved.NodeCount, dataCopy)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add node: %w", err)
+		}
+		// The node may be the last one unfilled, the tree then saves its nodes and root
+		if _, err := s.getTree(ctx, reserved.TreeID); err != nil {
+			fmt.Printf("Failed to build tree ID %d: %v\n", reserved.TreeID, err)
+		}
+		return node, nil
+	}
+
 	// Add the leaf to the tree
 	nodeID := tree.AddLeaf(dataCopy)
 	if nodeID < 0 {
-		mutex.Unlock()
 		return nil, fmt.Errorf("failed to add leaf to tree: node ID is negative")
 	}
 
 	// Add the node to the database
 	var node *entities.MerkleNode
+	needToLoad := reserved != nil
 	if needToLoad {
 		node, err = s.repo.AddNode(ctx, tree.GetTreeID(), nodeID, dataCopy)
 	} else {
 		node, err = s.repo.AddNodeAndIncrementNodeCount(ctx, tree.GetTreeID(), nodeID, dataCopy)
 	}
 	if err != nil {
+		// The cached tree may hold the leaf, it is loaded again by the next leaf
+		s.trees.RemoveTree(ctx, tree.GetTreeID())
+		s.trees.RemoveActiveTreeID(ctx, issuerDID)
 		return nil, fmt.Errorf("failed to add node: %w", err)
 	}
 
-	mutex.Unlock()
+	// Put the tree back with its new leaf, a cache storing copies of the trees misses it otherwise
+	s.trees.AddTree(ctx, tree)
+	if needToLoad {
+		s.trees.SetActiveTreeID(ctx, issuerDID, tree.GetTreeID())
+	}
+
+	// Save the path of the leaf, or the whole tree when it was just loaded in case the
+	// nodes of its previous leaves were not saved
+	from := nodeID
+	if needToLoad && !s.savedBefore(ctx, tree.GetTreeID(), nodeID) {
+		from = 1
+	}
+	s.saveNodes(ctx, tree, from)
 
 	return node, nil
 }
 
 func (s *MerkleService) GetProof(ctx context.Context, treeID, nodeID int) ([][]byte, error) {
-	// Get the tree by tree ID
-	tree, err := s.getTree(ctx, treeID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get tree: %w", err)
+	// A cached tree is the fastest, then the nodes saved for the last size of the tree
+	tree, exists := s.trees.GetTree(ctx, treeID)
+	if !exists || tree == nil {
+		proof, err := s.readProof(ctx, treeID, nodeID, lastSize, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read proof: %w", err)
+		}
+		if proof != nil {
+			return proof, nil
+		}
+
+		// Sealed trees are served from their archive, the others by tree ID
+		tree, err = s.getSealedTree(ctx, treeID)
+		if err != nil {
+			return nil, err
+		}
+		if tree == nil {
+			tree, err = s.getTree(ctx, treeID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get tree: %w", err)
+			}
+		}
 	}
 
 	proof, err := tree.GetProof(nodeID)
@@ -185,18 +413,132 @@ func (s *MerkleService) GetProof(ctx context.Context, treeID, nodeID int) ([][]b
 }
 
 func (s *MerkleService) GetRoot(ctx context.Context, treeID int) ([]byte, error) {
-	// Get the tree by tree ID
-	tree, err := s.getTree(ctx, treeID)
+	// A cached tree is the fastest, then the root saved for the last size of the tree
+	tree, exists := s.trees.GetTree(ctx, treeID)
+	if !exists || tree == nil {
+		hashes, err := s.repo.GetNodeHashes(ctx, treeID, lastSize, []int{1})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get root hash: %w", err)
+		}
+		if len(hashes) == 1 {
+			current, err := s.savedAtLastSize(ctx, treeID, hashes[0].Size)
+			if err != nil {
+				return nil, err
+			}
+			if current {
+				return hashes[0].Hash, nil
+			}
+		}
+		seal, err := s.repo.GetTreeSeal(ctx, treeID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get tree seal: %w", err)
+		}
+		if seal != nil {
+			return seal.Root, nil
+		}
+
+		// Get the tree by tree ID
+		tree, err = s.getTree(ctx, treeID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get tree: %w", err)
+		}
+	}
+
+	return tree.GetMerkleRoot(), nil
+}
+
+func (s *MerkleService) GetRootAt(ctx context.Context, treeID, size int) ([]byte, error) {
+	if size <= 0 || size > utils.MAX_LEAFS {
+		return nil, fmt.Errorf("invalid size: %d, must be between 1 and %d", size, utils.MAX_LEAFS)
+	}
+
+	// Roots are recorded as the tree grows, those of older trees are rebuilt once
+	root, err := s.repo.GetTreeRoot(ctx, treeID, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tree root: %w", err)
+	}
+	if root != nil {
+		return root.Root, nil
+	}
+
+	tree, err := s.buildTreeAt(ctx, treeID, size)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get tree: %w", err)
+		return nil, fmt.Errorf("failed to build tree: %w", err)
 	}
 
 	return tree.GetMerkleRoot(), nil
 }
 
-func (s *MerkleService) GetSyncedProof(ctx context.Context, treeID, nodeID int) ([][]byte, error) {
+func (s *MerkleService) GetProofAt(ctx context.Context, treeID, nodeID, size int) ([][]byte, error) {
+	if nodeID <= 0 || nodeID > size {
+		return nil, fmt.Errorf("failed to get proof: invalid position: %d, must be between 1 and %d", nodeID, size)
+	}
+
+	root, err := s.GetRootAt(ctx, treeID, size)
+	if err != nil {
+		return nil, err
+	}
+	proof, err := s.readProof(ctx, treeID, nodeID, size, root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read proof: %w", err)
+	}
+	if proof != nil {
+		return proof, nil
+	}
+
+	// The nodes of that size were not saved
+	tree, err := s.buildTreeAt(ctx, treeID, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build tree: %w", err)
+	}
+	if !bytes.Equal(tree.GetMerkleRoot(), root) {
+		return nil, fmt.Errorf("leaves of tree ID %d do not lead to its root at size %d", treeID, size)
+	}
+
+	proof, err = tree.GetProof(nodeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get proof: %w", err)
+	}
+
+	return proof, nil
+}
+
+func (s *MerkleService) GetSyncedProof(ctx context.Context, treeID, nodeID int, chainID uint64) ([][]byte, error) {
+	// The proof leads to the last confirmed root, read from the nodes saved at its node count
+	lastSync, err := s.repo.GetLastConfirmedSync(ctx, treeID, chainID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last confirmed sync: %w", err)
+	}
+	if lastSync != nil {
+		if nodeID <= 0 || nodeID > lastSync.NodeCount {
+			return nil, fmt.Errorf("failed to get proof: invalid position: %d, must be between 1 and %d", nodeID, lastSync.NodeCount)
+		}
+		proof, err := s.readProof(ctx, treeID, nodeID, lastSync.NodeCount, lastSync.Root)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read proof: %w", err)
+		}
+		if proof != nil {
+			return proof, nil
+		}
+
+		// The nodes of sealed trees are archived once synced at their last node
+		if lastSync.NodeCount == utils.MAX_LEAFS {
+			tree, err := s.getSealedTree(ctx, treeID)
+			if err != nil {
+				return nil, err
+			}
+			if tree != nil && bytes.Equal(tree.GetMerkleRoot(), lastSync.Root) {
+				proof, err := tree.GetProof(nodeID)
+				if err != nil {
+					return nil, fmt.Errorf("failed to get proof: %w", err)
+				}
+				return proof, nil
+			}
+		}
+	}
+
 	// Load the tree from the database
-	nodes, err := s.repo.GetNodesSyncedByTreeID(ctx, treeID)
+	nodes, err := s.repo.GetNodesSyncedByTreeID(ctx, treeID, chainID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get nodes by tree ID: %w", err)
 	}
@@ -206,6 +548,7 @@ func (s *MerkleService) GetSyncedProof(ctx context.Context, treeID, nodeID int)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create new merkle tree: %w", err)
 	}
+	s.saveNodes(ctx, tree, 1)
 
 	// Get the proof for the node ID
 	proof, err := tree.GetProof(nodeID)
@@ -216,9 +559,19 @@ func (s *MerkleService) GetSyncedProof(ctx context.Context, treeID, nodeID int)
 	return proof, nil
 }
 
-func (s *MerkleService) GetSyncedRoot(ctx context.Context, treeID int) ([]byte, error) {
+func (s *MerkleService) GetSyncedRoot(ctx context.Context, treeID int, chainID uint64) ([]byte, error) {
+	// The last confirmed root is the one on chain
+	lastSync, err := s.repo.GetLastConfirmedSync(ctx, treeID, chainID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last confirmed sync: %w", err)
+	}
+	if lastSync != nil {
+		return lastSync.Root, nil
+	}
+
+	// Trees synced before sync records existed only have node_count_sync
 	// Load the tree from the database
-	nodes, err := s.repo.GetNodesSyncedByTreeID(ctx, treeID)
+	nodes, err := s.repo.GetNodesSyncedByTreeID(ctx, treeID, chainID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get nodes by tree ID: %w", err)
 	}
@@ -237,3 +590,65 @@ func (s *MerkleService) GetSyncedRoot(ctx context.Context, treeID int) ([]byte,
 
 	return root, nil
 }
+
+func (s *MerkleService) GetCheckpointRoot(ctx context.Context, checkpointID int, chainID uint64) ([]byte, error) {
+	checkpoint, err := s.repo.GetCheckpoint(ctx, checkpointID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get checkpoint: %w", err)
+	}
+	if checkpoint == nil || checkpoint.ChainID != chainID {
+		return nil, nil
+	}
+
+	// The syncs of a checkpoint are confirmed together by the transaction anchoring it
+	for _, treeSync := range checkpoint.Syncs {
+		if treeSync.Status == entities.SyncStatusConfirmed {
+			return checkpoint.Root, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *MerkleService) GetCheckpointProof(ctx context.Context, treeID int, chainID uint64) (*entities.CheckpointProof, error) {
+	// The proof goes to the checkpoint of the last confirmed sync, the one of the synced root
+	lastSync, err := s.repo.GetLastConfirmedSync(ctx, treeID, chainID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last confirmed sync: %w", err)
+	}
+	if lastSync == nil || lastSync.CheckpointID == 0 {
+		return nil, nil
+	}
+
+	checkpoint, err := s.repo.GetCheckpoint(ctx, lastSync.CheckpointID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get checkpoint: %w", err)
+	}
+	if checkpoint == nil {
+		return nil, fmt.Errorf("checkpoint %d of tree ID %d not found", lastSync.CheckpointID, treeID)
+	}
+
+	// Rebuild the checkpoint from its tree roots
+	roots, err := merkletree.CheckpointRoots(checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := merkletree.NewCheckpoint(roots)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create checkpoint: %w", err)
+	}
+	if !bytes.Equal(tree.Root(), checkpoint.Root) {
+		return nil, fmt.Errorf("checkpoint %d does not lead to its anchored root", checkpoint.ID)
+	}
+
+	path, err := tree.GetProof(lastSync.CheckpointPosition)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get checkpoint proof: %w", err)
+	}
+
+	return &entities.CheckpointProof{
+		CheckpointID:  checkpoint.ID,
+		IssuerAddress: checkpoint.IssuerAddress,
+		Root:          checkpoint.Root,
+		Path:          path,
+	}, nil
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"merkle_module/cache"
+	"merkle_module/did"
+	"merkle_module/infra/storage"
+	"merkle_module/merkletree"
+	"merkle_module/utils"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// owners is an ERC-1056 registry, the identities it does not hold own themselves
+type owners map[common.Address]common.Address
+
+func (o owners) IdentityOwner(ctx context.Context, identity common.Address) (common.Address, error) {
+	if owner, exists := o[identity]; exists {
+		return owner, nil
+	}
+	return identity, nil
+}
+
+func TestAddLeafChecksIssuers(t *testing.T) {
+	ctx := context.Background()
+	active := "did:ethr:0x00000000000000000000000000000000000000aa"
+	deactivated := common.HexToAddress("0x00000000000000000000000000000000000000bb")
+	registry := owners{deactivated: {}}
+	issuers := did.NewResolver(did.NewEthrDriver(map[string]did.OwnerReader{did.DefaultEthrNetwork: registry}))
+	merkleRepo := storage.NewMerkleMemory()
+	service := NewMerkleService(merkleRepo, cache.NewLRU(10), issuers)
+	leaf := utils.Hash([]byte("credential"))
+
+	if node, err := service.AddLeaf(ctx, active, leaf); err != nil || node.NodeID != 1 {
+		t.Fatalf("Expected the leaf of an active issuer added to node 1, got %+v and error %v", node, err)
+	}
+
+	cases := []struct {
+		issuerDID string
+		expected  error
+	}{
+		{"did:ethr:" + deactivated.Hex(), did.ErrDeactivated},
+		{"did:example:issuer", did.ErrUnsupportedMethod},
+		{"did:ethr:0x1234", did.ErrInvalidDID},
+		{"issuer", did.ErrInvalidDID},
+	}
+	for _, c := range cases {
+		if _, err := service.AddLeaf(ctx, c.issuerDID, leaf); !errors.Is(err, c.expected) {
+			t.Errorf("Expected the leaf of %s rejected with %v, got %v", c.issuerDID, c.expected, err)
+		}
+	}
+
+	// rejected issuers never reserve a node
+	issuerTrees, err := merkleRepo.GetIssuers(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get issuers: %v", err)
+	}
+	if len(issuerTrees) != 1 || issuerTrees[0].IssuerDID != active {
+		t.Errorf("Expected only %s to own a tree, got %+v", active, issuerTrees)
+	}
+
+	// an issuer deactivated later is rejected from then on
+	registry[common.HexToAddress(active[len("did:ethr:"):])] = common.Address{}
+	if _, err := service.AddLeaf(ctx, active, utils.Hash([]byte("after deactivation"))); !errors.Is(err, did.ErrDeactivated) {
+		t.Errorf("Expected the leaf of a deactivated issuer rejected, got %v", err)
+	}
+}
+
+// rootOf returns the root of a tree of the leaves
+func rootOf(t *testing.T, leaves ...[]byte) []byte {
+	t.Helper()
+	tree, err := merkletree.NewMerkleTree(leaves, 0)
+	if err != nil {
+		t.Fatalf("Failed to build tree: %v", err)
+	}
+	return tree.GetMerkleRoot()
+}
+
+func TestTreeWithUnfilledNode(t *testing.T) {
+	ctx := context.Background()
+	issuerDID := "did:example:issuer"
+	merkleRepo := storage.NewMerkleMemory()
+	service := NewMerkleService(merkleRepo, cache.NewLRU(10), nil)
+	leaves := [][]byte{utils.Hash([]byte("credential 1")), utils.Hash([]byte("credential 2")), utils.Hash([]byte("credential 3"))}
+
+	var treeID int
+	for _, leaf := range leaves[:2] {
+		node, err := service.AddLeaf(ctx, issuerDID, leaf)
+		if err != nil {
+			t.Fatalf("Failed to add leaf: %v", err)
+		}
+		treeID = node.TreeID
+	}
+	// a process reserves node 3 and dies before adding its leaf, the next process adds node 4
+	if _, err := merkleRepo.GetActiveTreeForInserting(ctx, issuerDID); err != nil {
+		t.Fatalf("Failed to reserve a node: %v", err)
+	}
+	restarted := NewMerkleService(merkleRepo, cache.NewLRU(10), nil)
+	if node, err := restarted.AddLeaf(ctx, issuerDID, leaves[2]); err != nil || node.NodeID != 4 {
+		t.Fatalf("Expected the leaf added to node 4, got %+v and error %v", node, err)
+	}
+
+	// the tree stops at the unfilled node
+	fresh := NewMerkleService(merkleRepo, cache.NewLRU(10), nil)
+	if root, err := fresh.GetRoot(ctx, treeID); err != nil || !bytes.Equal(root, rootOf(t, leaves[:2]...)) {
+		t.Errorf("Expected the root of the 2 leaves before the unfilled node, got %x and error %v", root, err)
+	}
+	for _, nodeID := range []int{3, 4} {
+		if proof, err := fresh.GetProof(ctx, treeID, nodeID); err == nil {
+			t.Errorf("Expected no proof of node %d past the unfilled node, got %x", nodeID, proof)
+		}
+	}
+	if root, err := fresh.GetRootAt(ctx, treeID, 3); err == nil {
+		t.Errorf("Expected no root at size 3 before node 3 is filled, got %x", root)
+	}
+	if root, err := merkleRepo.GetTreeRoot(ctx, treeID, 3); err != nil || root != nil {
+		t.Errorf("Expected no root recorded at size 3, got %+v and error %v", root, err)
+	}
+
+	// once the reaper fills node 3 with the empty leaf, the tree holds the 4 nodes
+	filled, err := merkleRepo.ReapReservations(ctx, time.Now().Add(24*time.Hour), merkletree.EmptyLeaf)
+	if err != nil || len(filled) != 1 || filled[0].NodeID != 3 {
+		t.Fatalf("Expected node 3 filled, got %v and error %v", filled, err)
+	}
+	expected := rootOf(t, leaves[0], leaves[1], merkletree.EmptyLeaf, leaves[2])
+
+	// the nodes saved at size 2 are not served for the 4 nodes
+	reaped := NewMerkleService(merkleRepo, cache.NewLRU(10), nil)
+	if root, err := reaped.GetRoot(ctx, treeID); err != nil || !bytes.Equal(root, expected) {
+		t.Errorf("Expected root %x of the 4 nodes, got %x and error %v", expected, root, err)
+	}
+	if proof, err := NewMerkleService(merkleRepo, cache.NewLRU(10), nil).GetProof(ctx, treeID, 1); err != nil || !bytes.Equal(merkletree.RootFromProof(leaves[0], proof), expected) {
+		t.Errorf("Expected the proof of node 1 to lead to root %x, got error %v", expected, err)
+	}
+
+	if root, err := fresh.GetRootAt(ctx, treeID, 3); err != nil || !bytes.Equal(root, rootOf(t, leaves[0], leaves[1], merkletree.EmptyLeaf)) {
+		t.Errorf("Expected the root with the empty leaf at size 3, got %x and error %v", root, err)
+	}
+	if root, err := fresh.GetRootAt(ctx, treeID, 4); err != nil || !bytes.Equal(root, expected) {
+		t.Fatalf("Expected root %x at size 4, got %x and error %v", expected, root, err)
+	}
+	proof, err := fresh.GetProofAt(ctx, treeID, 4, 4)
+	if err != nil {
+		t.Fatalf("Failed to get proof of node 4: %v", err)
+	}
+	if !bytes.Equal(merkletree.RootFromProof(leaves[2], proof), expected) {
+		t.Errorf("Expected the proof of node 4 to lead to root %x", expected)
+	}
+}
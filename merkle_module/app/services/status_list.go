@@ -0,0 +1,49 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"merkle_module/app/interfaces"
+	"merkle_module/domain/entities"
+	"merkle_module/domain/repo"
+	"merkle_module/statuslist"
+)
+
+type StatusListService struct {
+	repo repo.StatusList
+}
+
+func NewStatusListService(repo repo.StatusList) interfaces.StatusList {
+	return &StatusListService{repo: repo}
+}
+
+func (s *StatusListService) AllocateStatus(ctx context.Context, issuerDID string) (*entities.StatusEntry, error) {
+	entry, err := s.repo.AllocateIndex(ctx, issuerDID, entities.StatusPurposeRevocation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate status index: %w", err)
+	}
+	return entry, nil
+}
+
+func (s *StatusListService) SetRevoked(ctx context.Context, statusListID, index int, revoked bool) error {
+	if _, err := s.repo.SetStatus(ctx, statusListID, index, revoked); err != nil {
+		return fmt.Errorf("failed to set status: %w", err)
+	}
+	return nil
+}
+
+func (s *StatusListService) IsRevoked(ctx context.Context, statusListID, index int) (bool, error) {
+	list, err := s.repo.GetStatusList(ctx, statusListID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get status list: %w", err)
+	}
+	return statuslist.FromBytes(list.Bits).Get(index)
+}
+
+func (s *StatusListService) GetEncodedList(ctx context.Context, statusListID int) (string, error) {
+	list, err := s.repo.GetStatusList(ctx, statusListID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get status list: %w", err)
+	}
+	return statuslist.FromBytes(list.Bits).Encode()
+}
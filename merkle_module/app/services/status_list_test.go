@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"merkle_module/infra/storage"
+	"merkle_module/statuslist"
+)
+
+func TestStatusListService(t *testing.T) {
+	ctx := context.Background()
+	service := NewStatusListService(storage.NewStatusListMemory())
+
+	// credentials of an issuer get the next indexes of its list
+	first, err := service.AllocateStatus(ctx, "did:example:issuer")
+	if err != nil {
+		t.Fatalf("Failed to allocate status: %v", err)
+	}
+	second, err := service.AllocateStatus(ctx, "did:example:issuer")
+	if err != nil {
+		t.Fatalf("Failed to allocate status: %v", err)
+	}
+	if second.StatusListID != first.StatusListID || first.Index != 0 || second.Index != 1 {
+		t.Fatalf("Expected indexes 0 and 1 of the same list, got %+v and %+v", first, second)
+	}
+
+	// revoking a credential flips its bit only, reinstating it flips it back
+	if err := service.SetRevoked(ctx, second.StatusListID, second.Index, true); err != nil {
+		t.Fatalf("Failed to revoke: %v", err)
+	}
+	for _, entry := range []struct {
+		index   int
+		revoked bool
+	}{{first.Index, false}, {second.Index, true}} {
+		if revoked, err := service.IsRevoked(ctx, first.StatusListID, entry.index); err != nil || revoked != entry.revoked {
+			t.Errorf("Expected index %d revoked %v, got %v and error %v", entry.index, entry.revoked, revoked, err)
+		}
+	}
+
+	// the encoded list carries the revoked bit
+	encoded, err := service.GetEncodedList(ctx, first.StatusListID)
+	if err != nil {
+		t.Fatalf("Failed to get encoded list: %v", err)
+	}
+	decoded, err := statuslist.Decode(encoded)
+	if err != nil {
+		t.Fatalf("Failed to decode list: %v", err)
+	}
+	if decoded.Size() != statuslist.MIN_SIZE || decoded.Bytes()[0] != 0x40 {
+		t.Errorf("Expected %d bits with index 1 set, got %d bits and first byte %08b", statuslist.MIN_SIZE, decoded.Size(), decoded.Bytes()[0])
+	}
+
+	if err := service.SetRevoked(ctx, second.StatusListID, second.Index, false); err != nil {
+		t.Fatalf("Failed to reinstate: %v", err)
+	}
+	if revoked, err := service.IsRevoked(ctx, second.StatusListID, second.Index); err != nil || revoked {
+		t.Errorf("Expected index %d reinstated, got %v and error %v", second.Index, revoked, err)
+	}
+
+	if err := service.SetRevoked(ctx, first.StatusListID, 2, true); err == nil {
+		t.Errorf("Expected an index not allocated to fail")
+	}
+	if _, err := service.IsRevoked(ctx, first.StatusListID, statuslist.MIN_SIZE); err == nil {
+		t.Errorf("Expected an index out of the list to fail")
+	}
+}
@@ -0,0 +1,74 @@
+// Package cache holds the caches of the Merkle trees built by the Merkle service: an LRU local
+// to the process by default, or Redis to share the trees between replicas.
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"merkle_module/merkletree"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// TreeCache caches the Merkle trees by tree ID and the tree every issuer adds its leaves to.
+// A failing cache behaves as an empty one: lookups miss and writes are dropped.
+type TreeCache interface {
+	// Get a tree, the caller may add leaves to it and put it back with AddTree
+	GetTree(ctx context.Context, treeID int) (*merkletree.MerkleTree, bool)
+	// Cache a tree, unless a larger version of it is cached already
+	AddTree(ctx context.Context, tree *merkletree.MerkleTree)
+	RemoveTree(ctx context.Context, treeID int)
+	GetActiveTreeID(ctx context.Context, issuerDID string) (int, bool)
+	SetActiveTreeID(ctx context.Context, issuerDID string, treeID int)
+	RemoveActiveTreeID(ctx context.Context, issuerDID string)
+	// Get the counters of the lookups made through this cache since it was created
+	Stats() Stats
+	// Whether other processes add leaves to the cached trees, the trees are then only as
+	// recent as the last leaf put back and a process may not take node IDs from them
+	Shared() bool
+}
+
+// Counters counts the lookups of a cache. Evictions are the entries dropped to make room for
+// others, not those removed by the service.
+type Counters struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+}
+
+type Stats struct {
+	Trees         Counters `json:"trees"`
+	ActiveTreeIDs Counters `json:"active_tree_ids"`
+}
+
+// counters are Counters safe for concurrent use
+type counters struct {
+	hits, misses, evictions atomic.Uint64
+}
+
+func (c *counters) lookup(hit bool) {
+	if hit {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+}
+
+func (c *counters) snapshot() Counters {
+	return Counters{Hits: c.hits.Load(), Misses: c.misses.Load(), Evictions: c.evictions.Load()}
+}
+
+// Open returns a Redis cache of size trees when url is set, a redis:// URL, and an LRU cache of
+// size trees and active tree IDs otherwise
+func Open(url string, size int) (TreeCache, error) {
+	if url == "" {
+		return NewLRU(size), nil
+	}
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Redis URL: %w", err)
+	}
+	return NewRedis(redis.NewClient(options), RedisConfig{Size: size}), nil
+}
@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"merkle_module/merkletree"
+	"merkle_module/utils"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTree builds tree ID treeID holding size leaves
+func newTree(t *testing.T, treeID, size int) *merkletree.MerkleTree {
+	t.Helper()
+	leaves := make([][]byte, size)
+	for i := range leaves {
+		leaves[i] = utils.Hash([]byte(fmt.Sprintf("leaf %d", i)))
+	}
+	tree, err := merkletree.NewMerkleTree(leaves, treeID)
+	if err != nil {
+		t.Fatalf("Failed to build tree: %v", err)
+	}
+	return tree
+}
+
+// testTreeCache checks a cache of 2 trees and active tree IDs
+func testTreeCache(t *testing.T, c TreeCache) {
+	ctx := context.Background()
+	if _, ok := c.GetTree(ctx, 1); ok {
+		t.Fatalf("Expected an empty cache")
+	}
+
+	tree := newTree(t, 1, 3)
+	c.AddTree(ctx, tree)
+	cached, ok := c.GetTree(ctx, 1)
+	if !ok || cached.Size() != 3 || !bytes.Equal(cached.GetMerkleRoot(), tree.GetMerkleRoot()) {
+		t.Fatalf("Expected Tree ID 1 with 3 leaves cached, got %v", cached)
+	}
+
+	// a smaller version of the tree does not replace it, a larger one does
+	c.AddTree(ctx, newTree(t, 1, 2))
+	if cached, ok := c.GetTree(ctx, 1); !ok || cached.Size() != 3 {
+		t.Errorf("Expected the stale tree ignored, got %v", cached)
+	}
+	cached.AddLeaf(utils.Hash([]byte("leaf 3")))
+	c.AddTree(ctx, cached)
+	if cached, ok := c.GetTree(ctx, 1); !ok || cached.Size() != 4 {
+		t.Errorf("Expected the tree replaced by its next leaf, got %v", cached)
+	}
+
+	// the least recently used tree is evicted
+	c.AddTree(ctx, newTree(t, 2, 1))
+	c.GetTree(ctx, 1)
+	c.AddTree(ctx, newTree(t, 3, 1))
+	if _, ok := c.GetTree(ctx, 2); ok {
+		t.Errorf("Expected Tree ID 2 evicted")
+	}
+	if _, ok := c.GetTree(ctx, 1); !ok {
+		t.Errorf("Expected Tree ID 1 kept")
+	}
+	c.RemoveTree(ctx, 1)
+	if _, ok := c.GetTree(ctx, 1); ok {
+		t.Errorf("Expected Tree ID 1 removed")
+	}
+
+	if _, ok := c.GetActiveTreeID(ctx, "did:example:issuer"); ok {
+		t.Errorf("Expected no active tree")
+	}
+	c.SetActiveTreeID(ctx, "did:example:issuer", 3)
+	if treeID, ok := c.GetActiveTreeID(ctx, "did:example:issuer"); !ok || treeID != 3 {
+		t.Errorf("Expected active Tree ID 3, got %d", treeID)
+	}
+	c.RemoveActiveTreeID(ctx, "did:example:issuer")
+	if _, ok := c.GetActiveTreeID(ctx, "did:example:issuer"); ok {
+		t.Errorf("Expected the active tree removed")
+	}
+
+	stats := c.Stats()
+	if expected := (Counters{Hits: 5, Misses: 3, Evictions: 1}); stats.Trees != expected {
+		t.Errorf("Expected tree counters %+v, got %+v", expected, stats.Trees)
+	}
+	if expected := (Counters{Hits: 1, Misses: 2}); stats.ActiveTreeIDs != expected {
+		t.Errorf("Expected active tree ID counters %+v, got %+v", expected, stats.ActiveTreeIDs)
+	}
+}
+
+func TestLRU(t *testing.T) {
+	testTreeCache(t, NewLRU(2))
+}
+
+func TestRedis(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	testTreeCache(t, NewRedis(client, RedisConfig{Size: 2}))
+}
+
+func TestRedisSharedBetweenReplicas(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	first := NewRedis(client, RedisConfig{Prefix: "test:", Size: 10})
+	second := NewRedis(client, RedisConfig{Prefix: "test:", Size: 10})
+
+	// a leaf added by a replica is seen by the other, which cannot put back its older tree
+	tree := newTree(t, 7, 5)
+	first.AddTree(ctx, tree)
+	stale, ok := second.GetTree(ctx, 7)
+	if !ok {
+		t.Fatalf("Expected Tree ID 7 shared")
+	}
+	tree.AddLeaf(utils.Hash([]byte("leaf 5")))
+	first.AddTree(ctx, tree)
+	second.AddTree(ctx, stale)
+	cached, ok := second.GetTree(ctx, 7)
+	if !ok || cached.Size() != 6 || !bytes.Equal(cached.GetMerkleRoot(), tree.GetMerkleRoot()) {
+		t.Errorf("Expected the tree with 6 leaves, got %v", cached)
+	}
+	proof, err := cached.GetProof(6)
+	if err != nil || !bytes.Equal(merkletree.RootFromProof(utils.Hash([]byte("leaf 5")), proof), tree.GetMerkleRoot()) {
+		t.Errorf("Expected a valid proof of the shared leaf, got error %v", err)
+	}
+	if stats := second.Stats(); stats.Trees.Hits != 2 || first.Stats().Trees.Hits != 0 {
+		t.Errorf("Expected the hits counted by replica, got %+v and %+v", first.Stats(), stats)
+	}
+
+	// an entry of another encoding or version is a miss
+	server.HSet("test:tree:7", "version", "5")
+	if _, ok := first.GetTree(ctx, 7); ok {
+		t.Errorf("Expected a tree stored under another version to miss")
+	}
+	server.HSet("test:tree:7", "version", "6", "data", "\x02")
+	if _, ok := first.GetTree(ctx, 7); ok {
+		t.Errorf("Expected a tree of another encoding to miss")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	if c, err := Open("", 10); err != nil {
+		t.Errorf("Failed to open the LRU cache: %v", err)
+	} else if _, ok := c.(*LRU); !ok || c.Shared() {
+		t.Errorf("Expected the LRU cache local to the process without a URL, got %T", c)
+	}
+	server := miniredis.RunT(t)
+	if c, err := Open("redis://"+server.Addr(), 10); err != nil {
+		t.Errorf("Failed to open the Redis cache: %v", err)
+	} else if _, ok := c.(*Redis); !ok || !c.Shared() {
+		t.Errorf("Expected the Redis cache shared between replicas with a URL, got %T", c)
+	}
+	if _, err := Open("http://localhost", 10); err == nil {
+		t.Errorf("Expected an invalid URL to fail")
+	}
+}
@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"context"
+
+	"merkle_module/merkletree"
+
+	"github.com/ethereum/go-ethereum/common/lru"
+)
+
+// LRU keeps the trees in the memory of the process. Its trees are shared with the caller, so
+// leaves added to a tree got from it are cached already.
+type LRU struct {
+	trees         *lru.Cache[int, *merkletree.MerkleTree]
+	activeTreeIDs *lru.Cache[string, int]
+	treeStats     counters
+	activeStats   counters
+}
+
+// NewLRU caches up to size trees and size active tree IDs
+func NewLRU(size int) *LRU {
+	return &LRU{
+		trees:         lru.NewCache[int, *merkletree.MerkleTree](size),
+		activeTreeIDs: lru.NewCache[string, int](size),
+	}
+}
+
+func (c *LRU) GetTree(ctx context.Context, treeID int) (*merkletree.MerkleTree, bool) {
+	tree, ok := c.trees.Get(treeID)
+	ok = ok && tree != nil
+	c.treeStats.lookup(ok)
+	return tree, ok
+}
+
+func (c *LRU) AddTree(ctx context.Context, tree *merkletree.MerkleTree) {
+	treeID := tree.GetTreeID()
+	if cached, ok := c.trees.Peek(treeID); ok && cached.Size() > tree.Size() {
+		return
+	}
+	if c.trees.Add(treeID, tree) {
+		c.treeStats.evictions.Add(1)
+	}
+}
+
+func (c *LRU) RemoveTree(ctx context.Context, treeID int) {
+	c.trees.Remove(treeID)
+}
+
+func (c *LRU) GetActiveTreeID(ctx context.Context, issuerDID string) (int, bool) {
+	treeID, ok := c.activeTreeIDs.Get(issuerDID)
+	c.activeStats.lookup(ok)
+	return treeID, ok
+}
+
+func (c *LRU) SetActiveTreeID(ctx context.Context, issuerDID string, treeID int) {
+	if c.activeTreeIDs.Add(issuerDID, treeID) {
+		c.activeStats.evictions.Add(1)
+	}
+}
+
+func (c *LRU) RemoveActiveTreeID(ctx context.Context, issuerDID string) {
+	c.activeTreeIDs.Remove(issuerDID)
+}
+
+func (c *LRU) Stats() Stats {
+	return Stats{Trees: c.treeStats.snapshot(), ActiveTreeIDs: c.activeStats.snapshot()}
+}
+
+// Shared is false, the trees are local to the process
+func (c *LRU) Shared() bool {
+	return false
+}
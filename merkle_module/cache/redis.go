@@ -0,0 +1,215 @@
+package cache
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"merkle_module/merkletree"
+	"merkle_module/utils"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// treeEncoding is the first byte of a serialized tree: its leaves, each prefixed by its length
+// as a uvarint, follow the tree ID as a uvarint. Trees of another encoding are cache misses.
+const treeEncoding = 1
+
+// addTreeScript caches a tree unless a larger version of it is cached, and evicts the least
+// recently used trees over the size. KEYS are the tree, the index of the trees by last use and
+// the clock of the index, ARGV the tree ID, its version, its data, the size and the prefix of
+// the tree keys. Returns the number of evicted trees, -1 when the tree is stale.
+var addTreeScript = redis.NewScript(`
+local current = redis.call('HGET', KEYS[1], 'version')
+if current and tonumber(current) > tonumber(ARGV[2]) then
+	return -1
+end
+redis.call('HSET', KEYS[1], 'version', ARGV[2], 'data', ARGV[3])
+redis.call('ZADD', KEYS[2], redis.call('INCR', KEYS[3]), ARGV[1])
+local evicted = 0
+local size = tonumber(ARGV[4])
+while size > 0 and redis.call('ZCARD', KEYS[2]) > size do
+	local oldest = redis.call('ZPOPMIN', KEYS[2])
+	redis.call('DEL', ARGV[5] .. oldest[1])
+	evicted = evicted + 1
+end
+return evicted
+`)
+
+// getTreeScript returns the version and data of a tree and marks it as used, with the KEYS of
+// addTreeScript and the tree ID
+var getTreeScript = redis.NewScript(`
+local entry = redis.call('HMGET', KEYS[1], 'version', 'data')
+if not entry[1] then
+	redis.call('ZREM', KEYS[2], ARGV[1])
+	return false
+end
+redis.call('ZADD', KEYS[2], redis.call('INCR', KEYS[3]), ARGV[1])
+return entry
+`)
+
+type RedisConfig struct {
+	Prefix string // prefix of the keys, "merkle:" by default
+	Size   int    // trees kept, the least recently used are evicted over it, 0 keeps every tree
+}
+
+// Redis shares the trees between the replicas of the service. A tree is stored serialized with
+// its number of leaves as its version, and a replica never replaces a tree by a smaller version
+// of it, built from the database before the last leaf was added. The scripts touch keys they
+// are not given, so Redis Cluster is not supported.
+type Redis struct {
+	client      redis.UniversalClient
+	prefix      string
+	size        int
+	treeStats   counters
+	activeStats counters
+}
+
+func NewRedis(client redis.UniversalClient, config RedisConfig) *Redis {
+	if config.Prefix == "" {
+		config.Prefix = "merkle:"
+	}
+	return &Redis{client: client, prefix: config.Prefix, size: config.Size}
+}
+
+func (c *Redis) treeKey(treeID int) string {
+	return c.treeKeyPrefix() + strconv.Itoa(treeID)
+}
+
+// treeKeyPrefix is the key of a tree without its ID, for addTreeScript to evict trees
+func (c *Redis) treeKeyPrefix() string {
+	return c.prefix + "tree:"
+}
+
+// treeKeys returns the KEYS of the tree scripts
+func (c *Redis) treeKeys(treeID int) []string {
+	return []string{c.treeKey(treeID), c.prefix + "trees", c.prefix + "clock"}
+}
+
+func (c *Redis) activeKey(issuerDID string) string {
+	return c.prefix + "active:" + issuerDID
+}
+
+func (c *Redis) GetTree(ctx context.Context, treeID int) (*merkletree.MerkleTree, bool) {
+	tree, err := c.getTree(ctx, treeID)
+	if err != nil && !errors.Is(err, redis.Nil) {
+		log.Printf("Failed to get tree ID %d from Redis: %v", treeID, err)
+	}
+	c.treeStats.lookup(err == nil)
+	return tree, err == nil
+}
+
+func (c *Redis) getTree(ctx context.Context, treeID int) (*merkletree.MerkleTree, error) {
+	entry, err := getTreeScript.Run(ctx, c.client, c.treeKeys(treeID), treeID).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+	if len(entry) != 2 {
+		return nil, fmt.Errorf("unexpected entry of %d fields", len(entry))
+	}
+	tree, err := decodeTree([]byte(entry[1]))
+	if err != nil {
+		return nil, err
+	}
+	if tree.GetTreeID() != treeID || strconv.Itoa(tree.Size()) != entry[0] {
+		return nil, fmt.Errorf("tree ID %d with %d leaves stored as version %s", tree.GetTreeID(), tree.Size(), entry[0])
+	}
+	return tree, nil
+}
+
+func (c *Redis) AddTree(ctx context.Context, tree *merkletree.MerkleTree) {
+	treeID := tree.GetTreeID()
+	evicted, err := addTreeScript.Run(ctx, c.client, c.treeKeys(treeID), treeID, tree.Size(), encodeTree(tree), c.size, c.treeKeyPrefix()).Int()
+	if err != nil {
+		log.Printf("Failed to add tree ID %d to Redis: %v", treeID, err)
+		return
+	}
+	if evicted > 0 {
+		c.treeStats.evictions.Add(uint64(evicted))
+	}
+}
+
+func (c *Redis) RemoveTree(ctx context.Context, treeID int) {
+	keys := c.treeKeys(treeID)
+	_, err := c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, keys[0])
+		pipe.ZRem(ctx, keys[1], treeID)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to remove tree ID %d from Redis: %v", treeID, err)
+	}
+}
+
+func (c *Redis) GetActiveTreeID(ctx context.Context, issuerDID string) (int, bool) {
+	treeID, err := c.client.Get(ctx, c.activeKey(issuerDID)).Int()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		log.Printf("Failed to get the active tree of %s from Redis: %v", issuerDID, err)
+	}
+	c.activeStats.lookup(err == nil)
+	return treeID, err == nil
+}
+
+func (c *Redis) SetActiveTreeID(ctx context.Context, issuerDID string, treeID int) {
+	if err := c.client.Set(ctx, c.activeKey(issuerDID), treeID, 0).Err(); err != nil {
+		log.Printf("Failed to set the active tree of %s in Redis: %v", issuerDID, err)
+	}
+}
+
+func (c *Redis) RemoveActiveTreeID(ctx context.Context, issuerDID string) {
+	if err := c.client.Del(ctx, c.activeKey(issuerDID)).Err(); err != nil {
+		log.Printf("Failed to remove the active tree of %s from Redis: %v", issuerDID, err)
+	}
+}
+
+// Stats counts the lookups made by this replica, the active tree IDs are never evicted
+func (c *Redis) Stats() Stats {
+	return Stats{Trees: c.treeStats.snapshot(), ActiveTreeIDs: c.activeStats.snapshot()}
+}
+
+// Shared is true, the replicas sharing the Redis add leaves to the same trees
+func (c *Redis) Shared() bool {
+	return true
+}
+
+// encodeTree serializes the tree ID and the leaves of a tree
+func encodeTree(tree *merkletree.MerkleTree) []byte {
+	data := []byte{treeEncoding}
+	data = binary.AppendUvarint(data, uint64(tree.GetTreeID()))
+	for _, leaf := range tree.Leaves() {
+		data = binary.AppendUvarint(data, uint64(len(leaf)))
+		data = append(data, leaf...)
+	}
+	return data
+}
+
+// decodeTree rebuilds a tree serialized by encodeTree
+func decodeTree(data []byte) (*merkletree.MerkleTree, error) {
+	if len(data) == 0 || data[0] != treeEncoding {
+		return nil, fmt.Errorf("unknown tree encoding")
+	}
+	data = data[1:]
+	treeID, n := binary.Uvarint(data)
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid tree ID")
+	}
+	data = data[n:]
+
+	var leaves [][]byte
+	for len(data) > 0 {
+		size, n := binary.Uvarint(data)
+		if n <= 0 || uint64(len(data)-n) < size {
+			return nil, fmt.Errorf("truncated leaf %d", len(leaves)+1)
+		}
+		if len(leaves) == utils.MAX_LEAFS {
+			return nil, fmt.Errorf("more than %d leaves", utils.MAX_LEAFS)
+		}
+		data = data[n:]
+		leaves = append(leaves, data[:size:size])
+		data = data[size:]
+	}
+	return merkletree.NewMerkleTree(leaves, int(treeID))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"merkle_module/cronjob"
+	"merkle_module/issuer"
+	credential "merkle_module/smartcontract"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+func runIntegrity(ctx context.Context, e *env, args []string) error {
+	flags := flag.NewFlagSet("integrity", flag.ContinueOnError)
+	repair := flags.Bool("repair", false, "release abandoned reservations, fix node counts and mark wrongly synced trees for sync")
+	grace := flags.Duration("grace", cronjob.ReservationGrace, "time after which an unfilled reservation is abandoned")
+	rpcURL := flags.String("rpc", "", "RPC endpoint of the chain to check the roots on, defaults to no on-chain check")
+	contract := flags.String("contract", "", "address of the contract on the --rpc chain")
+	issuers := flags.String("issuers", os.Getenv("ISSUER_ADDRESSES"), "issuer addresses as did=0xaddress,..., defaults to ISSUER_ADDRESSES")
+	if err := parse(flags, args, e.stderr); err != nil {
+		return err
+	}
+	if (*rpcURL == "") != (*contract == "") {
+		return usagef("--rpc and --contract go together")
+	}
+	if *contract != "" && !common.IsHexAddress(*contract) {
+		return usagef("invalid contract address %q", *contract)
+	}
+
+	var reconcilers []*cronjob.ReconcileJob
+	if *rpcURL != "" {
+		client, err := ethclient.DialContext(ctx, *rpcURL)
+		if err != nil {
+			return fmt.Errorf("failed to connect to %s: %w", *rpcURL, err)
+		}
+		defer client.Close()
+		chainID, err := client.ChainID(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get chain ID: %w", err)
+		}
+		address := common.HexToAddress(*contract)
+		binding, err := credential.NewCredential(address, client)
+		if err != nil {
+			return fmt.Errorf("failed to bind contract: %w", err)
+		}
+		staticIssuers, err := issuer.ParseStaticResolver(*issuers)
+		if err != nil {
+			return usagef("invalid --issuers: %v", err)
+		}
+		resolver := issuer.NewChainResolver(staticIssuers, issuer.DIDResolver{})
+		smartContract, err := credential.NewSmartContract(client, binding, address, nil)
+		if err != nil {
+			return err
+		}
+		reconcilers = append(reconcilers, cronjob.NewReconcileJob(ctx, e.merkle, chainID.Uint64(), smartContract, resolver))
+	}
+
+	job := cronjob.NewIntegrityJob(ctx, e.merkle, reconcilers...)
+	job.SetReservationGrace(*grace)
+	report, err := job.Check(ctx, *repair)
+	if err != nil {
+		return err
+	}
+
+	if err := e.print(report, func(w io.Writer) {
+		fmt.Fprintln(w, "TREE\tISSUER\tKIND\tNODES\tCHAIN\tREPAIRED\tDETAIL")
+		for _, issue := range report.Issues {
+			nodes := make([]string, len(issue.NodeIDs))
+			for i, nodeID := range issue.NodeIDs {
+				nodes[i] = fmt.Sprint(nodeID)
+			}
+			chain := ""
+			if issue.ChainID != 0 {
+				chain = fmt.Sprint(issue.ChainID)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%t\t%s\n", issue.TreeID, issue.IssuerDID, issue.Kind, strings.Join(nodes, ","), chain, issue.Repaired, issue.Detail)
+		}
+	}); err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stderr, "checked %d trees: %d issues\n", report.Checked, len(report.Issues))
+
+	left := 0
+	for _, issue := range report.Issues {
+		if !issue.Repaired {
+			left++
+		}
+	}
+	if left > 0 {
+		return fmt.Errorf("%d issues left", left)
+	}
+	return nil
+}
@@ -0,0 +1,342 @@
+// merkle-admin administers the trees of the merkle database.
+//
+//	merkle-admin issuers
+//	merkle-admin trees --issuer did:example:issuer
+//	merkle-admin --format json tree --id 227
+//	merkle-admin proof --tree 227 --node 5 --chain 1
+//	merkle-admin proof --issuer did:example:issuer --hash 0x...
+//	merkle-admin verify --leaf 0x... --proof 0x...,0x... --tree 227 --chain 1
+//	merkle-admin resync --tree 227 --chain 1
+//	merkle-admin integrity --repair --rpc https://rpc.example --contract 0x...
+//	merkle-admin export --issuer did:example:issuer --out issuer.snap
+//	merkle-admin import --in issuer.snap
+//	merkle-admin --dialect sqlite --db merkle.db import --dry-run < issuer.snap
+//
+// The commands reading trees print a table, or JSON with --format json. Roots and proofs are
+// served by the same MerkleService as the API, so they are those the holders get. integrity
+// scans every tree for missing or out of range nodes, recomputes its recorded, saved and synced
+// roots from its leaves and optionally repairs what can be repaired.
+//
+// Snapshots keep the tree IDs, which are the indexes the roots are anchored at on chain, so a
+// snapshot is imported into a database where its tree IDs are free. Every tree is checked
+// against its recorded roots before any is imported.
+//
+// The Postgres database defaults to DATABASE_URL and the SQLite one to SQLITE_PATH.
+// The exit code is 0 on success, 1 when a command fails and 2 on usage errors.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"merkle_module/app/interfaces"
+	"merkle_module/app/services"
+	"merkle_module/cache"
+	"merkle_module/domain/entities"
+	"merkle_module/domain/repo"
+	"merkle_module/infra/storage"
+	"merkle_module/snapshot"
+
+	_ "github.com/joho/godotenv/autoload"
+	_ "github.com/lib/pq"
+)
+
+const (
+	exitOK     = 0
+	exitFailed = 1
+	exitUsage  = 2
+)
+
+// errUsage marks the errors of a command caused by its arguments
+var errUsage = errors.New("usage")
+
+type usageError struct{ err error }
+
+func (e usageError) Error() string { return e.err.Error() }
+func (e usageError) Unwrap() error { return errUsage }
+
+func usagef(format string, args ...any) error {
+	return usageError{fmt.Errorf(format, args...)}
+}
+
+// env is what the commands run against
+type env struct {
+	merkle  repo.Merkle
+	service interfaces.Merkle
+	format  string // table or json
+	stdin   io.Reader
+	stdout  io.Writer
+	stderr  io.Writer
+}
+
+// print writes v as JSON, or as the table written by table
+func (e *env) print(v any, table func(w io.Writer)) error {
+	if e.format == "json" {
+		encoder := json.NewEncoder(e.stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	}
+	w := tabwriter.NewWriter(e.stdout, 0, 0, 2, ' ', 0)
+	table(w)
+	return w.Flush()
+}
+
+// command runs a command of merkle-admin with its own arguments
+type command struct {
+	usage string
+	run   func(ctx context.Context, e *env, args []string) error
+}
+
+var commands = map[string]command{
+	"issuers": {usage: "issuers", run: runIssuers},
+	"trees":   {usage: "trees --issuer DID", run: runTrees},
+	"tree":    {usage: "tree --id TREE", run: runTree},
+	"proof":   {usage: "proof (--tree TREE --node NODE | [--tree TREE | --issuer DID] --hash LEAF) [--chain CHAIN]", run: runProof},
+	"verify":  {usage: "verify --leaf LEAF --proof HASH,... (--root ROOT | --tree TREE [--chain CHAIN])", run: runVerify},
+	"resync":  {usage: "resync --tree TREE --chain CHAIN", run: runResync},
+	"integrity": {
+		usage: "integrity [--repair] [--grace DURATION] [--rpc URL --contract ADDRESS [--issuers DID=ADDRESS,...]]",
+		run:   runIntegrity,
+	},
+	"export": {usage: "export --issuer DID [--out FILE]", run: runExport},
+	"import": {usage: "import [--in FILE] [--dry-run]", run: runImport},
+}
+
+// commandNames lists the commands in the order of the usage
+var commandNames = []string{"issuers", "trees", "tree", "proof", "verify", "resync", "integrity", "export", "import"}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("merkle-admin", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: merkle-admin [flags] command [command flags]")
+		for _, name := range commandNames {
+			fmt.Fprintf(stderr, "  merkle-admin %s\n", commands[name].usage)
+		}
+		flags.PrintDefaults()
+	}
+	dialect := flags.String("dialect", "postgres", "database dialect: postgres or sqlite")
+	dsn := flags.String("db", "", "database URL, or file for sqlite, defaults to DATABASE_URL or SQLITE_PATH")
+	format := flags.String("format", "table", "output format: table or json")
+	timeout := flags.Duration("timeout", 10*time.Minute, "timeout of the whole command")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	usage := func(err error) int {
+		fmt.Fprintf(stderr, "merkle-admin: %v\n", err)
+		return exitUsage
+	}
+	if *format != "table" && *format != "json" {
+		return usage(fmt.Errorf("unknown format %q", *format))
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return exitUsage
+	}
+	cmd, ok := commands[flags.Arg(0)]
+	if !ok {
+		return usage(fmt.Errorf("unknown command %q", flags.Arg(0)))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	db, merkle, err := open(ctx, *dialect, *dsn)
+	if err != nil {
+		return usage(err)
+	}
+	defer db.Close()
+
+	e := &env{
+		merkle:  merkle,
+		service: services.NewMerkleService(merkle, cache.NewLRU(10), nil),
+		format:  *format,
+		stdin:   stdin,
+		stdout:  stdout,
+		stderr:  stderr,
+	}
+	err = cmd.run(ctx, e, flags.Args()[1:])
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, flag.ErrHelp):
+		return exitUsage
+	case errors.Is(err, errUsage):
+		fmt.Fprintf(stderr, "merkle-admin: %v\nusage: merkle-admin %s\n", err, cmd.usage)
+		return exitUsage
+	default:
+		fmt.Fprintf(stderr, "merkle-admin: %v\n", err)
+		return exitFailed
+	}
+}
+
+// open connects to the database of the dialect and returns its repository. SQLite databases
+// are migrated on open, Postgres ones with merkle-migrate.
+func open(ctx context.Context, dialect, dsn string) (*sql.DB, repo.Merkle, error) {
+	switch dialect {
+	case "postgres":
+		if dsn == "" {
+			dsn = os.Getenv("DATABASE_URL")
+		}
+		if dsn == "" {
+			return nil, nil, fmt.Errorf("--db or DATABASE_URL is required")
+		}
+		db, err := sql.Open("postgres", dsn)
+		if err != nil {
+			return nil, nil, err
+		}
+		return db, storage.NewMerklePostgres(db), nil
+	case "sqlite":
+		if dsn == "" {
+			dsn = os.Getenv("SQLITE_PATH")
+		}
+		if dsn == "" {
+			return nil, nil, fmt.Errorf("--db or SQLITE_PATH is required")
+		}
+		db, err := storage.OpenSQLite(ctx, dsn)
+		if err != nil {
+			return nil, nil, err
+		}
+		return db, storage.NewMerkleSQLite(db), nil
+	default:
+		return nil, nil, fmt.Errorf("unknown dialect %q", dialect)
+	}
+}
+
+// parse parses the flags of a command, which takes no positional arguments
+func parse(flags *flag.FlagSet, args []string, stderr io.Writer) error {
+	flags.SetOutput(stderr)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return usagef("unexpected argument %q", flags.Arg(0))
+	}
+	return nil
+}
+
+func runExport(ctx context.Context, e *env, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	issuerDID := flags.String("issuer", "", "DID of the issuer whose trees are exported")
+	out := flags.String("out", "-", "snapshot file to write, - for stdout")
+	if err := parse(flags, args, e.stderr); err != nil {
+		return err
+	}
+	if *issuerDID == "" {
+		return usagef("--issuer is required")
+	}
+
+	trees, err := e.merkle.GetIssuerTrees(ctx, *issuerDID)
+	if err != nil {
+		return err
+	}
+	if len(trees) == 0 {
+		return fmt.Errorf("issuer %s has no trees", *issuerDID)
+	}
+
+	if *out == "-" {
+		err = exportTrees(ctx, e.merkle, trees, e.stdout)
+	} else {
+		var file *os.File
+		if file, err = os.Create(*out); err != nil {
+			return err
+		}
+		err = exportTrees(ctx, e.merkle, trees, file)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			// A partial snapshot is never left behind
+			os.Remove(*out)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stderr, "exported %d trees of %s\n", len(trees), *issuerDID)
+	return nil
+}
+
+// exportTrees streams the snapshots of the trees to w
+func exportTrees(ctx context.Context, merkle repo.Merkle, trees []*entities.MerkleTree, w io.Writer) error {
+	writer, err := snapshot.NewWriter(w)
+	if err != nil {
+		return err
+	}
+	for _, tree := range trees {
+		treeSnapshot, err := merkle.GetTreeSnapshot(ctx, tree.ID)
+		if err != nil {
+			return fmt.Errorf("failed to read tree ID %d: %w", tree.ID, err)
+		}
+		if treeSnapshot == nil {
+			return fmt.Errorf("tree ID %d disappeared during the export", tree.ID)
+		}
+		if err := writer.WriteTree(treeSnapshot); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+func runImport(ctx context.Context, e *env, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	in := flags.String("in", "-", "snapshot file to read, - for stdin")
+	dryRun := flags.Bool("dry-run", false, "check the snapshot without importing it")
+	if err := parse(flags, args, e.stderr); err != nil {
+		return err
+	}
+
+	r := e.stdin
+	if *in != "-" {
+		file, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	// The whole snapshot is checked before anything is imported
+	reader, err := snapshot.NewReader(r)
+	if err != nil {
+		return err
+	}
+	var snapshots []*entities.TreeSnapshot
+	for {
+		treeSnapshot, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := snapshot.Verify(treeSnapshot); err != nil {
+			return fmt.Errorf("invalid snapshot: %w", err)
+		}
+		snapshots = append(snapshots, treeSnapshot)
+	}
+
+	if *dryRun {
+		fmt.Fprintf(e.stdout, "snapshot of %d trees is valid\n", len(snapshots))
+		return nil
+	}
+	if err := e.merkle.ImportTrees(ctx, snapshots); err != nil {
+		return err
+	}
+	for _, treeSnapshot := range snapshots {
+		fmt.Fprintf(e.stdout, "imported tree ID %d of %s with %d nodes\n", treeSnapshot.Tree.ID, treeSnapshot.Tree.IssuerDID, len(treeSnapshot.Nodes))
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"merkle_module/app/services"
+	"merkle_module/cache"
+	"merkle_module/domain/entities"
+	"merkle_module/domain/repo"
+	"merkle_module/infra/model"
+	"merkle_module/infra/storage"
+	"merkle_module/utils"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+const (
+	testIssuerDID  = "did:example:issuer"
+	otherIssuerDID = "did:example:other"
+)
+
+// adminDB is a SQLite database seeded with the trees of two issuers
+type adminDB struct {
+	path   string
+	merkle repo.Merkle
+	treeID int      // tree of testIssuerDID, synced on chain 1 at its 3 leaves
+	leaves [][]byte // leaves of treeID
+	root   []byte   // root of treeID
+	proof  [][]byte // proof of the last leaf of treeID
+}
+
+func testLeaf(i int) []byte { return utils.Hash([]byte(fmt.Sprintf("leaf %d", i))) }
+
+func newAdminDB(t *testing.T) *adminDB {
+	t.Helper()
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "merkle.db")
+	db, err := storage.OpenSQLite(ctx, path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	a := &adminDB{path: path, merkle: storage.NewMerkleSQLite(db)}
+	service := services.NewMerkleService(a.merkle, cache.NewLRU(10), nil)
+
+	for i := 1; i <= 3; i++ {
+		node, err := service.AddLeaf(ctx, testIssuerDID, testLeaf(i))
+		if err != nil {
+			t.Fatalf("Failed to add leaf: %v", err)
+		}
+		a.treeID = node.TreeID
+		a.leaves = append(a.leaves, testLeaf(i))
+	}
+	if _, err := service.AddLeaf(ctx, otherIssuerDID, testLeaf(4)); err != nil {
+		t.Fatalf("Failed to add leaf: %v", err)
+	}
+	if a.root, err = service.GetRoot(ctx, a.treeID); err != nil {
+		t.Fatalf("Failed to get root: %v", err)
+	}
+	if a.proof, err = service.GetProof(ctx, a.treeID, 3); err != nil {
+		t.Fatalf("Failed to get proof: %v", err)
+	}
+
+	treeSync := &entities.TreeSync{TreeID: a.treeID, ChainID: 1, NodeCount: 3, Root: a.root}
+	if err := a.merkle.CreatePendingSyncs(ctx, []*entities.TreeSync{treeSync}); err != nil {
+		t.Fatalf("Failed to create pending sync: %v", err)
+	}
+	if err := a.merkle.SetSyncTransaction(ctx, []int{treeSync.ID}, "0x01", 1); err != nil {
+		t.Fatalf("Failed to set sync transaction: %v", err)
+	}
+	if err := a.merkle.ConfirmSyncs(ctx, 1, "0x01", 10); err != nil {
+		t.Fatalf("Failed to confirm syncs: %v", err)
+	}
+	return a
+}
+
+// run runs merkle-admin against the database and returns its exit code, stdout and stderr
+func (a *adminDB) run(t *testing.T, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(append([]string{"--dialect", "sqlite", "--db", a.path}, args...), strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func joinHashes(hashes [][]byte) string {
+	encoded := make([]string, len(hashes))
+	for i, hash := range hashes {
+		encoded[i] = hexutil.Encode(hash)
+	}
+	return strings.Join(encoded, ",")
+}
+
+func TestCommands(t *testing.T) {
+	a := newAdminDB(t)
+	treeID := fmt.Sprint(a.treeID)
+	root, leaf, proof := hexutil.Encode(a.root), hexutil.Encode(a.leaves[2]), joinHashes(a.proof)
+
+	cases := []struct {
+		name   string
+		args   []string
+		code   int
+		stdout []string // expected in stdout
+		stderr []string // expected in stderr
+		json   func(t *testing.T, out []byte)
+	}{
+		{name: "issuers", args: []string{"issuers"}, stdout: []string{"ISSUER", testIssuerDID, otherIssuerDID}},
+		{name: "trees", args: []string{"trees", "--issuer", testIssuerDID}, stdout: []string{"ID", treeID}},
+		{
+			name: "trees json",
+			args: []string{"--format", "json", "trees", "--issuer", testIssuerDID},
+			json: func(t *testing.T, out []byte) {
+				var trees []*treeSummary
+				if err := json.Unmarshal(out, &trees); err != nil || len(trees) != 1 || trees[0].ID != a.treeID || trees[0].NodeCount != 3 || trees[0].Full {
+					t.Errorf("Expected Tree ID %d of 3 nodes, got %s and error %v", a.treeID, out, err)
+				}
+			},
+		},
+		{name: "tree", args: []string{"tree", "--id", treeID}, stdout: []string{testIssuerDID, "3 (3 leaves)", root, "0x01"}},
+		{
+			name: "tree json",
+			args: []string{"--format", "json", "tree", "--id", treeID},
+			json: func(t *testing.T, out []byte) {
+				var state treeState
+				if err := json.Unmarshal(out, &state); err != nil {
+					t.Fatalf("Failed to decode %s: %v", out, err)
+				}
+				if state.Leaves != 3 || !bytes.Equal(state.Root, a.root) || len(state.Chains) != 1 {
+					t.Fatalf("Expected 3 leaves under root %x synced on 1 chain, got %s", a.root, out)
+				}
+				if chain := state.Chains[0]; chain.ChainID != 1 || chain.SyncedNode != 3 || !chain.UpToDate || chain.TxHash != "0x01" {
+					t.Errorf("Expected the tree up to date on chain 1, got %+v", chain)
+				}
+			},
+		},
+		{name: "proof by node", args: []string{"proof", "--tree", treeID, "--node", "3", "--chain", "1"}, stdout: []string{"chain:", leaf, root}},
+		{
+			name: "proof by hash",
+			args: []string{"--format", "json", "proof", "--issuer", testIssuerDID, "--hash", leaf},
+			json: func(t *testing.T, out []byte) {
+				var result proofResult
+				if err := json.Unmarshal(out, &result); err != nil || result.TreeID != a.treeID || result.NodeID != 3 || !bytes.Equal(result.Root, a.root) || len(result.Proof) != len(a.proof) {
+					t.Errorf("Expected the proof of node 3 of Tree ID %d, got %s and error %v", a.treeID, out, err)
+				}
+			},
+		},
+		{name: "verify against the tree", args: []string{"verify", "--leaf", leaf, "--proof", proof, "--tree", treeID, "--chain", "1"}, stdout: []string{"valid:", "true"}},
+		{name: "verify against a root", args: []string{"verify", "--leaf", leaf, "--proof", proof, "--root", root}, stdout: []string{"true"}},
+		{
+			name:   "verify another leaf",
+			args:   []string{"verify", "--leaf", hexutil.Encode(a.leaves[0]), "--proof", proof, "--root", root},
+			code:   exitFailed,
+			stdout: []string{"false"},
+			stderr: []string{"does not lead to the root"},
+		},
+		{name: "integrity", args: []string{"integrity"}, stderr: []string{"checked 2 trees: 0 issues"}},
+
+		{name: "unknown tree", args: []string{"tree", "--id", "999"}, code: exitFailed, stderr: []string{"does not exist"}},
+		{name: "unknown leaf", args: []string{"proof", "--tree", treeID, "--hash", hexutil.Encode(testLeaf(9))}, code: exitFailed, stderr: []string{"not found"}},
+		{name: "node out of range", args: []string{"proof", "--tree", treeID, "--node", "4"}, code: exitFailed, stderr: []string{"has no leaf 4"}},
+
+		{name: "no command", code: exitUsage, stderr: []string{"usage: merkle-admin"}},
+		{name: "unknown command", args: []string{"check"}, code: exitUsage, stderr: []string{`unknown command "check"`}},
+		{name: "unknown format", args: []string{"--format", "yaml", "issuers"}, code: exitUsage, stderr: []string{`unknown format "yaml"`}},
+		{name: "missing issuer", args: []string{"trees"}, code: exitUsage, stderr: []string{"--issuer is required", "usage: merkle-admin trees"}},
+		{name: "node and hash", args: []string{"proof", "--tree", treeID, "--node", "1", "--hash", leaf}, code: exitUsage, stderr: []string{"exactly one of --node and --hash"}},
+		{name: "invalid hash", args: []string{"verify", "--leaf", "0x01", "--proof", proof, "--root", root}, code: exitUsage, stderr: []string{"--leaf must be"}},
+		{name: "positional argument", args: []string{"issuers", "extra"}, code: exitUsage, stderr: []string{`unexpected argument "extra"`}},
+		{name: "resync without chain", args: []string{"resync", "--tree", treeID}, code: exitUsage, stderr: []string{"--tree and --chain are required"}},
+		{name: "rpc without contract", args: []string{"integrity", "--rpc", "http://localhost:8545"}, code: exitUsage, stderr: []string{"go together"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			code, stdout, stderr := a.run(t, "", c.args...)
+			if code != c.code {
+				t.Fatalf("Expected exit code %d, got %d with stderr %q", c.code, code, stderr)
+			}
+			for _, expected := range c.stdout {
+				if !strings.Contains(stdout, expected) {
+					t.Errorf("Expected %q in stdout, got %q", expected, stdout)
+				}
+			}
+			for _, expected := range c.stderr {
+				if !strings.Contains(stderr, expected) {
+					t.Errorf("Expected %q in stderr, got %q", expected, stderr)
+				}
+			}
+			if c.json != nil {
+				c.json(t, []byte(stdout))
+			}
+		})
+	}
+}
+
+func TestResync(t *testing.T) {
+	a := newAdminDB(t)
+	if code, _, stderr := a.run(t, "", "resync", "--tree", fmt.Sprint(a.treeID), "--chain", "1"); code != exitOK {
+		t.Fatalf("Expected resync to succeed, got exit code %d with stderr %q", code, stderr)
+	}
+	trees, err := a.merkle.GetTreesWithNodesForSync(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Failed to get trees for sync: %v", err)
+	}
+	if !slices.ContainsFunc(trees, func(tree *model.MerkleTreeWithNodes) bool { return tree.Tree.ID == a.treeID && tree.Tree.NeedSync }) {
+		t.Errorf("Expected Tree ID %d marked for sync on chain 1", a.treeID)
+	}
+	if code, _, _ := a.run(t, "", "resync", "--tree", "999", "--chain", "1"); code != exitFailed {
+		t.Errorf("Expected an unknown tree to fail, got exit code %d", code)
+	}
+}
+
+func TestIntegrityRepair(t *testing.T) {
+	a := newAdminDB(t)
+	ctx := context.Background()
+
+	// the root served for the tree is saved from other leaves
+	if err := a.merkle.SaveNodeHashes(ctx, []*entities.NodeHash{{TreeID: a.treeID, Index: 1, Size: 3, Hash: testLeaf(0)}}); err != nil {
+		t.Fatalf("Failed to save node hashes: %v", err)
+	}
+	code, stdout, stderr := a.run(t, "", "integrity")
+	if code != exitFailed || !strings.Contains(stdout, "saved_root") || !strings.Contains(stderr, "1 issues left") {
+		t.Fatalf("Expected the saved root reported, got exit code %d with %q and %q", code, stdout, stderr)
+	}
+
+	code, stdout, stderr = a.run(t, "", "--format", "json", "integrity", "--repair")
+	if code != exitOK {
+		t.Fatalf("Expected the repair to succeed, got exit code %d with stderr %q", code, stderr)
+	}
+	var report struct {
+		Issues []struct {
+			Kind     string `json:"kind"`
+			Repaired bool   `json:"repaired"`
+		} `json:"issues"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &report); err != nil || len(report.Issues) != 1 || report.Issues[0].Kind != "saved_root" || !report.Issues[0].Repaired {
+		t.Fatalf("Expected the saved root repaired, got %s and error %v", stdout, err)
+	}
+	if code, _, stderr := a.run(t, "", "integrity"); code != exitOK {
+		t.Errorf("Expected no issue left, got exit code %d with stderr %q", code, stderr)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	a := newAdminDB(t)
+	code, snapshot, stderr := a.run(t, "", "export", "--issuer", testIssuerDID)
+	if code != exitOK || !strings.Contains(stderr, "exported 1 trees") {
+		t.Fatalf("Expected the export to succeed, got exit code %d with stderr %q", code, stderr)
+	}
+	if code, _, _ := a.run(t, "", "export", "--issuer", "did:example:unknown"); code != exitFailed {
+		t.Errorf("Expected the export of an issuer without trees to fail, got exit code %d", code)
+	}
+
+	// the tree IDs of the snapshot are taken in its own database
+	if code, _, _ := a.run(t, snapshot, "import"); code != exitFailed {
+		t.Errorf("Expected the import into the exported database to fail, got exit code %d", code)
+	}
+	if code, _, _ := a.run(t, "not a snapshot", "import", "--dry-run"); code != exitFailed {
+		t.Errorf("Expected an invalid snapshot to fail, got exit code %d", code)
+	}
+
+	target := &adminDB{path: filepath.Join(t.TempDir(), "merkle.db")}
+	code, stdout, stderr := target.run(t, snapshot, "import", "--dry-run")
+	if code != exitOK || !strings.Contains(stdout, "snapshot of 1 trees is valid") {
+		t.Fatalf("Expected the dry run to succeed, got exit code %d with %q and %q", code, stdout, stderr)
+	}
+	if _, stdout, _ := target.run(t, "", "issuers"); strings.Contains(stdout, testIssuerDID) {
+		t.Fatalf("Expected nothing imported by a dry run, got %q", stdout)
+	}
+	code, stdout, stderr = target.run(t, snapshot, "import")
+	if code != exitOK || !strings.Contains(stdout, fmt.Sprintf("imported tree ID %d of %s with 3 nodes", a.treeID, testIssuerDID)) {
+		t.Fatalf("Expected the import to succeed, got exit code %d with %q and %q", code, stdout, stderr)
+	}
+
+	// the imported tree serves the same root and proof
+	code, stdout, stderr = target.run(t, "", "verify", "--leaf", hexutil.Encode(a.leaves[2]), "--proof", joinHashes(a.proof), "--tree", fmt.Sprint(a.treeID), "--chain", "1")
+	if code != exitOK {
+		t.Errorf("Expected the proof verified against the imported tree, got exit code %d with %q and %q", code, stdout, stderr)
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"merkle_module/domain/entities"
+	"merkle_module/merkletree"
+	"merkle_module/utils"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// decodeHash decodes the bytes32 hash of a flag
+func decodeHash(name, value string) ([]byte, error) {
+	hash, err := hexutil.Decode(value)
+	if err != nil || len(hash) != 32 {
+		return nil, usagef("--%s must be a 0x-prefixed 32-byte hash, got %q", name, value)
+	}
+	return hash, nil
+}
+
+// treeSummary is a tree of an issuer
+type treeSummary struct {
+	ID        int  `json:"id"`
+	NodeCount int  `json:"node_count"`
+	Full      bool `json:"full"`
+}
+
+// chainState is the sync state of a tree on a chain
+type chainState struct {
+	ChainID    uint64        `json:"chain_id"`
+	NeedSync   bool          `json:"need_sync"`
+	SyncedNode int           `json:"node_count_sync"`
+	Root       hexutil.Bytes `json:"root,omitempty"` // synced root, empty before the first sync
+	TxHash     string        `json:"tx_hash,omitempty"`
+	UpToDate   bool          `json:"up_to_date"` // synced at the current root
+}
+
+// treeState is a tree with its current root and its sync state on every chain
+type treeState struct {
+	ID        int           `json:"id"`
+	IssuerDID string        `json:"issuer_did"`
+	NodeCount int           `json:"node_count"` // reserved nodes included
+	Leaves    int           `json:"leaves"`
+	Root      hexutil.Bytes `json:"root"`
+	Sealed    bool          `json:"sealed"`
+	Chains    []*chainState `json:"chains"`
+}
+
+// proofResult is the proof of a leaf with the root it leads to
+type proofResult struct {
+	TreeID  int             `json:"tree_id"`
+	NodeID  int             `json:"node_id"`
+	ChainID uint64          `json:"chain_id,omitempty"` // proof against the synced root of the chain
+	Leaf    hexutil.Bytes   `json:"leaf"`
+	Root    hexutil.Bytes   `json:"root"`
+	Proof   []hexutil.Bytes `json:"proof"`
+}
+
+// verifyResult compares the root a proof leads to with the expected one
+type verifyResult struct {
+	Leaf         hexutil.Bytes `json:"leaf"`
+	ComputedRoot hexutil.Bytes `json:"computed_root"`
+	Root         hexutil.Bytes `json:"root"`
+	Valid        bool          `json:"valid"`
+}
+
+func runIssuers(ctx context.Context, e *env, args []string) error {
+	flags := flag.NewFlagSet("issuers", flag.ContinueOnError)
+	if err := parse(flags, args, e.stderr); err != nil {
+		return err
+	}
+
+	issuers, err := e.merkle.GetIssuers(ctx)
+	if err != nil {
+		return err
+	}
+	return e.print(issuers, func(w io.Writer) {
+		fmt.Fprintln(w, "ISSUER\tTREES\tNODES\tLAST TREE")
+		for _, issuer := range issuers {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", issuer.IssuerDID, issuer.Trees, issuer.NodeCount, issuer.LastTreeID)
+		}
+	})
+}
+
+func runTrees(ctx context.Context, e *env, args []string) error {
+	flags := flag.NewFlagSet("trees", flag.ContinueOnError)
+	issuerDID := flags.String("issuer", "", "DID of the issuer whose trees are listed")
+	if err := parse(flags, args, e.stderr); err != nil {
+		return err
+	}
+	if *issuerDID == "" {
+		return usagef("--issuer is required")
+	}
+
+	trees, err := e.merkle.GetIssuerTrees(ctx, *issuerDID)
+	if err != nil {
+		return err
+	}
+	summaries := make([]*treeSummary, len(trees))
+	for i, tree := range trees {
+		summaries[i] = &treeSummary{ID: tree.ID, NodeCount: tree.NodeCount, Full: tree.NodeCount >= utils.MAX_LEAFS}
+	}
+	return e.print(summaries, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tNODES\tFULL")
+		for _, tree := range summaries {
+			fmt.Fprintf(w, "%d\t%d\t%t\n", tree.ID, tree.NodeCount, tree.Full)
+		}
+	})
+}
+
+func runTree(ctx context.Context, e *env, args []string) error {
+	flags := flag.NewFlagSet("tree", flag.ContinueOnError)
+	treeID := flags.Int("id", 0, "ID of the tree")
+	if err := parse(flags, args, e.stderr); err != nil {
+		return err
+	}
+	if *treeID <= 0 {
+		return usagef("--id is required")
+	}
+
+	treeSnapshot, err := e.merkle.GetTreeSnapshot(ctx, *treeID)
+	if err != nil {
+		return err
+	}
+	if treeSnapshot == nil {
+		return fmt.Errorf("tree ID %d does not exist", *treeID)
+	}
+	state := &treeState{
+		ID:        *treeID,
+		IssuerDID: treeSnapshot.Tree.IssuerDID,
+		NodeCount: treeSnapshot.Tree.NodeCount,
+		Leaves:    len(treeSnapshot.Nodes),
+		Sealed:    treeSnapshot.Seal != nil,
+		Chains:    []*chainState{},
+	}
+	if state.Leaves > 0 {
+		if state.Root, err = e.service.GetRoot(ctx, *treeID); err != nil {
+			return err
+		}
+	}
+	for _, chain := range treeSnapshot.Chains {
+		synced := &chainState{ChainID: chain.ChainID, NeedSync: chain.NeedSync, SyncedNode: chain.NodeCountSync}
+		if chain.NodeCountSync > 0 {
+			if synced.Root, err = e.service.GetSyncedRoot(ctx, *treeID, chain.ChainID); err != nil {
+				return fmt.Errorf("failed to get synced root on chain %d: %w", chain.ChainID, err)
+			}
+		}
+		for _, treeSync := range treeSnapshot.Syncs {
+			if treeSync.ChainID == chain.ChainID {
+				synced.TxHash = treeSync.TxHash
+			}
+		}
+		synced.UpToDate = chain.NodeCountSync == state.Leaves && bytes.Equal(synced.Root, state.Root)
+		state.Chains = append(state.Chains, synced)
+	}
+
+	return e.print(state, func(w io.Writer) {
+		fmt.Fprintf(w, "tree:\t%d\n", state.ID)
+		fmt.Fprintf(w, "issuer:\t%s\n", state.IssuerDID)
+		fmt.Fprintf(w, "nodes:\t%d (%d leaves)\n", state.NodeCount, state.Leaves)
+		fmt.Fprintf(w, "root:\t%s\n", state.Root)
+		fmt.Fprintf(w, "sealed:\t%t\n", state.Sealed)
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "CHAIN\tSYNCED\tNEED SYNC\tUP TO DATE\tSYNCED ROOT\tTX")
+		for _, chain := range state.Chains {
+			root := "-"
+			if len(chain.Root) > 0 {
+				root = chain.Root.String()
+			}
+			fmt.Fprintf(w, "%d\t%d\t%t\t%t\t%s\t%s\n", chain.ChainID, chain.SyncedNode, chain.NeedSync, chain.UpToDate, root, chain.TxHash)
+		}
+	})
+}
+
+// findLeaf returns the tree and node IDs of a leaf among the trees
+func findLeaf(ctx context.Context, e *env, treeIDs []int, leaf []byte) (int, int, error) {
+	for _, treeID := range treeIDs {
+		nodes, err := e.merkle.GetNodesByTreeID(ctx, treeID)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to get nodes of tree ID %d: %w", treeID, err)
+		}
+		if i := slices.IndexFunc(nodes, func(node *entities.MerkleNode) bool { return bytes.Equal(node.Data, leaf) }); i >= 0 {
+			return treeID, nodes[i].NodeID, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("leaf %s not found", hexutil.Encode(leaf))
+}
+
+func runProof(ctx context.Context, e *env, args []string) error {
+	flags := flag.NewFlagSet("proof", flag.ContinueOnError)
+	treeID := flags.Int("tree", 0, "ID of the tree of the leaf")
+	nodeID := flags.Int("node", 0, "node ID of the leaf, from 1")
+	hash := flags.String("hash", "", "leaf hash to look up, instead of --node")
+	issuerDID := flags.String("issuer", "", "DID of the issuer whose trees are searched for --hash, instead of --tree")
+	chainID := flags.Uint64("chain", 0, "chain whose synced root the proof leads to, defaults to the current root")
+	if err := parse(flags, args, e.stderr); err != nil {
+		return err
+	}
+	if (*nodeID == 0) == (*hash == "") {
+		return usagef("exactly one of --node and --hash is required")
+	}
+	if *nodeID != 0 && *treeID <= 0 {
+		return usagef("--tree is required with --node")
+	}
+	if *hash != "" && (*treeID <= 0) == (*issuerDID == "") {
+		return usagef("exactly one of --tree and --issuer is required with --hash")
+	}
+
+	result := &proofResult{TreeID: *treeID, NodeID: *nodeID, ChainID: *chainID}
+	if *hash != "" {
+		leaf, err := decodeHash("hash", *hash)
+		if err != nil {
+			return err
+		}
+		treeIDs := []int{*treeID}
+		if *issuerDID != "" {
+			trees, err := e.merkle.GetIssuerTrees(ctx, *issuerDID)
+			if err != nil {
+				return err
+			}
+			treeIDs = treeIDs[:0]
+			for _, tree := range trees {
+				treeIDs = append(treeIDs, tree.ID)
+			}
+		}
+		if result.TreeID, result.NodeID, err = findLeaf(ctx, e, treeIDs, leaf); err != nil {
+			return err
+		}
+		result.Leaf = leaf
+	} else {
+		nodes, err := e.merkle.GetNodesByTreeID(ctx, *treeID)
+		if err != nil {
+			return fmt.Errorf("failed to get nodes of tree ID %d: %w", *treeID, err)
+		}
+		leaves, _ := utils.LeadingLeaves(nodes)
+		if *nodeID < 1 || *nodeID > len(leaves) {
+			return fmt.Errorf("tree ID %d has no leaf %d, it holds %d leaves before its first unfilled node", *treeID, *nodeID, len(leaves))
+		}
+		result.Leaf = leaves[*nodeID-1]
+	}
+
+	var proof [][]byte
+	var err error
+	if *chainID != 0 {
+		if result.Root, err = e.service.GetSyncedRoot(ctx, result.TreeID, *chainID); err != nil {
+			return err
+		}
+		proof, err = e.service.GetSyncedProof(ctx, result.TreeID, result.NodeID, *chainID)
+	} else {
+		if result.Root, err = e.service.GetRoot(ctx, result.TreeID); err != nil {
+			return err
+		}
+		proof, err = e.service.GetProof(ctx, result.TreeID, result.NodeID)
+	}
+	if err != nil {
+		return err
+	}
+	result.Proof = make([]hexutil.Bytes, len(proof))
+	for i, hash := range proof {
+		result.Proof[i] = hash
+	}
+	if !bytes.Equal(merkletree.RootFromProof(result.Leaf, proof), result.Root) {
+		return fmt.Errorf("proof of node %d of tree ID %d does not lead to root %s", result.NodeID, result.TreeID, result.Root)
+	}
+
+	return e.print(result, func(w io.Writer) {
+		fmt.Fprintf(w, "tree / node:\t%d / %d\n", result.TreeID, result.NodeID)
+		if result.ChainID != 0 {
+			fmt.Fprintf(w, "chain:\t%d\n", result.ChainID)
+		}
+		fmt.Fprintf(w, "leaf:\t%s\n", result.Leaf)
+		fmt.Fprintf(w, "root:\t%s\n", result.Root)
+		for i, hash := range result.Proof {
+			fmt.Fprintf(w, "proof[%d]:\t%s\n", i, hash)
+		}
+	})
+}
+
+func runVerify(ctx context.Context, e *env, args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	leafHex := flags.String("leaf", "", "leaf hash")
+	proofHex := flags.String("proof", "", "comma-separated hashes of the proof, from the leaf up")
+	rootHex := flags.String("root", "", "expected root, instead of --tree")
+	treeID := flags.Int("tree", 0, "tree whose root is expected")
+	chainID := flags.Uint64("chain", 0, "chain whose synced root of --tree is expected, defaults to the current root")
+	if err := parse(flags, args, e.stderr); err != nil {
+		return err
+	}
+	if *leafHex == "" || *proofHex == "" {
+		return usagef("--leaf and --proof are required")
+	}
+	if (*rootHex == "") == (*treeID <= 0) {
+		return usagef("exactly one of --root and --tree is required")
+	}
+
+	leaf, err := decodeHash("leaf", *leafHex)
+	if err != nil {
+		return err
+	}
+	var proof [][]byte
+	for _, value := range strings.Split(*proofHex, ",") {
+		hash, err := decodeHash("proof", strings.TrimSpace(value))
+		if err != nil {
+			return err
+		}
+		proof = append(proof, hash)
+	}
+
+	result := &verifyResult{Leaf: leaf, ComputedRoot: merkletree.RootFromProof(leaf, proof)}
+	switch {
+	case *rootHex != "":
+		result.Root, err = decodeHash("root", *rootHex)
+	case *chainID != 0:
+		result.Root, err = e.service.GetSyncedRoot(ctx, *treeID, *chainID)
+	default:
+		result.Root, err = e.service.GetRoot(ctx, *treeID)
+	}
+	if err != nil {
+		return err
+	}
+	result.Valid = bytes.Equal(result.ComputedRoot, result.Root)
+
+	if err := e.print(result, func(w io.Writer) {
+		fmt.Fprintf(w, "leaf:\t%s\n", result.Leaf)
+		fmt.Fprintf(w, "computed root:\t%s\n", result.ComputedRoot)
+		fmt.Fprintf(w, "root:\t%s\n", result.Root)
+		fmt.Fprintf(w, "valid:\t%t\n", result.Valid)
+	}); err != nil {
+		return err
+	}
+	if !result.Valid {
+		return fmt.Errorf("the proof does not lead to the root")
+	}
+	return nil
+}
+
+func runResync(ctx context.Context, e *env, args []string) error {
+	flags := flag.NewFlagSet("resync", flag.ContinueOnError)
+	treeID := flags.Int("tree", 0, "ID of the tree to sync again")
+	chainID := flags.Uint64("chain", 0, "chain to sync the tree to")
+	if err := parse(flags, args, e.stderr); err != nil {
+		return err
+	}
+	if *treeID <= 0 || *chainID == 0 {
+		return usagef("--tree and --chain are required")
+	}
+
+	tree, err := e.merkle.GetTreeSnapshot(ctx, *treeID)
+	if err != nil {
+		return err
+	}
+	if tree == nil {
+		return fmt.Errorf("tree ID %d does not exist", *treeID)
+	}
+	if err := e.merkle.MarkTreesForSync(ctx, *chainID, []int{*treeID}); err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stdout, "tree ID %d is marked for sync on chain %d, the next sync run anchors its root\n", *treeID, *chainID)
+	return nil
+}
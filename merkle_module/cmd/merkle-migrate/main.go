@@ -0,0 +1,176 @@
+// merkle-migrate applies, reverts and lists the schema migrations of the merkle database.
+//
+//	merkle-migrate up
+//	merkle-migrate --steps 2 down
+//	merkle-migrate --dialect sqlite --db merkle.db status
+//
+// The Postgres database defaults to DATABASE_URL and the SQLite one to SQLITE_PATH.
+// The exit code is 0 on success, 1 when a migration fails and 2 on usage errors.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"merkle_module/infra/migrations"
+
+	_ "github.com/joho/godotenv/autoload"
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+const (
+	exitOK     = 0
+	exitFailed = 1
+	exitUsage  = 2
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("merkle-migrate", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: merkle-migrate [flags] up|down|status")
+		flags.PrintDefaults()
+	}
+	dialect := flags.String("dialect", "postgres", "database dialect: postgres or sqlite")
+	dsn := flags.String("db", "", "database URL, or file for sqlite, defaults to DATABASE_URL or SQLITE_PATH")
+	steps := flags.Int("steps", 1, "number of migrations reverted by down")
+	format := flags.String("format", "text", "output format of status: text or json")
+	timeout := flags.Duration("timeout", 5*time.Minute, "timeout of the whole command")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	usage := func(err error) int {
+		fmt.Fprintf(stderr, "merkle-migrate: %v\n", err)
+		return exitUsage
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return exitUsage
+	}
+	command := flags.Arg(0)
+	if command != "up" && command != "down" && command != "status" {
+		return usage(fmt.Errorf("unknown command %q", command))
+	}
+	if *steps < 1 {
+		return usage(fmt.Errorf("--steps must be at least 1"))
+	}
+	if *format != "text" && *format != "json" {
+		return usage(fmt.Errorf("unknown format %q", *format))
+	}
+
+	db, err := open(migrations.Dialect(*dialect), *dsn)
+	if err != nil {
+		return usage(err)
+	}
+	defer db.Close()
+	migrator, err := migrations.New(db, migrations.Dialect(*dialect))
+	if err != nil {
+		return usage(err)
+	}
+
+	fail := func(err error) int {
+		fmt.Fprintf(stderr, "merkle-migrate: %v\n", err)
+		return exitFailed
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	switch command {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, migration := range applied {
+			fmt.Fprintf(stdout, "applied %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			return fail(err)
+		}
+		if len(applied) == 0 {
+			fmt.Fprintln(stdout, "no pending migrations")
+		}
+	case "down":
+		reverted, err := migrator.Down(ctx, *steps)
+		for _, migration := range reverted {
+			fmt.Fprintf(stdout, "reverted %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			return fail(err)
+		}
+		if len(reverted) == 0 {
+			fmt.Fprintln(stdout, "no applied migrations")
+		}
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return fail(err)
+		}
+		if *format == "json" {
+			encoder := json.NewEncoder(stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(statuses); err != nil {
+				return fail(err)
+			}
+			return exitOK
+		}
+		printStatus(stdout, statuses)
+	}
+	return exitOK
+}
+
+// open connects to the database of the dialect without migrating it
+func open(dialect migrations.Dialect, dsn string) (*sql.DB, error) {
+	switch dialect {
+	case migrations.Postgres:
+		if dsn == "" {
+			dsn = os.Getenv("DATABASE_URL")
+		}
+		if dsn == "" {
+			return nil, fmt.Errorf("--db or DATABASE_URL is required")
+		}
+		return sql.Open("postgres", dsn)
+	case migrations.SQLite:
+		if dsn == "" {
+			dsn = os.Getenv("SQLITE_PATH")
+		}
+		if dsn == "" {
+			return nil, fmt.Errorf("--db or SQLITE_PATH is required")
+		}
+		db, err := sql.Open("sqlite", "file:"+dsn+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)")
+		if err != nil {
+			return nil, err
+		}
+		db.SetMaxOpenConns(1)
+		return db, nil
+	default:
+		return nil, fmt.Errorf("unknown dialect %q", dialect)
+	}
+}
+
+func printStatus(out io.Writer, statuses []migrations.Status) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+	for _, status := range statuses {
+		name, state, appliedAt := status.Name, "pending", ""
+		if name == "" {
+			name = "(unknown to this binary)"
+		}
+		if status.Applied {
+			state = "applied"
+			appliedAt = status.AppliedAt.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\t%s\n", status.Version, name, state, appliedAt)
+	}
+	w.Flush()
+}
@@ -0,0 +1,191 @@
+// merkle-verify checks a credential or a leaf hash against its Merkle proof envelope
+// without access to the issuer's database.
+//
+// The trusted root is either given with --root or read from the contract with --rpc:
+//
+//	merkle-verify --vc credential.json --rpc https://rpc.example
+//	merkle-verify --leaf 0x... --proof proof.json --root 0x...
+//
+// The exit code is 0 when the credential is valid, 1 when it is not and 2 on errors.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"time"
+
+	"merkle_module/vc"
+	"merkle_module/verifier"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+const (
+	exitValid   = 0
+	exitInvalid = 1
+	exitError   = 2
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("merkle-verify", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	leafHex := flags.String("leaf", "", "leaf hash (hex) to verify, instead of --vc")
+	vcFile := flags.String("vc", "", "credential file to verify")
+	proofFile := flags.String("proof", "", "proof envelope file, defaults to the proof embedded in --vc")
+	rpcURL := flags.String("rpc", "", "RPC endpoint to read the anchored root from")
+	contract := flags.String("contract", "", "contract address, defaults to the contractAddress of the proof")
+	block := flags.Int64("block", 0, "block number to read the root at, defaults to the latest block")
+	rootHex := flags.String("root", "", "trusted root (hex), instead of --rpc")
+	checkStatus := flags.Bool("status", true, "check the revocation status of --vc")
+	format := flags.String("format", "text", "output format: text or json")
+	timeout := flags.Duration("timeout", 30*time.Second, "timeout of RPC and status list requests")
+	if err := flags.Parse(args); err != nil {
+		return exitError
+	}
+
+	fail := func(err error) int {
+		fmt.Fprintf(stderr, "merkle-verify: %v\n", err)
+		return exitError
+	}
+	if (*leafHex == "") == (*vcFile == "") {
+		return fail(errors.New("exactly one of --leaf and --vc is required"))
+	}
+	if (*rpcURL == "") == (*rootHex == "") {
+		return fail(errors.New("exactly one of --rpc and --root is required"))
+	}
+	if *format != "text" && *format != "json" {
+		return fail(fmt.Errorf("unknown format %q", *format))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	var envelope *vc.MerkleProof
+	if *proofFile != "" {
+		data, err := os.ReadFile(*proofFile)
+		if err != nil {
+			return fail(err)
+		}
+		envelope = &vc.MerkleProof{}
+		if err := json.Unmarshal(data, envelope); err != nil {
+			return fail(fmt.Errorf("invalid proof envelope: %w", err))
+		}
+	}
+
+	var roots vc.RootSource
+	if *rootHex != "" {
+		root, err := hexutil.Decode(*rootHex)
+		if err != nil {
+			return fail(fmt.Errorf("invalid root: %w", err))
+		}
+		roots = vc.TrustedRoot(root)
+	} else {
+		client, err := ethclient.DialContext(ctx, *rpcURL)
+		if err != nil {
+			return fail(fmt.Errorf("failed to connect to %s: %w", *rpcURL, err))
+		}
+		defer client.Close()
+
+		var address common.Address
+		if *contract != "" {
+			if !common.IsHexAddress(*contract) {
+				return fail(fmt.Errorf("invalid contract address %q", *contract))
+			}
+			address = common.HexToAddress(*contract)
+		}
+		var blockNumber *big.Int
+		if *block > 0 {
+			blockNumber = big.NewInt(*block)
+		}
+		chainID, err := client.ChainID(ctx)
+		if err != nil {
+			return fail(fmt.Errorf("failed to get chain ID: %w", err))
+		}
+		contractRoots := vc.NewContractRootSource(client, address, blockNumber)
+		contractRoots.SetChainID(chainID.Uint64())
+		roots = contractRoots
+	}
+
+	var result *verifier.Result
+	if *leafHex != "" {
+		if envelope == nil {
+			return fail(errors.New("--proof is required with --leaf"))
+		}
+		leaf, err := hexutil.Decode(*leafHex)
+		if err != nil {
+			return fail(fmt.Errorf("invalid leaf: %w", err))
+		}
+		result, err = verifier.New(roots, nil).VerifyLeaf(ctx, leaf, envelope)
+		if err != nil {
+			return fail(err)
+		}
+	} else {
+		document, err := os.ReadFile(*vcFile)
+		if err != nil {
+			return fail(err)
+		}
+		var status verifier.StatusChecker
+		if *checkStatus {
+			status = verifier.NewStatusListChecker(nil)
+		}
+		v := verifier.New(roots, status)
+		if envelope != nil {
+			result, err = v.VerifyCredentialWithProof(ctx, document, envelope)
+		} else {
+			result, err = v.VerifyCredential(ctx, document)
+		}
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(result); err != nil {
+			return fail(err)
+		}
+	} else {
+		printText(stdout, result)
+	}
+
+	if !result.Valid() {
+		return exitInvalid
+	}
+	return exitValid
+}
+
+func printText(w io.Writer, result *verifier.Result) {
+	fmt.Fprintf(w, "verdict:       %s\n", result.Verdict)
+	fmt.Fprintf(w, "leaf:          %s\n", result.Leaf)
+	fmt.Fprintf(w, "tree / leaf:   %d / %d\n", result.TreeIndex, result.LeafIndex)
+	if result.Issuer != "" {
+		fmt.Fprintf(w, "issuer:        %s\n", result.Issuer)
+	}
+	if result.ChainID != 0 {
+		fmt.Fprintf(w, "chain:         %d\n", result.ChainID)
+	}
+	if result.CheckpointIndex != 0 {
+		fmt.Fprintf(w, "tree root:     %s\n", result.TreeRoot)
+		fmt.Fprintf(w, "checkpoint:    %d\n", result.CheckpointIndex)
+	}
+	fmt.Fprintf(w, "computed root: %s\n", result.ComputedRoot)
+	if result.TrustedRoot != "" {
+		fmt.Fprintf(w, "trusted root:  %s\n", result.TrustedRoot)
+	}
+	if result.Reason != "" {
+		fmt.Fprintf(w, "reason:        %s\n", result.Reason)
+	}
+}
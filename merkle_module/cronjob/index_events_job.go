@@ -0,0 +1,29 @@
+package cronjob
+
+import (
+	"context"
+	"log"
+	"merkle_module/indexer"
+)
+
+type IndexEventsJob struct {
+	ctx     context.Context
+	indexer *indexer.Indexer
+}
+
+func NewIndexEventsJob(ctx context.Context, indexer *indexer.Indexer) *IndexEventsJob {
+	return &IndexEventsJob{
+		ctx:     ctx,
+		indexer: indexer,
+	}
+}
+
+// Run indexes the contract events of the new blocks, implementing the Job interface.
+func (j *IndexEventsJob) Run() {
+	block, err := j.indexer.Sync(j.ctx)
+	if err != nil {
+		log.Printf("Error indexing contract events: %v", err)
+		return
+	}
+	log.Printf("Contract events indexed up to block %d", block)
+}
@@ -0,0 +1,358 @@
+package cronjob
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"merkle_module/domain/entities"
+	"merkle_module/domain/repo"
+	"merkle_module/merkletree"
+)
+
+const (
+	IntegrityGap          = "gap"           // a node below the last node of the tree is missing
+	IntegrityDuplicate    = "duplicate"     // a node ID is held twice
+	IntegrityBeyondCount  = "beyond_count"  // a node lies beyond the node count of its tree
+	IntegrityUnfilled     = "unfilled"      // nodes reserved after the last node were never filled
+	IntegrityRootMismatch = "root_mismatch" // a recorded root or the seal differs from the root of the leaves
+	IntegritySyncMismatch = "sync_mismatch" // the last confirmed root on a chain differs from the root of the leaves
+	IntegritySavedRoot    = "saved_root"    // the root node saved for the last size, the one served, differs from the root of the leaves or is saved at fewer leaves
+	IntegrityOnChain      = "on_chain"      // the root on chain drifted, as reported by ReconcileJob
+)
+
+// ReservationGrace is the time after which a reserved node that was never filled is considered
+// abandoned, AddLeaf fills its reservation right away and the reapReservations job fills the
+// expired ones before
+const ReservationGrace = 10 * time.Minute
+
+type IntegrityIssue struct {
+	TreeID    int    `json:"tree_id"`
+	IssuerDID string `json:"issuer_did"`
+	Kind      string `json:"kind"`
+	NodeIDs   []int  `json:"node_ids,omitempty"` // nodes of a gap, duplicate, beyond count or unfilled issue
+	Size      int    `json:"size,omitempty"`     // size of a mismatching root
+	ChainID   uint64 `json:"chain_id,omitempty"` // chain of a sync mismatch or on-chain drift
+	Detail    string `json:"detail"`
+	Repaired  bool   `json:"repaired"`
+}
+
+type IntegrityReport struct {
+	Checked int               `json:"checked"`
+	Issues  []*IntegrityIssue `json:"issues"`
+}
+
+type IntegrityJob struct {
+	ctx         context.Context
+	repo        repo.Merkle
+	reconcilers []*ReconcileJob
+	grace       time.Duration
+}
+
+// NewIntegrityJob checks every tree of the repository, and the roots on chain with the reconcilers
+func NewIntegrityJob(ctx context.Context, repo repo.Merkle, reconcilers ...*ReconcileJob) *IntegrityJob {
+	return &IntegrityJob{
+		ctx:         ctx,
+		repo:        repo,
+		reconcilers: reconcilers,
+		grace:       ReservationGrace,
+	}
+}
+
+// SetReservationGrace sets the time after which an unfilled reservation is released by a repair
+func (j *IntegrityJob) SetReservationGrace(grace time.Duration) {
+	j.grace = grace
+}
+
+// Run checks the trees without repairing them, implementing the Job interface.
+func (j *IntegrityJob) Run() {
+	report, err := j.Check(j.ctx, false)
+	if err != nil {
+		log.Printf("Error checking tree integrity: %v", err)
+		return
+	}
+
+	for _, issue := range report.Issues {
+		log.Printf("Integrity issue on Tree ID %d (%s): %s, nodes %v, chain %d: %s", issue.TreeID, issue.IssuerDID, issue.Kind, issue.NodeIDs, issue.ChainID, issue.Detail)
+	}
+	log.Printf("Checked the integrity of %d trees: %d issues", report.Checked, len(report.Issues))
+}
+
+// Check scans every tree for missing, duplicate and out of range nodes, and recomputes its
+// recorded roots, confirmed syncs and seal from its leaves. When repair is set:
+//   - the node count of a tree whose last reservations were never filled is lowered to its last
+//     node, once no node was reserved in it for the reservation grace
+//   - the node count of a tree holding nodes beyond it is raised to its last node
+//   - the trees whose confirmed root or root on chain is wrong are marked for sync
+//   - the nodes saved for the last size of a tree whose saved root is wrong are saved again
+//
+// Gaps and duplicates are left for an operator: node IDs are part of the proofs already handed
+// out, so nodes are never renumbered.
+func (j *IntegrityJob) Check(ctx context.Context, repair bool) (*IntegrityReport, error) {
+	issuers, err := j.repo.GetIssuers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get issuers: %w", err)
+	}
+
+	report := &IntegrityReport{}
+	for _, issuer := range issuers {
+		trees, err := j.repo.GetIssuerTrees(ctx, issuer.IssuerDID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get trees of issuer %s: %w", issuer.IssuerDID, err)
+		}
+		for _, tree := range trees {
+			snapshot, err := j.repo.GetTreeSnapshot(ctx, tree.ID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read tree ID %d: %w", tree.ID, err)
+			}
+			if snapshot == nil {
+				continue
+			}
+			report.Checked++
+
+			issues := checkTreeIntegrity(snapshot)
+			savedRoot, err := j.checkSavedRoot(ctx, snapshot, repair)
+			if err != nil {
+				return nil, err
+			}
+			if savedRoot != nil {
+				issues = append(issues, savedRoot)
+			}
+			if repair {
+				if err := j.repairTree(ctx, snapshot, issues); err != nil {
+					return nil, err
+				}
+			}
+			report.Issues = append(report.Issues, issues...)
+		}
+	}
+
+	for _, reconciler := range j.reconcilers {
+		drifts, err := reconciler.Reconcile(ctx, repair)
+		if err != nil {
+			return nil, fmt.Errorf("failed to reconcile chain %d: %w", reconciler.chainID, err)
+		}
+		for _, drift := range drifts.Drifts {
+			issue := &IntegrityIssue{
+				TreeID:    drift.TreeID,
+				IssuerDID: drift.IssuerDID,
+				Kind:      IntegrityOnChain,
+				ChainID:   reconciler.chainID,
+				Detail:    fmt.Sprintf("%s, expected root %x, on-chain root %x", drift.Kind, drift.ExpectedRoot, drift.OnChainRoot),
+				Repaired:  repair && drift.Kind != DriftUnresolved,
+			}
+			if drift.Err != nil {
+				issue.Detail = fmt.Sprintf("%s: %v", drift.Kind, drift.Err)
+			}
+			report.Issues = append(report.Issues, issue)
+		}
+	}
+
+	return report, nil
+}
+
+// helper function to compare the root node saved for the last size of a tree with the root of
+// its leaves at that size, and that size with the leaves of the tree. The nodes of the leaves
+// are saved again when repair is set.
+func (j *IntegrityJob) checkSavedRoot(ctx context.Context, snapshot *entities.TreeSnapshot, repair bool) (*IntegrityIssue, error) {
+	tree := snapshot.Tree
+	hashes, err := j.repo.GetNodeHashes(ctx, tree.ID, math.MaxInt32, []int{1})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the saved root of tree ID %d: %w", tree.ID, err)
+	}
+	if len(hashes) != 1 || hashes[0].Size > len(snapshot.Nodes) {
+		return nil, nil
+	}
+	saved := hashes[0]
+	leaves := make([][]byte, len(snapshot.Nodes))
+	for i, node := range snapshot.Nodes {
+		if node.NodeID != i+1 {
+			// The gap is reported already, the leaves do not lead to any root
+			return nil, nil
+		}
+		leaves[i] = node.Data
+	}
+	full, err := merkletree.NewMerkleTree(leaves, tree.ID)
+	if err != nil {
+		return nil, err
+	}
+	built := full
+	if saved.Size < full.Size() {
+		if built, err = merkletree.NewMerkleTree(leaves[:saved.Size], tree.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	issue := &IntegrityIssue{
+		TreeID:    tree.ID,
+		IssuerDID: tree.IssuerDID,
+		Kind:      IntegritySavedRoot,
+		Size:      saved.Size,
+	}
+	var repairs []*entities.NodeHash
+	switch {
+	case !bytes.Equal(saved.Hash, built.GetMerkleRoot()):
+		issue.Detail = fmt.Sprintf("saved root %x at size %d, the leaves lead to %x", saved.Hash, saved.Size, built.GetMerkleRoot())
+		repairs = built.GetNodeHashes(1)
+		if full.Size() > built.Size() {
+			repairs = append(repairs, full.GetNodeHashes(1)...)
+		}
+	case saved.Size < full.Size():
+		// The proofs are then built from the leaves, saving the nodes again serves them from the database
+		issue.Detail = fmt.Sprintf("saved root at size %d, the tree holds %d leaves", saved.Size, full.Size())
+		repairs = full.GetNodeHashes(1)
+	default:
+		return nil, nil
+	}
+	if repair {
+		if err := j.repo.SaveNodeHashes(ctx, repairs); err != nil {
+			return nil, fmt.Errorf("failed to save the nodes of tree ID %d: %w", tree.ID, err)
+		}
+		root := &entities.TreeRoot{TreeID: tree.ID, Size: full.Size(), Root: full.GetMerkleRoot()}
+		if err := j.repo.AddTreeRoots(ctx, []*entities.TreeRoot{root}); err != nil {
+			return nil, fmt.Errorf("failed to record the root of tree ID %d: %w", tree.ID, err)
+		}
+		issue.Repaired = true
+	}
+	return issue, nil
+}
+
+// helper function to find the issues of a tree from its snapshot
+func checkTreeIntegrity(snapshot *entities.TreeSnapshot) []*IntegrityIssue {
+	tree := snapshot.Tree
+	newIssue := func(kind string) *IntegrityIssue {
+		return &IntegrityIssue{TreeID: tree.ID, IssuerDID: tree.IssuerDID, Kind: kind}
+	}
+	var issues []*IntegrityIssue
+
+	// Nodes are read ordered by node ID
+	duplicate, beyond := newIssue(IntegrityDuplicate), newIssue(IntegrityBeyondCount)
+	last := 0
+	for i, node := range snapshot.Nodes {
+		if i > 0 && node.NodeID == snapshot.Nodes[i-1].NodeID {
+			duplicate.NodeIDs = append(duplicate.NodeIDs, node.NodeID)
+			continue
+		}
+		if node.NodeID <= 0 || node.NodeID > tree.NodeCount {
+			beyond.NodeIDs = append(beyond.NodeIDs, node.NodeID)
+		}
+		last = max(last, node.NodeID)
+	}
+	gap, unfilled := newIssue(IntegrityGap), newIssue(IntegrityUnfilled)
+	next := 0
+	for nodeID := 1; nodeID <= max(last, tree.NodeCount); nodeID++ {
+		for next < len(snapshot.Nodes) && snapshot.Nodes[next].NodeID < nodeID {
+			next++
+		}
+		if next < len(snapshot.Nodes) && snapshot.Nodes[next].NodeID == nodeID {
+			continue
+		}
+		if nodeID < last {
+			gap.NodeIDs = append(gap.NodeIDs, nodeID)
+		} else {
+			unfilled.NodeIDs = append(unfilled.NodeIDs, nodeID)
+		}
+	}
+	for _, issue := range []*IntegrityIssue{gap, duplicate, beyond, unfilled} {
+		if len(issue.NodeIDs) > 0 {
+			issue.Detail = fmt.Sprintf("node count %d, last node %d", tree.NodeCount, last)
+			issues = append(issues, issue)
+		}
+	}
+	if len(gap.NodeIDs) > 0 || len(duplicate.NodeIDs) > 0 || (len(snapshot.Nodes) > 0 && snapshot.Nodes[0].NodeID <= 0) {
+		// The leaves do not lead to any root
+		return issues
+	}
+
+	leaves := make([][]byte, len(snapshot.Nodes))
+	for i, node := range snapshot.Nodes {
+		leaves[i] = node.Data
+	}
+	rootAt := func(size int) ([]byte, error) {
+		if size <= 0 || size > len(leaves) {
+			return nil, fmt.Errorf("the tree holds %d leaves", len(leaves))
+		}
+		built, err := merkletree.NewMerkleTree(leaves[:size], tree.ID)
+		if err != nil {
+			return nil, err
+		}
+		return built.GetMerkleRoot(), nil
+	}
+	mismatch := func(kind string, size int, chainID uint64, recorded []byte) {
+		root, err := rootAt(size)
+		if err == nil && bytes.Equal(root, recorded) {
+			return
+		}
+		issue := newIssue(kind)
+		issue.Size, issue.ChainID = size, chainID
+		if err != nil {
+			issue.Detail = fmt.Sprintf("root %x at size %d: %v", recorded, size, err)
+		} else if bytes.Equal(recorded, merkletree.LegacyRoot(leaves[:size])) {
+			issue.Detail = fmt.Sprintf("root %x at size %d hashes empty leaves as nil, the leaves lead to %x", recorded, size, root)
+		} else {
+			issue.Detail = fmt.Sprintf("root %x at size %d, the leaves lead to %x", recorded, size, root)
+		}
+		issues = append(issues, issue)
+	}
+
+	for _, root := range snapshot.Roots {
+		mismatch(IntegrityRootMismatch, root.Size, 0, root.Root)
+	}
+	if snapshot.Seal != nil {
+		mismatch(IntegrityRootMismatch, len(leaves), 0, snapshot.Seal.Root)
+	}
+	// Syncs are ordered by ID, only the last confirmed one of a chain is on chain
+	lastSyncs := make(map[uint64]*entities.TreeSync)
+	var chainIDs []uint64
+	for _, treeSync := range snapshot.Syncs {
+		if lastSyncs[treeSync.ChainID] == nil {
+			chainIDs = append(chainIDs, treeSync.ChainID)
+		}
+		lastSyncs[treeSync.ChainID] = treeSync
+	}
+	for _, chainID := range chainIDs {
+		lastSync := lastSyncs[chainID]
+		mismatch(IntegritySyncMismatch, lastSync.NodeCount, chainID, lastSync.Root)
+	}
+	return issues
+}
+
+// helper function to repair the issues of a tree which can be repaired without renumbering nodes
+func (j *IntegrityJob) repairTree(ctx context.Context, snapshot *entities.TreeSnapshot, issues []*IntegrityIssue) error {
+	tree := snapshot.Tree
+	kinds := make(map[string]bool)
+	for _, issue := range issues {
+		kinds[issue.Kind] = true
+	}
+
+	for _, issue := range issues {
+		switch {
+		case issue.Kind == IntegrityUnfilled && !kinds[IntegrityGap] && !kinds[IntegrityDuplicate]:
+			nodeCount := issue.NodeIDs[0] - 1
+			set, err := j.repo.SetNodeCount(ctx, tree.ID, tree.NodeCount, nodeCount, j.grace)
+			if err != nil {
+				return fmt.Errorf("failed to release the unfilled nodes of tree ID %d: %w", tree.ID, err)
+			}
+			issue.Repaired = set
+			if !set {
+				issue.Detail += ", left as the tree was reserved in lately"
+			}
+		case issue.Kind == IntegrityBeyondCount && !kinds[IntegrityGap] && !kinds[IntegrityDuplicate] && issue.NodeIDs[0] > 0:
+			nodeCount := issue.NodeIDs[len(issue.NodeIDs)-1]
+			set, err := j.repo.SetNodeCount(ctx, tree.ID, tree.NodeCount, nodeCount, 0)
+			if err != nil {
+				return fmt.Errorf("failed to raise the node count of tree ID %d: %w", tree.ID, err)
+			}
+			issue.Repaired = set
+		case issue.Kind == IntegritySyncMismatch:
+			if err := j.repo.MarkTreesForSync(ctx, issue.ChainID, []int{tree.ID}); err != nil {
+				return fmt.Errorf("failed to mark tree ID %d for sync: %w", tree.ID, err)
+			}
+			issue.Repaired = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package cronjob
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"testing"
+
+	"merkle_module/domain/entities"
+	"merkle_module/domain/repo"
+	"merkle_module/infra/model"
+	"merkle_module/infra/storage"
+	"merkle_module/issuer"
+	"merkle_module/merkletree"
+	"merkle_module/utils"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// missingRoots is a contract on which no root was ever anchored
+type missingRoots struct{}
+
+func (missingRoots) GetTreeRoot(ctx context.Context, issuer common.Address, treeIndex int) ([32]byte, error) {
+	return [32]byte{}, nil
+}
+
+// fillNodes reserves a node of the issuer for every given leaf, adding those which are not nil
+func fillNodes(t *testing.T, merkle repo.Merkle, issuerDID string, leaves ...[]byte) int {
+	t.Helper()
+	ctx := context.Background()
+	treeID := 0
+	for _, leaf := range leaves {
+		active, err := merkle.GetActiveTreeForInserting(ctx, issuerDID)
+		if err != nil {
+			t.Fatalf("Failed to reserve a node: %v", err)
+		}
+		treeID = active.TreeID
+		if leaf == nil {
+			continue
+		}
+		if _, err := merkle.AddNode(ctx, active.TreeID, active.NodeCount, leaf); err != nil {
+			t.Fatalf("Failed to add node %d: %v", active.NodeCount, err)
+		}
+	}
+	return treeID
+}
+
+// issuesOf returns the issues of the tree by kind
+func issuesOf(report *IntegrityReport, treeID int) map[string]*IntegrityIssue {
+	issues := make(map[string]*IntegrityIssue)
+	for _, issue := range report.Issues {
+		if issue.TreeID == treeID {
+			issues[issue.Kind] = issue
+		}
+	}
+	return issues
+}
+
+func TestIntegrityJob(t *testing.T) {
+	ctx := context.Background()
+	merkle := storage.NewMerkleMemory()
+	leaf := func(i int) []byte { return utils.Hash([]byte(fmt.Sprintf("leaf %d", i))) }
+
+	// node 4 reserved but never filled, a wrong recorded root and a wrong confirmed root
+	unfilled := fillNodes(t, merkle, testIssuerDID, leaf(1), leaf(2), leaf(3), nil)
+	if err := merkle.AddTreeRoots(ctx, []*entities.TreeRoot{{TreeID: unfilled, Size: 2, Root: leaf(0)}}); err != nil {
+		t.Fatalf("Failed to add tree roots: %v", err)
+	}
+	treeSync := &entities.TreeSync{TreeID: unfilled, ChainID: 1, NodeCount: 3, Root: leaf(0)}
+	if err := merkle.CreatePendingSyncs(ctx, []*entities.TreeSync{treeSync}); err != nil {
+		t.Fatalf("Failed to create pending sync: %v", err)
+	}
+	if err := merkle.SetSyncTransaction(ctx, []int{treeSync.ID}, "0x01", 1); err != nil {
+		t.Fatalf("Failed to set sync transaction: %v", err)
+	}
+	if err := merkle.ConfirmSyncs(ctx, 1, "0x01", 10); err != nil {
+		t.Fatalf("Failed to confirm syncs: %v", err)
+	}
+	// node 2 reserved but never filled below node 3
+	gapped := fillNodes(t, merkle, "did:example:gapped", leaf(1), nil, leaf(3))
+	// node 2 added without a reservation
+	beyond := fillNodes(t, merkle, "did:example:beyond", leaf(1))
+	if _, err := merkle.AddNode(ctx, beyond, 2, leaf(2)); err != nil {
+		t.Fatalf("Failed to add node: %v", err)
+	}
+	healthy := fillNodes(t, merkle, "did:example:healthy", leaf(1), leaf(2))
+
+	reconciler := NewReconcileJob(ctx, merkle, 1, missingRoots{}, issuer.StaticResolver{testIssuerDID: common.HexToAddress("0x0c")})
+	job := NewIntegrityJob(ctx, merkle, reconciler)
+	report, err := job.Check(ctx, false)
+	if err != nil {
+		t.Fatalf("Failed to check integrity: %v", err)
+	}
+	if report.Checked != 4 {
+		t.Errorf("Expected 4 trees checked, got %d", report.Checked)
+	}
+
+	issues := issuesOf(report, unfilled)
+	if issue := issues[IntegrityUnfilled]; issue == nil || !slices.Equal(issue.NodeIDs, []int{4}) {
+		t.Errorf("Expected node 4 of Tree ID %d unfilled, got %+v", unfilled, issue)
+	}
+	if issue := issues[IntegrityRootMismatch]; issue == nil || issue.Size != 2 {
+		t.Errorf("Expected the root at size 2 of Tree ID %d to mismatch, got %+v", unfilled, issue)
+	}
+	if issue := issues[IntegritySyncMismatch]; issue == nil || issue.ChainID != 1 || issue.Size != 3 {
+		t.Errorf("Expected the confirmed root of Tree ID %d to mismatch, got %+v", unfilled, issue)
+	}
+	if issue := issues[IntegrityOnChain]; issue == nil || issue.ChainID != 1 {
+		t.Errorf("Expected the root of Tree ID %d missing on chain, got %+v", unfilled, issue)
+	}
+	if issue := issuesOf(report, gapped)[IntegrityGap]; issue == nil || !slices.Equal(issue.NodeIDs, []int{2}) {
+		t.Errorf("Expected node 2 of Tree ID %d missing, got %+v", gapped, issue)
+	}
+	if issue := issuesOf(report, beyond)[IntegrityBeyondCount]; issue == nil || !slices.Equal(issue.NodeIDs, []int{2}) {
+		t.Errorf("Expected node 2 of Tree ID %d beyond its node count, got %+v", beyond, issue)
+	}
+	if issues := issuesOf(report, healthy); len(issues) != 0 {
+		t.Errorf("Expected no issue on Tree ID %d, got %+v", healthy, issues)
+	}
+
+	// the reservation is recent, so it is left alone
+	report, err = job.Check(ctx, true)
+	if err != nil {
+		t.Fatalf("Failed to repair: %v", err)
+	}
+	if issue := issuesOf(report, unfilled)[IntegrityUnfilled]; issue == nil || issue.Repaired {
+		t.Errorf("Expected the recent reservation not to be released, got %+v", issue)
+	}
+
+	job.SetReservationGrace(0)
+	report, err = job.Check(ctx, true)
+	if err != nil {
+		t.Fatalf("Failed to repair: %v", err)
+	}
+	issues = issuesOf(report, unfilled)
+	for _, kind := range []string{IntegrityUnfilled, IntegritySyncMismatch, IntegrityOnChain} {
+		if issue := issues[kind]; issue == nil || !issue.Repaired {
+			t.Errorf("Expected the %s issue of Tree ID %d repaired, got %+v", kind, unfilled, issue)
+		}
+	}
+	if issue := issues[IntegrityRootMismatch]; issue == nil || issue.Repaired {
+		t.Errorf("Expected the recorded root to be left for an operator, got %+v", issue)
+	}
+	if issue := issuesOf(report, gapped)[IntegrityGap]; issue == nil || issue.Repaired {
+		t.Errorf("Expected the gap to be left for an operator, got %+v", issue)
+	}
+
+	report, err = job.Check(ctx, false)
+	if err != nil {
+		t.Fatalf("Failed to check integrity: %v", err)
+	}
+	if issue := issuesOf(report, unfilled)[IntegrityUnfilled]; issue != nil {
+		t.Errorf("Expected the unfilled reservation released, got %+v", issue)
+	}
+	if issue := issuesOf(report, beyond)[IntegrityBeyondCount]; issue != nil {
+		t.Errorf("Expected the node count raised over node 2, got %+v", issue)
+	}
+	trees, err := merkle.GetTreesWithNodesForSync(ctx, 1)
+	if err != nil {
+		t.Fatalf("Failed to get trees for sync: %v", err)
+	}
+	if !slices.ContainsFunc(trees, func(tree *model.MerkleTreeWithNodes) bool {
+		return tree.Tree.ID == unfilled && tree.Tree.NodeCount == 3
+	}) {
+		t.Errorf("Expected Tree ID %d due for sync at its 3 nodes", unfilled)
+	}
+}
+
+func TestIntegrityJobSavedRoot(t *testing.T) {
+	ctx := context.Background()
+	merkle := storage.NewMerkleMemory()
+	leaf := func(i int) []byte { return utils.Hash([]byte(fmt.Sprintf("leaf %d", i))) }
+	treeID := fillNodes(t, merkle, testIssuerDID, leaf(1), leaf(2))
+
+	// the root served for the tree is saved from other leaves
+	if err := merkle.SaveNodeHashes(ctx, []*entities.NodeHash{{TreeID: treeID, Index: 1, Size: 2, Hash: leaf(0)}}); err != nil {
+		t.Fatalf("Failed to save node hashes: %v", err)
+	}
+	job := NewIntegrityJob(ctx, merkle)
+	report, err := job.Check(ctx, false)
+	if err != nil {
+		t.Fatalf("Failed to check integrity: %v", err)
+	}
+	if issue := issuesOf(report, treeID)[IntegritySavedRoot]; issue == nil || issue.Size != 2 || issue.Repaired {
+		t.Fatalf("Expected the saved root at size 2 of Tree ID %d to mismatch, got %+v", treeID, issue)
+	}
+
+	// the nodes are saved again from the leaves
+	report, err = job.Check(ctx, true)
+	if err != nil {
+		t.Fatalf("Failed to repair: %v", err)
+	}
+	if issue := issuesOf(report, treeID)[IntegritySavedRoot]; issue == nil || !issue.Repaired {
+		t.Fatalf("Expected the saved root of Tree ID %d repaired, got %+v", treeID, issue)
+	}
+	if report, err := job.Check(ctx, false); err != nil || len(report.Issues) != 0 {
+		t.Errorf("Expected no issue once the nodes are saved again, got %+v and error %v", report, err)
+	}
+
+	// a leaf added without saving the nodes leaves the saved root at the size before it
+	fillNodes(t, merkle, testIssuerDID, leaf(3))
+	report, err = job.Check(ctx, false)
+	if err != nil {
+		t.Fatalf("Failed to check integrity: %v", err)
+	}
+	if issue := issuesOf(report, treeID)[IntegritySavedRoot]; issue == nil || issue.Size != 2 || issue.Repaired {
+		t.Fatalf("Expected the saved root at size 2 of Tree ID %d reported behind its 3 leaves, got %+v", treeID, issue)
+	}
+	if _, err := job.Check(ctx, true); err != nil {
+		t.Fatalf("Failed to repair: %v", err)
+	}
+	expected, err := merkletree.NewMerkleTree([][]byte{leaf(1), leaf(2), leaf(3)}, treeID)
+	if err != nil {
+		t.Fatalf("Failed to build tree: %v", err)
+	}
+	hashes, err := merkle.GetNodeHashes(ctx, treeID, math.MaxInt32, []int{1})
+	if err != nil || len(hashes) != 1 || hashes[0].Size != 3 || !bytes.Equal(hashes[0].Hash, expected.GetMerkleRoot()) {
+		t.Fatalf("Expected the root of Tree ID %d saved at size 3, got %v and error %v", treeID, hashes, err)
+	}
+	if root, err := merkle.GetTreeRoot(ctx, treeID, 3); err != nil || root == nil || !bytes.Equal(root.Root, expected.GetMerkleRoot()) {
+		t.Errorf("Expected the root of Tree ID %d recorded at size 3, got %+v and error %v", treeID, root, err)
+	}
+	if report, err := job.Check(ctx, false); err != nil || len(report.Issues) != 0 {
+		t.Errorf("Expected no issue once the nodes are saved at size 3, got %+v and error %v", report, err)
+	}
+}
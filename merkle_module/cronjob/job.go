@@ -3,32 +3,146 @@ package cronjob
 import (
 	"context"
 	"log"
+	"merkle_module/cache"
 	"merkle_module/domain/repo"
+	"merkle_module/indexer"
+	"merkle_module/issuer"
 	credential "merkle_module/smartcontract"
 )
 
 type AsyncJob struct {
-	ctx           context.Context
-	jobManager    *JobManager
-	repo          repo.Merkle
-	smartContract *credential.SmartContract
+	ctx            context.Context
+	jobManager     *JobManager
+	repo           repo.Merkle
+	statusListRepo repo.StatusList
+	smartContract  *credential.SmartContract
+	indexer        *indexer.Indexer
+	chains         []*Chain
+	confirmations  uint64
+	issuers        issuer.Resolver
+	syncGasBudget  uint64
+	trees          cache.TreeCache
 }
 
-func NewAsyncJob(ctx context.Context, repo repo.Merkle, smartContract *credential.SmartContract) *AsyncJob {
+func NewAsyncJob(ctx context.Context, repo repo.Merkle, statusListRepo repo.StatusList, smartContract *credential.SmartContract) *AsyncJob {
 	jobManager := NewJobManager()
 	return &AsyncJob{
-		ctx:           ctx,
-		jobManager:    jobManager,
-		repo:          repo,
-		smartContract: smartContract,
+		ctx:            ctx,
+		jobManager:     jobManager,
+		repo:           repo,
+		statusListRepo: statusListRepo,
+		smartContract:  smartContract,
 	}
 }
 
+// AddChain adds a chain to anchor the tree roots to, with its own sync and reconcile jobs;
+// it must be called before Start. The smart contract of NewAsyncJob only publishes status lists.
+func (aj *AsyncJob) AddChain(chain *Chain) {
+	aj.chains = append(aj.chains, chain)
+}
+
+// SetIssuerResolver sets how issuer DIDs are mapped to the addresses roots are anchored under
+func (aj *AsyncJob) SetIssuerResolver(issuers issuer.Resolver) {
+	aj.issuers = issuers
+}
+
+// SetSyncGasBudget sets the estimated gas above which the roots are split into several transactions,
+// for the chains without a gas budget of their own
+func (aj *AsyncJob) SetSyncGasBudget(gasBudget uint64) {
+	aj.syncGasBudget = gasBudget
+}
+
+// SetConfirmations sets the confirmations a root transaction needs before the sync is confirmed,
+// for the chains without confirmations of their own
+func (aj *AsyncJob) SetConfirmations(confirmations uint64) {
+	aj.confirmations = confirmations
+}
+
+// SetIndexer enables the contract event indexing job, it must be called before Start
+func (aj *AsyncJob) SetIndexer(indexer *indexer.Indexer) {
+	aj.indexer = indexer
+}
+
+// SetTreeCache sets the cache the Merkle services share, for the jobs which change trees outside
+// of them to remove the trees they change
+func (aj *AsyncJob) SetTreeCache(trees cache.TreeCache) {
+	aj.trees = trees
+}
+
 func (aj *AsyncJob) Start() {
-	// Add a job to sync the Merkle root
-	syncMerkleJob := NewSyncMerkleJob(aj.ctx, aj.repo, aj.smartContract)
-	if err := aj.jobManager.AddJob("syncMerkleRoot", "@every 10s", syncMerkleJob); err != nil {
-		log.Printf("Failed to add syncMerkleRoot job: %v", err)
+	if len(aj.chains) == 0 {
+		log.Println("No chain to anchor Merkle roots to, skipping syncMerkleRoot job")
+	}
+	for _, chain := range aj.chains {
+		if chain.Confirmations == 0 {
+			chain.Confirmations = aj.confirmations
+		}
+		if chain.GasBudget == 0 {
+			chain.GasBudget = aj.syncGasBudget
+		}
+		schedule := chain.Schedule
+		if schedule == "" {
+			schedule = DefaultSyncSchedule
+		}
+
+		// Add a job to sync the Merkle root to the chain, at the cadence of the chain
+		name := "syncMerkleRoot/" + chain.Name
+		syncMerkleJob := NewSyncMerkleJob(aj.ctx, aj.repo, chain, aj.issuers)
+		if err := aj.jobManager.AddJob(name, schedule, syncMerkleJob); err != nil {
+			log.Printf("Failed to add %s job: %v", name, err)
+		}
+
+		// Add a job to reconcile the synced roots with the contract of the chain
+		if chain.Contract != nil {
+			name := "reconcileMerkleRoot/" + chain.Name
+			reconcileJob := NewReconcileJob(aj.ctx, aj.repo, chain.ChainID, chain.Contract, aj.issuers)
+			if err := aj.jobManager.AddJob(name, "@every 10m", reconcileJob); err != nil {
+				log.Printf("Failed to add %s job: %v", name, err)
+			}
+		}
+	}
+
+	// Add a job to seal the full trees once synced on every chain
+	if len(aj.chains) > 0 {
+		chainIDs := make([]uint64, len(aj.chains))
+		for i, chain := range aj.chains {
+			chainIDs[i] = chain.ChainID
+		}
+		sealTreesJob := NewSealTreesJob(aj.ctx, aj.repo, chainIDs)
+		if err := aj.jobManager.AddJob("sealTrees", "@every 1h", sealTreesJob); err != nil {
+			log.Printf("Failed to add sealTrees job: %v", err)
+		}
+	}
+
+	// Add a job to fill the nodes abandoned by a process which died after reserving them
+	reapReservationsJob := NewReapReservationsJob(aj.ctx, aj.repo)
+	if aj.trees != nil {
+		reapReservationsJob.SetTreeCache(aj.trees)
+	}
+	if err := aj.jobManager.AddJob("reapReservations", "@every 1m", reapReservationsJob); err != nil {
+		log.Printf("Failed to add reapReservations job: %v", err)
+	}
+
+	// Add a job to check the integrity of the trees, the reconcile jobs check the roots on chain
+	integrityJob := NewIntegrityJob(aj.ctx, aj.repo)
+	if err := aj.jobManager.AddJob("integrityCheck", "@every 1h", integrityJob); err != nil {
+		log.Printf("Failed to add integrityCheck job: %v", err)
+	}
+
+	// Add a job to publish the changed status lists
+	if aj.statusListRepo != nil {
+		syncStatusListJob := NewSyncStatusListJob(aj.ctx, aj.statusListRepo, aj.smartContract, aj.issuers)
+		if err := aj.jobManager.AddJob("syncStatusList", "@every 1m", syncStatusListJob); err != nil {
+			log.Printf("Failed to add syncStatusList job: %v", err)
+		}
+	}
+
+	// Add a job to index the contract events
+	if aj.indexer != nil {
+		indexEventsJob := NewIndexEventsJob(aj.ctx, aj.indexer)
+		if err := aj.jobManager.AddJob("indexEvents", "@every 15s", indexEventsJob); err != nil {
+			log.Printf("Failed to add indexEvents job: %v", err)
+		}
 	}
 
 	aj.jobManager.Start()
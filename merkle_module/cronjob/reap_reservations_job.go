@@ -0,0 +1,107 @@
+package cronjob
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"merkle_module/cache"
+	"merkle_module/domain/entities"
+	"merkle_module/domain/repo"
+	"merkle_module/merkletree"
+	"merkle_module/utils"
+)
+
+type ReapReservationsJob struct {
+	ctx   context.Context
+	repo  repo.Merkle
+	trees cache.TreeCache
+}
+
+// NewReapReservationsJob fills the nodes whose reservation expired with merkletree.EmptyLeaf.
+// A reservation expires when the process which made it died before adding its leaf, leaving a
+// gap the sync job cannot sync past. The node IDs after the gap are part of the proofs already
+// handed out, so the gap is filled rather than reassigned: the empty leaf is the value the tree
+// holds at an unfilled position, and no credential ever matches it.
+func NewReapReservationsJob(ctx context.Context, repo repo.Merkle) *ReapReservationsJob {
+	return &ReapReservationsJob{
+		ctx:  ctx,
+		repo: repo,
+	}
+}
+
+// SetTreeCache sets the cache shared with the Merkle services, the trees filled by the job are
+// removed from it
+func (j *ReapReservationsJob) SetTreeCache(trees cache.TreeCache) {
+	j.trees = trees
+}
+
+// Run reaps the expired reservations, implementing the Job interface.
+func (j *ReapReservationsJob) Run() {
+	nodes, err := j.Reap(j.ctx, time.Now())
+	if err != nil {
+		log.Printf("Error reaping node reservations: %v", err)
+		return
+	}
+
+	for _, node := range nodes {
+		log.Printf("Filled abandoned node %d of Tree ID %d with the empty leaf", node.NodeID, node.TreeID)
+	}
+}
+
+// Reap fills the nodes whose reservation expired by now and were never added, returning them.
+// The leaves added while a node was unfilled were saved without their node hashes, so the trees
+// left without an unfilled node are built again to save their nodes and root at their new size.
+func (j *ReapReservationsJob) Reap(ctx context.Context, now time.Time) ([]*entities.MerkleNode, error) {
+	nodes, err := j.repo.ReapReservations(ctx, now, merkletree.EmptyLeaf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reap reservations: %w", err)
+	}
+
+	saved := make(map[int]bool)
+	for _, node := range nodes {
+		if saved[node.TreeID] {
+			continue
+		}
+		saved[node.TreeID] = true
+		if j.trees != nil {
+			j.trees.RemoveTree(ctx, node.TreeID)
+		}
+		if err := j.saveTree(ctx, node.TreeID); err != nil {
+			log.Printf("Failed to save the nodes of Tree ID %d: %v", node.TreeID, err)
+		}
+	}
+	return nodes, nil
+}
+
+// saveTree saves the node hashes and the root of a tree at its size, unless a node is still unfilled
+func (j *ReapReservationsJob) saveTree(ctx context.Context, treeID int) error {
+	nodes, err := j.repo.GetNodesByTreeID(ctx, treeID)
+	if err != nil {
+		return fmt.Errorf("failed to get nodes by tree ID: %w", err)
+	}
+	leaves, complete := utils.LeadingLeaves(nodes)
+	if !complete {
+		return nil
+	}
+
+	tree, err := merkletree.NewMerkleTree(leaves, treeID)
+	if err != nil {
+		return fmt.Errorf("failed to build tree: %w", err)
+	}
+	hashes := tree.GetNodeHashes(1)
+	if err := j.repo.SaveNodeHashes(ctx, hashes); err != nil {
+		return fmt.Errorf("failed to save node hashes: %w", err)
+	}
+	var roots []*entities.TreeRoot
+	for _, hash := range hashes {
+		if hash.Index == 1 {
+			roots = append(roots, &entities.TreeRoot{TreeID: hash.TreeID, Size: hash.Size, Root: hash.Hash})
+		}
+	}
+	if err := j.repo.AddTreeRoots(ctx, roots); err != nil {
+		return fmt.Errorf("failed to add tree roots: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package cronjob
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"merkle_module/app/services"
+	"merkle_module/cache"
+	"merkle_module/merkletree"
+	"merkle_module/utils"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestReapReservationsJob(t *testing.T) {
+	ctx := context.Background()
+	chain := newTestChain(t)
+	job := chain.syncJob(common.Address{})
+
+	// the process dies after reserving node 3, the restarted service adds the next leaf to node 4
+	nodes, _ := chain.addLeaves(t, 0, 2)
+	treeID := nodes[0].TreeID
+	if _, err := chain.repo.GetActiveTreeForInserting(ctx, testIssuerDID); err != nil {
+		t.Fatalf("Failed to reserve a node: %v", err)
+	}
+	restarted := services.NewMerkleService(chain.repo, cache.NewLRU(10), nil)
+	leaf := utils.Hash([]byte("credential after the gap"))
+	node, err := restarted.AddLeaf(ctx, testIssuerDID, leaf)
+	if err != nil {
+		t.Fatalf("Failed to add leaf: %v", err)
+	}
+	if node.TreeID != treeID || node.NodeID != 4 {
+		t.Fatalf("Expected the leaf added to its reserved node 4 of Tree ID %d, got %+v", treeID, node)
+	}
+
+	// the tree is synced up to the gap, and only once
+	job.Run()
+	lastSync, err := chain.repo.GetLastConfirmedSync(ctx, treeID, chain.chainID)
+	if err != nil || lastSync == nil || lastSync.NodeCount != 2 {
+		t.Fatalf("Expected Tree ID %d synced up to node 2, got %+v and error %v", treeID, lastSync, err)
+	}
+	job.Run()
+	if again, err := chain.repo.GetLastConfirmedSync(ctx, treeID, chain.chainID); err != nil || again.ID != lastSync.ID {
+		t.Fatalf("Expected Tree ID %d not synced again before the gap is filled, got %+v and error %v", treeID, again, err)
+	}
+
+	reaper := NewReapReservationsJob(ctx, chain.repo)
+	if filled, err := reaper.Reap(ctx, time.Now()); err != nil || len(filled) != 0 {
+		t.Fatalf("Expected no reservation expired yet, got %v and error %v", filled, err)
+	}
+	// the services share a cache still holding the tree before the gap
+	trees := cache.NewLRU(10)
+	stale, err := merkletree.NewMerkleTree([][]byte{nodes[0].Data, nodes[1].Data}, treeID)
+	if err != nil {
+		t.Fatalf("Failed to build tree: %v", err)
+	}
+	trees.AddTree(ctx, stale)
+	reaper.SetTreeCache(trees)
+	filled, err := reaper.Reap(ctx, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to reap reservations: %v", err)
+	}
+	if len(filled) != 1 || filled[0].TreeID != treeID || filled[0].NodeID != 3 || !bytes.Equal(filled[0].Data, merkletree.EmptyLeaf) {
+		t.Fatalf("Expected node 3 of Tree ID %d filled with the empty leaf, got %v", treeID, filled)
+	}
+
+	// the nodes and the root of the filled tree are saved, and its stale copy is no longer cached
+	if _, ok := trees.GetTree(ctx, treeID); ok {
+		t.Errorf("Expected Tree ID %d removed from the cache", treeID)
+	}
+	hashes, err := chain.repo.GetNodeHashes(ctx, treeID, 4, []int{1})
+	if err != nil || len(hashes) != 1 || hashes[0].Size != 4 {
+		t.Fatalf("Expected the root node of Tree ID %d saved at size 4, got %v and error %v", treeID, hashes, err)
+	}
+	root, err := chain.repo.GetTreeRoot(ctx, treeID, 4)
+	if err != nil || root == nil || !bytes.Equal(root.Root, hashes[0].Hash) {
+		t.Fatalf("Expected the root of Tree ID %d recorded at size 4, got %+v and error %v", treeID, root, err)
+	}
+	saved := services.NewMerkleService(leaflessRepo{chain.repo}, cache.NewLRU(10), nil)
+	savedProof, err := saved.GetProof(ctx, treeID, node.NodeID)
+	if err != nil {
+		t.Fatalf("Failed to get the proof from the saved nodes: %v", err)
+	}
+	if !bytes.Equal(merkletree.RootFromProof(leaf, savedProof), root.Root) {
+		t.Errorf("Expected the proof of node %d read from the saved nodes to verify", node.NodeID)
+	}
+
+	// the whole tree is synced and the leaf after the gap verifies on chain
+	job.Run()
+	lastSync, err = chain.repo.GetLastConfirmedSync(ctx, treeID, chain.chainID)
+	if err != nil || lastSync == nil || lastSync.NodeCount != 4 {
+		t.Fatalf("Expected Tree ID %d synced up to node 4, got %+v and error %v", treeID, lastSync, err)
+	}
+	proof, err := restarted.GetSyncedProof(ctx, treeID, node.NodeID, chain.chainID)
+	if err != nil {
+		t.Fatalf("Failed to get synced proof: %v", err)
+	}
+	if !chain.verifyVC(t, chain.issuer, treeID, leaf, proof) {
+		t.Errorf("Expected verifyVC to accept the proof of node %d", node.NodeID)
+	}
+
+	// the tree is loaded again by the next leaf once its gap is filled
+	node, err = restarted.AddLeaf(ctx, testIssuerDID, utils.Hash([]byte("credential after the fill")))
+	if err != nil || node.NodeID != 5 {
+		t.Errorf("Expected the next leaf added to node 5, got %+v and error %v", node, err)
+	}
+}
@@ -0,0 +1,201 @@
+package cronjob
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"merkle_module/domain/entities"
+	"merkle_module/domain/repo"
+	"merkle_module/issuer"
+	"merkle_module/merkletree"
+	"merkle_module/utils"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	DriftMissing    = "missing"    // no root on chain for the tree
+	DriftMismatch   = "mismatch"   // the root on chain differs from the synced nodes
+	DriftLegacy     = "legacy"     // the root on chain is that of the synced nodes with empty leaves hashed as nil
+	DriftUnresolved = "unresolved" // the issuer address of the tree is unknown
+)
+
+// TreeRootReader reads the roots anchored in the contract
+type TreeRootReader interface {
+	GetTreeRoot(ctx context.Context, issuer common.Address, treeIndex int) ([32]byte, error)
+}
+
+type TreeDrift struct {
+	TreeID        int
+	IssuerDID     string
+	Issuer        common.Address
+	NodeCountSync int
+	ExpectedRoot  []byte
+	OnChainRoot   []byte
+	CheckpointID  int // set when the root was anchored through a checkpoint
+	Kind          string
+	Err           error
+}
+
+type DriftReport struct {
+	Checked  int
+	Drifts   []*TreeDrift
+	Repaired []int // tree IDs marked for sync again
+}
+
+type ReconcileJob struct {
+	ctx      context.Context
+	repo     repo.Merkle
+	chainID  uint64
+	contract TreeRootReader
+	issuers  issuer.Resolver
+}
+
+// NewReconcileJob reconciles the trees synced to the chain with its contract
+func NewReconcileJob(ctx context.Context, repo repo.Merkle, chainID uint64, contract TreeRootReader, issuers issuer.Resolver) *ReconcileJob {
+	if issuers == nil {
+		issuers = issuer.DIDResolver{}
+	}
+	return &ReconcileJob{
+		ctx:      ctx,
+		repo:     repo,
+		chainID:  chainID,
+		contract: contract,
+		issuers:  issuers,
+	}
+}
+
+// Run reconciles the synced trees with the contract, implementing the Job interface.
+func (j *ReconcileJob) Run() {
+	report, err := j.Reconcile(j.ctx, true)
+	if err != nil {
+		log.Printf("Error reconciling synced roots: %v", err)
+		return
+	}
+
+	for _, drift := range report.Drifts {
+		log.Printf("Drift on Tree ID %d (%s): %s, expected root %x, on-chain root %x, err: %v", drift.TreeID, drift.IssuerDID, drift.Kind, drift.ExpectedRoot, drift.OnChainRoot, drift.Err)
+	}
+	log.Printf("Reconciled %d trees on chain %d: %d drifts, %d trees marked for sync", report.Checked, j.chainID, len(report.Drifts), len(report.Repaired))
+}
+
+// Reconcile compares the root of the synced nodes of every synced tree with the root on chain,
+// and when repair is set, marks the drifted trees for sync so the next sync run anchors them again
+func (j *ReconcileJob) Reconcile(ctx context.Context, repair bool) (*DriftReport, error) {
+	if j.contract == nil {
+		return nil, fmt.Errorf("smart contract is not initialized")
+	}
+
+	trees, err := j.repo.GetSyncedTrees(ctx, j.chainID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get synced trees: %w", err)
+	}
+
+	report := &DriftReport{}
+	for _, tree := range trees {
+		report.Checked++
+
+		drift := &TreeDrift{
+			TreeID:        tree.ID,
+			IssuerDID:     tree.IssuerDID,
+			NodeCountSync: tree.NodeCountSync,
+		}
+
+		drift.Issuer, err = j.issuers.Resolve(ctx, tree.IssuerDID)
+		if err != nil {
+			// Re-syncing would not help, the root can not be anchored under a known address
+			drift.Kind = DriftUnresolved
+			drift.Err = err
+			report.Drifts = append(report.Drifts, drift)
+			continue
+		}
+
+		nodes, err := j.repo.GetNodesSyncedByTreeID(ctx, tree.ID, j.chainID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get synced nodes of tree ID %d: %w", tree.ID, err)
+		}
+		syncedTree, err := merkletree.NewMerkleTree(utils.NodesToBytes(nodes), tree.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build tree ID %d: %w", tree.ID, err)
+		}
+		drift.ExpectedRoot = syncedTree.GetMerkleRoot()
+
+		// A root committed into a checkpoint is anchored through the super-root of the checkpoint
+		anchorIssuer, anchorIndex, anchoredRoot := drift.Issuer, tree.ID, drift.ExpectedRoot
+		lastSync, err := j.repo.GetLastConfirmedSync(ctx, tree.ID, j.chainID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get last confirmed sync of tree ID %d: %w", tree.ID, err)
+		}
+		if lastSync != nil && lastSync.CheckpointID != 0 {
+			drift.CheckpointID = lastSync.CheckpointID
+			anchorIssuer, anchoredRoot, err = j.checkpointRoot(ctx, lastSync, drift.ExpectedRoot)
+			if err != nil {
+				return nil, fmt.Errorf("failed to rebuild checkpoint of tree ID %d: %w", tree.ID, err)
+			}
+			anchorIndex = lastSync.CheckpointID
+		}
+
+		onChain, err := j.contract.GetTreeRoot(ctx, anchorIssuer, anchorIndex)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get on-chain root of tree ID %d: %w", tree.ID, err)
+		}
+
+		switch {
+		case onChain == [32]byte{}:
+			drift.Kind = DriftMissing
+		case bytes.Equal(onChain[:], anchoredRoot):
+			continue
+		case drift.CheckpointID == 0 && bytes.Equal(onChain[:], merkletree.LegacyRoot(utils.NodesToBytes(nodes))):
+			// Anchored before empty leaves were the zero bytes32, it is anchored again like a mismatch
+			drift.Kind = DriftLegacy
+			drift.OnChainRoot = onChain[:]
+		default:
+			drift.Kind = DriftMismatch
+			drift.OnChainRoot = onChain[:]
+		}
+		report.Drifts = append(report.Drifts, drift)
+	}
+
+	if !repair {
+		return report, nil
+	}
+
+	for _, drift := range report.Drifts {
+		if drift.Kind != DriftUnresolved {
+			report.Repaired = append(report.Repaired, drift.TreeID)
+		}
+	}
+	if err := j.repo.MarkTreesForSync(ctx, j.chainID, report.Repaired); err != nil {
+		return nil, fmt.Errorf("failed to mark drifted trees for sync: %w", err)
+	}
+
+	return report, nil
+}
+
+// helper function to rebuild the checkpoint of a sync with the root of the synced nodes in place of
+// the committed root, returning the address the checkpoint is anchored under and its super-root
+func (j *ReconcileJob) checkpointRoot(ctx context.Context, lastSync *entities.TreeSync, root []byte) (common.Address, []byte, error) {
+	checkpoint, err := j.repo.GetCheckpoint(ctx, lastSync.CheckpointID)
+	if err != nil {
+		return common.Address{}, nil, err
+	}
+	if checkpoint == nil {
+		return common.Address{}, nil, fmt.Errorf("checkpoint %d not found", lastSync.CheckpointID)
+	}
+
+	roots, err := merkletree.CheckpointRoots(checkpoint)
+	if err != nil {
+		return common.Address{}, nil, err
+	}
+	if lastSync.CheckpointPosition >= len(roots) {
+		return common.Address{}, nil, fmt.Errorf("checkpoint %d has no root at position %d", checkpoint.ID, lastSync.CheckpointPosition)
+	}
+	roots[lastSync.CheckpointPosition].Root = root
+
+	tree, err := merkletree.NewCheckpoint(roots)
+	if err != nil {
+		return common.Address{}, nil, err
+	}
+	return common.HexToAddress(checkpoint.IssuerAddress), tree.Root(), nil
+}
@@ -0,0 +1,157 @@
+package cronjob
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"merkle_module/issuer"
+	"merkle_module/merkletree"
+	"merkle_module/utils"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// markedForSync tells whether the tree is marked to be synced again to the chain
+func (c *testChain) markedForSync(t *testing.T, treeID int) bool {
+	t.Helper()
+	trees, err := c.repo.GetTreesWithNodesForSync(context.Background(), c.chainID)
+	if err != nil {
+		t.Fatalf("Failed to get trees for sync: %v", err)
+	}
+	for _, tree := range trees {
+		if tree.Tree.ID == treeID {
+			return tree.Tree.NeedSync
+		}
+	}
+	return false
+}
+
+func TestReconcileJobRepairsDriftedRoots(t *testing.T) {
+	ctx := context.Background()
+	chain := newTestChain(t)
+	job := chain.syncJob(common.Address{})
+	resolver := issuer.StaticResolver{testIssuerDID: chain.issuer}
+	reconciler := NewReconcileJob(ctx, chain.repo, chain.chainID, chain.contract, resolver)
+
+	nodes, _ := chain.addLeaves(t, 0, 3)
+	treeID := nodes[0].TreeID
+	job.Run()
+	expected, err := chain.merkle.GetSyncedRoot(ctx, treeID, chain.chainID)
+	if err != nil {
+		t.Fatalf("Failed to get synced root: %v", err)
+	}
+
+	// another writer overwrites the root on chain
+	overwritten := utils.Hash([]byte("overwritten root"))
+	if err := chain.contract.SendRoot([]common.Address{chain.issuer}, []int{treeID}, [][32]byte{utils.ToByte32(overwritten)}); err != nil {
+		t.Fatalf("Failed to overwrite root: %v", err)
+	}
+
+	// the drift is reported without marking the tree when not repairing
+	report, err := reconciler.Reconcile(ctx, false)
+	if err != nil {
+		t.Fatalf("Failed to reconcile: %v", err)
+	}
+	if report.Checked != 1 || len(report.Drifts) != 1 || len(report.Repaired) != 0 {
+		t.Fatalf("Expected 1 tree checked with 1 drift and no repair, got %d trees, %d drifts and %v repaired", report.Checked, len(report.Drifts), report.Repaired)
+	}
+	drift := report.Drifts[0]
+	if drift.TreeID != treeID || drift.Kind != DriftMismatch || drift.NodeCountSync != 3 || !bytes.Equal(drift.ExpectedRoot, expected) || !bytes.Equal(drift.OnChainRoot, overwritten) {
+		t.Fatalf("Expected a mismatch of Tree ID %d at node count 3 from %x to %x, got %+v", treeID, expected, overwritten, drift)
+	}
+	if chain.markedForSync(t, treeID) {
+		t.Fatalf("Expected Tree ID %d not marked for sync by a check", treeID)
+	}
+
+	// repairing marks the tree for sync, and the next sync run anchors the root again
+	report, err = reconciler.Reconcile(ctx, true)
+	if err != nil {
+		t.Fatalf("Failed to reconcile: %v", err)
+	}
+	if len(report.Repaired) != 1 || report.Repaired[0] != treeID || !chain.markedForSync(t, treeID) {
+		t.Fatalf("Expected Tree ID %d marked for sync, got %v", treeID, report.Repaired)
+	}
+	job.Run()
+	onChain, err := chain.contract.GetTreeRoot(ctx, chain.issuer, treeID)
+	if err != nil {
+		t.Fatalf("Failed to get tree root: %v", err)
+	}
+	if onChain != utils.ToByte32(expected) {
+		t.Errorf("Expected root %x anchored again, got %x", expected, onChain)
+	}
+	if report, err := reconciler.Reconcile(ctx, true); err != nil || len(report.Drifts) != 0 {
+		t.Errorf("Expected no drift once anchored again, got %+v and error %v", report, err)
+	}
+
+	// a root missing under the address of the issuer is repaired, an unknown issuer is not
+	moved := NewReconcileJob(ctx, chain.repo, chain.chainID, chain.contract, issuer.StaticResolver{testIssuerDID: common.HexToAddress("0x0d")})
+	if report, err := moved.Reconcile(ctx, false); err != nil || len(report.Drifts) != 1 || report.Drifts[0].Kind != DriftMissing {
+		t.Errorf("Expected a missing root, got %+v and error %v", report, err)
+	}
+	report, err = NewReconcileJob(ctx, chain.repo, chain.chainID, chain.contract, issuer.StaticResolver{}).Reconcile(ctx, true)
+	if err != nil {
+		t.Fatalf("Failed to reconcile: %v", err)
+	}
+	if len(report.Drifts) != 1 || report.Drifts[0].Kind != DriftUnresolved || report.Drifts[0].Err == nil || len(report.Repaired) != 0 {
+		t.Errorf("Expected an unresolved issuer left as is, got %+v", report)
+	}
+	if chain.markedForSync(t, treeID) {
+		t.Errorf("Expected Tree ID %d not marked for sync for an unknown issuer", treeID)
+	}
+}
+
+func TestReconcileJobReanchorsLegacyRoots(t *testing.T) {
+	ctx := context.Background()
+	chain := newTestChain(t)
+	job := chain.syncJob(common.Address{})
+	reconciler := NewReconcileJob(ctx, chain.repo, chain.chainID, chain.contract, issuer.StaticResolver{testIssuerDID: chain.issuer})
+
+	nodes, leaves := chain.addLeaves(t, 0, 3)
+	treeID := nodes[0].TreeID
+	job.Run()
+	expected, err := chain.merkle.GetSyncedRoot(ctx, treeID, chain.chainID)
+	if err != nil {
+		t.Fatalf("Failed to get synced root: %v", err)
+	}
+
+	// the root anchored by a deployment hashing empty leaves as nil
+	legacy := merkletree.LegacyRoot(leaves)
+	if bytes.Equal(legacy, expected) {
+		t.Fatalf("Expected the legacy root of a partial tree to differ from %x", expected)
+	}
+	if err := chain.contract.SendRoot([]common.Address{chain.issuer}, []int{treeID}, [][32]byte{utils.ToByte32(legacy)}); err != nil {
+		t.Fatalf("Failed to anchor legacy root: %v", err)
+	}
+
+	report, err := reconciler.Reconcile(ctx, true)
+	if err != nil {
+		t.Fatalf("Failed to reconcile: %v", err)
+	}
+	if len(report.Drifts) != 1 || report.Drifts[0].Kind != DriftLegacy || !bytes.Equal(report.Drifts[0].OnChainRoot, legacy) {
+		t.Fatalf("Expected the legacy root %x reported, got %+v", legacy, report.Drifts)
+	}
+	if len(report.Repaired) != 1 || !chain.markedForSync(t, treeID) {
+		t.Fatalf("Expected Tree ID %d marked for sync, got %v", treeID, report.Repaired)
+	}
+
+	// the next sync run anchors the root of the current hashing, which proofs verify against
+	job.Run()
+	onChain, err := chain.contract.GetTreeRoot(ctx, chain.issuer, treeID)
+	if err != nil {
+		t.Fatalf("Failed to get tree root: %v", err)
+	}
+	if onChain != utils.ToByte32(expected) {
+		t.Fatalf("Expected root %x anchored in place of the legacy root, got %x", expected, onChain)
+	}
+	proof, err := chain.merkle.GetSyncedProof(ctx, treeID, nodes[2].NodeID, chain.chainID)
+	if err != nil {
+		t.Fatalf("Failed to get synced proof: %v", err)
+	}
+	if !chain.verifyVC(t, chain.issuer, treeID, leaves[2], proof) {
+		t.Errorf("Expected the proof of the last leaf verified on chain once anchored again")
+	}
+	if report, err := reconciler.Reconcile(ctx, false); err != nil || len(report.Drifts) != 0 {
+		t.Errorf("Expected no drift once anchored again, got %+v and error %v", report, err)
+	}
+}
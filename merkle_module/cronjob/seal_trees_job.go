@@ -0,0 +1,119 @@
+package cronjob
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"merkle_module/domain/entities"
+	"merkle_module/domain/repo"
+	"merkle_module/merkletree"
+	"merkle_module/utils"
+)
+
+type SealReport struct {
+	Checked int
+	Sealed  []int // tree IDs sealed
+	Skipped map[int]error
+}
+
+type SealTreesJob struct {
+	ctx      context.Context
+	repo     repo.Merkle
+	chainIDs []uint64
+}
+
+// NewSealTreesJob seals the full trees synced at their last node on every chain, the first
+// chain being the one whose sync is recorded in the seal
+func NewSealTreesJob(ctx context.Context, repo repo.Merkle, chainIDs []uint64) *SealTreesJob {
+	return &SealTreesJob{
+		ctx:      ctx,
+		repo:     repo,
+		chainIDs: chainIDs,
+	}
+}
+
+// Run seals the trees ready to be sealed, implementing the Job interface.
+func (j *SealTreesJob) Run() {
+	report, err := j.Seal(j.ctx)
+	if err != nil {
+		log.Printf("Error sealing trees: %v", err)
+		return
+	}
+
+	for treeID, err := range report.Skipped {
+		log.Printf("Tree ID %d not sealed: %v", treeID, err)
+	}
+	if len(report.Sealed) > 0 || len(report.Skipped) > 0 {
+		log.Printf("Checked %d full trees: %d sealed, %d skipped", report.Checked, len(report.Sealed), len(report.Skipped))
+	}
+}
+
+// Seal records the final root of every full tree synced on all chains with the transaction of
+// its last sync, and moves its nodes to the archive of the tree. A tree whose confirmed roots
+// differ between chains or from its leaves is skipped and left as it is.
+func (j *SealTreesJob) Seal(ctx context.Context) (*SealReport, error) {
+	if len(j.chainIDs) == 0 {
+		return nil, fmt.Errorf("no chain to check the syncs of the trees on")
+	}
+
+	trees, err := j.repo.GetTreesToSeal(ctx, j.chainIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trees to seal: %w", err)
+	}
+
+	report := &SealReport{Skipped: make(map[int]error)}
+	for _, tree := range trees {
+		report.Checked++
+
+		seal, err := j.sealOf(ctx, tree.ID)
+		if err != nil {
+			report.Skipped[tree.ID] = err
+			continue
+		}
+		if err := j.repo.SealTree(ctx, seal); err != nil {
+			return nil, fmt.Errorf("failed to seal tree ID %d: %w", tree.ID, err)
+		}
+		report.Sealed = append(report.Sealed, tree.ID)
+	}
+
+	return report, nil
+}
+
+// helper function to build the seal of a tree from its last confirmed syncs, checking the final
+// root against the leaves of the tree
+func (j *SealTreesJob) sealOf(ctx context.Context, treeID int) (*entities.TreeSeal, error) {
+	var seal *entities.TreeSeal
+	for _, chainID := range j.chainIDs {
+		lastSync, err := j.repo.GetLastConfirmedSync(ctx, treeID, chainID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get last confirmed sync: %w", err)
+		}
+		if lastSync == nil || lastSync.NodeCount != utils.MAX_LEAFS {
+			return nil, fmt.Errorf("no confirmed sync of the last node on chain %d", chainID)
+		}
+		if seal == nil {
+			seal = &entities.TreeSeal{TreeID: treeID, Root: lastSync.Root, ChainID: chainID, TxHash: lastSync.TxHash}
+		} else if !bytes.Equal(lastSync.Root, seal.Root) {
+			return nil, fmt.Errorf("root %x synced on chain %d differs from root %x on chain %d", lastSync.Root, chainID, seal.Root, seal.ChainID)
+		}
+	}
+
+	nodes, err := j.repo.GetNodesByTreeID(ctx, treeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get nodes by tree ID: %w", err)
+	}
+	leaves, complete := utils.LeadingLeaves(nodes)
+	if !complete {
+		return nil, fmt.Errorf("node %d of tree ID %d is not filled", len(leaves)+1, treeID)
+	}
+	tree, err := merkletree.NewMerkleTree(leaves, treeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build tree: %w", err)
+	}
+	if !bytes.Equal(tree.GetMerkleRoot(), seal.Root) {
+		return nil, fmt.Errorf("synced root %x differs from the root %x of the leaves", seal.Root, tree.GetMerkleRoot())
+	}
+
+	return seal, nil
+}
@@ -0,0 +1,83 @@
+package cronjob
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"merkle_module/app/services"
+	"merkle_module/cache"
+	"merkle_module/merkletree"
+	"merkle_module/utils"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestSealTreesJob(t *testing.T) {
+	ctx := context.Background()
+	chain := newTestChain(t)
+	nodes, leaves := chain.addLeaves(t, 0, utils.MAX_LEAFS)
+	treeID := nodes[0].TreeID
+
+	// a tree synced on one chain only is not sealed
+	other := NewSealTreesJob(ctx, chain.repo, []uint64{chain.chainID, chain.chainID + 1})
+	chain.syncJob(common.Address{}).Run()
+	if report, err := other.Seal(ctx); err != nil || report.Checked != 0 {
+		t.Fatalf("Expected no tree to seal before it is synced on every chain, got %+v and error %v", report, err)
+	}
+
+	job := NewSealTreesJob(ctx, chain.repo, []uint64{chain.chainID})
+	report, err := job.Seal(ctx)
+	if err != nil {
+		t.Fatalf("Failed to seal trees: %v", err)
+	}
+	if len(report.Sealed) != 1 || report.Sealed[0] != treeID || len(report.Skipped) != 0 {
+		t.Fatalf("Expected Tree ID %d sealed, got %+v", treeID, report)
+	}
+	anchored, err := chain.merkle.GetSyncedRoot(ctx, treeID, chain.chainID)
+	if err != nil {
+		t.Fatalf("Failed to get synced root: %v", err)
+	}
+	seal, err := chain.repo.GetTreeSeal(ctx, treeID)
+	if err != nil {
+		t.Fatalf("Failed to get tree seal: %v", err)
+	}
+	if seal == nil || !bytes.Equal(seal.Root, anchored) || seal.ChainID != chain.chainID || seal.TxHash == "" {
+		t.Fatalf("Unexpected seal: %+v", seal)
+	}
+	if report, err := job.Seal(ctx); err != nil || report.Checked != 0 {
+		t.Errorf("Expected the sealed tree not to be sealed again, got %+v and error %v", report, err)
+	}
+
+	// a service without cached trees serves the proofs of the sealed tree from its archive
+	uncached := services.NewMerkleService(chain.repo, cache.NewLRU(10), nil)
+	root, err := uncached.GetRoot(ctx, treeID)
+	if err != nil || !bytes.Equal(root, anchored) {
+		t.Fatalf("Expected the sealed root %x, got %x and error %v", anchored, root, err)
+	}
+	for _, i := range []int{0, 13, utils.MAX_LEAFS - 1} {
+		proof, err := uncached.GetSyncedProof(ctx, treeID, nodes[i].NodeID, chain.chainID)
+		if err != nil {
+			t.Fatalf("Failed to get synced proof of node %d: %v", nodes[i].NodeID, err)
+		}
+		if !chain.verifyVC(t, chain.issuer, treeID, leaves[i], proof) {
+			t.Errorf("Expected verifyVC to accept the sealed proof of node %d", nodes[i].NodeID)
+		}
+		proof, err = uncached.GetProof(ctx, treeID, nodes[i].NodeID)
+		if err != nil {
+			t.Fatalf("Failed to get proof of node %d: %v", nodes[i].NodeID, err)
+		}
+		if !bytes.Equal(merkletree.RootFromProof(leaves[i], proof), anchored) {
+			t.Errorf("Expected the proof of node %d to lead to the sealed root", nodes[i].NodeID)
+		}
+		proof, err = uncached.GetProofAt(ctx, treeID, nodes[i].NodeID, utils.MAX_LEAFS)
+		if err != nil || !bytes.Equal(merkletree.RootFromProof(leaves[i], proof), anchored) {
+			t.Errorf("Expected the proof of node %d at the last size to lead to the sealed root, got error %v", nodes[i].NodeID, err)
+		}
+	}
+
+	// serving the sealed tree does not save its nodes again
+	if hashes, err := chain.repo.GetNodeHashes(ctx, treeID, utils.MAX_LEAFS, []int{1, 2, 3}); err != nil || len(hashes) != 0 {
+		t.Errorf("Expected no node hashes of the sealed tree, got %d and error %v", len(hashes), err)
+	}
+}
@@ -2,36 +2,106 @@ package cronjob
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"merkle_module/domain/entities"
 	"merkle_module/domain/repo"
+	"merkle_module/issuer"
 	"merkle_module/merkletree"
 	credential "merkle_module/smartcontract"
 	"merkle_module/utils"
+	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
 )
 
+// Chain is a chain the tree roots are anchored to, its sync state is kept apart from the other chains
+type Chain struct {
+	Name          string
+	ChainID       uint64
+	Contract      *credential.SmartContract
+	Schedule      string // cron spec of the sync job, DefaultSyncSchedule when empty
+	Confirmations uint64
+	GasBudget     uint64
+	// CheckpointIssuer enables the hierarchical mode: the roots of a run are committed into one
+	// checkpoint and only its super-root is anchored, as tree index checkpoint ID of this address.
+	// It is a pseudo-issuer, the trees of an issuer resolving to it are not synced.
+	CheckpointIssuer common.Address
+}
+
 type SyncMerkleJob struct {
-	ctx      context.Context
-	repo     repo.Merkle
-	contract *credential.SmartContract
+	ctx           context.Context
+	repo          repo.Merkle
+	chainID       uint64
+	contract      *credential.SmartContract
+	issuers       issuer.Resolver
+	confirmations uint64
+	gasBudget     uint64
+	checkpoint    common.Address
+	running       sync.Mutex
+	// submitted holds the transactions sent for syncs whose transaction failed to be recorded,
+	// by sync ID, for the next run to record them instead of failing the syncs as never sent
+	submitted  map[int]*types.Transaction
+	retryDelay time.Duration // between the attempts to record a sync transaction
 }
 
-func NewSyncMerkleJob(ctx context.Context, repo repo.Merkle, contract *credential.SmartContract) *SyncMerkleJob {
+const (
+	// DefaultSyncGasBudget keeps a batch well under the 30M block gas limit of mainnet
+	DefaultSyncGasBudget = 10_000_000
+	DefaultSyncSchedule  = "@every 10s"
+	// recordAttempts is the number of attempts to record a transaction once it is sent
+	recordAttempts = 3
+)
+
+func NewSyncMerkleJob(ctx context.Context, repo repo.Merkle, chain *Chain, issuers issuer.Resolver) *SyncMerkleJob {
+	if issuers == nil {
+		issuers = issuer.DIDResolver{}
+	}
+	confirmations := chain.Confirmations
+	if confirmations == 0 {
+		confirmations = 1
+	}
+	gasBudget := chain.GasBudget
+	if gasBudget == 0 {
+		gasBudget = DefaultSyncGasBudget
+	}
 	return &SyncMerkleJob{
-		ctx:      ctx,
-		repo:     repo,
-		contract: contract,
+		ctx:           ctx,
+		repo:          repo,
+		chainID:       chain.ChainID,
+		contract:      chain.Contract,
+		issuers:       issuers,
+		confirmations: confirmations,
+		gasBudget:     gasBudget,
+		checkpoint:    chain.CheckpointIssuer,
+		submitted:     make(map[int]*types.Transaction),
+		retryDelay:    time.Second,
 	}
 }
 
 type RootResult struct {
-	Root   []byte
-	TreeID int
+	Root      []byte
+	TreeID    int
+	IssuerDID string
+	NodeCount int
 }
 
 // Run executes the job to sync the Merkle root, implementing the Job interface.
 func (j *SyncMerkleJob) Run() {
+	// A run waits for confirmations, skip the tick if the previous run is still going
+	if !j.running.TryLock() {
+		log.Println("Previous sync is still running, skipping")
+		return
+	}
+	defer j.running.Unlock()
+
+	// Finish the syncs submitted by previous runs first, their trees are not synced again until then
+	if j.contract != nil {
+		j.resumePendingSyncs()
+	}
+
 	// Get the results of the Merkle root sync
 	results, err := j.getRootResults()
 	if err != nil {
@@ -40,14 +110,14 @@ func (j *SyncMerkleJob) Run() {
 	}
 
 	// If no results, exit early
-	if results == nil || len(results) == 0 {
+	if len(results) == 0 {
 		log.Println("No results to sync")
 		return
 	}
 
 	// Print the results
 	for _, result := range results {
-		log.Printf("Tree ID: %d, Merkle Root: %x", result.TreeID, result.Root)
+		log.Printf("Chain %d, Tree ID: %d, Merkle Root: %x", j.chainID, result.TreeID, result.Root)
 	}
 
 	// Send to smart contract
@@ -58,6 +128,7 @@ func (j *SyncMerkleJob) Run() {
 	var issuers []common.Address
 	var roots [][32]byte
 	var treeIDs []int
+	var syncs []*entities.TreeSync
 	for _, result := range results {
 		// Convert the Merkle root to a 32-byte array
 		if len(result.Root) != 32 {
@@ -67,71 +138,382 @@ func (j *SyncMerkleJob) Run() {
 		var root [32]byte
 		copy(root[:], result.Root)
 
+		// The tree stays marked for sync until its issuer can be resolved
+		issuerAddress, err := j.issuers.Resolve(j.ctx, result.IssuerDID)
+		if err != nil {
+			log.Printf("Skipping Tree ID %d: failed to resolve issuer %s: %v", result.TreeID, result.IssuerDID, err)
+			continue
+		}
+		// The tree indexes of the checkpoint issuer are checkpoint IDs, a tree anchored under
+		// it would overwrite a super-root
+		if j.checkpoint != (common.Address{}) && issuerAddress == j.checkpoint {
+			log.Printf("Skipping Tree ID %d: issuer %s resolves to the checkpoint issuer %s", result.TreeID, result.IssuerDID, j.checkpoint.Hex())
+			continue
+		}
+
 		// Append the issuer address and root
-		issuers = append(issuers, common.HexToAddress("0xYourIssuerAddress"))
+		issuers = append(issuers, issuerAddress)
 		roots = append(roots, root)
 		treeIDs = append(treeIDs, result.TreeID)
+		syncs = append(syncs, &entities.TreeSync{
+			TreeID:        result.TreeID,
+			ChainID:       j.chainID,
+			NodeCount:     result.NodeCount,
+			Root:          result.Root,
+			IssuerAddress: issuerAddress.Hex(),
+		})
+	}
+	if len(syncs) == 0 {
+		return
+	}
+
+	if j.checkpoint != (common.Address{}) {
+		j.syncCheckpoint(issuers, treeIDs, syncs)
+		return
+	}
+
+	// Record the roots before submitting, so a crash never leaves an untracked transaction
+	if err := j.repo.CreatePendingSyncs(j.ctx, syncs); err != nil {
+		log.Printf("Error creating pending syncs: %v", err)
+		return
+	}
+
+	// Split the roots into transactions under the gas budget
+	chunks, err := j.contract.ChunkRoots(j.ctx, issuers, treeIDs, roots, j.gasBudget)
+	if err != nil {
+		log.Printf("Error splitting Merkle roots into transactions: %v", err)
+		if err := j.repo.FailSyncs(j.ctx, syncIDsOf(syncs), entities.SyncStatusFailed, err.Error()); err != nil {
+			log.Printf("Error failing pending syncs: %v", err)
+		}
+		return
+	}
+
+	// Chunks are submitted in order so they get sequential nonces, then mined concurrently;
+	// a failed chunk only re-queues its own trees
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		chunkSyncIDs := syncIDsOf(syncs[chunk.Start:chunk.End])
+		tx, err := j.contract.SubmitRoots(j.ctx, issuers[chunk.Start:chunk.End], treeIDs[chunk.Start:chunk.End], roots[chunk.Start:chunk.End])
+		if err != nil {
+			log.Printf("Error sending chunk %d/%d of Merkle roots to smart contract: %v", i+1, len(chunks), err)
+			if err := j.repo.FailSyncs(j.ctx, chunkSyncIDs, entities.SyncStatusFailed, err.Error()); err != nil {
+				log.Printf("Error failing pending syncs: %v", err)
+			}
+			continue
+		}
+		if !j.recordTransaction(chunkSyncIDs, tx) {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			j.settleTransaction(chunkSyncIDs, tx)
+		}()
+	}
+	wg.Wait()
+}
+
+// helper function to commit the roots into a checkpoint and anchor only its super-root,
+// the syncs of all the trees share the checkpoint transaction
+func (j *SyncMerkleJob) syncCheckpoint(issuers []common.Address, treeIDs []int, syncs []*entities.TreeSync) {
+	roots := make([]merkletree.TreeRoot, len(syncs))
+	for i, treeSync := range syncs {
+		roots[i] = merkletree.TreeRoot{Issuer: issuers[i], TreeID: treeIDs[i], Root: treeSync.Root}
+	}
+	tree, err := merkletree.NewCheckpoint(roots)
+	if err != nil {
+		log.Printf("Error creating checkpoint: %v", err)
+		return
+	}
+
+	// Record the checkpoint and its roots before submitting, as for single roots
+	checkpoint := &entities.Checkpoint{
+		ChainID:       j.chainID,
+		IssuerAddress: j.checkpoint.Hex(),
+		Root:          tree.Root(),
+	}
+	if err := j.repo.CreateCheckpoint(j.ctx, checkpoint); err != nil {
+		log.Printf("Error creating checkpoint: %v", err)
+		return
+	}
+	for i, treeSync := range syncs {
+		treeSync.CheckpointID = checkpoint.ID
+		treeSync.CheckpointPosition = i
+	}
+	if err := j.repo.CreatePendingSyncs(j.ctx, syncs); err != nil {
+		log.Printf("Error creating pending syncs: %v", err)
+		return
 	}
-	if err := j.contract.SendRoot(issuers, treeIDs, roots); err != nil {
-		log.Printf("Error sending Merkle roots to smart contract: %v", err)
+
+	syncIDs := syncIDsOf(syncs)
+	tx, err := j.contract.SubmitRoots(j.ctx, []common.Address{j.checkpoint}, []int{checkpoint.ID}, [][32]byte{utils.ToByte32(checkpoint.Root)})
+	if err != nil {
+		log.Printf("Error sending checkpoint %d to smart contract: %v", checkpoint.ID, err)
+		if err := j.repo.FailSyncs(j.ctx, syncIDs, entities.SyncStatusFailed, err.Error()); err != nil {
+			log.Printf("Error failing pending syncs: %v", err)
+		}
+		return
+	}
+	if !j.recordTransaction(syncIDs, tx) {
 		return
 	}
 
-	log.Println("Merkle roots successfully sent to smart contract")
+	log.Printf("Checkpoint %d commits %d Merkle roots into super-root %x", checkpoint.ID, len(syncs), checkpoint.Root)
+	j.settleTransaction(syncIDs, tx)
+}
+
+// helper function to record the transaction sent for syncs, retrying as the transaction can not
+// be sent again. A transaction still not recorded is kept for the next run to record it, the
+// syncs stay pending and their trees are not synced again meanwhile.
+func (j *SyncMerkleJob) recordTransaction(syncIDs []int, tx *types.Transaction) bool {
+	var err error
+	for attempt := 1; attempt <= recordAttempts; attempt++ {
+		if err = j.repo.SetSyncTransaction(j.ctx, syncIDs, tx.Hash().Hex(), tx.Nonce()); err == nil {
+			for _, syncID := range syncIDs {
+				delete(j.submitted, syncID)
+			}
+			return true
+		}
+		log.Printf("Error recording sync transaction %s, attempt %d/%d: %v", tx.Hash().Hex(), attempt, recordAttempts, err)
+		if attempt < recordAttempts {
+			time.Sleep(j.retryDelay)
+		}
+	}
+
+	for _, syncID := range syncIDs {
+		j.submitted[syncID] = tx
+	}
+	return false
+}
+
+// helper function to wait for a submitted chunk and confirm or fail its syncs
+func (j *SyncMerkleJob) settleTransaction(syncIDs []int, tx *types.Transaction) {
+	receipt, err := j.contract.WaitConfirmed(j.ctx, tx, j.confirmations)
+	if err != nil {
+		if receipt != nil {
+			// Mined but reverted
+			log.Printf("Merkle roots transaction %s failed: %v", receipt.TxHash.Hex(), err)
+			if err := j.repo.FailSyncs(j.ctx, syncIDs, entities.SyncStatusFailed, err.Error()); err != nil {
+				log.Printf("Error failing pending syncs: %v", err)
+			}
+			return
+		}
+		// The syncs stay pending and are resumed by the next run
+		log.Printf("Error waiting for Merkle roots transaction %s: %v", tx.Hash().Hex(), err)
+		return
+	}
+
+	// A replacement with higher fees may have been mined instead
+	txHash := receipt.TxHash.Hex()
+	if receipt.TxHash != tx.Hash() {
+		if err := j.repo.SetSyncTransaction(j.ctx, syncIDs, txHash, tx.Nonce()); err != nil {
+			log.Printf("Error recording replacement transaction %s: %v", txHash, err)
+			return
+		}
+	}
+	if err := j.repo.ConfirmSyncs(j.ctx, j.chainID, txHash, receipt.BlockNumber.Uint64()); err != nil {
+		log.Printf("Error confirming syncs of transaction %s: %v", txHash, err)
+		return
+	}
+
+	log.Printf("%d Merkle roots successfully sent to smart contract on chain %d in transaction %s", len(syncIDs), j.chainID, txHash)
+}
+
+func syncIDsOf(syncs []*entities.TreeSync) []int {
+	syncIDs := make([]int, len(syncs))
+	for i, treeSync := range syncs {
+		syncIDs[i] = treeSync.ID
+	}
+	return syncIDs
+}
+
+// helper function to settle the pending syncs of previous runs
+func (j *SyncMerkleJob) resumePendingSyncs() {
+	syncs, err := j.repo.GetPendingSyncs(j.ctx, j.chainID)
+	if err != nil {
+		log.Printf("Error getting pending syncs: %v", err)
+		return
+	}
+
+	// One transaction covers many syncs
+	byTx := make(map[string][]*entities.TreeSync)
+	var txHashes []string
+	// Record the transactions sent by previous runs which failed to record them, the syncs
+	// settled since then no longer need it
+	pending := make(map[int]bool, len(syncs))
+	for _, treeSync := range syncs {
+		pending[treeSync.ID] = true
+	}
+	for syncID := range j.submitted {
+		if !pending[syncID] {
+			delete(j.submitted, syncID)
+		}
+	}
+	unrecorded := make(map[*types.Transaction][]int)
+	var sent []*types.Transaction
+	for _, treeSync := range syncs {
+		if tx := j.submitted[treeSync.ID]; treeSync.TxHash == "" && tx != nil {
+			if _, exists := unrecorded[tx]; !exists {
+				sent = append(sent, tx)
+			}
+			unrecorded[tx] = append(unrecorded[tx], treeSync.ID)
+		}
+	}
+	recorded := make(map[int]*types.Transaction)
+	for _, tx := range sent {
+		if j.recordTransaction(unrecorded[tx], tx) {
+			for _, syncID := range unrecorded[tx] {
+				recorded[syncID] = tx
+			}
+		}
+	}
+
+	var untracked []int
+	for _, treeSync := range syncs {
+		if tx := recorded[treeSync.ID]; tx != nil {
+			treeSync.TxHash, treeSync.Nonce = tx.Hash().Hex(), tx.Nonce()
+		}
+		// A transaction still not recorded is checked once it is
+		if j.submitted[treeSync.ID] != nil {
+			continue
+		}
+		if treeSync.TxHash == "" {
+			untracked = append(untracked, treeSync.ID)
+			continue
+		}
+		if _, exists := byTx[treeSync.TxHash]; !exists {
+			txHashes = append(txHashes, treeSync.TxHash)
+		}
+		byTx[treeSync.TxHash] = append(byTx[treeSync.TxHash], treeSync)
+	}
+
+	// Syncs without a transaction were interrupted before submitting
+	if len(untracked) > 0 {
+		if err := j.repo.FailSyncs(j.ctx, untracked, entities.SyncStatusFailed, "transaction was never submitted"); err != nil {
+			log.Printf("Error failing untracked syncs: %v", err)
+		}
+	}
+
+	for _, txHash := range txHashes {
+		txSyncs := byTx[txHash]
+		state, receipt, err := j.contract.CheckTransaction(j.ctx, common.HexToHash(txHash), txSyncs[0].Nonce, j.confirmations)
+		if err != nil {
+			log.Printf("Error checking sync transaction %s: %v", txHash, err)
+			continue
+		}
+
+		syncIDs := syncIDsOf(txSyncs)
+
+		switch state {
+		case credential.TxConfirmed:
+			err = j.repo.ConfirmSyncs(j.ctx, j.chainID, txHash, receipt.BlockNumber.Uint64())
+		case credential.TxFailed:
+			err = j.repo.FailSyncs(j.ctx, syncIDs, entities.SyncStatusFailed, "transaction reverted or dropped")
+		case credential.TxReplaced:
+			err = j.repo.FailSyncs(j.ctx, syncIDs, entities.SyncStatusReplaced, "nonce used by another transaction")
+		default:
+			log.Printf("Sync transaction %s is still pending", txHash)
+			continue
+		}
+		if err != nil {
+			log.Printf("Error settling sync transaction %s: %v", txHash, err)
+			continue
+		}
+		log.Printf("Settled %d pending syncs of transaction %s", len(txSyncs), txHash)
+	}
 }
 
 func (j *SyncMerkleJob) getRootResults() ([]RootResult, error) {
-	results, err := j.repo.GetTreesWithNodesForSync(j.ctx)
+	results, err := j.repo.GetTreesWithNodesForSync(j.ctx, j.chainID)
 	if err != nil {
 		return nil, err
 	}
 
 	var rootResults []RootResult
 	for _, tree := range results {
-		// check node ids must fill the range from 1 to NodeCount
-		if tree.Tree.NodeCount != len(tree.Nodes) {
-			log.Printf("Node count mismatch for Tree ID %d: expected %d, got %d", tree.Tree.ID, tree.Tree.NodeCount, len(tree.Nodes))
-			log.Printf("Skipping Tree ID %d due to invalid node IDs", tree.Tree.ID)
-			continue
-		}
-		nodeMap := make(map[int]bool)
+		// Nodes are read ordered by node ID, a node ID held twice makes the tree ambiguous
 		flag := false
-		for _, node := range tree.Nodes {
-			if node.NodeID <= 0 || node.NodeID > tree.Tree.NodeCount {
-				log.Printf("Invalid node ID %d for Tree ID %d: must be between 1 and %d", node.NodeID, tree.Tree.ID, tree.Tree.NodeCount)
-				flag = true
-			}
-			if nodeMap[node.NodeID] {
+		for i, node := range tree.Nodes {
+			if i > 0 && node.NodeID == tree.Nodes[i-1].NodeID {
 				log.Printf("Duplicate node ID %d found for Tree ID %d", node.NodeID, tree.Tree.ID)
 				flag = true
 			}
-			nodeMap[node.NodeID] = true
 		}
 		if flag {
-			log.Printf("Skipping Tree ID %d due to invalid node IDs", tree.Tree.ID)
+			log.Printf("Skipping Tree ID %d due to invalid node IDs, see the integrityCheck job or merkle-admin integrity", tree.Tree.ID)
 			continue
 		}
 
-		// build the Merkle tree
-		tree, err := merkletree.NewMerkleTree(utils.NodesToBytes(tree.Nodes), tree.Tree.ID)
-		if err != nil {
-			log.Printf("Error creating Merkle tree for Tree ID %d: %v", tree.GetTreeID(), err)
+		// The tree is synced up to its last contiguous node, the nodes after a gap wait for the
+		// reapReservations job to fill it
+		contiguous := 0
+		for contiguous < len(tree.Nodes) && tree.Nodes[contiguous].NodeID == contiguous+1 {
+			contiguous++
+		}
+		if contiguous < len(tree.Nodes) {
+			log.Printf("Node %d of Tree ID %d is missing, syncing its first %d nodes", contiguous+1, tree.Tree.ID, contiguous)
+			tree.Nodes = tree.Nodes[:contiguous]
+		}
+		if contiguous == 0 || (contiguous <= tree.Tree.NodeCountSync && !tree.Tree.NeedSync) {
+			// Reserved nodes are not filled yet, the tree is already synced up to them
 			continue
 		}
 
-		// get the Merkle root
-		root := tree.GetMerkleRoot()
-		if root == nil {
-			log.Printf("Error getting Merkle root for Tree ID %d: root is nil", tree.GetTreeID())
+		nodeCount := len(tree.Nodes)
+		treeID := tree.Tree.ID
+		issuerDID := tree.Tree.IssuerDID
+		root, err := j.getRoot(treeID, nodeCount, tree.Nodes)
+		if err != nil {
+			log.Printf("Error getting Merkle root for Tree ID %d: %v", treeID, err)
 			continue
 		}
 
 		// append the result
 		rootResults = append(rootResults, RootResult{
-			Root:   root,
-			TreeID: tree.GetTreeID(),
+			Root:      root,
+			TreeID:    treeID,
+			IssuerDID: issuerDID,
+			NodeCount: nodeCount,
 		})
 	}
 
+	// record the synced roots in the history of their trees
+	roots := make([]*entities.TreeRoot, len(rootResults))
+	for i, result := range rootResults {
+		roots[i] = &entities.TreeRoot{TreeID: result.TreeID, Size: result.NodeCount, Root: result.Root}
+	}
+	if err := j.repo.AddTreeRoots(j.ctx, roots); err != nil {
+		log.Printf("Error recording tree roots: %v", err)
+	}
+
 	return rootResults, nil
 }
+
+// getRoot returns the root of the tree once it holds nodeCount leaves. The root saved by the
+// service for that size is used when there is one, otherwise the tree is built from its nodes
+// and all its nodes are saved, so that the proofs of the synced root are read from the database.
+func (j *SyncMerkleJob) getRoot(treeID, nodeCount int, nodes []*entities.MerkleNode) ([]byte, error) {
+	hashes, err := j.repo.GetNodeHashes(j.ctx, treeID, nodeCount, []int{1})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get saved root: %w", err)
+	}
+	if len(hashes) == 1 && hashes[0].Size == nodeCount {
+		return hashes[0].Hash, nil
+	}
+
+	// build the Merkle tree
+	tree, err := merkletree.NewMerkleTree(utils.NodesToBytes(nodes), treeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create merkle tree: %w", err)
+	}
+	root := tree.GetMerkleRoot()
+	if root == nil {
+		return nil, fmt.Errorf("root is nil")
+	}
+	if err := j.repo.SaveNodeHashes(j.ctx, tree.GetNodeHashes(1)); err != nil {
+		log.Printf("Error saving the nodes of Tree ID %d: %v", treeID, err)
+	}
+
+	return root, nil
+}
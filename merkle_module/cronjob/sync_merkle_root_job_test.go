@@ -0,0 +1,709 @@
+package cronjob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"merkle_module/app/interfaces"
+	"merkle_module/app/services"
+	"merkle_module/cache"
+	"merkle_module/domain/entities"
+	"merkle_module/domain/repo"
+	"merkle_module/infra/storage"
+	"merkle_module/issuer"
+	"merkle_module/merkletree"
+	credential "merkle_module/smartcontract"
+	"merkle_module/utils"
+	"merkle_module/vc"
+	"merkle_module/verifier"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient/simulated"
+	"github.com/redis/go-redis/v9"
+)
+
+const testIssuerDID = "did:example:issuer"
+
+type testChain struct {
+	sim      *simulated.Backend
+	chainID  uint64
+	issuer   common.Address
+	address  common.Address
+	auth     *bind.TransactOpts
+	contract *credential.SmartContract
+	merkle   interfaces.Merkle
+	repo     repo.Merkle
+}
+
+// newTestChain deploys the contract to a simulated backend which mines a block every few
+// milliseconds, so the sync job settles its transactions as on a live chain
+func newTestChain(t *testing.T) *testChain {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	balance, _ := new(big.Int).SetString("1000000000000000000000", 10)
+	sim := simulated.NewBackend(types.GenesisAlloc{from: {Balance: balance}})
+	t.Cleanup(func() { sim.Close() })
+
+	chainID, err := sim.Client().ChainID(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get chain ID: %v", err)
+	}
+	auth := credential.NewSignerTransactOpts(credential.NewKeySigner(key), chainID)
+	address, _, contract, err := credential.DeployCredential(auth, sim.Client())
+	if err != nil {
+		t.Fatalf("Failed to deploy contract: %v", err)
+	}
+	sim.Commit()
+
+	smartContract, err := credential.NewSmartContract(sim.Client(), contract, address, auth)
+	if err != nil {
+		t.Fatalf("Failed to create smart contract: %v", err)
+	}
+	smartContract.SetTxConfig(credential.TxConfig{PollInterval: 10 * time.Millisecond})
+
+	stop := make(chan struct{})
+	mined := make(chan struct{})
+	go func() {
+		defer close(mined)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(10 * time.Millisecond):
+				sim.Commit()
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		close(stop)
+		<-mined
+	})
+
+	repo := storage.NewMerkleMemory()
+	merkle := services.NewMerkleService(repo, cache.NewLRU(10), nil)
+	return &testChain{sim: sim, chainID: chainID.Uint64(), issuer: from, address: address, auth: auth, contract: smartContract, merkle: merkle, repo: repo}
+}
+
+func (c *testChain) syncJob(checkpointIssuer common.Address) *SyncMerkleJob {
+	return NewSyncMerkleJob(context.Background(), c.repo, &Chain{
+		Name:             "simulated",
+		ChainID:          c.chainID,
+		Contract:         c.contract,
+		Confirmations:    1,
+		CheckpointIssuer: checkpointIssuer,
+	}, issuer.StaticResolver{testIssuerDID: c.issuer})
+}
+
+func (c *testChain) addLeaves(t *testing.T, from, to int) ([]*entities.MerkleNode, [][]byte) {
+	t.Helper()
+	var nodes []*entities.MerkleNode
+	var leaves [][]byte
+	for i := from; i < to; i++ {
+		leaf := utils.Hash([]byte(fmt.Sprintf("credential %d", i)))
+		node, err := c.merkle.AddLeaf(context.Background(), testIssuerDID, leaf)
+		if err != nil {
+			t.Fatalf("Failed to add leaf %d: %v", i, err)
+		}
+		nodes = append(nodes, node)
+		leaves = append(leaves, leaf)
+	}
+	return nodes, leaves
+}
+
+func (c *testChain) verifyVC(t *testing.T, issuer common.Address, treeIndex int, leaf []byte, path [][]byte) bool {
+	t.Helper()
+	proof := make([][32]byte, len(path))
+	for i, p := range path {
+		proof[i] = utils.ToByte32(p)
+	}
+	valid, err := c.contract.Verify(context.Background(), issuer, issuer, treeIndex, utils.ToByte32(leaf), proof)
+	if err != nil {
+		t.Fatalf("Failed to call verifyVC: %v", err)
+	}
+	return valid
+}
+
+func TestSyncMerkleJobAnchorsVerifiableRoots(t *testing.T) {
+	ctx := context.Background()
+	chain := newTestChain(t)
+	job := chain.syncJob(common.Address{})
+
+	// a partial tree, its proofs go through empty subtrees
+	nodes, leaves := chain.addLeaves(t, 0, 3)
+	treeID := nodes[0].TreeID
+	job.Run()
+
+	for i, node := range nodes {
+		proof, err := chain.merkle.GetSyncedProof(ctx, treeID, node.NodeID, chain.chainID)
+		if err != nil {
+			t.Fatalf("Failed to get synced proof of node %d: %v", node.NodeID, err)
+		}
+		if !chain.verifyVC(t, chain.issuer, treeID, leaves[i], proof) {
+			t.Errorf("Expected verifyVC to accept the synced proof of node %d", node.NodeID)
+		}
+	}
+	synced, err := chain.merkle.GetSyncedRoot(ctx, treeID, chain.chainID)
+	if err != nil {
+		t.Fatalf("Failed to get synced root: %v", err)
+	}
+	onChain, err := chain.contract.GetTreeRoot(ctx, chain.issuer, treeID)
+	if err != nil {
+		t.Fatalf("Failed to get tree root: %v", err)
+	}
+	if onChain != utils.ToByte32(synced) {
+		t.Errorf("Expected the synced root %x on chain, got %x", synced, onChain)
+	}
+
+	// a leaf added after the sync is not anchored yet
+	unsynced, unsyncedLeaves := chain.addLeaves(t, 3, 4)
+	if _, err := chain.merkle.GetSyncedProof(ctx, treeID, unsynced[0].NodeID, chain.chainID); err == nil {
+		t.Errorf("Expected no synced proof for the unsynced node %d", unsynced[0].NodeID)
+	}
+	proof, err := chain.merkle.GetProof(ctx, treeID, unsynced[0].NodeID)
+	if err != nil {
+		t.Fatalf("Failed to get proof: %v", err)
+	}
+	if chain.verifyVC(t, chain.issuer, treeID, unsyncedLeaves[0], proof) {
+		t.Errorf("Expected verifyVC to reject the proof of the unsynced node %d", unsynced[0].NodeID)
+	}
+
+	// the next run anchors it
+	job.Run()
+	if !chain.verifyVC(t, chain.issuer, treeID, unsyncedLeaves[0], proof) {
+		t.Errorf("Expected verifyVC to accept the proof of node %d once synced", unsynced[0].NodeID)
+	}
+	if pending, _ := chain.repo.GetPendingSyncs(ctx, chain.chainID); len(pending) != 0 {
+		t.Errorf("Expected no pending syncs, got %d", len(pending))
+	}
+
+	// the synced root matches the contract, reconciling finds no drift
+	report, err := NewReconcileJob(ctx, chain.repo, chain.chainID, chain.contract, issuer.StaticResolver{testIssuerDID: chain.issuer}).Reconcile(ctx, false)
+	if err != nil {
+		t.Fatalf("Failed to reconcile: %v", err)
+	}
+	if report.Checked != 1 || len(report.Drifts) != 0 {
+		t.Errorf("Expected 1 tree without drift, got %d trees and %d drifts", report.Checked, len(report.Drifts))
+	}
+}
+
+// leaflessRepo fails to read the leaves of trees, proofs can only be read from the saved nodes
+type leaflessRepo struct {
+	repo.Merkle
+}
+
+func (leaflessRepo) GetNodesByTreeID(ctx context.Context, treeID int) ([]*entities.MerkleNode, error) {
+	return nil, fmt.Errorf("leaves of tree ID %d read", treeID)
+}
+
+func (leaflessRepo) GetNodesSyncedByTreeID(ctx context.Context, treeID int, chainID uint64) ([]*entities.MerkleNode, error) {
+	return nil, fmt.Errorf("synced leaves of tree ID %d read", treeID)
+}
+
+// unrecordedRepo fails to record the transactions of syncs while failures remain
+type unrecordedRepo struct {
+	repo.Merkle
+	failures int
+}
+
+func (r *unrecordedRepo) SetSyncTransaction(ctx context.Context, syncIDs []int, txHash string, nonce uint64) error {
+	if r.failures > 0 {
+		r.failures--
+		return errors.New("database unavailable")
+	}
+	return r.Merkle.SetSyncTransaction(ctx, syncIDs, txHash, nonce)
+}
+
+// gasBackend estimates every call at gas, or fails the estimation with err
+type gasBackend struct {
+	credential.Backend
+	gas uint64
+	err error
+}
+
+func (b gasBackend) EstimateGas(ctx context.Context, call ethereum.CallMsg) (uint64, error) {
+	return b.gas, b.err
+}
+
+func TestFailedSyncsKeepNodeCountSync(t *testing.T) {
+	ctx := context.Background()
+	chain := newTestChain(t)
+	nodes, _ := chain.addLeaves(t, 0, 3)
+	treeID := nodes[0].TreeID
+
+	syncThrough := func(backend credential.Backend) {
+		contract, err := credential.NewCredential(chain.address, backend)
+		if err != nil {
+			t.Fatalf("Failed to bind contract: %v", err)
+		}
+		smartContract, err := credential.NewSmartContract(backend, contract, chain.address, chain.auth)
+		if err != nil {
+			t.Fatalf("Failed to create smart contract: %v", err)
+		}
+		smartContract.SetTxConfig(credential.TxConfig{PollInterval: 10 * time.Millisecond})
+		NewSyncMerkleJob(ctx, chain.repo, &Chain{Name: "simulated", ChainID: chain.chainID, Contract: smartContract, Confirmations: 1}, issuer.StaticResolver{testIssuerDID: chain.issuer}).Run()
+	}
+	expectNotSynced := func(after string) {
+		t.Helper()
+		if pending, err := chain.repo.GetPendingSyncs(ctx, chain.chainID); err != nil || len(pending) != 0 {
+			t.Fatalf("Expected no pending sync after %s, got %d and error %v", after, len(pending), err)
+		}
+		if lastSync, err := chain.repo.GetLastConfirmedSync(ctx, treeID, chain.chainID); err != nil || lastSync != nil {
+			t.Fatalf("Expected no confirmed sync after %s, got %+v and error %v", after, lastSync, err)
+		}
+		trees, err := chain.repo.GetTreesWithNodesForSync(ctx, chain.chainID)
+		if err != nil || len(trees) != 1 || trees[0].Tree.NodeCountSync != 0 {
+			t.Fatalf("Expected Tree ID %d still to sync from node count 0 after %s, got %v and error %v", treeID, after, trees, err)
+		}
+		if onChain, err := chain.contract.GetTreeRoot(ctx, chain.issuer, treeID); err != nil || onChain != [32]byte{} {
+			t.Fatalf("Expected no root on chain after %s, got %x and error %v", after, onChain, err)
+		}
+	}
+
+	// the roots can not be submitted
+	syncThrough(gasBackend{Backend: chain.sim.Client(), err: errors.New("estimation failed")})
+	expectNotSynced("a failed submission")
+
+	// the transaction is mined but runs out of gas
+	nonce, err := chain.sim.Client().NonceAt(ctx, chain.issuer, nil)
+	if err != nil {
+		t.Fatalf("Failed to get nonce: %v", err)
+	}
+	syncThrough(gasBackend{Backend: chain.sim.Client(), gas: 25_000})
+	if mined, err := chain.sim.Client().NonceAt(ctx, chain.issuer, nil); err != nil || mined != nonce+1 {
+		t.Fatalf("Expected the reverted transaction mined, got nonce %d after %d and error %v", mined, nonce, err)
+	}
+	expectNotSynced("a reverted transaction")
+
+	// the tree is still marked, the next run anchors it
+	chain.syncJob(common.Address{}).Run()
+	if lastSync, err := chain.repo.GetLastConfirmedSync(ctx, treeID, chain.chainID); err != nil || lastSync == nil || lastSync.NodeCount != 3 {
+		t.Errorf("Expected Tree ID %d synced up to node 3, got %+v and error %v", treeID, lastSync, err)
+	}
+}
+
+func TestProofsReadFromSavedNodes(t *testing.T) {
+	ctx := context.Background()
+	chain := newTestChain(t)
+	nodes, leaves := chain.addLeaves(t, 0, 5)
+	treeID := nodes[0].TreeID
+	chain.syncJob(common.Address{}).Run()
+	unsynced, unsyncedLeaves := chain.addLeaves(t, 5, 7)
+	nodes, leaves = append(nodes, unsynced...), append(leaves, unsyncedLeaves...)
+
+	// a service without cached trees serves proofs without reading the leaves
+	uncached := services.NewMerkleService(leaflessRepo{chain.repo}, cache.NewLRU(10), nil)
+	for i, node := range nodes[:5] {
+		proof, err := uncached.GetSyncedProof(ctx, treeID, node.NodeID, chain.chainID)
+		if err != nil {
+			t.Fatalf("Failed to get synced proof of node %d: %v", node.NodeID, err)
+		}
+		if !chain.verifyVC(t, chain.issuer, treeID, leaves[i], proof) {
+			t.Errorf("Expected verifyVC to accept the synced proof of node %d", node.NodeID)
+		}
+	}
+	root, err := uncached.GetRoot(ctx, treeID)
+	if err != nil {
+		t.Fatalf("Failed to get root: %v", err)
+	}
+	if expected, _ := chain.merkle.GetRoot(ctx, treeID); !bytes.Equal(root, expected) {
+		t.Errorf("Expected root %x, got %x", expected, root)
+	}
+	for i, node := range nodes {
+		proof, err := uncached.GetProof(ctx, treeID, node.NodeID)
+		if err != nil {
+			t.Fatalf("Failed to get proof of node %d: %v", node.NodeID, err)
+		}
+		if !bytes.Equal(merkletree.RootFromProof(leaves[i], proof), root) {
+			t.Errorf("Expected the proof of node %d to lead to the root", node.NodeID)
+		}
+	}
+
+	// the nodes of a tree filled without the service are saved once it is built from its leaves
+	active, err := chain.repo.GetActiveTreeForInserting(ctx, "did:example:legacy")
+	if err != nil {
+		t.Fatalf("Failed to reserve a node: %v", err)
+	}
+	legacy := utils.Hash([]byte("legacy"))
+	if _, err := chain.repo.AddNode(ctx, active.TreeID, active.NodeCount, legacy); err != nil {
+		t.Fatalf("Failed to add node: %v", err)
+	}
+	if _, err := uncached.GetProof(ctx, active.TreeID, 1); err == nil {
+		t.Errorf("Expected the proof of a tree without saved nodes to need its leaves")
+	}
+	rebuilt := services.NewMerkleService(chain.repo, cache.NewLRU(10), nil)
+	if _, err := rebuilt.GetProof(ctx, active.TreeID, 1); err != nil {
+		t.Fatalf("Failed to get proof: %v", err)
+	}
+	if _, err := uncached.GetProof(ctx, active.TreeID, 1); err != nil {
+		t.Errorf("Expected the proof to be read from the saved nodes, got %v", err)
+	}
+}
+
+func TestReplicasSharingRedisCache(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	merkleRepo := storage.NewMerkleMemory()
+	firstCache := cache.NewRedis(client, cache.RedisConfig{Size: 10})
+	secondCache := cache.NewRedis(client, cache.RedisConfig{Size: 10})
+	replicas := []interfaces.Merkle{
+		services.NewMerkleService(merkleRepo, firstCache, nil),
+		services.NewMerkleService(leaflessRepo{merkleRepo}, secondCache, nil),
+	}
+
+	// the replicas take turns adding leaves to the tree the other one cached
+	var leaves [][]byte
+	var treeID int
+	for i := 0; i < 6; i++ {
+		leaf := utils.Hash([]byte(fmt.Sprintf("shared credential %d", i)))
+		node, err := replicas[i%2].AddLeaf(ctx, testIssuerDID, leaf)
+		if err != nil {
+			t.Fatalf("Failed to add leaf %d: %v", i, err)
+		}
+		if node.NodeID != i+1 || (i > 0 && node.TreeID != treeID) {
+			t.Fatalf("Expected leaf %d added to node %d of Tree ID %d, got %+v", i, i+1, treeID, node)
+		}
+		treeID = node.TreeID
+		leaves = append(leaves, leaf)
+	}
+
+	root, err := replicas[0].GetRoot(ctx, treeID)
+	if err != nil {
+		t.Fatalf("Failed to get root: %v", err)
+	}
+	for i, leaf := range leaves {
+		proof, err := replicas[1].GetProof(ctx, treeID, i+1)
+		if err != nil {
+			t.Fatalf("Failed to get proof of node %d: %v", i+1, err)
+		}
+		if !bytes.Equal(merkletree.RootFromProof(leaf, proof), root) {
+			t.Errorf("Expected the proof of node %d to lead to the root", i+1)
+		}
+	}
+	// the node IDs come from the database, the shared trees only serve the roots and proofs
+	if stats := secondCache.Stats(); stats.Trees.Hits == 0 || stats.ActiveTreeIDs.Hits+stats.ActiveTreeIDs.Misses != 0 {
+		t.Errorf("Expected the second replica to read the shared tree and not the active tree IDs, got %+v", stats)
+	}
+}
+
+func TestReplicasAddingLeavesConcurrently(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	merkleRepo := storage.NewMerkleMemory()
+	replicas := []interfaces.Merkle{
+		services.NewMerkleService(merkleRepo, cache.NewRedis(client, cache.RedisConfig{Size: 10}), nil),
+		services.NewMerkleService(merkleRepo, cache.NewRedis(client, cache.RedisConfig{Size: 10}), nil),
+	}
+
+	// both replicas add leaves for the same issuer at the same time
+	const perReplica = 12
+	var wg sync.WaitGroup
+	added := make([][]*entities.MerkleNode, len(replicas))
+	errs := make(chan error, len(replicas)*perReplica)
+	for r, replica := range replicas {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perReplica; i++ {
+				leaf := utils.Hash([]byte(fmt.Sprintf("replica %d credential %d", r, i)))
+				node, err := replica.AddLeaf(ctx, testIssuerDID, leaf)
+				if err != nil {
+					errs <- fmt.Errorf("replica %d failed to add leaf %d: %w", r, i, err)
+					continue
+				}
+				node.Data = leaf
+				added[r] = append(added[r], node)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if t.Failed() {
+		t.FailNow()
+	}
+
+	// every leaf went to its own node and no node was counted without its leaf
+	leaves := make([][]byte, 2*perReplica)
+	treeID := added[0][0].TreeID
+	for _, nodes := range added {
+		for _, node := range nodes {
+			if node.TreeID != treeID || node.NodeID < 1 || node.NodeID > len(leaves) || leaves[node.NodeID-1] != nil {
+				t.Fatalf("Expected every leaf added to its own node of Tree ID %d, got %+v", treeID, node)
+			}
+			leaves[node.NodeID-1] = node.Data
+		}
+	}
+	trees, err := merkleRepo.GetIssuerTrees(ctx, testIssuerDID)
+	if err != nil || len(trees) != 1 || trees[0].NodeCount != len(leaves) {
+		t.Fatalf("Expected one tree counting %d nodes, got %+v and error %v", len(leaves), trees, err)
+	}
+
+	expected, err := merkletree.NewMerkleTree(leaves, treeID)
+	if err != nil {
+		t.Fatalf("Failed to build tree: %v", err)
+	}
+	for r, replica := range replicas {
+		if root, err := replica.GetRoot(ctx, treeID); err != nil || !bytes.Equal(root, expected.GetMerkleRoot()) {
+			t.Errorf("Expected replica %d to serve the root of the %d leaves, got %x and error %v", r, len(leaves), root, err)
+		}
+	}
+}
+
+func TestRootsAndProofsAtPastSizes(t *testing.T) {
+	ctx := context.Background()
+	chain := newTestChain(t)
+	job := chain.syncJob(common.Address{})
+
+	nodes, leaves := chain.addLeaves(t, 0, 3)
+	treeID := nodes[0].TreeID
+	job.Run()
+	anchored, err := chain.merkle.GetSyncedRoot(ctx, treeID, chain.chainID)
+	if err != nil {
+		t.Fatalf("Failed to get synced root: %v", err)
+	}
+	chain.addLeaves(t, 3, 5)
+	job.Run()
+
+	// every add and sync is recorded in the history
+	history, err := chain.repo.GetTreeRoots(ctx, treeID)
+	if err != nil {
+		t.Fatalf("Failed to get tree roots: %v", err)
+	}
+	if len(history) != 5 || !bytes.Equal(history[2].Root, anchored) {
+		t.Fatalf("Expected 5 roots with the anchored one at size 3, got %d", len(history))
+	}
+
+	// holders of proofs issued against the older anchored root are still served
+	uncached := services.NewMerkleService(leaflessRepo{chain.repo}, cache.NewLRU(10), nil)
+	root, err := uncached.GetRootAt(ctx, treeID, 3)
+	if err != nil {
+		t.Fatalf("Failed to get root at size 3: %v", err)
+	}
+	if !bytes.Equal(root, anchored) {
+		t.Errorf("Expected the anchored root %x at size 3, got %x", anchored, root)
+	}
+	for i, node := range nodes {
+		proof, err := uncached.GetProofAt(ctx, treeID, node.NodeID, 3)
+		if err != nil {
+			t.Fatalf("Failed to get proof of node %d at size 3: %v", node.NodeID, err)
+		}
+		if !bytes.Equal(merkletree.RootFromProof(leaves[i], proof), anchored) {
+			t.Errorf("Expected the proof of node %d at size 3 to lead to the anchored root", node.NodeID)
+		}
+	}
+	if _, err := uncached.GetProofAt(ctx, treeID, 4, 3); err == nil {
+		t.Errorf("Expected no proof of node 4 at size 3")
+	}
+	if _, err := uncached.GetRootAt(ctx, treeID, 0); err == nil {
+		t.Errorf("Expected no root at size 0")
+	}
+
+	// the history of a tree filled without the service is rebuilt from its leaves
+	active, err := chain.repo.GetActiveTreeForInserting(ctx, "did:example:legacy")
+	if err != nil {
+		t.Fatalf("Failed to reserve a node: %v", err)
+	}
+	legacy := utils.Hash([]byte("legacy"))
+	if _, err := chain.repo.AddNode(ctx, active.TreeID, active.NodeCount, legacy); err != nil {
+		t.Fatalf("Failed to add node: %v", err)
+	}
+	if _, err := chain.merkle.GetRootAt(ctx, active.TreeID, 2); err == nil {
+		t.Errorf("Expected no root past the leaves of the tree")
+	}
+	proof, err := chain.merkle.GetProofAt(ctx, active.TreeID, 1, 1)
+	if err != nil {
+		t.Fatalf("Failed to get proof at size 1: %v", err)
+	}
+	if root, _ := chain.repo.GetTreeRoot(ctx, active.TreeID, 1); root == nil || !bytes.Equal(merkletree.RootFromProof(legacy, proof), root.Root) {
+		t.Errorf("Expected the rebuilt root at size 1 to be recorded, got %+v", root)
+	}
+}
+
+func TestSyncMerkleJobRecordsSentTransactions(t *testing.T) {
+	for name, checkpointIssuer := range map[string]common.Address{
+		"roots":      {},
+		"checkpoint": common.HexToAddress("0x00000000000000000000000000000000000c0de"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			chain := newTestChain(t)
+			unrecorded := &unrecordedRepo{Merkle: chain.repo}
+			job := NewSyncMerkleJob(ctx, unrecorded, &Chain{
+				Name:             "simulated",
+				ChainID:          chain.chainID,
+				Contract:         chain.contract,
+				Confirmations:    1,
+				CheckpointIssuer: checkpointIssuer,
+			}, issuer.StaticResolver{testIssuerDID: chain.issuer})
+			job.retryDelay = 0
+
+			// a failure to record the transaction is retried
+			nodes, _ := chain.addLeaves(t, 0, 2)
+			treeID := nodes[0].TreeID
+			unrecorded.failures = recordAttempts - 1
+			job.Run()
+			if lastSync, err := chain.repo.GetLastConfirmedSync(ctx, treeID, chain.chainID); err != nil || lastSync == nil || lastSync.NodeCount != 2 {
+				t.Fatalf("Expected Tree ID %d synced up to node 2, got %+v and error %v", treeID, lastSync, err)
+			}
+
+			// a transaction never recorded keeps its syncs pending until the next run records it
+			chain.addLeaves(t, 2, 3)
+			unrecorded.failures = recordAttempts
+			job.Run()
+			pending, err := chain.repo.GetPendingSyncs(ctx, chain.chainID)
+			if err != nil || len(pending) != 1 || pending[0].TxHash != "" {
+				t.Fatalf("Expected one pending sync without its transaction, got %+v and error %v", pending, err)
+			}
+			sent := job.submitted[pending[0].ID]
+			if sent == nil {
+				t.Fatalf("Expected the transaction of sync %d kept", pending[0].ID)
+			}
+			for {
+				if _, err := chain.sim.Client().TransactionReceipt(ctx, sent.Hash()); err == nil {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			job.Run()
+			lastSync, err := chain.repo.GetLastConfirmedSync(ctx, treeID, chain.chainID)
+			if err != nil || lastSync == nil || lastSync.ID != pending[0].ID || lastSync.TxHash != sent.Hash().Hex() {
+				t.Fatalf("Expected sync %d confirmed with transaction %s, got %+v and error %v", pending[0].ID, sent.Hash().Hex(), lastSync, err)
+			}
+			if len(job.submitted) != 0 {
+				t.Errorf("Expected no transaction kept once recorded, got %d", len(job.submitted))
+			}
+			if pending, _ := chain.repo.GetPendingSyncs(ctx, chain.chainID); len(pending) != 0 {
+				t.Errorf("Expected no pending syncs, got %d", len(pending))
+			}
+		})
+	}
+}
+
+func TestSyncMerkleJobCheckpointIssuerCollision(t *testing.T) {
+	ctx := context.Background()
+	chain := newTestChain(t)
+
+	// the issuer is also the checkpoint pseudo-issuer, its tree would overwrite a super-root
+	nodes, _ := chain.addLeaves(t, 0, 2)
+	chain.syncJob(chain.issuer).Run()
+	if lastSync, err := chain.repo.GetLastConfirmedSync(ctx, nodes[0].TreeID, chain.chainID); err != nil || lastSync != nil {
+		t.Errorf("Expected Tree ID %d not synced under the checkpoint issuer, got %+v and error %v", nodes[0].TreeID, lastSync, err)
+	}
+	if root, _ := chain.contract.GetTreeRoot(ctx, chain.issuer, 1); root != ([32]byte{}) {
+		t.Errorf("Expected nothing anchored under the checkpoint issuer, got %x", root)
+	}
+
+	// the tree is synced once the checkpoint issuer is another address
+	chain.syncJob(common.HexToAddress("0x00000000000000000000000000000000000c0de")).Run()
+	if lastSync, err := chain.repo.GetLastConfirmedSync(ctx, nodes[0].TreeID, chain.chainID); err != nil || lastSync == nil || lastSync.CheckpointID == 0 {
+		t.Errorf("Expected Tree ID %d synced in a checkpoint, got %+v and error %v", nodes[0].TreeID, lastSync, err)
+	}
+}
+
+func TestSyncMerkleJobCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	chain := newTestChain(t)
+	checkpointIssuer := common.HexToAddress("0x00000000000000000000000000000000000c0de")
+	job := chain.syncJob(checkpointIssuer)
+
+	// two trees of the issuer, a full one and a partial one, committed into one checkpoint
+	nodes, leaves := chain.addLeaves(t, 0, utils.MAX_LEAFS+2)
+	job.Run()
+
+	roots := NewReconcileJob(ctx, chain.repo, chain.chainID, chain.contract, issuer.StaticResolver{testIssuerDID: chain.issuer})
+	report, err := roots.Reconcile(ctx, false)
+	if err != nil {
+		t.Fatalf("Failed to reconcile: %v", err)
+	}
+	if report.Checked != 2 || len(report.Drifts) != 0 {
+		t.Errorf("Expected 2 checkpointed trees without drift, got %d trees and %d drifts", report.Checked, len(report.Drifts))
+	}
+
+	contractRoots := vc.NewContractRootSource(chain.sim.Client(), chain.address, nil)
+	syncedRoots := &vc.SyncedRootSource{Merkle: chain.merkle}
+	for _, i := range []int{0, utils.MAX_LEAFS - 1, utils.MAX_LEAFS + 1} {
+		envelope, err := vc.BuildProof(ctx, chain.merkle, nodes[i].TreeID, nodes[i].NodeID, vc.ProofOptions{
+			IssuerAddress:   chain.issuer,
+			ChainID:         chain.chainID,
+			ContractAddress: chain.address,
+		})
+		if err != nil {
+			t.Fatalf("Failed to build proof of leaf %d: %v", i, err)
+		}
+		if envelope.Checkpoint == nil || envelope.Checkpoint.IssuerAddress != checkpointIssuer.Hex() {
+			t.Fatalf("Expected a two-level proof for leaf %d, got %+v", i, envelope.Checkpoint)
+		}
+
+		result, err := verifier.New(contractRoots, nil).VerifyLeaf(ctx, leaves[i], envelope)
+		if err != nil {
+			t.Fatalf("Failed to verify leaf %d: %v", i, err)
+		}
+		if !result.Valid() {
+			t.Errorf("Expected leaf %d to be valid, got %s (%s)", i, result.Verdict, result.Reason)
+		}
+
+		// the synced roots trust the super-root of the checkpoint, not a tree of the same index
+		result, err = verifier.New(syncedRoots, nil).VerifyLeaf(ctx, leaves[i], envelope)
+		if err != nil {
+			t.Fatalf("Failed to verify leaf %d against the synced roots: %v", i, err)
+		}
+		if !result.Valid() || result.TrustedRoot != envelope.Checkpoint.MerkleRoot {
+			t.Errorf("Expected leaf %d valid against the synced super-root, got %s (%s)", i, result.Verdict, result.Reason)
+		}
+		if trusted, err := syncedRoots.Root(ctx, envelope); err != nil || hexutil.Encode(trusted) != envelope.Checkpoint.MerkleRoot {
+			t.Errorf("Expected the envelope of leaf %d to lead to the synced super-root, got %x and error %v", i, trusted, err)
+		}
+
+		// the tree root itself is not anchored, only the super-root
+		anchorIssuer, index, path, err := verifier.AnchoredProof(envelope)
+		if err != nil {
+			t.Fatalf("Failed to build anchored proof of leaf %d: %v", i, err)
+		}
+		if !chain.verifyVC(t, anchorIssuer, index, leaves[i], path) {
+			t.Errorf("Expected verifyVC to accept the chained proof of leaf %d", i)
+		}
+		if root, _ := chain.contract.GetTreeRoot(ctx, chain.issuer, nodes[i].TreeID); root != ([32]byte{}) {
+			t.Errorf("Expected no root anchored for Tree ID %d, got %x", nodes[i].TreeID, root)
+		}
+	}
+
+	// a checkpoint of another chain or never confirmed is not trusted
+	envelope, err := vc.BuildProof(ctx, chain.merkle, nodes[0].TreeID, nodes[0].NodeID, vc.ProofOptions{
+		IssuerAddress: chain.issuer,
+		ChainID:       chain.chainID,
+	})
+	if err != nil {
+		t.Fatalf("Failed to build proof: %v", err)
+	}
+	envelope.ChainID = chain.chainID + 1
+	if _, err := syncedRoots.Root(ctx, envelope); !errors.Is(err, vc.ErrTreeMissing) {
+		t.Errorf("Expected ErrTreeMissing for a checkpoint of another chain, got %v", err)
+	}
+	envelope.ChainID = chain.chainID
+	envelope.Checkpoint.CheckpointIndex++
+	if _, err := syncedRoots.Root(ctx, envelope); !errors.Is(err, vc.ErrTreeMissing) {
+		t.Errorf("Expected ErrTreeMissing for an unknown checkpoint, got %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package cronjob
+
+import (
+	"context"
+	"log"
+	"merkle_module/domain/entities"
+	"merkle_module/domain/repo"
+	"merkle_module/issuer"
+	credential "merkle_module/smartcontract"
+	"merkle_module/statuslist"
+)
+
+type SyncStatusListJob struct {
+	ctx      context.Context
+	repo     repo.StatusList
+	contract *credential.SmartContract
+	issuers  issuer.Resolver
+}
+
+func NewSyncStatusListJob(ctx context.Context, repo repo.StatusList, contract *credential.SmartContract, issuers issuer.Resolver) *SyncStatusListJob {
+	if issuers == nil {
+		issuers = issuer.DIDResolver{}
+	}
+	return &SyncStatusListJob{
+		ctx:      ctx,
+		repo:     repo,
+		contract: contract,
+		issuers:  issuers,
+	}
+}
+
+// Run publishes the status lists changed since the last run, implementing the Job interface.
+func (j *SyncStatusListJob) Run() {
+	lists, err := j.repo.GetStatusListsForPublish(j.ctx)
+	if err != nil {
+		log.Printf("Error getting status lists for publish: %v", err)
+		return
+	}
+
+	// If no lists, exit early
+	if len(lists) == 0 {
+		log.Println("No status lists to publish")
+		return
+	}
+
+	if j.contract == nil {
+		log.Println("Smart contract is not initialized, skipping publishing status lists")
+		return
+	}
+
+	// One transaction per issuer, the contract emits the batch under the issuer address
+	byIssuer := make(map[string][]*entities.StatusList)
+	var issuerDIDs []string
+	for _, list := range lists {
+		if _, exists := byIssuer[list.IssuerDID]; !exists {
+			issuerDIDs = append(issuerDIDs, list.IssuerDID)
+		}
+		byIssuer[list.IssuerDID] = append(byIssuer[list.IssuerDID], list)
+	}
+
+	for _, issuerDID := range issuerDIDs {
+		issuerAddress, err := j.issuers.Resolve(j.ctx, issuerDID)
+		if err != nil {
+			log.Printf("Skipping status lists of issuer %s: %v", issuerDID, err)
+			continue
+		}
+
+		var listIDs []string
+		var encodedLists [][]byte
+		var published []*entities.StatusList
+		for _, list := range byIssuer[issuerDID] {
+			encoded, err := statuslist.FromBytes(list.Bits).Encode()
+			if err != nil {
+				log.Printf("Error encoding status list %s: %v", list.ListID, err)
+				continue
+			}
+			// publish the same encodedList value as in the status list credential
+			listIDs = append(listIDs, list.ListID)
+			encodedLists = append(encodedLists, []byte(encoded))
+			published = append(published, list)
+		}
+		if len(listIDs) == 0 {
+			continue
+		}
+
+		if err := j.contract.SendStatusLists(issuerAddress, listIDs, encodedLists); err != nil {
+			log.Printf("Error sending status lists of issuer %s to smart contract: %v", issuerDID, err)
+			continue
+		}
+
+		// Only the versions read before sending are marked, later changes stay pending
+		for _, list := range published {
+			if err := j.repo.MarkStatusListPublished(j.ctx, list.ID, list.Version); err != nil {
+				log.Printf("Error marking status list %s as published: %v", list.ListID, err)
+			}
+		}
+		log.Printf("Published %d status lists of issuer %s", len(listIDs), issuerDID)
+	}
+}
@@ -0,0 +1,91 @@
+package cronjob
+
+import (
+	"context"
+	"testing"
+
+	"merkle_module/domain/entities"
+	"merkle_module/infra/storage"
+	"merkle_module/issuer"
+	credential "merkle_module/smartcontract"
+	"merkle_module/statuslist"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// publishedStatusLists returns the BatchRevokeStatusListUpdated events emitted by the contract
+func (c *testChain) publishedStatusLists(t *testing.T) []*credential.CredentialBatchRevokeStatusListUpdated {
+	t.Helper()
+	contract, err := credential.NewCredential(c.address, c.sim.Client())
+	if err != nil {
+		t.Fatalf("Failed to bind contract: %v", err)
+	}
+	events, err := contract.FilterBatchRevokeStatusListUpdated(&bind.FilterOpts{Context: context.Background()}, nil)
+	if err != nil {
+		t.Fatalf("Failed to filter status list events: %v", err)
+	}
+	defer events.Close()
+	var published []*credential.CredentialBatchRevokeStatusListUpdated
+	for events.Next() {
+		published = append(published, events.Event)
+	}
+	return published
+}
+
+func TestSyncStatusListJobPublishesChangedLists(t *testing.T) {
+	ctx := context.Background()
+	chain := newTestChain(t)
+	lists := storage.NewStatusListMemory()
+	otherDID, otherIssuer := "did:example:other", common.HexToAddress("0x0b")
+	job := NewSyncStatusListJob(ctx, lists, chain.contract, issuer.StaticResolver{testIssuerDID: chain.issuer, otherDID: otherIssuer})
+
+	entry, err := lists.AllocateIndex(ctx, testIssuerDID, entities.StatusPurposeRevocation)
+	if err != nil {
+		t.Fatalf("Failed to allocate index: %v", err)
+	}
+	other, err := lists.AllocateIndex(ctx, otherDID, entities.StatusPurposeRevocation)
+	if err != nil {
+		t.Fatalf("Failed to allocate index: %v", err)
+	}
+
+	// nothing is sent before a status changes
+	job.Run()
+	if published := chain.publishedStatusLists(t); len(published) != 0 {
+		t.Fatalf("Expected no status list published, got %d", len(published))
+	}
+
+	// only the changed list is sent, under its issuer, as the encodedList of its credential
+	if _, err := lists.SetStatus(ctx, entry.StatusListID, entry.Index, true); err != nil {
+		t.Fatalf("Failed to set status: %v", err)
+	}
+	job.Run()
+	published := chain.publishedStatusLists(t)
+	if len(published) != 1 {
+		t.Fatalf("Expected 1 batchUpdateRevokeStatusList call, got %d", len(published))
+	}
+	if published[0].Issuer != chain.issuer || len(published[0].RevokeStatusListIds) != 1 || published[0].RevokeStatusListIds[0] != entry.ListID {
+		t.Fatalf("Expected status list %s of %s published, got %v of %s", entry.ListID, chain.issuer, published[0].RevokeStatusListIds, published[0].Issuer)
+	}
+	decoded, err := statuslist.Decode(string(published[0].RevokeStatusLists[0]))
+	if err != nil {
+		t.Fatalf("Failed to decode the published list: %v", err)
+	}
+	if revoked, _ := decoded.Get(entry.Index); !revoked {
+		t.Errorf("Expected index %d revoked in the published list", entry.Index)
+	}
+
+	// a published list is not sent again until it changes
+	job.Run()
+	if published := chain.publishedStatusLists(t); len(published) != 1 {
+		t.Fatalf("Expected no list published again, got %d calls", len(published))
+	}
+	if _, err := lists.SetStatus(ctx, other.StatusListID, other.Index, true); err != nil {
+		t.Fatalf("Failed to set status: %v", err)
+	}
+	job.Run()
+	published = chain.publishedStatusLists(t)
+	if len(published) != 2 || published[1].Issuer != otherIssuer || len(published[1].RevokeStatusListIds) != 1 || published[1].RevokeStatusListIds[0] != other.ListID {
+		t.Errorf("Expected only status list %s of %s published next, got %d calls", other.ListID, otherIssuer, len(published))
+	}
+}
@@ -0,0 +1,105 @@
+package did
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var ErrNoEthereumAddress = errors.New("no Ethereum verification method")
+
+// EthereumAddress returns the controller address of the document, from the first verification method
+// carrying a blockchain account or a secp256k1 public key
+func (d *Document) EthereumAddress() (common.Address, error) {
+	for _, method := range d.VerificationMethod {
+		address, err := method.EthereumAddress()
+		if errors.Is(err, ErrNoEthereumAddress) {
+			continue
+		}
+		if err != nil {
+			return common.Address{}, fmt.Errorf("invalid verification method %s: %w", method.ID, err)
+		}
+		return address, nil
+	}
+	return common.Address{}, fmt.Errorf("%w in %s", ErrNoEthereumAddress, d.ID)
+}
+
+func (m *VerificationMethod) EthereumAddress() (common.Address, error) {
+	switch {
+	case m.BlockchainAccountID != "":
+		// CAIP-10 account id, eip155:<chain id>:<address>
+		parts := strings.Split(m.BlockchainAccountID, ":")
+		if len(parts) != 3 || parts[0] != "eip155" {
+			return common.Address{}, ErrNoEthereumAddress
+		}
+		if !common.IsHexAddress(parts[2]) {
+			return common.Address{}, fmt.Errorf("invalid blockchain account %q", m.BlockchainAccountID)
+		}
+		return common.HexToAddress(parts[2]), nil
+
+	case m.PublicKeyHex != "" && strings.Contains(m.Type, "Secp256k1"):
+		publicKey, err := hexutil.Decode("0x" + strings.TrimPrefix(m.PublicKeyHex, "0x"))
+		if err != nil {
+			return common.Address{}, fmt.Errorf("invalid public key: %w", err)
+		}
+		return secp256k1Address(publicKey)
+
+	case m.PublicKeyMultibase != "":
+		codec, publicKey, err := decodeMultikey(m.PublicKeyMultibase)
+		if err != nil || string(codec) != string(CodecSecp256k1) {
+			return common.Address{}, ErrNoEthereumAddress
+		}
+		return secp256k1Address(publicKey)
+
+	case m.PublicKeyJwk != nil:
+		if m.PublicKeyJwk["kty"] != "EC" || m.PublicKeyJwk["crv"] != "secp256k1" {
+			return common.Address{}, ErrNoEthereumAddress
+		}
+		x, errX := jwkCoordinate(m.PublicKeyJwk["x"])
+		y, errY := jwkCoordinate(m.PublicKeyJwk["y"])
+		if errX != nil || errY != nil {
+			return common.Address{}, fmt.Errorf("invalid secp256k1 JWK")
+		}
+		publicKey := append([]byte{0x04}, append(common.LeftPadBytes(x, 32), common.LeftPadBytes(y, 32)...)...)
+		return secp256k1Address(publicKey)
+	}
+	return common.Address{}, ErrNoEthereumAddress
+}
+
+// helper function to get the address of a compressed or uncompressed secp256k1 public key
+func secp256k1Address(publicKey []byte) (common.Address, error) {
+	if len(publicKey) == 33 {
+		key, err := crypto.DecompressPubkey(publicKey)
+		if err != nil {
+			return common.Address{}, fmt.Errorf("invalid public key: %w", err)
+		}
+		return crypto.PubkeyToAddress(*key), nil
+	}
+	key, err := crypto.UnmarshalPubkey(publicKey)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("invalid public key: %w", err)
+	}
+	return crypto.PubkeyToAddress(*key), nil
+}
+
+func jwkCoordinate(value any) ([]byte, error) {
+	s, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("missing coordinate")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	coordinate := new(big.Int).SetBytes(data)
+	if coordinate.BitLen() > 256 {
+		return nil, fmt.Errorf("coordinate too large")
+	}
+	return coordinate.Bytes(), nil
+}
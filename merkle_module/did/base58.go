@@ -0,0 +1,53 @@
+package did
+
+import (
+	"fmt"
+	"math/big"
+)
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+var base58Radix = big.NewInt(58)
+
+// helper function to decode the base58btc alphabet used by did:key
+func base58Decode(s string) ([]byte, error) {
+	value := new(big.Int)
+	for i := 0; i < len(s); i++ {
+		digit := -1
+		for j := 0; j < len(base58Alphabet); j++ {
+			if base58Alphabet[j] == s[i] {
+				digit = j
+				break
+			}
+		}
+		if digit < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", s[i])
+		}
+		value.Mul(value, base58Radix)
+		value.Add(value, big.NewInt(int64(digit)))
+	}
+
+	// leading '1's are leading zero bytes
+	zeros := 0
+	for zeros < len(s) && s[zeros] == '1' {
+		zeros++
+	}
+	return append(make([]byte, zeros), value.Bytes()...), nil
+}
+
+func base58Encode(data []byte) string {
+	value := new(big.Int).SetBytes(data)
+	mod := new(big.Int)
+	var out []byte
+	for value.Sign() > 0 {
+		value.DivMod(value, base58Radix, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	for i := 0; i < len(data) && data[i] == 0; i++ {
+		out = append(out, '1')
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
@@ -0,0 +1,159 @@
+package did
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidDID        = errors.New("invalid DID")
+	ErrUnsupportedMethod = errors.New("unsupported DID method")
+	ErrNotFound          = errors.New("DID not found")
+	ErrDeactivated       = errors.New("DID is deactivated")
+)
+
+// Document is the subset of a DID document used to identify issuers
+type Document struct {
+	Context            any                  `json:"@context,omitempty"`
+	ID                 string               `json:"id"`
+	Controller         any                  `json:"controller,omitempty"`
+	VerificationMethod []VerificationMethod `json:"verificationMethod,omitempty"`
+	Authentication     []any                `json:"authentication,omitempty"`
+	AssertionMethod    []any                `json:"assertionMethod,omitempty"`
+}
+
+type VerificationMethod struct {
+	ID                  string         `json:"id"`
+	Type                string         `json:"type"`
+	Controller          string         `json:"controller"`
+	PublicKeyMultibase  string         `json:"publicKeyMultibase,omitempty"`
+	PublicKeyHex        string         `json:"publicKeyHex,omitempty"`
+	PublicKeyJwk        map[string]any `json:"publicKeyJwk,omitempty"`
+	BlockchainAccountID string         `json:"blockchainAccountId,omitempty"`
+}
+
+type Metadata struct {
+	Deactivated bool `json:"deactivated,omitempty"`
+}
+
+type Resolution struct {
+	Document *Document
+	Metadata Metadata
+}
+
+// Resolver resolves a DID to its document. A deactivated DID is reported in the metadata, not as an error
+type Resolver interface {
+	Resolve(ctx context.Context, did string) (*Resolution, error)
+}
+
+// Driver resolves the DIDs of a single method
+type Driver interface {
+	Resolver
+	Method() string
+}
+
+// DID is a parsed decentralized identifier, did:<method>:<id>
+type DID struct {
+	Method string
+	ID     string
+}
+
+func Parse(did string) (*DID, error) {
+	parts := strings.SplitN(did, ":", 3)
+	if len(parts) != 3 || parts[0] != "did" || parts[1] == "" || parts[2] == "" {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidDID, did)
+	}
+	// the fragment and query are not part of the identifier
+	id := parts[2]
+	if idx := strings.IndexAny(id, "?#"); idx >= 0 {
+		id = id[:idx]
+	}
+	return &DID{Method: parts[1], ID: id}, nil
+}
+
+func (d *DID) String() string {
+	return "did:" + d.Method + ":" + d.ID
+}
+
+// MethodResolver dispatches to the driver of the DID method
+type MethodResolver map[string]Driver
+
+func NewResolver(drivers ...Driver) MethodResolver {
+	resolver := make(MethodResolver, len(drivers))
+	for _, driver := range drivers {
+		resolver[driver.Method()] = driver
+	}
+	return resolver
+}
+
+func (m MethodResolver) Resolve(ctx context.Context, did string) (*Resolution, error) {
+	parsed, err := Parse(did)
+	if err != nil {
+		return nil, err
+	}
+	driver, exists := m[parsed.Method]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedMethod, parsed.Method)
+	}
+	return driver.Resolve(ctx, parsed.String())
+}
+
+// ResolveActive resolves the DID and returns ErrDeactivated if it has been deactivated
+func ResolveActive(ctx context.Context, resolver Resolver, did string) (*Document, error) {
+	resolution, err := resolver.Resolve(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+	if resolution.Metadata.Deactivated {
+		return nil, fmt.Errorf("%w: %s", ErrDeactivated, did)
+	}
+	return resolution.Document, nil
+}
+
+// CachedResolver keeps successful resolutions for a while, so issuing credentials does not hit the network for every leaf
+type CachedResolver struct {
+	resolver Resolver
+	ttl      time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]cachedResolution
+}
+
+type cachedResolution struct {
+	resolution *Resolution
+	expires    time.Time
+}
+
+func NewCachedResolver(resolver Resolver, ttl time.Duration) *CachedResolver {
+	return &CachedResolver{
+		resolver: resolver,
+		ttl:      ttl,
+		now:      time.Now,
+		entries:  make(map[string]cachedResolution),
+	}
+}
+
+func (c *CachedResolver) Resolve(ctx context.Context, did string) (*Resolution, error) {
+	c.mu.Lock()
+	entry, exists := c.entries[did]
+	c.mu.Unlock()
+	if exists && c.now().Before(entry.expires) {
+		return entry.resolution, nil
+	}
+
+	resolution, err := c.resolver.Resolve(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.entries[did] = cachedResolution{resolution: resolution, expires: c.now().Add(c.ttl)}
+	c.mu.Unlock()
+
+	return resolution, nil
+}
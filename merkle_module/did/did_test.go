@@ -0,0 +1,259 @@
+package did
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestParse(t *testing.T) {
+	parsed, err := Parse("did:web:example.com:issuers:1#key-1")
+	if err != nil {
+		t.Fatalf("Failed to parse DID: %v", err)
+	}
+	if parsed.Method != "web" || parsed.ID != "example.com:issuers:1" {
+		t.Errorf("Unexpected parsed DID %+v", parsed)
+	}
+
+	for _, invalid := range []string{"", "did:web", "did::x", "urn:web:example.com"} {
+		if _, err := Parse(invalid); !errors.Is(err, ErrInvalidDID) {
+			t.Errorf("Expected ErrInvalidDID for %q, got %v", invalid, err)
+		}
+	}
+}
+
+func TestKeyDriver(t *testing.T) {
+	ctx := context.Background()
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	did := KeyDID(CodecSecp256k1, crypto.CompressPubkey(&key.PublicKey))
+	document, err := ResolveActive(ctx, KeyDriver{}, did)
+	if err != nil {
+		t.Fatalf("Failed to resolve %s: %v", did, err)
+	}
+	address, err := document.EthereumAddress()
+	if err != nil {
+		t.Fatalf("Failed to derive address: %v", err)
+	}
+	if address != crypto.PubkeyToAddress(key.PublicKey) {
+		t.Errorf("Expected address %s, got %s", crypto.PubkeyToAddress(key.PublicKey).Hex(), address.Hex())
+	}
+
+	// An Ed25519 key resolves but has no Ethereum address
+	publicKey, _, _ := ed25519.GenerateKey(rand.Reader)
+	document, err = ResolveActive(ctx, KeyDriver{}, KeyDID(CodecEd25519, publicKey))
+	if err != nil {
+		t.Fatalf("Failed to resolve Ed25519 did:key: %v", err)
+	}
+	if _, err := document.EthereumAddress(); !errors.Is(err, ErrNoEthereumAddress) {
+		t.Errorf("Expected ErrNoEthereumAddress, got %v", err)
+	}
+
+	// The known test vector of the did:key specification
+	if _, err := (KeyDriver{}).Resolve(ctx, "did:key:z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK"); err != nil {
+		t.Errorf("Failed to resolve the specification example: %v", err)
+	}
+	if _, err := (KeyDriver{}).Resolve(ctx, "did:key:z6Mk"); !errors.Is(err, ErrInvalidDID) {
+		t.Errorf("Expected ErrInvalidDID for a truncated key, got %v", err)
+	}
+}
+
+func TestWebDriver(t *testing.T) {
+	ctx := context.Background()
+	address := common.HexToAddress("0x5A9cC578d5CC3Af41caf52c3818E77Af8Ff578D2")
+
+	documents := map[string]*Document{
+		"https://example.com/.well-known/did.json": {
+			ID: "did:web:example.com",
+			VerificationMethod: []VerificationMethod{
+				{ID: "did:web:example.com#ed", Type: "Multikey", PublicKeyMultibase: "z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK"},
+				{ID: "did:web:example.com#eth", Type: "EcdsaSecp256k1RecoveryMethod2020", BlockchainAccountID: "eip155:1:" + address.Hex()},
+			},
+		},
+		"https://localhost:8443/issuers/a/did.json": {ID: "did:web:localhost%3A8443:issuers:a"},
+		"https://example.org/.well-known/did.json":  {ID: "did:web:example.com"},
+	}
+	var fetched []string
+	driver := NewWebDriver(FetcherFunc(func(ctx context.Context, url string) ([]byte, error) {
+		fetched = append(fetched, url)
+		switch url {
+		case "https://gone.example/.well-known/did.json":
+			return nil, ErrDeactivated
+		}
+		document, exists := documents[url]
+		if !exists {
+			return nil, ErrNotFound
+		}
+		return json.Marshal(document)
+	}))
+
+	document, err := ResolveActive(ctx, driver, "did:web:example.com")
+	if err != nil {
+		t.Fatalf("Failed to resolve did:web: %v", err)
+	}
+	if got, err := document.EthereumAddress(); err != nil || got != address {
+		t.Errorf("Expected address %s, got %s (%v)", address.Hex(), got.Hex(), err)
+	}
+
+	if _, err := driver.Resolve(ctx, "did:web:localhost%3A8443:issuers:a"); err != nil {
+		t.Errorf("Failed to resolve did:web with port and path: %v", err)
+	}
+	if _, err := driver.Resolve(ctx, "did:web:missing.example"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if _, err := ResolveActive(ctx, driver, "did:web:gone.example"); !errors.Is(err, ErrDeactivated) {
+		t.Errorf("Expected ErrDeactivated, got %v", err)
+	}
+	if _, err := driver.Resolve(ctx, "did:web:example.org"); err == nil {
+		t.Errorf("Expected an error for a document with another id")
+	}
+	if _, err := driver.Resolve(ctx, "did:web:example.com:a%2Fb"); !errors.Is(err, ErrInvalidDID) {
+		t.Errorf("Expected ErrInvalidDID for an encoded slash, got %v", err)
+	}
+
+	expected := []string{
+		"https://example.com/.well-known/did.json",
+		"https://localhost:8443/issuers/a/did.json",
+	}
+	for i, url := range expected {
+		if fetched[i] != url {
+			t.Errorf("Expected fetch %d of %s, got %s", i, url, fetched[i])
+		}
+	}
+}
+
+type fakeRegistry map[common.Address]common.Address
+
+func (f fakeRegistry) IdentityOwner(ctx context.Context, identity common.Address) (common.Address, error) {
+	if owner, exists := f[identity]; exists {
+		return owner, nil
+	}
+	return identity, nil
+}
+
+func TestEthrDriver(t *testing.T) {
+	ctx := context.Background()
+	identity := common.HexToAddress("0x5A9cC578d5CC3Af41caf52c3818E77Af8Ff578D2")
+	newOwner := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	deactivated := common.HexToAddress("0x00000000000000000000000000000000000000bb")
+
+	driver := NewEthrDriver(map[string]OwnerReader{
+		"sepolia": fakeRegistry{identity: newOwner, deactivated: {}},
+	})
+
+	cases := []struct {
+		did      string
+		expected common.Address
+	}{
+		// without a registry for mainnet the identity controls itself
+		{"did:ethr:" + identity.Hex(), identity},
+		{"did:ethr:sepolia:" + identity.Hex(), newOwner},
+	}
+	for _, c := range cases {
+		document, err := ResolveActive(ctx, driver, c.did)
+		if err != nil {
+			t.Errorf("Failed to resolve %s: %v", c.did, err)
+			continue
+		}
+		if got, err := document.EthereumAddress(); err != nil || got != c.expected {
+			t.Errorf("Expected controller %s for %s, got %s (%v)", c.expected.Hex(), c.did, got.Hex(), err)
+		}
+	}
+
+	key, _ := crypto.GenerateKey()
+	keyDID := "did:ethr:0x" + common.Bytes2Hex(crypto.CompressPubkey(&key.PublicKey))
+	document, err := ResolveActive(ctx, driver, keyDID)
+	if err != nil {
+		t.Fatalf("Failed to resolve %s: %v", keyDID, err)
+	}
+	if len(document.VerificationMethod) != 2 {
+		t.Errorf("Expected the controller key in the document, got %d methods", len(document.VerificationMethod))
+	}
+	if got, _ := document.EthereumAddress(); got != crypto.PubkeyToAddress(key.PublicKey) {
+		t.Errorf("Expected the address of the public key, got %s", got.Hex())
+	}
+
+	if _, err := ResolveActive(ctx, driver, "did:ethr:sepolia:"+deactivated.Hex()); !errors.Is(err, ErrDeactivated) {
+		t.Errorf("Expected ErrDeactivated, got %v", err)
+	}
+	if _, err := driver.Resolve(ctx, "did:ethr:0x1234"); !errors.Is(err, ErrInvalidDID) {
+		t.Errorf("Expected ErrInvalidDID, got %v", err)
+	}
+}
+
+func TestParseEthr(t *testing.T) {
+	identity := common.HexToAddress("0x5A9cC578d5CC3Af41caf52c3818E77Af8Ff578D2")
+	parsed, err := ParseEthr("did:ethr:" + identity.Hex() + "#controller")
+	if err != nil {
+		t.Fatalf("Failed to parse did:ethr: %v", err)
+	}
+	if parsed.DID != "did:ethr:"+identity.Hex() || parsed.Network != DefaultEthrNetwork || parsed.Identity != identity || parsed.PublicKey != nil {
+		t.Errorf("Unexpected parsed did:ethr %+v", parsed)
+	}
+
+	key, _ := crypto.GenerateKey()
+	compressed := crypto.CompressPubkey(&key.PublicKey)
+	parsed, err = ParseEthr("did:ethr:0xaa36a7:0x" + common.Bytes2Hex(compressed))
+	if err != nil {
+		t.Fatalf("Failed to parse did:ethr: %v", err)
+	}
+	if parsed.Network != "0xaa36a7" || parsed.Identity != crypto.PubkeyToAddress(key.PublicKey) || !bytes.Equal(parsed.PublicKey, compressed) {
+		t.Errorf("Unexpected parsed did:ethr %+v", parsed)
+	}
+
+	if _, err := ParseEthr("did:web:example.com"); !errors.Is(err, ErrUnsupportedMethod) {
+		t.Errorf("Expected ErrUnsupportedMethod, got %v", err)
+	}
+	if _, err := ParseEthr("did:ethr:sepolia:0x1234"); !errors.Is(err, ErrInvalidDID) {
+		t.Errorf("Expected ErrInvalidDID, got %v", err)
+	}
+}
+
+type countingResolver struct {
+	calls int
+}
+
+func (c *countingResolver) Resolve(ctx context.Context, did string) (*Resolution, error) {
+	c.calls++
+	return &Resolution{Document: &Document{ID: did}}, nil
+}
+
+func TestMethodAndCachedResolver(t *testing.T) {
+	ctx := context.Background()
+	resolver := NewResolver(KeyDriver{}, NewEthrDriver(nil))
+	if _, err := resolver.Resolve(ctx, "did:example:123"); !errors.Is(err, ErrUnsupportedMethod) {
+		t.Errorf("Expected ErrUnsupportedMethod, got %v", err)
+	}
+	if _, err := resolver.Resolve(ctx, "did:ethr:0x5A9cC578d5CC3Af41caf52c3818E77Af8Ff578D2#controller"); err != nil {
+		t.Errorf("Failed to resolve a DID URL: %v", err)
+	}
+
+	counting := &countingResolver{}
+	cached := NewCachedResolver(counting, time.Minute)
+	now := time.Now()
+	cached.now = func() time.Time { return now }
+	for i := 0; i < 3; i++ {
+		if _, err := cached.Resolve(ctx, "did:example:123"); err != nil {
+			t.Fatalf("Failed to resolve: %v", err)
+		}
+	}
+	if counting.calls != 1 {
+		t.Errorf("Expected 1 resolution, got %d", counting.calls)
+	}
+	now = now.Add(2 * time.Minute)
+	cached.Resolve(ctx, "did:example:123")
+	if counting.calls != 2 {
+		t.Errorf("Expected the expired entry to be resolved again, got %d resolutions", counting.calls)
+	}
+}
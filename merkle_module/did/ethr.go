@@ -0,0 +1,176 @@
+package did
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// DefaultEthrNetwork is the network of did:ethr identifiers without a network segment
+const DefaultEthrNetwork = "mainnet"
+
+// OwnerReader reads the current owner of an identity from an ERC-1056 registry
+type OwnerReader interface {
+	IdentityOwner(ctx context.Context, identity common.Address) (common.Address, error)
+}
+
+// EthrDriver resolves did:ethr identifiers. The controller is read from the registry of the DID's
+// network; without a registry for the network the identity controls itself, as in a fresh registry
+type EthrDriver struct {
+	registries map[string]OwnerReader
+}
+
+// NewEthrDriver creates a did:ethr driver with the ERC-1056 registries by network name or hex chain ID
+func NewEthrDriver(registries map[string]OwnerReader) *EthrDriver {
+	if registries == nil {
+		registries = make(map[string]OwnerReader)
+	}
+	return &EthrDriver{registries: registries}
+}
+
+func (e *EthrDriver) Method() string {
+	return "ethr"
+}
+
+func (e *EthrDriver) Resolve(ctx context.Context, did string) (*Resolution, error) {
+	parsed, err := ParseEthr(did)
+	if err != nil {
+		return nil, err
+	}
+	id, identity, publicKey := parsed.DID, parsed.Identity, parsed.PublicKey
+
+	owner := identity
+	if registry, exists := e.registries[parsed.Network]; exists {
+		owner, err = registry.IdentityOwner(ctx, identity)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the owner of %s: %w", did, err)
+		}
+	}
+
+	// The registry sets the owner to the zero address to deactivate an identity
+	if owner == (common.Address{}) {
+		return &Resolution{Document: &Document{ID: id}, Metadata: Metadata{Deactivated: true}}, nil
+	}
+
+	controllerID := id + "#controller"
+	document := &Document{
+		Context: []any{"https://www.w3.org/ns/did/v1"},
+		ID:      id,
+		VerificationMethod: []VerificationMethod{{
+			ID:                  controllerID,
+			Type:                "EcdsaSecp256k1RecoveryMethod2020",
+			Controller:          id,
+			BlockchainAccountID: fmt.Sprintf("eip155:%s:%s", ethrChainID(parsed.Network), owner.Hex()),
+		}},
+		Authentication:  []any{controllerID},
+		AssertionMethod: []any{controllerID},
+	}
+
+	// The public key is only valid while the identity has not changed owner
+	if publicKey != nil && owner == identity {
+		keyID := id + "#controllerKey"
+		document.VerificationMethod = append(document.VerificationMethod, VerificationMethod{
+			ID:           keyID,
+			Type:         "EcdsaSecp256k1VerificationKey2019",
+			Controller:   id,
+			PublicKeyHex: hexutil.Encode(publicKey)[2:],
+		})
+		document.Authentication = append(document.Authentication, keyID)
+		document.AssertionMethod = append(document.AssertionMethod, keyID)
+	}
+
+	return &Resolution{Document: document}, nil
+}
+
+// EthrDID is a parsed did:ethr:[network:]<address | compressed public key>
+type EthrDID struct {
+	DID       string         // the DID without its fragment and query
+	Network   string         // DefaultEthrNetwork when the DID has no network
+	Identity  common.Address // the address, or the address of the public key
+	PublicKey []byte         // the compressed public key, nil for an address
+}
+
+// ParseEthr parses a did:ethr without resolving its controller, which is the identity itself
+// until the identity changes owner in the registry of its network
+func ParseEthr(did string) (*EthrDID, error) {
+	parsed, err := Parse(did)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Method != "ethr" {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedMethod, parsed.Method)
+	}
+
+	network := DefaultEthrNetwork
+	identifier := parsed.ID
+	if idx := strings.LastIndex(parsed.ID, ":"); idx >= 0 {
+		network, identifier = parsed.ID[:idx], parsed.ID[idx+1:]
+	}
+	identity, publicKey, err := parseEthrIdentifier(identifier)
+	if err != nil {
+		return nil, err
+	}
+	return &EthrDID{DID: parsed.String(), Network: network, Identity: identity, PublicKey: publicKey}, nil
+}
+
+// helper function to parse the address or compressed public key of a did:ethr
+func parseEthrIdentifier(identifier string) (common.Address, []byte, error) {
+	if len(identifier) == 42 && common.IsHexAddress(identifier) {
+		return common.HexToAddress(identifier), nil, nil
+	}
+
+	publicKey, err := hexutil.Decode(identifier)
+	if err != nil || len(publicKey) != 33 {
+		return common.Address{}, nil, fmt.Errorf("%w: invalid did:ethr identifier %q", ErrInvalidDID, identifier)
+	}
+	key, err := crypto.DecompressPubkey(publicKey)
+	if err != nil {
+		return common.Address{}, nil, fmt.Errorf("%w: invalid did:ethr public key: %v", ErrInvalidDID, err)
+	}
+	return crypto.PubkeyToAddress(*key), publicKey, nil
+}
+
+// helper function to get the CAIP-2 chain ID of a did:ethr network
+func ethrChainID(network string) string {
+	switch network {
+	case "mainnet":
+		return "1"
+	case "sepolia":
+		return "11155111"
+	case "holesky":
+		return "17000"
+	}
+	if chainID, err := hexutil.DecodeUint64(network); err == nil {
+		return fmt.Sprint(chainID)
+	}
+	return network
+}
+
+const ethrRegistryABI = `[{"constant":true,"inputs":[{"name":"identity","type":"address"}],"name":"identityOwner","outputs":[{"name":"","type":"address"}],"type":"function","stateMutability":"view"}]`
+
+// EthrRegistry reads identity owners from a deployed ERC-1056 EthereumDIDRegistry
+type EthrRegistry struct {
+	contract *bind.BoundContract
+}
+
+func NewEthrRegistry(address common.Address, caller bind.ContractCaller) (*EthrRegistry, error) {
+	parsed, err := abi.JSON(strings.NewReader(ethrRegistryABI))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse registry ABI: %w", err)
+	}
+	return &EthrRegistry{contract: bind.NewBoundContract(address, parsed, caller, nil, nil)}, nil
+}
+
+func (r *EthrRegistry) IdentityOwner(ctx context.Context, identity common.Address) (common.Address, error) {
+	var out []any
+	if err := r.contract.Call(&bind.CallOpts{Context: ctx}, &out, "identityOwner", identity); err != nil {
+		return common.Address{}, err
+	}
+	return *abi.ConvertType(out[0], new(common.Address)).(*common.Address), nil
+}
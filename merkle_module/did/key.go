@@ -0,0 +1,84 @@
+package did
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Multicodec prefixes of the public keys supported by did:key, as unsigned varints
+var (
+	CodecEd25519   = []byte{0xed, 0x01}
+	CodecSecp256k1 = []byte{0xe7, 0x01}
+	CodecP256      = []byte{0x80, 0x24}
+)
+
+var keyCodecs = []struct {
+	prefix []byte
+	size   int
+}{
+	{CodecEd25519, 32},
+	{CodecSecp256k1, 33},
+	{CodecP256, 33},
+}
+
+// KeyDriver resolves did:key identifiers, the document is derived from the key without any lookup
+type KeyDriver struct{}
+
+func (KeyDriver) Method() string {
+	return "key"
+}
+
+func (KeyDriver) Resolve(ctx context.Context, did string) (*Resolution, error) {
+	parsed, err := Parse(did)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Method != "key" {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedMethod, parsed.Method)
+	}
+
+	// Reject identifiers which do not carry a supported public key
+	if _, _, err := decodeMultikey(parsed.ID); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDID, err)
+	}
+
+	id := parsed.String()
+	methodID := id + "#" + parsed.ID
+	return &Resolution{
+		Document: &Document{
+			Context: []any{"https://www.w3.org/ns/did/v1", "https://w3id.org/security/multikey/v1"},
+			ID:      id,
+			VerificationMethod: []VerificationMethod{{
+				ID:                 methodID,
+				Type:               "Multikey",
+				Controller:         id,
+				PublicKeyMultibase: parsed.ID,
+			}},
+			Authentication:  []any{methodID},
+			AssertionMethod: []any{methodID},
+		},
+	}, nil
+}
+
+// KeyDID builds the did:key of a public key with the given multicodec prefix
+func KeyDID(codec []byte, publicKey []byte) string {
+	return "did:key:z" + base58Encode(append(append([]byte{}, codec...), publicKey...))
+}
+
+// helper function to decode a multibase (base58btc) multicodec public key
+func decodeMultikey(multibase string) ([]byte, []byte, error) {
+	if !strings.HasPrefix(multibase, "z") {
+		return nil, nil, fmt.Errorf("unsupported multibase encoding %q", multibase)
+	}
+	data, err := base58Decode(multibase[1:])
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, codec := range keyCodecs {
+		if len(data) == len(codec.prefix)+codec.size && string(data[:len(codec.prefix)]) == string(codec.prefix) {
+			return codec.prefix, data[len(codec.prefix):], nil
+		}
+	}
+	return nil, nil, fmt.Errorf("unsupported multicodec public key")
+}
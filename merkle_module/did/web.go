@@ -0,0 +1,148 @@
+package did
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxDocumentSize bounds the size of a fetched did:web document
+const maxDocumentSize = 1 << 20
+
+// Fetcher fetches the DID document at the URL. It returns ErrNotFound when there is no document
+// and ErrDeactivated when the document has been removed on purpose
+type Fetcher interface {
+	Fetch(ctx context.Context, url string) ([]byte, error)
+}
+
+// FetcherFunc adapts a function to the Fetcher interface
+type FetcherFunc func(ctx context.Context, url string) ([]byte, error)
+
+func (f FetcherFunc) Fetch(ctx context.Context, url string) ([]byte, error) {
+	return f(ctx, url)
+}
+
+// HTTPFetcher fetches documents over HTTPS
+type HTTPFetcher struct {
+	Client *http.Client
+}
+
+func (h HTTPFetcher) Fetch(ctx context.Context, url string) ([]byte, error) {
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/did+json, application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, ErrNotFound
+	case http.StatusGone:
+		return nil, ErrDeactivated
+	default:
+		return nil, fmt.Errorf("failed to fetch %s: unexpected status %s", url, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxDocumentSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", url, err)
+	}
+	if len(body) > maxDocumentSize {
+		return nil, fmt.Errorf("document at %s is larger than %d bytes", url, maxDocumentSize)
+	}
+	return body, nil
+}
+
+// WebDriver resolves did:web identifiers by fetching did.json from the domain
+type WebDriver struct {
+	fetcher Fetcher
+}
+
+// NewWebDriver creates a did:web driver, a nil fetcher uses HTTPS with the default client
+func NewWebDriver(fetcher Fetcher) *WebDriver {
+	if fetcher == nil {
+		fetcher = HTTPFetcher{}
+	}
+	return &WebDriver{fetcher: fetcher}
+}
+
+func (w *WebDriver) Method() string {
+	return "web"
+}
+
+func (w *WebDriver) Resolve(ctx context.Context, did string) (*Resolution, error) {
+	parsed, err := Parse(did)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Method != "web" {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedMethod, parsed.Method)
+	}
+
+	documentURL, err := WebDocumentURL(parsed.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	id := parsed.String()
+	body, err := w.fetcher.Fetch(ctx, documentURL)
+	if errors.Is(err, ErrDeactivated) {
+		return &Resolution{Document: &Document{ID: id}, Metadata: Metadata{Deactivated: true}}, nil
+	}
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var document Document
+	if err := json.Unmarshal(body, &document); err != nil {
+		return nil, fmt.Errorf("failed to parse DID document of %s: %w", id, err)
+	}
+	if document.ID != id {
+		return nil, fmt.Errorf("DID document at %s is for %q, expected %s", documentURL, document.ID, id)
+	}
+
+	return &Resolution{Document: &document}, nil
+}
+
+// WebDocumentURL returns the URL of the did.json of a did:web method specific identifier
+func WebDocumentURL(id string) (string, error) {
+	segments := strings.Split(id, ":")
+	for i, segment := range segments {
+		decoded, err := url.PathUnescape(segment)
+		if err != nil || decoded == "" || strings.Contains(decoded, "/") {
+			return "", fmt.Errorf("%w: invalid did:web segment %q", ErrInvalidDID, segment)
+		}
+		segments[i] = decoded
+	}
+
+	// The host may carry a percent-encoded port
+	host := segments[0]
+	if u, err := url.Parse("https://" + host); err != nil || u.Host != host {
+		return "", fmt.Errorf("%w: invalid did:web host %q", ErrInvalidDID, host)
+	}
+
+	if len(segments) == 1 {
+		return "https://" + host + "/.well-known/did.json", nil
+	}
+	return "https://" + host + "/" + strings.Join(segments[1:], "/") + "/did.json", nil
+}
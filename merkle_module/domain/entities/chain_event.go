@@ -0,0 +1,30 @@
+package entities
+
+// AnchoredRoot is a tree root written to the contract, one per TreeUpdated event or per element of a BatchTreesUpdated event
+type AnchoredRoot struct {
+	ID              int    `json:"id"`
+	ContractAddress string `json:"contract_address"`
+	Issuer          string `json:"issuer"`
+	TreeIndex       int64  `json:"tree_index"`
+	Root            []byte `json:"root"`
+	BlockNumber     uint64 `json:"block_number"`
+	BlockHash       string `json:"block_hash"`
+	TxHash          string `json:"tx_hash"`
+	LogIndex        uint   `json:"log_index"`
+	BatchPosition   int    `json:"batch_position"`
+}
+
+// StatusListEvent is a status list published to the contract
+type StatusListEvent struct {
+	ID              int    `json:"id"`
+	ContractAddress string `json:"contract_address"`
+	Issuer          string `json:"issuer"`
+	ListID          string `json:"list_id"`      // empty for RevokeStatusListUpdated, the id is an indexed topic
+	ListIDHash      string `json:"list_id_hash"` // keccak256 of the list id
+	List            []byte `json:"list"`
+	BlockNumber     uint64 `json:"block_number"`
+	BlockHash       string `json:"block_hash"`
+	TxHash          string `json:"tx_hash"`
+	LogIndex        uint   `json:"log_index"`
+	BatchPosition   int    `json:"batch_position"`
+}
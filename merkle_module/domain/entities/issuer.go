@@ -0,0 +1,6 @@
+package entities
+
+type Issuer struct {
+	DID     string `json:"did"`
+	Address string `json:"address"` // Ethereum address the issuer's roots are anchored under
+}
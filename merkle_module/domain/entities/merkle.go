@@ -1,5 +1,7 @@
 package entities
 
+import "time"
+
 type MerkleNode struct {
 	ID     int    `json:"id"`
 	TreeID int    `json:"tree_id"`
@@ -7,9 +9,111 @@ type MerkleNode struct {
 	Data   []byte `json:"value"`
 }
 
+// MerkleTree holds the sync state of a single chain, NeedSync and NodeCountSync are
+// those of the chain the tree was read for
 type MerkleTree struct {
-	ID        int    `json:"id"`
-	IssuerDID string `json:"issuer_did"`
-	NodeCount int    `json:"node_count"`
-	NeedSync  bool   `json:"need_sync"`
+	ID            int    `json:"id"`
+	IssuerDID     string `json:"issuer_did"`
+	NodeCount     int    `json:"node_count"`
+	NeedSync      bool   `json:"need_sync"`
+	NodeCountSync int    `json:"node_count_sync"`
+}
+
+// IssuerTrees sums up the trees of an issuer
+type IssuerTrees struct {
+	IssuerDID  string `json:"issuer_did"`
+	Trees      int    `json:"trees"`
+	NodeCount  int    `json:"node_count"`   // nodes of all the trees of the issuer
+	LastTreeID int    `json:"last_tree_id"` // tree the issuer adds its leaves to
+}
+
+// NodeHash is the hash of a node of a tree once the tree holds Size leaves. Index is 1 for the
+// root, the children of node i are 2i and 2i+1 and leaf n is at MAX_LEAFS+n-1.
+type NodeHash struct {
+	TreeID int    `json:"tree_id"`
+	Index  int    `json:"index"`
+	Size   int    `json:"size"`
+	Hash   []byte `json:"hash"`
+}
+
+// TreeRoot is the root of a tree once it held Size leaves
+type TreeRoot struct {
+	TreeID int    `json:"tree_id"`
+	Size   int    `json:"size"`
+	Root   []byte `json:"root"`
+}
+
+// TreeSeal finalizes a full tree synced to every chain, it never changes again and its nodes
+// are archived
+type TreeSeal struct {
+	TreeID   int       `json:"tree_id"`
+	Root     []byte    `json:"root"`
+	ChainID  uint64    `json:"chain_id"` // chain of the transaction of the last sync of the root
+	TxHash   string    `json:"tx_hash"`
+	SealedAt time.Time `json:"sealed_at"`
+	Leaves   [][]byte  `json:"leaves,omitempty"` // read from the archive by GetTreeSeal
+}
+
+// TreeChain is the sync state of a tree on a chain
+type TreeChain struct {
+	TreeID        int    `json:"tree_id"`
+	ChainID       uint64 `json:"chain_id"`
+	NeedSync      bool   `json:"need_sync"`
+	NodeCountSync int    `json:"node_count_sync"`
+}
+
+// TreeSnapshot holds everything needed to restore a tree in another database under the same
+// tree ID, which is also the index its roots are anchored at on chain
+type TreeSnapshot struct {
+	Tree   *MerkleTree   `json:"tree"`  // ID, IssuerDID and NodeCount
+	Nodes  []*MerkleNode `json:"nodes"` // ordered by node ID, read from the archive once sealed
+	Roots  []*TreeRoot   `json:"roots"` // ordered by size
+	Chains []*TreeChain  `json:"chains"`
+	Syncs  []*TreeSync   `json:"syncs"`          // confirmed syncs ordered by ID
+	Seal   *TreeSeal     `json:"seal,omitempty"` // without its leaves, nil if the tree is not sealed
+}
+
+const (
+	SyncStatusPending   = "pending"   // root computed, transaction submitted or about to be
+	SyncStatusConfirmed = "confirmed" // transaction mined with enough confirmations
+	SyncStatusFailed    = "failed"    // transaction reverted, dropped or never submitted
+	SyncStatusReplaced  = "replaced"  // another transaction was mined with the same nonce
+)
+
+// TreeSync records the anchoring of a tree root at a given node count on a chain
+type TreeSync struct {
+	ID          int    `json:"id"`
+	TreeID      int    `json:"tree_id"`
+	ChainID     uint64 `json:"chain_id"`
+	NodeCount   int    `json:"node_count"`
+	Root        []byte `json:"root"`
+	Status      string `json:"status"`
+	TxHash      string `json:"tx_hash"`
+	Nonce       uint64 `json:"nonce"`
+	BlockNumber uint64 `json:"block_number"`
+	Error       string `json:"error"`
+	// The address the root is anchored under, or committed under in a checkpoint
+	IssuerAddress string `json:"issuer_address"`
+	// Set when the root is committed into a checkpoint instead of being anchored itself
+	CheckpointID       int `json:"checkpoint_id"`
+	CheckpointPosition int `json:"checkpoint_position"`
+}
+
+// Checkpoint is a super-root over the roots of many trees, anchored on its chain
+// as the tree index ID of IssuerAddress
+type Checkpoint struct {
+	ID            int         `json:"id"`
+	ChainID       uint64      `json:"chain_id"`
+	IssuerAddress string      `json:"issuer_address"`
+	Root          []byte      `json:"root"`
+	Syncs         []*TreeSync `json:"syncs"` // ordered by checkpoint position
+}
+
+// CheckpointProof is the second level of a proof, from the root of a tree to the super-root
+// of the checkpoint it was last synced in
+type CheckpointProof struct {
+	CheckpointID  int      `json:"checkpoint_id"`
+	IssuerAddress string   `json:"issuer_address"`
+	Root          []byte   `json:"root"`
+	Path          [][]byte `json:"path"` // from the checkpoint leaf of the tree, without its key
 }
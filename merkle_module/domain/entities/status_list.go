@@ -0,0 +1,25 @@
+package entities
+
+const (
+	StatusPurposeRevocation = "revocation"
+)
+
+type StatusList struct {
+	ID               int    `json:"id"`
+	ListID           string `json:"list_id"` // identifier published on chain
+	IssuerDID        string `json:"issuer_did"`
+	Purpose          string `json:"purpose"`
+	Size             int    `json:"size"`
+	NextIndex        int    `json:"next_index"`
+	Bits             []byte `json:"bits"`
+	Version          int    `json:"version"`           // incremented on every status change
+	PublishedVersion int    `json:"published_version"` // last version published on chain
+}
+
+// StatusEntry is the position of a credential in a status list
+type StatusEntry struct {
+	StatusListID int    `json:"status_list_id"`
+	ListID       string `json:"list_id"`
+	Purpose      string `json:"purpose"`
+	Index        int    `json:"index"`
+}
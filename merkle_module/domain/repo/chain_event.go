@@ -0,0 +1,22 @@
+package repo
+
+import (
+	"context"
+	"merkle_module/domain/entities"
+	"merkle_module/infra/model"
+)
+
+type ChainEvent interface {
+	// Get the cursor of the indexer, nil if the indexer has never run
+	GetCursor(ctx context.Context, name string) (*model.IndexerCursor, error)
+	// Save the events of a block range and advance the cursor atomically
+	SaveEvents(ctx context.Context, batch *model.EventBatch) error
+	// Delete the events of the contract from the block on and reset the cursor, a nil cursor removes it
+	Rewind(ctx context.Context, name string, cursor *model.IndexerCursor, contractAddress string, fromBlock uint64) error
+	// Get the anchored roots of a tree, oldest first
+	GetRootHistory(ctx context.Context, contractAddress, issuer string, treeIndex int64) ([]*entities.AnchoredRoot, error)
+	// Get the root of a tree as of a block, nil if the tree had no root yet
+	GetRootAtBlock(ctx context.Context, contractAddress, issuer string, treeIndex int64, blockNumber uint64) (*entities.AnchoredRoot, error)
+	// Get the published versions of a status list, oldest first
+	GetStatusListHistory(ctx context.Context, contractAddress, issuer, listIDHash string) ([]*entities.StatusListEvent, error)
+}
@@ -0,0 +1,14 @@
+package repo
+
+import (
+	"context"
+	"merkle_module/domain/entities"
+)
+
+type Issuer interface {
+	// Get the registered issuer, nil if the DID is not registered
+	GetIssuer(ctx context.Context, did string) (*entities.Issuer, error)
+	// Register the address of an issuer, replacing any previous one
+	SetIssuer(ctx context.Context, issuer *entities.Issuer) error
+	ListIssuers(ctx context.Context) ([]*entities.Issuer, error)
+}
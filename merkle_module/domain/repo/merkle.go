@@ -4,18 +4,81 @@ import (
 	"context"
 	"merkle_module/domain/entities"
 	"merkle_module/infra/model"
+	"time"
 )
 
 type Merkle interface {
-	// Get all nodes belonging to a specific tree ID
-	GetNodesByTreeID(ctx context.Context, treeID int) ([][]byte, error)
+	// Get all nodes belonging to a specific tree ID ordered by node ID, read from the archive of
+	// a sealed tree. Reserved nodes not filled yet leave gaps in the node IDs.
+	GetNodesByTreeID(ctx context.Context, treeID int) ([]*entities.MerkleNode, error)
 	AddNode(ctx context.Context, treeID int, nodeID int, data []byte) (*entities.MerkleNode, error)
-	// Retrieve the active tree and reserve an empty node for inserting a new one
+	// Count the nodes of a tree and get the largest node ID among them, the two are equal unless a
+	// reserved node is not filled yet. Sealed trees have no node left, their nodes are archived.
+	CountNodes(ctx context.Context, treeID int) (int, int, error)
+	// Retrieve the active tree and reserve an empty node for inserting a new one, the reservation
+	// expires unless AddNode fills the node
 	GetActiveTreeForInserting(ctx context.Context, issuerDID string) (*model.ActiveTree, error)
 	// Add a new node to the tree and increment the node count
 	AddNodeAndIncrementNodeCount(ctx context.Context, treeID int, nodeID int, data []byte) (*entities.MerkleNode, error)
-	// Get the nodes of trees that need to be synced
-	GetTreesWithNodesForSync(ctx context.Context) ([]*model.MerkleTreeWithNodes, error)
-	// Get the nodes synced by tree ID
-	GetNodesSyncedByTreeID(ctx context.Context, treeID int) ([]*entities.MerkleNode, error)
+	// Save the hashes of tree nodes, replacing those already saved for the same node and size
+	SaveNodeHashes(ctx context.Context, hashes []*entities.NodeHash) error
+	// Get the hashes of the nodes at the given indexes once the tree holds size leaves, that is
+	// the hash saved with the largest size up to size. Nodes without such hash are left out.
+	GetNodeHashes(ctx context.Context, treeID int, size int, indexes []int) ([]*entities.NodeHash, error)
+	// Record roots in the history of their trees, a root already recorded for the size is kept
+	AddTreeRoots(ctx context.Context, roots []*entities.TreeRoot) error
+	// Get the root of the tree once it held size leaves, nil if it was not recorded
+	GetTreeRoot(ctx context.Context, treeID int, size int) (*entities.TreeRoot, error)
+	// Get the recorded roots of the tree ordered by size
+	GetTreeRoots(ctx context.Context, treeID int) ([]*entities.TreeRoot, error)
+	// Get the full trees not sealed yet whose nodes are all confirmed on every given chain
+	GetTreesToSeal(ctx context.Context, chainIDs []uint64) ([]*entities.MerkleTree, error)
+	// Seal a full tree: record its seal, setting SealedAt, archive its nodes out of the nodes of
+	// the active trees and drop its saved node hashes. Sealed nodes are still read by the methods
+	// getting nodes.
+	SealTree(ctx context.Context, seal *entities.TreeSeal) error
+	// Get the seal of a tree with its archived leaves, nil if the tree is not sealed
+	GetTreeSeal(ctx context.Context, treeID int) (*entities.TreeSeal, error)
+	// Set the node count of a tree which is not sealed from observed to nodeCount, provided it is
+	// still observed, no node lies beyond nodeCount and no node was reserved in the tree for idle.
+	// False when any of these does not hold.
+	SetNodeCount(ctx context.Context, treeID, observed, nodeCount int, idle time.Duration) (bool, error)
+	// Fill the reserved nodes which were not added by now with leaf and drop their reservations,
+	// returning the filled nodes. The reservations of nodes beyond the node count of their tree,
+	// released by SetNodeCount, are dropped without filling them.
+	ReapReservations(ctx context.Context, now time.Time, leaf []byte) ([]*entities.MerkleNode, error)
+	// Get the issuers which own trees ordered by DID
+	GetIssuers(ctx context.Context) ([]*entities.IssuerTrees, error)
+	// Get the trees of an issuer ordered by ID, without sync state
+	GetIssuerTrees(ctx context.Context, issuerDID string) ([]*entities.MerkleTree, error)
+	// Read a tree with its nodes, roots, sync state on every chain, confirmed syncs and seal at
+	// once, nil if the tree does not exist
+	GetTreeSnapshot(ctx context.Context, treeID int) (*entities.TreeSnapshot, error)
+	// Restore the trees of snapshots under their tree IDs, all or none. A tree ID must not exist
+	// yet. The syncs get new IDs and keep their checkpoint IDs, checkpoints are not restored.
+	ImportTrees(ctx context.Context, snapshots []*entities.TreeSnapshot) error
+	// Get the nodes of trees that need to be synced to the chain and have no pending sync on it,
+	// with NeedSync and NodeCountSync of the trees on the chain
+	GetTreesWithNodesForSync(ctx context.Context, chainID uint64) ([]*model.MerkleTreeWithNodes, error)
+	// Get the nodes synced by tree ID to the chain
+	GetNodesSyncedByTreeID(ctx context.Context, treeID int, chainID uint64) ([]*entities.MerkleNode, error)
+	// Get the trees which have been synced to the chain at least once
+	GetSyncedTrees(ctx context.Context, chainID uint64) ([]*entities.MerkleTree, error)
+	// Mark trees to be synced again to the chain by its next sync run
+	MarkTreesForSync(ctx context.Context, chainID uint64, treeIDs []int) error
+	// Record the computed roots as pending syncs on their chain, setting their IDs
+	CreatePendingSyncs(ctx context.Context, syncs []*entities.TreeSync) error
+	// Attach the submitted transaction to pending syncs
+	SetSyncTransaction(ctx context.Context, syncIDs []int, txHash string, nonce uint64) error
+	GetPendingSyncs(ctx context.Context, chainID uint64) ([]*entities.TreeSync, error)
+	// Confirm the pending syncs of a transaction and advance node_count_sync of their trees on the chain
+	ConfirmSyncs(ctx context.Context, chainID uint64, txHash string, blockNumber uint64) error
+	// Close pending syncs with a failed or replaced status, their trees stay marked for sync
+	FailSyncs(ctx context.Context, syncIDs []int, status string, reason string) error
+	// Get the last confirmed sync of a tree on the chain, nil if the tree has never been confirmed there
+	GetLastConfirmedSync(ctx context.Context, treeID int, chainID uint64) (*entities.TreeSync, error)
+	// Record a checkpoint before its syncs are created, setting its ID
+	CreateCheckpoint(ctx context.Context, checkpoint *entities.Checkpoint) error
+	// Get a checkpoint with the syncs committed into it, nil if it does not exist
+	GetCheckpoint(ctx context.Context, checkpointID int) (*entities.Checkpoint, error)
 }
@@ -0,0 +1,19 @@
+package repo
+
+import (
+	"context"
+	"merkle_module/domain/entities"
+)
+
+type StatusList interface {
+	// Reserve the next free index in the active status list of the issuer, creating a new list when it is full
+	AllocateIndex(ctx context.Context, issuerDID string, purpose string) (*entities.StatusEntry, error)
+	// Set the status bit at the index and bump the list version
+	SetStatus(ctx context.Context, statusListID int, index int, value bool) (*entities.StatusList, error)
+	GetStatusList(ctx context.Context, statusListID int) (*entities.StatusList, error)
+	GetStatusListByListID(ctx context.Context, listID string) (*entities.StatusList, error)
+	// Get the status lists changed since they were last published
+	GetStatusListsForPublish(ctx context.Context) ([]*entities.StatusList, error)
+	// Record that the given version of the status list has been published
+	MarkStatusListPublished(ctx context.Context, statusListID int, version int) error
+}
@@ -0,0 +1,259 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+
+	"merkle_module/domain/entities"
+	"merkle_module/domain/repo"
+	"merkle_module/infra/model"
+	credential "merkle_module/smartcontract"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Backend is the part of the Ethereum client used by the indexer
+type Backend interface {
+	bind.ContractFilterer
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+}
+
+type Config struct {
+	Name          string // cursor name, defaults to the contract address
+	StartBlock    uint64 // first block to index, usually the deployment block
+	BatchSize     uint64 // max blocks per filter query
+	Confirmations uint64 // blocks behind the head which are not indexed yet
+	ReorgDepth    uint64 // blocks re-indexed when a reorg is detected
+}
+
+// Indexer copies the events of the NDACredential contract into the database. It backfills from
+// the start block in batches and follows the chain on every Sync; when the hash of the last
+// indexed block changes, the last ReorgDepth blocks are dropped and indexed again.
+type Indexer struct {
+	backend  Backend
+	filterer *credential.CredentialFilterer
+	repo     repo.ChainEvent
+	address  common.Address
+	cfg      Config
+}
+
+func NewIndexer(backend Backend, address common.Address, repo repo.ChainEvent, cfg Config) (*Indexer, error) {
+	filterer, err := credential.NewCredentialFilterer(address, backend)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create contract filterer: %w", err)
+	}
+
+	if cfg.Name == "" {
+		cfg.Name = address.Hex()
+	}
+	if cfg.BatchSize == 0 {
+		cfg.BatchSize = 2000
+	}
+	if cfg.ReorgDepth == 0 {
+		cfg.ReorgDepth = 64
+	}
+
+	return &Indexer{
+		backend:  backend,
+		filterer: filterer,
+		repo:     repo,
+		address:  address,
+		cfg:      cfg,
+	}, nil
+}
+
+// Sync indexes the blocks up to the confirmed head and returns the last indexed block
+func (ix *Indexer) Sync(ctx context.Context) (uint64, error) {
+	cursor, err := ix.repo.GetCursor(ctx, ix.cfg.Name)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get cursor: %w", err)
+	}
+
+	// Check that the last indexed block is still canonical
+	if cursor != nil {
+		cursor, err = ix.handleReorg(ctx, cursor)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	head, err := ix.backend.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get head: %w", err)
+	}
+	if head.Number.Uint64() < ix.cfg.Confirmations {
+		return ix.lastBlock(cursor), nil
+	}
+	target := head.Number.Uint64() - ix.cfg.Confirmations
+
+	from := ix.cfg.StartBlock
+	if cursor != nil {
+		from = cursor.BlockNumber + 1
+	}
+
+	for from <= target {
+		to := min(from+ix.cfg.BatchSize-1, target)
+		if err := ix.indexRange(ctx, from, to); err != nil {
+			return ix.lastBlock(cursor), err
+		}
+		cursor = &model.IndexerCursor{Name: ix.cfg.Name, BlockNumber: to}
+		from = to + 1
+	}
+
+	return ix.lastBlock(cursor), nil
+}
+
+func (ix *Indexer) lastBlock(cursor *model.IndexerCursor) uint64 {
+	if cursor == nil {
+		return 0
+	}
+	return cursor.BlockNumber
+}
+
+// helper function to rewind the indexed events when the last indexed block is no longer canonical
+func (ix *Indexer) handleReorg(ctx context.Context, cursor *model.IndexerCursor) (*model.IndexerCursor, error) {
+	header, err := ix.backend.HeaderByNumber(ctx, new(big.Int).SetUint64(cursor.BlockNumber))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get header of block %d: %w", cursor.BlockNumber, err)
+	}
+	if header.Hash().Hex() == cursor.BlockHash {
+		return cursor, nil
+	}
+
+	from := ix.cfg.StartBlock
+	if cursor.BlockNumber >= from+ix.cfg.ReorgDepth {
+		from = cursor.BlockNumber - ix.cfg.ReorgDepth + 1
+	}
+	log.Printf("Reorg detected at block %d, re-indexing from block %d", cursor.BlockNumber, from)
+
+	// Without a canonical block before the range, the cursor is removed and indexing restarts from the start block
+	var rewound *model.IndexerCursor
+	if from > 0 {
+		parent, err := ix.backend.HeaderByNumber(ctx, new(big.Int).SetUint64(from-1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get header of block %d: %w", from-1, err)
+		}
+		rewound = &model.IndexerCursor{Name: ix.cfg.Name, BlockNumber: from - 1, BlockHash: parent.Hash().Hex()}
+	}
+	if err := ix.repo.Rewind(ctx, ix.cfg.Name, rewound, ix.address.Hex(), from); err != nil {
+		return nil, fmt.Errorf("failed to rewind events: %w", err)
+	}
+
+	return rewound, nil
+}
+
+// helper function to index the events of the block range [from, to]
+func (ix *Indexer) indexRange(ctx context.Context, from, to uint64) error {
+	opts := &bind.FilterOpts{Start: from, End: &to, Context: ctx}
+	batch := &model.EventBatch{}
+
+	treeUpdated, err := ix.filterer.FilterTreeUpdated(opts, nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to filter TreeUpdated events: %w", err)
+	}
+	for treeUpdated.Next() {
+		event := treeUpdated.Event
+		batch.Roots = append(batch.Roots, ix.anchoredRoot(event.Raw, 0, event.Issuer, event.TreeIndex, event.NewRoot))
+	}
+	if err := closeIterator(treeUpdated.Error(), treeUpdated.Close()); err != nil {
+		return fmt.Errorf("failed to iterate TreeUpdated events: %w", err)
+	}
+
+	batchTreesUpdated, err := ix.filterer.FilterBatchTreesUpdated(opts)
+	if err != nil {
+		return fmt.Errorf("failed to filter BatchTreesUpdated events: %w", err)
+	}
+	for batchTreesUpdated.Next() {
+		event := batchTreesUpdated.Event
+		for i := range event.Issuers {
+			batch.Roots = append(batch.Roots, ix.anchoredRoot(event.Raw, i, event.Issuers[i], event.TreeIndices[i], event.NewRoots[i]))
+		}
+	}
+	if err := closeIterator(batchTreesUpdated.Error(), batchTreesUpdated.Close()); err != nil {
+		return fmt.Errorf("failed to iterate BatchTreesUpdated events: %w", err)
+	}
+
+	statusListUpdated, err := ix.filterer.FilterRevokeStatusListUpdated(opts, nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to filter RevokeStatusListUpdated events: %w", err)
+	}
+	for statusListUpdated.Next() {
+		event := statusListUpdated.Event
+		// the list id is an indexed string, only its hash is available
+		batch.StatusLists = append(batch.StatusLists, ix.statusListEvent(event.Raw, 0, event.Issuer, "", event.RevokeStatusListId, event.RevokeStatusList))
+	}
+	if err := closeIterator(statusListUpdated.Error(), statusListUpdated.Close()); err != nil {
+		return fmt.Errorf("failed to iterate RevokeStatusListUpdated events: %w", err)
+	}
+
+	batchStatusListUpdated, err := ix.filterer.FilterBatchRevokeStatusListUpdated(opts, nil)
+	if err != nil {
+		return fmt.Errorf("failed to filter BatchRevokeStatusListUpdated events: %w", err)
+	}
+	for batchStatusListUpdated.Next() {
+		event := batchStatusListUpdated.Event
+		for i, listID := range event.RevokeStatusListIds {
+			listIDHash := crypto.Keccak256Hash([]byte(listID))
+			batch.StatusLists = append(batch.StatusLists, ix.statusListEvent(event.Raw, i, event.Issuer, listID, listIDHash, event.RevokeStatusLists[i]))
+		}
+	}
+	if err := closeIterator(batchStatusListUpdated.Error(), batchStatusListUpdated.Close()); err != nil {
+		return fmt.Errorf("failed to iterate BatchRevokeStatusListUpdated events: %w", err)
+	}
+
+	header, err := ix.backend.HeaderByNumber(ctx, new(big.Int).SetUint64(to))
+	if err != nil {
+		return fmt.Errorf("failed to get header of block %d: %w", to, err)
+	}
+	batch.Cursor = &model.IndexerCursor{Name: ix.cfg.Name, BlockNumber: to, BlockHash: header.Hash().Hex()}
+
+	if err := ix.repo.SaveEvents(ctx, batch); err != nil {
+		return fmt.Errorf("failed to save events of blocks %d-%d: %w", from, to, err)
+	}
+	if len(batch.Roots) > 0 || len(batch.StatusLists) > 0 {
+		log.Printf("Indexed %d roots and %d status lists from blocks %d-%d", len(batch.Roots), len(batch.StatusLists), from, to)
+	}
+
+	return nil
+}
+
+func (ix *Indexer) anchoredRoot(raw types.Log, position int, issuer common.Address, treeIndex *big.Int, root [32]byte) *entities.AnchoredRoot {
+	return &entities.AnchoredRoot{
+		ContractAddress: ix.address.Hex(),
+		Issuer:          issuer.Hex(),
+		TreeIndex:       treeIndex.Int64(),
+		Root:            common.CopyBytes(root[:]),
+		BlockNumber:     raw.BlockNumber,
+		BlockHash:       raw.BlockHash.Hex(),
+		TxHash:          raw.TxHash.Hex(),
+		LogIndex:        raw.Index,
+		BatchPosition:   position,
+	}
+}
+
+func (ix *Indexer) statusListEvent(raw types.Log, position int, issuer common.Address, listID string, listIDHash common.Hash, list []byte) *entities.StatusListEvent {
+	return &entities.StatusListEvent{
+		ContractAddress: ix.address.Hex(),
+		Issuer:          issuer.Hex(),
+		ListID:          listID,
+		ListIDHash:      listIDHash.Hex(),
+		List:            common.CopyBytes(list),
+		BlockNumber:     raw.BlockNumber,
+		BlockHash:       raw.BlockHash.Hex(),
+		TxHash:          raw.TxHash.Hex(),
+		LogIndex:        raw.Index,
+		BatchPosition:   position,
+	}
+}
+
+func closeIterator(iterErr, closeErr error) error {
+	if iterErr != nil {
+		return iterErr
+	}
+	return closeErr
+}
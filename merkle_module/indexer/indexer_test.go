@@ -0,0 +1,263 @@
+package indexer
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"testing"
+
+	"merkle_module/domain/entities"
+	"merkle_module/infra/model"
+	credential "merkle_module/smartcontract"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient/simulated"
+)
+
+// memoryChainEvent is an in-memory repo.ChainEvent for tests
+type memoryChainEvent struct {
+	mu          sync.Mutex
+	cursors     map[string]*model.IndexerCursor
+	roots       []*entities.AnchoredRoot
+	statusLists []*entities.StatusListEvent
+}
+
+func newMemoryChainEvent() *memoryChainEvent {
+	return &memoryChainEvent{cursors: make(map[string]*model.IndexerCursor)}
+}
+
+func (m *memoryChainEvent) GetCursor(ctx context.Context, name string) (*model.IndexerCursor, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if cursor, ok := m.cursors[name]; ok {
+		c := *cursor
+		return &c, nil
+	}
+	return nil, nil
+}
+
+func (m *memoryChainEvent) SaveEvents(ctx context.Context, batch *model.EventBatch) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.roots = append(m.roots, batch.Roots...)
+	m.statusLists = append(m.statusLists, batch.StatusLists...)
+	c := *batch.Cursor
+	m.cursors[c.Name] = &c
+	return nil
+}
+
+func (m *memoryChainEvent) Rewind(ctx context.Context, name string, cursor *model.IndexerCursor, contractAddress string, fromBlock uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var roots []*entities.AnchoredRoot
+	for _, root := range m.roots {
+		if root.ContractAddress != contractAddress || root.BlockNumber < fromBlock {
+			roots = append(roots, root)
+		}
+	}
+	m.roots = roots
+	var statusLists []*entities.StatusListEvent
+	for _, event := range m.statusLists {
+		if event.ContractAddress != contractAddress || event.BlockNumber < fromBlock {
+			statusLists = append(statusLists, event)
+		}
+	}
+	m.statusLists = statusLists
+	if cursor == nil {
+		delete(m.cursors, name)
+	} else {
+		c := *cursor
+		m.cursors[name] = &c
+	}
+	return nil
+}
+
+func (m *memoryChainEvent) GetRootHistory(ctx context.Context, contractAddress, issuer string, treeIndex int64) ([]*entities.AnchoredRoot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var roots []*entities.AnchoredRoot
+	for _, root := range m.roots {
+		if root.ContractAddress == contractAddress && root.Issuer == issuer && root.TreeIndex == treeIndex {
+			roots = append(roots, root)
+		}
+	}
+	return roots, nil
+}
+
+func (m *memoryChainEvent) GetRootAtBlock(ctx context.Context, contractAddress, issuer string, treeIndex int64, blockNumber uint64) (*entities.AnchoredRoot, error) {
+	roots, _ := m.GetRootHistory(ctx, contractAddress, issuer, treeIndex)
+	var latest *entities.AnchoredRoot
+	for _, root := range roots {
+		if root.BlockNumber <= blockNumber {
+			latest = root
+		}
+	}
+	return latest, nil
+}
+
+func (m *memoryChainEvent) GetStatusListHistory(ctx context.Context, contractAddress, issuer, listIDHash string) ([]*entities.StatusListEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var events []*entities.StatusListEvent
+	for _, event := range m.statusLists {
+		if event.ContractAddress == contractAddress && event.Issuer == issuer && event.ListIDHash == listIDHash {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+type testChain struct {
+	sim      *simulated.Backend
+	auth     *bind.TransactOpts
+	address  common.Address
+	contract *credential.Credential
+}
+
+func newTestChain(t *testing.T) *testChain {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	balance, _ := new(big.Int).SetString("1000000000000000000000", 10)
+	sim := simulated.NewBackend(types.GenesisAlloc{from: {Balance: balance}})
+	t.Cleanup(func() { sim.Close() })
+
+	chainID, err := sim.Client().ChainID(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get chain ID: %v", err)
+	}
+	auth, err := bind.NewKeyedTransactorWithChainID(key, chainID)
+	if err != nil {
+		t.Fatalf("Failed to create transactor: %v", err)
+	}
+
+	address, _, contract, err := credential.DeployCredential(auth, sim.Client())
+	if err != nil {
+		t.Fatalf("Failed to deploy contract: %v", err)
+	}
+	sim.Commit()
+
+	return &testChain{sim: sim, auth: auth, address: address, contract: contract}
+}
+
+func TestIndexerBackfillAndFollow(t *testing.T) {
+	ctx := context.Background()
+	chain := newTestChain(t)
+	issuer := common.HexToAddress("0x5A9cC578d5CC3Af41caf52c3818E77Af8Ff578D2")
+
+	// Two roots for tree 1 and one for tree 2 before the indexer starts
+	root1 := crypto.Keccak256Hash([]byte("root-1"))
+	root2 := crypto.Keccak256Hash([]byte("root-2"))
+	if _, err := chain.contract.BatchUpdateTreeRoots(chain.auth, []common.Address{issuer, issuer}, []*big.Int{big.NewInt(1), big.NewInt(2)}, [][32]byte{root1, root2}); err != nil {
+		t.Fatalf("Failed to update tree roots: %v", err)
+	}
+	chain.sim.Commit()
+	if _, err := chain.contract.UpdateTreeRoot(chain.auth, issuer, big.NewInt(1), root2); err != nil {
+		t.Fatalf("Failed to update tree root: %v", err)
+	}
+	if _, err := chain.contract.BatchUpdateRevokeStatusList(chain.auth, issuer, []string{"list-1"}, [][]byte{[]byte("encoded")}); err != nil {
+		t.Fatalf("Failed to update status list: %v", err)
+	}
+	chain.sim.Commit()
+
+	repo := newMemoryChainEvent()
+	ix, err := NewIndexer(chain.sim.Client(), chain.address, repo, Config{BatchSize: 1})
+	if err != nil {
+		t.Fatalf("Failed to create indexer: %v", err)
+	}
+	if _, err := ix.Sync(ctx); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+
+	history, _ := repo.GetRootHistory(ctx, chain.address.Hex(), issuer.Hex(), 1)
+	if len(history) != 2 {
+		t.Fatalf("Expected 2 roots for tree 1, got %d", len(history))
+	}
+	if common.BytesToHash(history[0].Root) != root1 || common.BytesToHash(history[1].Root) != root2 {
+		t.Errorf("Unexpected root history for tree 1")
+	}
+
+	atBlock, _ := repo.GetRootAtBlock(ctx, chain.address.Hex(), issuer.Hex(), 1, history[0].BlockNumber)
+	if atBlock == nil || common.BytesToHash(atBlock.Root) != root1 {
+		t.Errorf("Expected root-1 at block %d", history[0].BlockNumber)
+	}
+
+	lists, _ := repo.GetStatusListHistory(ctx, chain.address.Hex(), issuer.Hex(), crypto.Keccak256Hash([]byte("list-1")).Hex())
+	if len(lists) != 1 || string(lists[0].List) != "encoded" {
+		t.Errorf("Expected one published status list, got %d", len(lists))
+	}
+
+	// Follow a new block
+	root3 := crypto.Keccak256Hash([]byte("root-3"))
+	if _, err := chain.contract.UpdateTreeRoot(chain.auth, issuer, big.NewInt(2), root3); err != nil {
+		t.Fatalf("Failed to update tree root: %v", err)
+	}
+	chain.sim.Commit()
+	if _, err := ix.Sync(ctx); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	history, _ = repo.GetRootHistory(ctx, chain.address.Hex(), issuer.Hex(), 2)
+	if len(history) != 2 || common.BytesToHash(history[1].Root) != root3 {
+		t.Errorf("Expected the new root of tree 2 to be indexed, got %d roots", len(history))
+	}
+}
+
+func TestIndexerHandlesReorg(t *testing.T) {
+	ctx := context.Background()
+	chain := newTestChain(t)
+	issuer := common.HexToAddress("0x5A9cC578d5CC3Af41caf52c3818E77Af8Ff578D2")
+
+	parent, err := chain.sim.Client().HeaderByNumber(ctx, nil)
+	if err != nil {
+		t.Fatalf("Failed to get head: %v", err)
+	}
+
+	root := crypto.Keccak256Hash([]byte("root"))
+	if _, err := chain.contract.UpdateTreeRoot(chain.auth, issuer, big.NewInt(1), root); err != nil {
+		t.Fatalf("Failed to update tree root: %v", err)
+	}
+	chain.sim.Commit()
+
+	repo := newMemoryChainEvent()
+	ix, err := NewIndexer(chain.sim.Client(), chain.address, repo, Config{})
+	if err != nil {
+		t.Fatalf("Failed to create indexer: %v", err)
+	}
+	if _, err := ix.Sync(ctx); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	history, _ := repo.GetRootHistory(ctx, chain.address.Hex(), issuer.Hex(), 1)
+	if len(history) != 1 {
+		t.Fatalf("Expected 1 root before the reorg, got %d", len(history))
+	}
+	orphanedHash := history[0].BlockHash
+
+	// Replace the block with a longer side chain, the transaction is re-included in a new block
+	if err := chain.sim.Fork(parent.Hash()); err != nil {
+		t.Fatalf("Failed to fork: %v", err)
+	}
+	chain.sim.Commit()
+	chain.sim.Commit()
+
+	if _, err := ix.Sync(ctx); err != nil {
+		t.Fatalf("Failed to sync after reorg: %v", err)
+	}
+	history, _ = repo.GetRootHistory(ctx, chain.address.Hex(), issuer.Hex(), 1)
+	if len(history) != 1 {
+		t.Fatalf("Expected 1 root after the reorg, got %d", len(history))
+	}
+	if history[0].BlockHash == orphanedHash {
+		t.Errorf("Root is still attributed to the orphaned block %s", orphanedHash)
+	}
+
+	header, _ := chain.sim.Client().HeaderByNumber(ctx, new(big.Int).SetUint64(history[0].BlockNumber))
+	if header.Hash().Hex() != history[0].BlockHash {
+		t.Errorf("Root is not attributed to the canonical block")
+	}
+}
@@ -0,0 +1,188 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"testing/fstest"
+
+	_ "modernc.org/sqlite"
+)
+
+func openSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", "file::memory:")
+	if err != nil {
+		t.Fatalf("Failed to open sqlite database: %v", err)
+	}
+	// a single connection keeps the in-memory database alive
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func tableExists(t *testing.T, db *sql.DB, table string) bool {
+	t.Helper()
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = $1`, table).Scan(&count)
+	if err != nil {
+		t.Fatalf("Failed to query tables: %v", err)
+	}
+	return count > 0
+}
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"ok/0002_second.up.sql":   {Data: []byte("up 2")},
+		"ok/0002_second.down.sql": {Data: []byte("down 2")},
+		"ok/0001_first.up.sql":    {Data: []byte("up 1")},
+		"ok/0001_first.down.sql":  {Data: []byte("down 1")},
+
+		"missing/0001_first.up.sql": {Data: []byte("up 1")},
+
+		"renamed/0001_first.up.sql":    {Data: []byte("up 1")},
+		"renamed/0001_other.down.sql":  {Data: []byte("down 1")},
+		"unexpected/0001_first.sql":    {Data: []byte("up 1")},
+		"zero/0000_first.up.sql":       {Data: []byte("up 0")},
+		"zero/0000_first.down.sql":     {Data: []byte("down 0")},
+		"unexpected/0001_first.up.sql": {Data: []byte("up 1")},
+	}
+
+	migrations, err := Load(fsys, "ok")
+	if err != nil {
+		t.Fatalf("Failed to load migrations: %v", err)
+	}
+	if len(migrations) != 2 || migrations[0].Version != 1 || migrations[1].Version != 2 {
+		t.Fatalf("Expected migrations 1 and 2 in order, got %+v", migrations)
+	}
+	if migrations[0].Name != "first" || migrations[0].Up != "up 1" || migrations[0].Down != "down 1" {
+		t.Errorf("Unexpected migration: %+v", migrations[0])
+	}
+
+	for _, dir := range []string{"missing", "renamed", "unexpected", "zero"} {
+		if _, err := Load(fsys, dir); err == nil {
+			t.Errorf("Expected the migrations of %s to be rejected", dir)
+		}
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	// versions are contiguous so that a gap is not mistaken for a missing file
+	for _, dialect := range []Dialect{Postgres, SQLite} {
+		migrator, err := New(nil, dialect)
+		if err != nil {
+			t.Fatalf("Failed to load %s migrations: %v", dialect, err)
+		}
+		for i, migration := range migrator.Migrations() {
+			if migration.Version != i+1 {
+				t.Errorf("Expected %s migration %d, got %d_%s", dialect, i+1, migration.Version, migration.Name)
+			}
+		}
+	}
+	if _, err := New(nil, "mysql"); err == nil {
+		t.Errorf("Expected an unknown dialect to be rejected")
+	}
+}
+
+func TestSQLiteMigrations(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	migrator, err := New(db, SQLite)
+	if err != nil {
+		t.Fatalf("Failed to create migrator: %v", err)
+	}
+
+	applied, err := migrator.Up(ctx)
+	if err != nil {
+		t.Fatalf("Failed to migrate up: %v", err)
+	}
+	if len(applied) != len(migrator.Migrations()) || !tableExists(t, db, "merkle_tree_chains") {
+		t.Fatalf("Expected every migration to be applied, got %d", len(applied))
+	}
+	if applied, err := migrator.Up(ctx); err != nil || len(applied) != 0 {
+		t.Errorf("Expected nothing to apply twice, got %d and error %v", len(applied), err)
+	}
+
+	// node IDs are unique within their tree
+	if _, err := db.Exec(`INSERT INTO merkle_trees (issuer_did, node_count) VALUES ('did:example:1', 1)`); err != nil {
+		t.Fatalf("Failed to insert tree: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO merkle_nodes (tree_id, node_id, data) VALUES (1, 1, x'01')`); err != nil {
+		t.Fatalf("Failed to insert node: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO merkle_nodes (tree_id, node_id, data) VALUES (1, 1, x'02')`); err == nil {
+		t.Errorf("Expected a duplicate node to be rejected")
+	}
+
+	reverted, err := migrator.Down(ctx, len(migrator.Migrations()))
+	if err != nil {
+		t.Fatalf("Failed to migrate down: %v", err)
+	}
+	if len(reverted) != len(migrator.Migrations()) || tableExists(t, db, "merkle_trees") {
+		t.Errorf("Expected every migration to be reverted, got %d", len(reverted))
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	migrations := []Migration{
+		{Version: 1, Name: "first", Up: "CREATE TABLE first (id INTEGER)", Down: "DROP TABLE first"},
+		{Version: 2, Name: "second", Up: "CREATE TABLE second (id INTEGER)", Down: "DROP TABLE second"},
+		{Version: 3, Name: "broken", Up: "CREATE TABLE third (id INTEGER); INSERT INTO missing VALUES (1)", Down: "DROP TABLE third"},
+	}
+
+	// a failing migration is rolled back and stops the run
+	migrator := NewMigrator(db, migrations)
+	applied, err := migrator.Up(ctx)
+	if err == nil {
+		t.Fatalf("Expected the broken migration to fail")
+	}
+	if len(applied) != 2 || tableExists(t, db, "third") {
+		t.Errorf("Expected migrations 1 and 2 applied and 3 rolled back, got %d applied", len(applied))
+	}
+
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+	if len(statuses) != 3 || !statuses[0].Applied || !statuses[1].Applied || statuses[2].Applied {
+		t.Fatalf("Unexpected statuses: %+v", statuses)
+	}
+	if statuses[0].AppliedAt == nil || statuses[0].AppliedAt.IsZero() {
+		t.Errorf("Expected the time migration 1 was applied")
+	}
+
+	// down reverts the newest migrations first
+	reverted, err := migrator.Down(ctx, 1)
+	if err != nil {
+		t.Fatalf("Failed to migrate down: %v", err)
+	}
+	if len(reverted) != 1 || reverted[0].Version != 2 || tableExists(t, db, "second") || !tableExists(t, db, "first") {
+		t.Errorf("Expected migration 2 reverted, got %+v", reverted)
+	}
+
+	// a migration applied by a newer binary is listed and cannot be reverted
+	fixed := NewMigrator(db, []Migration{migrations[0], migrations[1], {Version: 3, Name: "fixed", Up: "CREATE TABLE third (id INTEGER)", Down: "DROP TABLE third"}})
+	if applied, err := fixed.Up(ctx); err != nil || len(applied) != 2 {
+		t.Fatalf("Expected migrations 2 and 3 applied, got %d and error %v", len(applied), err)
+	}
+	statuses, err = migrator.Status(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+	if !statuses[2].Applied || statuses[2].Name != "broken" {
+		t.Errorf("Unexpected status of migration 3: %+v", statuses[2])
+	}
+	older := NewMigrator(db, migrations[:2])
+	statuses, err = older.Status(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+	if len(statuses) != 3 || !statuses[2].Applied || statuses[2].Name != "" {
+		t.Errorf("Expected the unknown migration 3 in the status, got %+v", statuses)
+	}
+	if _, err := older.Down(ctx, 1); err == nil {
+		t.Errorf("Expected the unknown migration 3 not to be reverted")
+	}
+}
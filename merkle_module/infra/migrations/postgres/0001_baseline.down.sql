@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS merkle_nodes;
+DROP TABLE IF EXISTS merkle_trees;
@@ -12,4 +12,4 @@ CREATE TABLE IF NOT EXISTS merkle_nodes (
     node_id INT NOT NULL,
     data BYTEA NOT NULL,
     FOREIGN KEY (tree_id) REFERENCES merkle_trees(id)
-);
\ No newline at end of file
+);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS issuers;
+DROP TABLE IF EXISTS status_list_events;
+DROP TABLE IF EXISTS anchored_roots;
+DROP TABLE IF EXISTS indexer_cursors;
+DROP TABLE IF EXISTS status_lists;
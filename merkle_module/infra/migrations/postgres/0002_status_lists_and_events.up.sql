@@ -0,0 +1,55 @@
+CREATE TABLE IF NOT EXISTS status_lists (
+    id SERIAL PRIMARY KEY,
+    list_id VARCHAR(255) NOT NULL UNIQUE,
+    issuer_did VARCHAR(255) NOT NULL,
+    purpose VARCHAR(32) NOT NULL DEFAULT 'revocation',
+    size INT NOT NULL,
+    next_index INT NOT NULL DEFAULT 0,
+    bits BYTEA NOT NULL,
+    version INT NOT NULL DEFAULT 0,
+    published_version INT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS indexer_cursors (
+    name VARCHAR(64) PRIMARY KEY,
+    block_number BIGINT NOT NULL,
+    block_hash VARCHAR(66) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS anchored_roots (
+    id SERIAL PRIMARY KEY,
+    contract_address VARCHAR(42) NOT NULL,
+    issuer VARCHAR(42) NOT NULL,
+    tree_index BIGINT NOT NULL,
+    root BYTEA NOT NULL,
+    block_number BIGINT NOT NULL,
+    block_hash VARCHAR(66) NOT NULL,
+    tx_hash VARCHAR(66) NOT NULL,
+    log_index INT NOT NULL,
+    batch_position INT NOT NULL DEFAULT 0,
+    UNIQUE (tx_hash, log_index, batch_position)
+);
+
+CREATE INDEX IF NOT EXISTS idx_anchored_roots_tree ON anchored_roots (contract_address, issuer, tree_index, block_number);
+
+CREATE TABLE IF NOT EXISTS status_list_events (
+    id SERIAL PRIMARY KEY,
+    contract_address VARCHAR(42) NOT NULL,
+    issuer VARCHAR(42) NOT NULL,
+    list_id VARCHAR(255) NOT NULL DEFAULT '',
+    list_id_hash VARCHAR(66) NOT NULL,
+    list BYTEA NOT NULL,
+    block_number BIGINT NOT NULL,
+    block_hash VARCHAR(66) NOT NULL,
+    tx_hash VARCHAR(66) NOT NULL,
+    log_index INT NOT NULL,
+    batch_position INT NOT NULL DEFAULT 0,
+    UNIQUE (tx_hash, log_index, batch_position)
+);
+
+CREATE INDEX IF NOT EXISTS idx_status_list_events_list ON status_list_events (contract_address, issuer, list_id_hash, block_number);
+
+CREATE TABLE IF NOT EXISTS issuers (
+    did VARCHAR(255) PRIMARY KEY,
+    eth_address VARCHAR(42) NOT NULL
+);
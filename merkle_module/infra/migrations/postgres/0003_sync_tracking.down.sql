@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS merkle_tree_chains;
+DROP TABLE IF EXISTS merkle_checkpoints;
+DROP TABLE IF EXISTS merkle_tree_syncs;
@@ -0,0 +1,45 @@
+-- Databases created from earlier versions of create_table.sql may lack some of the columns
+CREATE TABLE IF NOT EXISTS merkle_tree_syncs (
+    id SERIAL PRIMARY KEY,
+    tree_id INT NOT NULL,
+    node_count INT NOT NULL,
+    root BYTEA NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    tx_hash VARCHAR(66) NOT NULL DEFAULT '',
+    nonce BIGINT NOT NULL DEFAULT 0,
+    block_number BIGINT NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (tree_id) REFERENCES merkle_trees(id)
+);
+
+ALTER TABLE merkle_tree_syncs
+    ADD COLUMN IF NOT EXISTS chain_id BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS issuer_address VARCHAR(42) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS checkpoint_id INT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS checkpoint_position INT NOT NULL DEFAULT 0;
+
+-- The index was first created without the chain
+DROP INDEX IF EXISTS idx_merkle_tree_syncs_status;
+CREATE INDEX idx_merkle_tree_syncs_status ON merkle_tree_syncs (chain_id, status, tree_id);
+CREATE INDEX IF NOT EXISTS idx_merkle_tree_syncs_checkpoint ON merkle_tree_syncs (checkpoint_id, checkpoint_position);
+
+-- Super-roots committing the roots of many trees, anchored as tree index id of issuer_address
+CREATE TABLE IF NOT EXISTS merkle_checkpoints (
+    id SERIAL PRIMARY KEY,
+    chain_id BIGINT NOT NULL,
+    issuer_address VARCHAR(42) NOT NULL,
+    root BYTEA NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Sync state of each tree on each chain its roots are anchored to
+CREATE TABLE IF NOT EXISTS merkle_tree_chains (
+    tree_id INT NOT NULL,
+    chain_id BIGINT NOT NULL,
+    need_sync BOOLEAN NOT NULL DEFAULT false,
+    node_count_sync INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (tree_id, chain_id),
+    FOREIGN KEY (tree_id) REFERENCES merkle_trees(id)
+);
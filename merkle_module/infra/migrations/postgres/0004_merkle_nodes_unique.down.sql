@@ -0,0 +1 @@
+ALTER TABLE merkle_nodes DROP CONSTRAINT IF EXISTS merkle_nodes_tree_id_node_id_key;
@@ -0,0 +1,3 @@
+-- Fails on databases holding the same node twice, merkle-migrate status lists it as pending
+-- until the duplicates are removed
+ALTER TABLE merkle_nodes ADD CONSTRAINT merkle_nodes_tree_id_node_id_key UNIQUE (tree_id, node_id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_merkle_nodes_data;
+DROP INDEX IF EXISTS idx_merkle_trees_issuer;
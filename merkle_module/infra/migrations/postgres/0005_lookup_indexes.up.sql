@@ -0,0 +1,5 @@
+-- Active tree lookup of GetActiveTreeForInserting
+CREATE INDEX IF NOT EXISTS idx_merkle_trees_issuer ON merkle_trees (issuer_did, node_count);
+
+-- Lookup of a leaf by its hash
+CREATE INDEX IF NOT EXISTS idx_merkle_nodes_data ON merkle_nodes USING HASH (data);
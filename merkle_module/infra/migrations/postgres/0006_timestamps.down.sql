@@ -0,0 +1,10 @@
+ALTER TABLE merkle_tree_chains
+    DROP COLUMN IF EXISTS updated_at,
+    DROP COLUMN IF EXISTS synced_at;
+
+ALTER TABLE merkle_nodes
+    DROP COLUMN IF EXISTS created_at;
+
+ALTER TABLE merkle_trees
+    DROP COLUMN IF EXISTS updated_at,
+    DROP COLUMN IF EXISTS created_at;
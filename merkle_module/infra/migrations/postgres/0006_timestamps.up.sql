@@ -0,0 +1,11 @@
+ALTER TABLE merkle_trees
+    ADD COLUMN IF NOT EXISTS created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP NOT NULL DEFAULT NOW();
+
+ALTER TABLE merkle_nodes
+    ADD COLUMN IF NOT EXISTS created_at TIMESTAMP NOT NULL DEFAULT NOW();
+
+-- synced_at is the time the last sync of the tree was confirmed on the chain
+ALTER TABLE merkle_tree_chains
+    ADD COLUMN IF NOT EXISTS synced_at TIMESTAMP,
+    ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP NOT NULL DEFAULT NOW();
@@ -0,0 +1,12 @@
+-- Hashes of the nodes of the trees, versioned by the number of leaves of the tree when they
+-- were computed. The hash of a node once a tree holds n leaves is the one of its row with the
+-- largest size up to n, a node without such row holds the hash of an empty subtree.
+-- node_index is 1 for the root and the children of node i are 2i and 2i+1.
+CREATE TABLE IF NOT EXISTS merkle_node_hashes (
+    tree_id INT NOT NULL,
+    node_index INT NOT NULL,
+    size INT NOT NULL,
+    hash BYTEA NOT NULL,
+    PRIMARY KEY (tree_id, node_index, size),
+    FOREIGN KEY (tree_id) REFERENCES merkle_trees(id)
+);
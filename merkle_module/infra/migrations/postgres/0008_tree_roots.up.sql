@@ -0,0 +1,16 @@
+-- History of the roots of the trees, one per size the tree has had
+CREATE TABLE IF NOT EXISTS tree_roots (
+    tree_id INT NOT NULL,
+    size INT NOT NULL,
+    root BYTEA NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (tree_id, size),
+    FOREIGN KEY (tree_id) REFERENCES merkle_trees(id)
+);
+
+-- The roots computed by past syncs start the history
+INSERT INTO tree_roots (tree_id, size, root, created_at)
+SELECT DISTINCT ON (tree_id, node_count) tree_id, node_count, root, created_at
+FROM merkle_tree_syncs
+ORDER BY tree_id, node_count, created_at
+ON CONFLICT (tree_id, size) DO NOTHING;
@@ -0,0 +1,12 @@
+-- The nodes of sealed trees only live in their archive, which SQL cannot decode
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM merkle_tree_seals) THEN
+        RAISE EXCEPTION 'sealed trees cannot be reverted, their nodes only live in merkle_tree_seals';
+    END IF;
+END $$;
+
+ALTER TABLE merkle_trees
+    DROP COLUMN IF EXISTS sealed_at;
+
+DROP TABLE IF EXISTS merkle_tree_seals;
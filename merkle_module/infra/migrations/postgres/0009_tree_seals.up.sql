@@ -0,0 +1,15 @@
+-- A full tree synced to every chain is sealed: it never changes again, its final root and the
+-- transaction of its last sync are recorded, and its nodes move out of merkle_nodes into the
+-- leaves blob, a version byte then each leaf prefixed by its length as a uvarint
+CREATE TABLE IF NOT EXISTS merkle_tree_seals (
+    tree_id INT PRIMARY KEY,
+    root BYTEA NOT NULL,
+    chain_id BIGINT NOT NULL,
+    tx_hash VARCHAR(66) NOT NULL,
+    leaves BYTEA NOT NULL,
+    sealed_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (tree_id) REFERENCES merkle_trees(id)
+);
+
+ALTER TABLE merkle_trees
+    ADD COLUMN IF NOT EXISTS sealed_at TIMESTAMP;
@@ -0,0 +1,12 @@
+-- GetActiveTreeForInserting reserves a node before AddNode fills it. A reservation left past its
+-- expiry was abandoned, the reapReservations job fills its node with the empty leaf so that the
+-- nodes after it can be synced.
+CREATE TABLE IF NOT EXISTS merkle_node_reservations (
+    tree_id INT NOT NULL,
+    node_id INT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (tree_id, node_id),
+    FOREIGN KEY (tree_id) REFERENCES merkle_trees(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_merkle_node_reservations_expires_at ON merkle_node_reservations (expires_at);
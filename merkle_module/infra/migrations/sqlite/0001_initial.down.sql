@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS merkle_tree_chains;
+DROP TABLE IF EXISTS merkle_checkpoints;
+DROP TABLE IF EXISTS merkle_tree_syncs;
+DROP TABLE IF EXISTS merkle_nodes;
+DROP TABLE IF EXISTS merkle_trees;
@@ -0,0 +1,65 @@
+-- SQLite counterpart of the postgres migrations up to 0006 for the tables of MerkleSQLite
+CREATE TABLE IF NOT EXISTS merkle_trees (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    issuer_did TEXT NOT NULL,
+    node_count INTEGER NOT NULL,
+    need_sync INTEGER NOT NULL DEFAULT 1,
+    node_count_sync INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_merkle_trees_issuer ON merkle_trees (issuer_did, node_count);
+
+CREATE TABLE IF NOT EXISTS merkle_nodes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    tree_id INTEGER NOT NULL,
+    node_id INTEGER NOT NULL,
+    data BLOB NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (tree_id, node_id),
+    FOREIGN KEY (tree_id) REFERENCES merkle_trees(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_merkle_nodes_data ON merkle_nodes (data);
+
+CREATE TABLE IF NOT EXISTS merkle_tree_syncs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    tree_id INTEGER NOT NULL,
+    chain_id INTEGER NOT NULL DEFAULT 0,
+    node_count INTEGER NOT NULL,
+    root BLOB NOT NULL,
+    status TEXT NOT NULL,
+    tx_hash TEXT NOT NULL DEFAULT '',
+    nonce INTEGER NOT NULL DEFAULT 0,
+    block_number INTEGER NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    issuer_address TEXT NOT NULL DEFAULT '',
+    checkpoint_id INTEGER NOT NULL DEFAULT 0,
+    checkpoint_position INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (tree_id) REFERENCES merkle_trees(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_merkle_tree_syncs_status ON merkle_tree_syncs (chain_id, status, tree_id);
+CREATE INDEX IF NOT EXISTS idx_merkle_tree_syncs_checkpoint ON merkle_tree_syncs (checkpoint_id, checkpoint_position);
+
+CREATE TABLE IF NOT EXISTS merkle_checkpoints (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    chain_id INTEGER NOT NULL,
+    issuer_address TEXT NOT NULL,
+    root BLOB NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS merkle_tree_chains (
+    tree_id INTEGER NOT NULL,
+    chain_id INTEGER NOT NULL,
+    need_sync INTEGER NOT NULL DEFAULT 0,
+    node_count_sync INTEGER NOT NULL DEFAULT 0,
+    synced_at TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (tree_id, chain_id),
+    FOREIGN KEY (tree_id) REFERENCES merkle_trees(id)
+);
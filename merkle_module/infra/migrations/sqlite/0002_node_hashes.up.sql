@@ -0,0 +1,9 @@
+-- See the postgres migration 0007_node_hashes
+CREATE TABLE IF NOT EXISTS merkle_node_hashes (
+    tree_id INTEGER NOT NULL,
+    node_index INTEGER NOT NULL,
+    size INTEGER NOT NULL,
+    hash BLOB NOT NULL,
+    PRIMARY KEY (tree_id, node_index, size),
+    FOREIGN KEY (tree_id) REFERENCES merkle_trees(id)
+);
@@ -0,0 +1,14 @@
+-- See the postgres migration 0008_tree_roots
+CREATE TABLE IF NOT EXISTS tree_roots (
+    tree_id INTEGER NOT NULL,
+    size INTEGER NOT NULL,
+    root BLOB NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (tree_id, size),
+    FOREIGN KEY (tree_id) REFERENCES merkle_trees(id)
+);
+
+INSERT OR IGNORE INTO tree_roots (tree_id, size, root, created_at)
+SELECT tree_id, node_count, root, created_at
+FROM merkle_tree_syncs
+ORDER BY tree_id, node_count, created_at;
@@ -0,0 +1,8 @@
+-- The nodes of sealed trees only live in their archive, which SQL cannot decode: the NULL node
+-- ID violates merkle_nodes constraints and fails the migration while a tree is sealed
+INSERT INTO merkle_nodes (tree_id, node_id, data)
+SELECT tree_id, NULL, leaves FROM merkle_tree_seals;
+
+ALTER TABLE merkle_trees DROP COLUMN sealed_at;
+
+DROP TABLE IF EXISTS merkle_tree_seals;
@@ -0,0 +1,12 @@
+-- See the postgres migration 0009_tree_seals
+CREATE TABLE IF NOT EXISTS merkle_tree_seals (
+    tree_id INTEGER PRIMARY KEY,
+    root BLOB NOT NULL,
+    chain_id INTEGER NOT NULL,
+    tx_hash TEXT NOT NULL,
+    leaves BLOB NOT NULL,
+    sealed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (tree_id) REFERENCES merkle_trees(id)
+);
+
+ALTER TABLE merkle_trees ADD COLUMN sealed_at TIMESTAMP;
@@ -0,0 +1,10 @@
+-- See the postgres migration 0010_node_reservations
+CREATE TABLE IF NOT EXISTS merkle_node_reservations (
+    tree_id INTEGER NOT NULL,
+    node_id INTEGER NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (tree_id, node_id),
+    FOREIGN KEY (tree_id) REFERENCES merkle_trees(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_merkle_node_reservations_expires_at ON merkle_node_reservations (expires_at);
@@ -13,3 +13,17 @@ type MerkleTreeWithNodes struct {
 	Tree  *entities.MerkleTree
 	Nodes []*entities.MerkleNode
 }
+
+// IndexerCursor is the last block processed by an event indexer
+type IndexerCursor struct {
+	Name        string `json:"name"`
+	BlockNumber uint64 `json:"block_number"`
+	BlockHash   string `json:"block_hash"`
+}
+
+// EventBatch holds the events of a block range, saved together with the new cursor
+type EventBatch struct {
+	Cursor      *IndexerCursor
+	Roots       []*entities.AnchoredRoot
+	StatusLists []*entities.StatusListEvent
+}
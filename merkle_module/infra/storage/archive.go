@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"slices"
+
+	"merkle_module/domain/entities"
+	"merkle_module/infra/model"
+	"merkle_module/utils"
+)
+
+// archiveVersion is the first byte of the archive of a sealed tree
+const archiveVersion = 1
+
+// packLeaves encodes the leaves of a sealed tree into a single blob: the archive version, then
+// every leaf as its length in a uvarint followed by its data
+func packLeaves(leaves [][]byte) []byte {
+	blob := []byte{archiveVersion}
+	for _, leaf := range leaves {
+		blob = binary.AppendUvarint(blob, uint64(len(leaf)))
+		blob = append(blob, leaf...)
+	}
+	return blob
+}
+
+// unpackLeaves decodes the blob of packLeaves
+func unpackLeaves(blob []byte) ([][]byte, error) {
+	if len(blob) == 0 || blob[0] != archiveVersion {
+		return nil, fmt.Errorf("unsupported archive version")
+	}
+
+	var leaves [][]byte
+	for rest := blob[1:]; len(rest) > 0; {
+		size, n := binary.Uvarint(rest)
+		if n <= 0 || uint64(len(rest)-n) < size {
+			return nil, fmt.Errorf("truncated archive after %d leaves", len(leaves))
+		}
+		// the capacity is capped so that appending to a leaf does not overwrite the next one
+		leaves = append(leaves, rest[n:n+int(size):n+int(size)])
+		rest = rest[n+int(size):]
+	}
+	return leaves, nil
+}
+
+// sealedLeaves returns the data of the nodes of a tree to seal, which must be the nodes 1 to
+// MAX_LEAFS in order
+func sealedLeaves(treeID int, nodes []*entities.MerkleNode) ([][]byte, error) {
+	if len(nodes) != utils.MAX_LEAFS {
+		return nil, fmt.Errorf("tree ID %d holds %d nodes, only full trees are sealed", treeID, len(nodes))
+	}
+	leaves := make([][]byte, len(nodes))
+	for i, node := range nodes {
+		if node.NodeID != i+1 {
+			return nil, fmt.Errorf("tree ID %d misses node %d", treeID, i+1)
+		}
+		leaves[i] = node.Data
+	}
+	return leaves, nil
+}
+
+// archivedNodes returns the nodes of a sealed tree with a node ID up to last from its archive
+func archivedNodes(treeID int, blob []byte, last int) ([]*entities.MerkleNode, error) {
+	leaves, err := unpackLeaves(blob)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive of tree ID %d: %w", treeID, err)
+	}
+	var nodes []*entities.MerkleNode
+	for i, leaf := range leaves[:min(last, len(leaves))] {
+		nodes = append(nodes, &entities.MerkleNode{TreeID: treeID, NodeID: i + 1, Data: leaf})
+	}
+	return nodes, nil
+}
+
+// sealNodes reads the nodes of a tree to seal with query, selecting node_id and data of the tree
+func sealNodes(ctx context.Context, tx *sql.Tx, treeID int, query string) ([][]byte, error) {
+	rows, err := tx.QueryContext(ctx, query, treeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query nodes to seal: %w", err)
+	}
+	defer rows.Close()
+
+	var nodes []*entities.MerkleNode
+	for rows.Next() {
+		node := &entities.MerkleNode{TreeID: treeID}
+		if err := rows.Scan(&node.NodeID, &node.Data); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		nodes = append(nodes, node)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+	return sealedLeaves(treeID, nodes)
+}
+
+// getTreeSeal reads the seal of a tree with query, selecting root, chain_id, tx_hash, sealed_at
+// and leaves of the tree
+func getTreeSeal(ctx context.Context, db *sql.DB, treeID int, query string) (*entities.TreeSeal, error) {
+	seal := &entities.TreeSeal{TreeID: treeID}
+	var blob []byte
+	err := db.QueryRowContext(ctx, query, treeID).Scan(&seal.Root, &seal.ChainID, &seal.TxHash, &seal.SealedAt, &blob)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tree seal: %w", err)
+	}
+	if seal.Leaves, err = unpackLeaves(blob); err != nil {
+		return nil, fmt.Errorf("failed to read archive of tree ID %d: %w", treeID, err)
+	}
+	return seal, nil
+}
+
+// appendArchivedTrees appends the sealed trees of rows, selecting id, issuer_did, node_count and
+// leaves, to the trees with nodes and orders them by tree ID
+func appendArchivedTrees(trees []*model.MerkleTreeWithNodes, rows *sql.Rows) ([]*model.MerkleTreeWithNodes, error) {
+	for rows.Next() {
+		tree := &entities.MerkleTree{}
+		var blob []byte
+		if err := rows.Scan(&tree.ID, &tree.IssuerDID, &tree.NodeCount, &blob); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		nodes, err := archivedNodes(tree.ID, blob, tree.NodeCount)
+		if err != nil {
+			return nil, err
+		}
+		tree.NodeCount = len(nodes)
+		trees = append(trees, &model.MerkleTreeWithNodes{Tree: tree, Nodes: nodes})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+	slices.SortFunc(trees, func(a, b *model.MerkleTreeWithNodes) int { return a.Tree.ID - b.Tree.ID })
+	return trees, nil
+}
+
+// distinctChains returns the chain IDs without duplicates
+func distinctChains(chainIDs []uint64) []uint64 {
+	chainIDs = slices.Clone(chainIDs)
+	slices.Sort(chainIDs)
+	return slices.Compact(chainIDs)
+}
@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"merkle_module/domain/entities"
+	"merkle_module/domain/repo"
+	"merkle_module/infra/model"
+)
+
+type ChainEventPostgres struct {
+	db *sql.DB
+}
+
+func NewChainEventPostgres(db *sql.DB) repo.ChainEvent {
+	return &ChainEventPostgres{db: db}
+}
+
+func (c *ChainEventPostgres) GetCursor(ctx context.Context, name string) (*model.IndexerCursor, error) {
+	cursor := &model.IndexerCursor{Name: name}
+	err := c.db.QueryRowContext(ctx, `
+	SELECT block_number, block_hash
+	FROM indexer_cursors
+	WHERE name = $1
+	`, name).Scan(&cursor.BlockNumber, &cursor.BlockHash)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get indexer cursor: %w", err)
+	}
+	return cursor, nil
+}
+
+func (c *ChainEventPostgres) SaveEvents(ctx context.Context, batch *model.EventBatch) error {
+	// Begin a transaction
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	// Events are unique per log, so re-indexing a range is idempotent
+	for _, root := range batch.Roots {
+		_, err = tx.ExecContext(ctx, `
+		INSERT INTO anchored_roots (contract_address, issuer, tree_index, root, block_number, block_hash, tx_hash, log_index, batch_position)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (tx_hash, log_index, batch_position) DO NOTHING
+		`, root.ContractAddress, root.Issuer, root.TreeIndex, root.Root, root.BlockNumber, root.BlockHash, root.TxHash, root.LogIndex, root.BatchPosition)
+		if err != nil {
+			return fmt.Errorf("failed to insert anchored root: %w", err)
+		}
+	}
+
+	for _, event := range batch.StatusLists {
+		_, err = tx.ExecContext(ctx, `
+		INSERT INTO status_list_events (contract_address, issuer, list_id, list_id_hash, list, block_number, block_hash, tx_hash, log_index, batch_position)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (tx_hash, log_index, batch_position) DO NOTHING
+		`, event.ContractAddress, event.Issuer, event.ListID, event.ListIDHash, event.List, event.BlockNumber, event.BlockHash, event.TxHash, event.LogIndex, event.BatchPosition)
+		if err != nil {
+			return fmt.Errorf("failed to insert status list event: %w", err)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO indexer_cursors (name, block_number, block_hash)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (name) DO UPDATE SET block_number = EXCLUDED.block_number, block_hash = EXCLUDED.block_hash
+	`, batch.Cursor.Name, batch.Cursor.BlockNumber, batch.Cursor.BlockHash)
+	if err != nil {
+		return fmt.Errorf("failed to update indexer cursor: %w", err)
+	}
+
+	return nil
+}
+
+func (c *ChainEventPostgres) Rewind(ctx context.Context, name string, cursor *model.IndexerCursor, contractAddress string, fromBlock uint64) error {
+	// Begin a transaction
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	_, err = tx.ExecContext(ctx, `
+	DELETE FROM anchored_roots
+	WHERE contract_address = $1 AND block_number >= $2
+	`, contractAddress, fromBlock)
+	if err != nil {
+		return fmt.Errorf("failed to delete anchored roots: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	DELETE FROM status_list_events
+	WHERE contract_address = $1 AND block_number >= $2
+	`, contractAddress, fromBlock)
+	if err != nil {
+		return fmt.Errorf("failed to delete status list events: %w", err)
+	}
+
+	if cursor == nil {
+		_, err = tx.ExecContext(ctx, `DELETE FROM indexer_cursors WHERE name = $1`, name)
+	} else {
+		_, err = tx.ExecContext(ctx, `
+		UPDATE indexer_cursors
+		SET block_number = $1, block_hash = $2
+		WHERE name = $3
+		`, cursor.BlockNumber, cursor.BlockHash, name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to reset indexer cursor: %w", err)
+	}
+
+	return nil
+}
+
+func (c *ChainEventPostgres) GetRootHistory(ctx context.Context, contractAddress, issuer string, treeIndex int64) ([]*entities.AnchoredRoot, error) {
+	rows, err := c.db.QueryContext(ctx, `
+	SELECT id, contract_address, issuer, tree_index, root, block_number, block_hash, tx_hash, log_index, batch_position
+	FROM anchored_roots
+	WHERE contract_address = $1 AND issuer = $2 AND tree_index = $3
+	ORDER BY block_number, log_index, batch_position
+	`, contractAddress, issuer, treeIndex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query root history: %w", err)
+	}
+	defer rows.Close()
+
+	var roots []*entities.AnchoredRoot
+	for rows.Next() {
+		root, err := scanAnchoredRoot(rows)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, root)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	return roots, nil
+}
+
+func (c *ChainEventPostgres) GetRootAtBlock(ctx context.Context, contractAddress, issuer string, treeIndex int64, blockNumber uint64) (*entities.AnchoredRoot, error) {
+	root, err := scanAnchoredRoot(c.db.QueryRowContext(ctx, `
+	SELECT id, contract_address, issuer, tree_index, root, block_number, block_hash, tx_hash, log_index, batch_position
+	FROM anchored_roots
+	WHERE contract_address = $1 AND issuer = $2 AND tree_index = $3 AND block_number <= $4
+	ORDER BY block_number DESC, log_index DESC, batch_position DESC
+	LIMIT 1
+	`, contractAddress, issuer, treeIndex, blockNumber))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get root at block %d: %w", blockNumber, err)
+	}
+	return root, nil
+}
+
+func (c *ChainEventPostgres) GetStatusListHistory(ctx context.Context, contractAddress, issuer, listIDHash string) ([]*entities.StatusListEvent, error) {
+	rows, err := c.db.QueryContext(ctx, `
+	SELECT id, contract_address, issuer, list_id, list_id_hash, list, block_number, block_hash, tx_hash, log_index, batch_position
+	FROM status_list_events
+	WHERE contract_address = $1 AND issuer = $2 AND list_id_hash = $3
+	ORDER BY block_number, log_index, batch_position
+	`, contractAddress, issuer, listIDHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query status list history: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*entities.StatusListEvent
+	for rows.Next() {
+		var event entities.StatusListEvent
+		if err := rows.Scan(&event.ID, &event.ContractAddress, &event.Issuer, &event.ListID, &event.ListIDHash, &event.List, &event.BlockNumber, &event.BlockHash, &event.TxHash, &event.LogIndex, &event.BatchPosition); err != nil {
+			return nil, fmt.Errorf("failed to scan status list event: %w", err)
+		}
+		events = append(events, &event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	return events, nil
+}
+
+// scanAnchoredRoot returns sql.ErrNoRows unwrapped so callers can tell a missing root apart
+func scanAnchoredRoot(row rowScanner) (*entities.AnchoredRoot, error) {
+	var root entities.AnchoredRoot
+	err := row.Scan(&root.ID, &root.ContractAddress, &root.Issuer, &root.TreeIndex, &root.Root, &root.BlockNumber, &root.BlockHash, &root.TxHash, &root.LogIndex, &root.BatchPosition)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan anchored root: %w", err)
+	}
+	return &root, nil
+}
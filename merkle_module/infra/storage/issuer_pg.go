@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"merkle_module/domain/entities"
+	"merkle_module/domain/repo"
+)
+
+type IssuerPostgres struct {
+	db *sql.DB
+}
+
+func NewIssuerPostgres(db *sql.DB) repo.Issuer {
+	return &IssuerPostgres{db: db}
+}
+
+func (i *IssuerPostgres) GetIssuer(ctx context.Context, did string) (*entities.Issuer, error) {
+	issuer := &entities.Issuer{DID: did}
+	err := i.db.QueryRowContext(ctx, `
+	SELECT eth_address
+	FROM issuers
+	WHERE did = $1
+	`, did).Scan(&issuer.Address)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get issuer: %w", err)
+	}
+	return issuer, nil
+}
+
+func (i *IssuerPostgres) SetIssuer(ctx context.Context, issuer *entities.Issuer) error {
+	_, err := i.db.ExecContext(ctx, `
+	INSERT INTO issuers (did, eth_address)
+	VALUES ($1, $2)
+	ON CONFLICT (did) DO UPDATE SET eth_address = EXCLUDED.eth_address
+	`, issuer.DID, issuer.Address)
+	if err != nil {
+		return fmt.Errorf("failed to set issuer: %w", err)
+	}
+	return nil
+}
+
+func (i *IssuerPostgres) ListIssuers(ctx context.Context) ([]*entities.Issuer, error) {
+	rows, err := i.db.QueryContext(ctx, `
+	SELECT did, eth_address
+	FROM issuers
+	ORDER BY did
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query issuers: %w", err)
+	}
+	defer rows.Close()
+
+	var issuers []*entities.Issuer
+	for rows.Next() {
+		var issuer entities.Issuer
+		if err := rows.Scan(&issuer.DID, &issuer.Address); err != nil {
+			return nil, fmt.Errorf("failed to scan issuer: %w", err)
+		}
+		issuers = append(issuers, &issuer)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	return issuers, nil
+}
@@ -0,0 +1,791 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"merkle_module/domain/entities"
+	"merkle_module/domain/repo"
+	"merkle_module/infra/model"
+	"merkle_module/utils"
+)
+
+// MerkleMemory keeps the trees, their syncs and checkpoints in memory with the semantics
+// of MerklePostgres. It is meant for tests and local demos, its state is lost on exit.
+type MerkleMemory struct {
+	mu          sync.Mutex
+	trees       []*memoryTree // tree ID i is trees[i-1], nil for the IDs skipped by an import
+	chains      map[memoryChainKey]*memoryChainState
+	syncs       []*entities.TreeSync // sync ID i is syncs[i-1]
+	checkpoints []*entities.Checkpoint
+}
+
+type memoryTree struct {
+	issuerDID string
+	nodeCount int
+	reserved  time.Time                    // time the last node was reserved, zero for imported trees
+	expiries  map[int]time.Time            // expiry of the reserved nodes not added yet by node ID
+	nodes     []*entities.MerkleNode       // ordered by node ID
+	hashes    map[int][]*entities.NodeHash // by node index, ordered by size
+	roots     []*entities.TreeRoot         // ordered by size
+	seal      *entities.TreeSeal           // set once sealed, the nodes then move to archive
+	archive   []byte
+}
+
+type memoryChainKey struct {
+	treeID  int
+	chainID uint64
+}
+
+type memoryChainState struct {
+	needSync      bool
+	nodeCountSync int
+}
+
+func NewMerkleMemory() repo.Merkle {
+	return &MerkleMemory{chains: make(map[memoryChainKey]*memoryChainState)}
+}
+
+func (m *MerkleMemory) tree(treeID int) *memoryTree {
+	if treeID <= 0 || treeID > len(m.trees) {
+		return nil
+	}
+	return m.trees[treeID-1]
+}
+
+// chain returns the sync state of the tree on the chain, an unsynced state if there is none
+func (m *MerkleMemory) chain(treeID int, chainID uint64) *memoryChainState {
+	key := memoryChainKey{treeID: treeID, chainID: chainID}
+	if m.chains[key] == nil {
+		m.chains[key] = &memoryChainState{}
+	}
+	return m.chains[key]
+}
+
+// allNodes returns the nodes of the tree, read from its archive once sealed
+func (t *memoryTree) allNodes(treeID int) []*entities.MerkleNode {
+	if t.archive == nil {
+		return t.nodes
+	}
+	nodes, _ := archivedNodes(treeID, t.archive, utils.MAX_LEAFS)
+	return nodes
+}
+
+// nodesUpTo returns copies of the nodes of the tree with a node ID up to last
+func (t *memoryTree) nodesUpTo(treeID, last int) []*entities.MerkleNode {
+	var nodes []*entities.MerkleNode
+	for _, node := range t.allNodes(treeID) {
+		if node.NodeID <= last {
+			copied := *node
+			nodes = append(nodes, &copied)
+		}
+	}
+	return nodes
+}
+
+func (m *MerkleMemory) GetNodesByTreeID(ctx context.Context, treeID int) ([]*entities.MerkleNode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if tree := m.tree(treeID); tree != nil {
+		return tree.nodesUpTo(treeID, math.MaxInt), nil
+	}
+	return []*entities.MerkleNode{}, nil
+}
+
+func (m *MerkleMemory) AddNode(ctx context.Context, treeID int, nodeID int, data []byte) (*entities.MerkleNode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	node, err := m.addNode(treeID, nodeID, data)
+	if err != nil {
+		return nil, err
+	}
+	delete(m.trees[treeID-1].expiries, nodeID)
+	return node, nil
+}
+
+func (m *MerkleMemory) addNode(treeID int, nodeID int, data []byte) (*entities.MerkleNode, error) {
+	tree := m.tree(treeID)
+	if tree == nil {
+		return nil, fmt.Errorf("failed to insert merkle node: tree ID %d does not exist", treeID)
+	}
+
+	// keep the nodes ordered by node ID, a node ID is unique within its tree
+	i := sort.Search(len(tree.nodes), func(i int) bool { return tree.nodes[i].NodeID >= nodeID })
+	if i < len(tree.nodes) && tree.nodes[i].NodeID == nodeID {
+		return nil, fmt.Errorf("failed to insert merkle node: node ID %d of tree ID %d already exists", nodeID, treeID)
+	}
+
+	// make a copy of the data
+	dataCopy := make([]byte, len(data))
+	copy(dataCopy, data)
+	node := &entities.MerkleNode{TreeID: treeID, NodeID: nodeID, Data: dataCopy}
+	tree.nodes = append(tree.nodes, nil)
+	copy(tree.nodes[i+1:], tree.nodes[i:])
+	tree.nodes[i] = node
+
+	copied := *node
+	return &copied, nil
+}
+
+func (m *MerkleMemory) CountNodes(ctx context.Context, treeID int) (int, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tree := m.tree(treeID)
+	if tree == nil || len(tree.nodes) == 0 {
+		return 0, 0, nil
+	}
+	return len(tree.nodes), tree.nodes[len(tree.nodes)-1].NodeID, nil
+}
+
+func (m *MerkleMemory) GetActiveTreeForInserting(ctx context.Context, issuerDID string) (*model.ActiveTree, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	treeID := 0
+	for i, tree := range m.trees {
+		if tree != nil && tree.issuerDID == issuerDID && tree.nodeCount < utils.MAX_LEAFS {
+			treeID = i + 1
+			break
+		}
+	}
+	if treeID == 0 {
+		// If not found, create a new one with node_count = 1
+		tree := &memoryTree{issuerDID: issuerDID, nodeCount: 1}
+		tree.reserve()
+		m.trees = append(m.trees, tree)
+		return &model.ActiveTree{TreeID: len(m.trees), IssuerDID: issuerDID, NodeCount: 1}, nil
+	}
+
+	tree := m.trees[treeID-1]
+	tree.nodeCount++
+	tree.reserve()
+	var nodes [][]byte
+	for _, node := range tree.nodes {
+		nodes = append(nodes, node.Data)
+	}
+	return &model.ActiveTree{
+		TreeID:    treeID,
+		IssuerDID: issuerDID,
+		NodeCount: tree.nodeCount, // Already reserved a slot for the next node
+		Nodes:     nodes,
+	}, nil
+}
+
+// reserve reserves the node at the node count of the tree until AddNode fills it
+func (t *memoryTree) reserve() {
+	t.reserved = time.Now()
+	if t.expiries == nil {
+		t.expiries = make(map[int]time.Time)
+	}
+	t.expiries[t.nodeCount] = reservationExpiry()
+}
+
+func (m *MerkleMemory) AddNodeAndIncrementNodeCount(ctx context.Context, treeID int, nodeID int, data []byte) (*entities.MerkleNode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	node, err := m.addNode(treeID, nodeID, data)
+	if err != nil {
+		return nil, err
+	}
+	m.trees[treeID-1].nodeCount++
+	return node, nil
+}
+
+func (m *MerkleMemory) SaveNodeHashes(ctx context.Context, hashes []*entities.NodeHash) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, hash := range hashes {
+		if m.tree(hash.TreeID) == nil {
+			return fmt.Errorf("failed to save node hashes: tree ID %d does not exist", hash.TreeID)
+		}
+	}
+	for _, hash := range hashes {
+		tree := m.tree(hash.TreeID)
+		if tree.hashes == nil {
+			tree.hashes = make(map[int][]*entities.NodeHash)
+		}
+		copied := *hash
+		copied.Hash = append([]byte(nil), hash.Hash...)
+
+		versions := tree.hashes[hash.Index]
+		i := sort.Search(len(versions), func(i int) bool { return versions[i].Size >= hash.Size })
+		if i < len(versions) && versions[i].Size == hash.Size {
+			versions[i] = &copied
+			continue
+		}
+		versions = append(versions, nil)
+		copy(versions[i+1:], versions[i:])
+		versions[i] = &copied
+		tree.hashes[hash.Index] = versions
+	}
+	return nil
+}
+
+func (m *MerkleMemory) GetNodeHashes(ctx context.Context, treeID int, size int, indexes []int) ([]*entities.NodeHash, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tree := m.tree(treeID)
+	if tree == nil {
+		return nil, nil
+	}
+	sorted := append([]int(nil), indexes...)
+	sort.Ints(sorted)
+
+	var hashes []*entities.NodeHash
+	for i, index := range sorted {
+		if i > 0 && sorted[i-1] == index {
+			continue
+		}
+		// the last version with a size up to size
+		versions := tree.hashes[index]
+		j := sort.Search(len(versions), func(j int) bool { return versions[j].Size > size })
+		if j == 0 {
+			continue
+		}
+		copied := *versions[j-1]
+		hashes = append(hashes, &copied)
+	}
+	return hashes, nil
+}
+
+func (m *MerkleMemory) AddTreeRoots(ctx context.Context, roots []*entities.TreeRoot) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, root := range roots {
+		if m.tree(root.TreeID) == nil {
+			return fmt.Errorf("failed to add tree roots: tree ID %d does not exist", root.TreeID)
+		}
+	}
+	for _, root := range roots {
+		tree := m.tree(root.TreeID)
+		i := sort.Search(len(tree.roots), func(i int) bool { return tree.roots[i].Size >= root.Size })
+		if i < len(tree.roots) && tree.roots[i].Size == root.Size {
+			continue
+		}
+		copied := *root
+		copied.Root = append([]byte(nil), root.Root...)
+		tree.roots = append(tree.roots, nil)
+		copy(tree.roots[i+1:], tree.roots[i:])
+		tree.roots[i] = &copied
+	}
+	return nil
+}
+
+func (m *MerkleMemory) GetTreeRoot(ctx context.Context, treeID int, size int) (*entities.TreeRoot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if tree := m.tree(treeID); tree != nil {
+		for _, root := range tree.roots {
+			if root.Size == size {
+				copied := *root
+				return &copied, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (m *MerkleMemory) GetTreeRoots(ctx context.Context, treeID int) ([]*entities.TreeRoot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var roots []*entities.TreeRoot
+	if tree := m.tree(treeID); tree != nil {
+		for _, root := range tree.roots {
+			copied := *root
+			roots = append(roots, &copied)
+		}
+	}
+	return roots, nil
+}
+
+func (m *MerkleMemory) GetTreesToSeal(ctx context.Context, chainIDs []uint64) ([]*entities.MerkleTree, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chainIDs = distinctChains(chainIDs)
+	if len(chainIDs) == 0 {
+		return nil, nil
+	}
+	pending := make(map[int]bool)
+	for _, treeSync := range m.syncs {
+		if treeSync.Status == entities.SyncStatusPending {
+			pending[treeSync.TreeID] = true
+		}
+	}
+
+	var trees []*entities.MerkleTree
+	for i, tree := range m.trees {
+		treeID := i + 1
+		if tree == nil || tree.seal != nil || pending[treeID] || tree.nodeCount != utils.MAX_LEAFS || len(tree.nodes) != utils.MAX_LEAFS {
+			continue
+		}
+		synced := true
+		for _, chainID := range chainIDs {
+			state := m.chains[memoryChainKey{treeID: treeID, chainID: chainID}]
+			synced = synced && state != nil && state.nodeCountSync == utils.MAX_LEAFS && !state.needSync
+		}
+		if synced {
+			trees = append(trees, &entities.MerkleTree{ID: treeID, IssuerDID: tree.issuerDID, NodeCount: tree.nodeCount})
+		}
+	}
+	return trees, nil
+}
+
+func (m *MerkleMemory) SealTree(ctx context.Context, seal *entities.TreeSeal) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tree := m.tree(seal.TreeID)
+	if tree == nil {
+		return fmt.Errorf("failed to get tree: tree ID %d does not exist", seal.TreeID)
+	}
+	if tree.seal != nil {
+		return fmt.Errorf("tree ID %d is already sealed", seal.TreeID)
+	}
+	leaves, err := sealedLeaves(seal.TreeID, tree.nodes)
+	if err != nil {
+		return err
+	}
+
+	seal.SealedAt = time.Now().UTC()
+	copied := *seal
+	copied.Root = append([]byte(nil), seal.Root...)
+	copied.Leaves = nil
+	tree.seal = &copied
+	tree.archive = packLeaves(leaves)
+	tree.nodes = nil
+	tree.hashes = nil
+	return nil
+}
+
+func (m *MerkleMemory) GetTreeSeal(ctx context.Context, treeID int) (*entities.TreeSeal, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tree := m.tree(treeID)
+	if tree == nil || tree.seal == nil {
+		return nil, nil
+	}
+	seal := *tree.seal
+	seal.Leaves, _ = unpackLeaves(tree.archive)
+	return &seal, nil
+}
+
+func (m *MerkleMemory) SetNodeCount(ctx context.Context, treeID, observed, nodeCount int, idle time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tree := m.tree(treeID)
+	if tree == nil || tree.seal != nil || tree.nodeCount != observed || time.Since(tree.reserved) < idle {
+		return false, nil
+	}
+	if len(tree.nodes) > 0 && tree.nodes[len(tree.nodes)-1].NodeID > nodeCount {
+		return false, nil
+	}
+	tree.nodeCount = nodeCount
+	tree.reserved = time.Now()
+	return true, nil
+}
+
+func (m *MerkleMemory) ReapReservations(ctx context.Context, now time.Time, leaf []byte) ([]*entities.MerkleNode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var nodes []*entities.MerkleNode
+	for i, tree := range m.trees {
+		if tree == nil {
+			continue
+		}
+		var expired []int
+		for nodeID, expiry := range tree.expiries {
+			if !expiry.After(now) {
+				expired = append(expired, nodeID)
+			}
+		}
+		sort.Ints(expired)
+		for _, nodeID := range expired {
+			delete(tree.expiries, nodeID)
+			if nodeID > tree.nodeCount || tree.seal != nil {
+				continue
+			}
+			// A node added since its reservation is kept
+			if node, err := m.addNode(i+1, nodeID, leaf); err == nil {
+				nodes = append(nodes, node)
+			}
+		}
+	}
+	return nodes, nil
+}
+
+func (m *MerkleMemory) GetIssuers(ctx context.Context) ([]*entities.IssuerTrees, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	byDID := make(map[string]*entities.IssuerTrees)
+	var issuers []*entities.IssuerTrees
+	for i, tree := range m.trees {
+		if tree == nil {
+			continue
+		}
+		issuer, ok := byDID[tree.issuerDID]
+		if !ok {
+			issuer = &entities.IssuerTrees{IssuerDID: tree.issuerDID}
+			byDID[tree.issuerDID] = issuer
+			issuers = append(issuers, issuer)
+		}
+		issuer.Trees++
+		issuer.NodeCount += tree.nodeCount
+		issuer.LastTreeID = i + 1
+	}
+	sort.Slice(issuers, func(i, j int) bool { return issuers[i].IssuerDID < issuers[j].IssuerDID })
+	return issuers, nil
+}
+
+func (m *MerkleMemory) GetIssuerTrees(ctx context.Context, issuerDID string) ([]*entities.MerkleTree, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var trees []*entities.MerkleTree
+	for i, tree := range m.trees {
+		if tree != nil && tree.issuerDID == issuerDID {
+			trees = append(trees, &entities.MerkleTree{ID: i + 1, IssuerDID: tree.issuerDID, NodeCount: tree.nodeCount})
+		}
+	}
+	return trees, nil
+}
+
+func (m *MerkleMemory) GetTreeSnapshot(ctx context.Context, treeID int) (*entities.TreeSnapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tree := m.tree(treeID)
+	if tree == nil {
+		return nil, nil
+	}
+	snapshot := &entities.TreeSnapshot{
+		Tree:  &entities.MerkleTree{ID: treeID, IssuerDID: tree.issuerDID, NodeCount: tree.nodeCount},
+		Nodes: tree.nodesUpTo(treeID, math.MaxInt), // nodes beyond the node count included, as read by SQL
+	}
+	for _, root := range tree.roots {
+		copied := *root
+		snapshot.Roots = append(snapshot.Roots, &copied)
+	}
+	for key, state := range m.chains {
+		if key.treeID == treeID && (state.needSync || state.nodeCountSync > 0) {
+			snapshot.Chains = append(snapshot.Chains, &entities.TreeChain{TreeID: treeID, ChainID: key.chainID, NeedSync: state.needSync, NodeCountSync: state.nodeCountSync})
+		}
+	}
+	sort.Slice(snapshot.Chains, func(i, j int) bool { return snapshot.Chains[i].ChainID < snapshot.Chains[j].ChainID })
+	for _, treeSync := range m.syncs {
+		if treeSync.TreeID == treeID && treeSync.Status == entities.SyncStatusConfirmed {
+			copied := *treeSync
+			snapshot.Syncs = append(snapshot.Syncs, &copied)
+		}
+	}
+	if tree.seal != nil {
+		seal := *tree.seal
+		snapshot.Seal = &seal
+	}
+	return snapshot, nil
+}
+
+func (m *MerkleMemory) ImportTrees(ctx context.Context, snapshots []*entities.TreeSnapshot) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Check every snapshot first so that none is imported on failure
+	imported := make(map[int]bool)
+	archives := make(map[int][]byte)
+	for _, snapshot := range snapshots {
+		treeID := snapshot.Tree.ID
+		if treeID <= 0 || m.tree(treeID) != nil || imported[treeID] {
+			return fmt.Errorf("failed to import trees: tree ID %d already exists", treeID)
+		}
+		imported[treeID] = true
+		if snapshot.Seal != nil {
+			leaves, err := sealedLeaves(treeID, snapshot.Nodes)
+			if err != nil {
+				return fmt.Errorf("failed to import trees: %w", err)
+			}
+			archives[treeID] = packLeaves(leaves)
+		}
+	}
+
+	for _, snapshot := range snapshots {
+		treeID := snapshot.Tree.ID
+		for len(m.trees) < treeID {
+			m.trees = append(m.trees, nil)
+		}
+		tree := &memoryTree{issuerDID: snapshot.Tree.IssuerDID, nodeCount: snapshot.Tree.NodeCount}
+		m.trees[treeID-1] = tree
+		if snapshot.Seal != nil {
+			seal := *snapshot.Seal
+			seal.Leaves = nil
+			tree.seal, tree.archive = &seal, archives[treeID]
+		} else {
+			for _, node := range snapshot.Nodes {
+				if _, err := m.addNode(treeID, node.NodeID, node.Data); err != nil {
+					return err
+				}
+			}
+		}
+		for _, root := range snapshot.Roots {
+			tree.roots = append(tree.roots, &entities.TreeRoot{TreeID: treeID, Size: root.Size, Root: root.Root})
+		}
+		sort.Slice(tree.roots, func(i, j int) bool { return tree.roots[i].Size < tree.roots[j].Size })
+		for _, chain := range snapshot.Chains {
+			m.chains[memoryChainKey{treeID: treeID, chainID: chain.ChainID}] = &memoryChainState{needSync: chain.NeedSync, nodeCountSync: chain.NodeCountSync}
+		}
+		for _, treeSync := range snapshot.Syncs {
+			stored := *treeSync
+			stored.ID, stored.TreeID = len(m.syncs)+1, treeID
+			m.syncs = append(m.syncs, &stored)
+		}
+	}
+	return nil
+}
+
+func (m *MerkleMemory) GetTreesWithNodesForSync(ctx context.Context, chainID uint64) ([]*model.MerkleTreeWithNodes, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pending := make(map[int]bool)
+	for _, treeSync := range m.syncs {
+		if treeSync.ChainID == chainID && treeSync.Status == entities.SyncStatusPending {
+			pending[treeSync.TreeID] = true
+		}
+	}
+
+	var result []*model.MerkleTreeWithNodes
+	for i, tree := range m.trees {
+		treeID := i + 1
+		if tree == nil {
+			continue
+		}
+		state := m.chain(treeID, chainID)
+		if pending[treeID] || (tree.nodeCount <= state.nodeCountSync && !state.needSync) {
+			continue
+		}
+		// Trees without any node inserted yet have nothing to sync
+		nodes := tree.nodesUpTo(treeID, tree.nodeCount)
+		if len(nodes) == 0 {
+			continue
+		}
+		result = append(result, &model.MerkleTreeWithNodes{
+			Tree: &entities.MerkleTree{
+				ID:            treeID,
+				IssuerDID:     tree.issuerDID,
+				NodeCount:     len(nodes),
+				NeedSync:      state.needSync,
+				NodeCountSync: state.nodeCountSync,
+			},
+			Nodes: nodes,
+		})
+	}
+	return result, nil
+}
+
+func (m *MerkleMemory) GetNodesSyncedByTreeID(ctx context.Context, treeID int, chainID uint64) ([]*entities.MerkleNode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tree := m.tree(treeID)
+	if tree == nil {
+		return nil, nil
+	}
+	return tree.nodesUpTo(treeID, m.chain(treeID, chainID).nodeCountSync), nil
+}
+
+func (m *MerkleMemory) GetSyncedTrees(ctx context.Context, chainID uint64) ([]*entities.MerkleTree, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var trees []*entities.MerkleTree
+	for i, tree := range m.trees {
+		if tree == nil {
+			continue
+		}
+		state := m.chain(i+1, chainID)
+		if state.nodeCountSync == 0 {
+			continue
+		}
+		trees = append(trees, &entities.MerkleTree{
+			ID:            i + 1,
+			IssuerDID:     tree.issuerDID,
+			NodeCount:     tree.nodeCount,
+			NeedSync:      state.needSync,
+			NodeCountSync: state.nodeCountSync,
+		})
+	}
+	return trees, nil
+}
+
+func (m *MerkleMemory) MarkTreesForSync(ctx context.Context, chainID uint64, treeIDs []int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, treeID := range treeIDs {
+		if m.tree(treeID) == nil {
+			return fmt.Errorf("failed to mark trees for sync: tree ID %d does not exist", treeID)
+		}
+	}
+	for _, treeID := range treeIDs {
+		m.chain(treeID, chainID).needSync = true
+	}
+	return nil
+}
+
+func (m *MerkleMemory) CreatePendingSyncs(ctx context.Context, syncs []*entities.TreeSync) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, treeSync := range syncs {
+		if m.tree(treeSync.TreeID) == nil {
+			return fmt.Errorf("failed to create pending sync for tree ID %d: tree does not exist", treeSync.TreeID)
+		}
+	}
+	for _, treeSync := range syncs {
+		treeSync.ID = len(m.syncs) + 1
+		treeSync.Status = entities.SyncStatusPending
+		stored := *treeSync
+		stored.TxHash, stored.Nonce, stored.BlockNumber, stored.Error = "", 0, 0, ""
+		m.syncs = append(m.syncs, &stored)
+	}
+	return nil
+}
+
+func (m *MerkleMemory) SetSyncTransaction(ctx context.Context, syncIDs []int, txHash string, nonce uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, treeSync := range m.pendingSyncs(syncIDs) {
+		treeSync.TxHash = txHash
+		treeSync.Nonce = nonce
+	}
+	return nil
+}
+
+// pendingSyncs returns the stored pending syncs among the sync IDs
+func (m *MerkleMemory) pendingSyncs(syncIDs []int) []*entities.TreeSync {
+	var syncs []*entities.TreeSync
+	for _, syncID := range syncIDs {
+		if syncID <= 0 || syncID > len(m.syncs) {
+			continue
+		}
+		if treeSync := m.syncs[syncID-1]; treeSync.Status == entities.SyncStatusPending {
+			syncs = append(syncs, treeSync)
+		}
+	}
+	return syncs
+}
+
+func (m *MerkleMemory) GetPendingSyncs(ctx context.Context, chainID uint64) ([]*entities.TreeSync, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var syncs []*entities.TreeSync
+	for _, treeSync := range m.syncs {
+		if treeSync.ChainID == chainID && treeSync.Status == entities.SyncStatusPending {
+			copied := *treeSync
+			syncs = append(syncs, &copied)
+		}
+	}
+	return syncs, nil
+}
+
+func (m *MerkleMemory) ConfirmSyncs(ctx context.Context, chainID uint64, txHash string, blockNumber uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, treeSync := range m.syncs {
+		if treeSync.ChainID != chainID || treeSync.TxHash != txHash || treeSync.Status != entities.SyncStatusPending {
+			continue
+		}
+		treeSync.Status = entities.SyncStatusConfirmed
+		treeSync.BlockNumber = blockNumber
+
+		// The tree stays due for sync if nodes were added after the root was computed
+		state := m.chain(treeSync.TreeID, chainID)
+		state.nodeCountSync = max(state.nodeCountSync, treeSync.NodeCount)
+		state.needSync = false
+	}
+	return nil
+}
+
+func (m *MerkleMemory) FailSyncs(ctx context.Context, syncIDs []int, status string, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, treeSync := range m.pendingSyncs(syncIDs) {
+		treeSync.Status = status
+		treeSync.Error = reason
+	}
+	return nil
+}
+
+func (m *MerkleMemory) GetLastConfirmedSync(ctx context.Context, treeID int, chainID uint64) (*entities.TreeSync, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var last *entities.TreeSync
+	for _, treeSync := range m.syncs {
+		if treeSync.TreeID != treeID || treeSync.ChainID != chainID || treeSync.Status != entities.SyncStatusConfirmed {
+			continue
+		}
+		if last == nil || treeSync.NodeCount >= last.NodeCount {
+			last = treeSync
+		}
+	}
+	if last == nil {
+		return nil, nil
+	}
+	copied := *last
+	return &copied, nil
+}
+
+func (m *MerkleMemory) CreateCheckpoint(ctx context.Context, checkpoint *entities.Checkpoint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	checkpoint.ID = len(m.checkpoints) + 1
+	m.checkpoints = append(m.checkpoints, &entities.Checkpoint{
+		ID:            checkpoint.ID,
+		ChainID:       checkpoint.ChainID,
+		IssuerAddress: checkpoint.IssuerAddress,
+		Root:          checkpoint.Root,
+	})
+	return nil
+}
+
+func (m *MerkleMemory) GetCheckpoint(ctx context.Context, checkpointID int) (*entities.Checkpoint, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if checkpointID <= 0 || checkpointID > len(m.checkpoints) {
+		return nil, nil
+	}
+	checkpoint := *m.checkpoints[checkpointID-1]
+	for _, treeSync := range m.syncs {
+		if treeSync.CheckpointID == checkpointID {
+			copied := *treeSync
+			checkpoint.Syncs = append(checkpoint.Syncs, &copied)
+		}
+	}
+	sort.SliceStable(checkpoint.Syncs, func(i, j int) bool {
+		return checkpoint.Syncs[i].CheckpointPosition < checkpoint.Syncs[j].CheckpointPosition
+	})
+	return &checkpoint, nil
+}
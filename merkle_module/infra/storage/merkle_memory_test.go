@@ -0,0 +1,14 @@
+package storage
+
+import (
+	"testing"
+
+	"merkle_module/domain/repo"
+	"merkle_module/infra/storage/storagetest"
+)
+
+func TestMerkleMemory(t *testing.T) {
+	storagetest.TestMerkle(t, func(t *testing.T) repo.Merkle {
+		return NewMerkleMemory()
+	})
+}
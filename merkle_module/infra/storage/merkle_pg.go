@@ -9,6 +9,7 @@ import (
 	"merkle_module/domain/repo"
 	"merkle_module/infra/model"
 	"merkle_module/utils"
+	"time"
 
 	"github.com/lib/pq"
 )
@@ -21,7 +22,7 @@ func NewMerklePostgres(db *sql.DB) repo.Merkle {
 	return &MerklePostgres{db: db}
 }
 
-func (m *MerklePostgres) GetNodesByTreeID(ctx context.Context, treeID int) ([][]byte, error) {
+func (m *MerklePostgres) GetNodesByTreeID(ctx context.Context, treeID int) ([]*entities.MerkleNode, error) {
 	rows, err := m.db.QueryContext(ctx, `
 	SELECT node_id, data
 	FROM merkle_nodes
@@ -33,28 +34,59 @@ func (m *MerklePostgres) GetNodesByTreeID(ctx context.Context, treeID int) ([][]
 	}
 	defer rows.Close()
 
-	datas := make([][]byte, 0)
+	nodes := make([]*entities.MerkleNode, 0)
 	for rows.Next() {
 		var nodeID int
 		var nodeData []byte
 		if err := rows.Scan(&nodeID, &nodeData); err != nil {
 			return nil, fmt.Errorf("failed to scan row: %w", err)
 		}
-		datas = append(datas, nodeData)
+		nodes = append(nodes, &entities.MerkleNode{TreeID: treeID, NodeID: nodeID, Data: nodeData})
 	}
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("error iterating over rows: %w", err)
 	}
-	// fmt.Printf("Retrieved %d nodes for tree ID %d\n", len(datas), treeID)
-	return datas, nil
+	if len(nodes) == 0 {
+		// The nodes of a sealed tree are archived
+		return m.readArchive(ctx, treeID)
+	}
+	return nodes, nil
+}
+
+// readArchive returns the nodes of a sealed tree from its archive, none if it is not sealed
+func (m *MerklePostgres) readArchive(ctx context.Context, treeID int) ([]*entities.MerkleNode, error) {
+	var blob []byte
+	err := m.db.QueryRowContext(ctx, `
+	SELECT leaves FROM merkle_tree_seals WHERE tree_id = $1
+	`, treeID).Scan(&blob)
+	if err == sql.ErrNoRows {
+		return []*entities.MerkleNode{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query archive: %w", err)
+	}
+	return archivedNodes(treeID, blob, utils.MAX_LEAFS)
 }
 
 func (m *MerklePostgres) AddNode(ctx context.Context, treeID int, nodeID int, data []byte) (*entities.MerkleNode, error) {
 	// make a copy of the data
 	dataCopy := make([]byte, len(data))
 	copy(dataCopy, data)
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
 	// Insert the new node into the database
-	_, err := m.db.ExecContext(ctx, `
+	_, err = tx.ExecContext(ctx, `
 	INSERT INTO merkle_nodes (tree_id, node_id, data)
 	VALUES ($1, $2, $3)
 	`, treeID, nodeID, dataCopy)
@@ -62,6 +94,14 @@ func (m *MerklePostgres) AddNode(ctx context.Context, treeID int, nodeID int, da
 		return nil, fmt.Errorf("failed to insert merkle node: %w", err)
 	}
 
+	// The node is filled, its reservation is no longer reaped
+	_, err = tx.ExecContext(ctx, `
+	DELETE FROM merkle_node_reservations WHERE tree_id = $1 AND node_id = $2
+	`, treeID, nodeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to release node reservation: %w", err)
+	}
+
 	// Return the new node
 	return &entities.MerkleNode{
 		TreeID: treeID,
@@ -70,6 +110,19 @@ func (m *MerklePostgres) AddNode(ctx context.Context, treeID int, nodeID int, da
 	}, nil
 }
 
+func (m *MerklePostgres) CountNodes(ctx context.Context, treeID int) (int, int, error) {
+	var count, last int
+	err := m.db.QueryRowContext(ctx, `
+	SELECT COUNT(*), COALESCE(MAX(node_id), 0)
+	FROM merkle_nodes
+	WHERE tree_id = $1
+	`, treeID).Scan(&count, &last)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to count merkle nodes: %w", err)
+	}
+	return count, last, nil
+}
+
 func (m *MerklePostgres) GetActiveTreeForInserting(ctx context.Context, issuerDID string) (*model.ActiveTree, error) {
 	// Begin a transaction
 	tx, err := m.db.BeginTx(ctx, nil)
@@ -110,7 +163,8 @@ func (m *MerklePostgres) GetActiveTreeForInserting(ctx context.Context, issuerDI
 		err = tx.QueryRowContext(ctx, `
 		UPDATE merkle_trees 
 		SET node_count = node_count + 1,
-			need_sync = TRUE
+			need_sync = TRUE,
+			updated_at = NOW()
 		WHERE id = $1 
 		RETURNING node_count
 		`, treeID).Scan(&nodeID)
@@ -119,6 +173,16 @@ func (m *MerklePostgres) GetActiveTreeForInserting(ctx context.Context, issuerDI
 		}
 	}
 
+	// Reserve the node until AddNode fills it, see ReapReservations
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO merkle_node_reservations (tree_id, node_id, expires_at)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (tree_id, node_id) DO UPDATE SET expires_at = EXCLUDED.expires_at
+	`, treeID, nodeID, reservationExpiry())
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve node: %w", err)
+	}
+
 	fmt.Printf("Retrieved nodes for tree ID %d, node ID %d\n", treeID, nodeID)
 
 	// Get the nodes for the active tree
@@ -150,8 +214,21 @@ func (m *MerklePostgres) GetActiveTreeForInserting(ctx context.Context, issuerDI
 }
 
 func (m *MerklePostgres) AddNodeAndIncrementNodeCount(ctx context.Context, treeID int, nodeID int, data []byte) (*entities.MerkleNode, error) {
+	// The node is only counted with its data, a failure leaves no unfilled node behind
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
 	// Insert the new node into the database
-	_, err := m.db.ExecContext(ctx, `
+	_, err = tx.ExecContext(ctx, `
 	INSERT INTO merkle_nodes (tree_id, node_id, data)
 	VALUES ($1, $2, $3)
 	`, treeID, nodeID, data)
@@ -160,10 +237,11 @@ func (m *MerklePostgres) AddNodeAndIncrementNodeCount(ctx context.Context, treeI
 	}
 
 	// Increment the node count for the tree with lock
-	_, err = m.db.ExecContext(ctx, `
+	_, err = tx.ExecContext(ctx, `
 	UPDATE merkle_trees
 	SET node_count = node_count + 1,
-		need_sync = TRUE
+		need_sync = TRUE,
+		updated_at = NOW()
 	WHERE id = $1
 	`, treeID)
 	if err != nil {
@@ -177,26 +255,425 @@ func (m *MerklePostgres) AddNodeAndIncrementNodeCount(ctx context.Context, treeI
 	}, nil
 }
 
-func (m *MerklePostgres) GetTreesWithNodesForSync(ctx context.Context) ([]*model.MerkleTreeWithNodes, error) {
-	// Update and get the tree IDs that need to be synced
-	var treeIDs []int64
+func (m *MerklePostgres) SaveNodeHashes(ctx context.Context, hashes []*entities.NodeHash) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	treeIDs := make([]int, len(hashes))
+	indexes := make([]int, len(hashes))
+	sizes := make([]int, len(hashes))
+	datas := make([][]byte, len(hashes))
+	for i, hash := range hashes {
+		treeIDs[i], indexes[i], sizes[i], datas[i] = hash.TreeID, hash.Index, hash.Size, hash.Hash
+	}
+
+	_, err := m.db.ExecContext(ctx, `
+	INSERT INTO merkle_node_hashes (tree_id, node_index, size, hash)
+	SELECT * FROM UNNEST($1::INT[], $2::INT[], $3::INT[], $4::BYTEA[])
+	ON CONFLICT (tree_id, node_index, size) DO UPDATE SET hash = EXCLUDED.hash
+	`, pq.Array(treeIDs), pq.Array(indexes), pq.Array(sizes), pq.Array(datas))
+	if err != nil {
+		return fmt.Errorf("failed to save node hashes: %w", err)
+	}
+	return nil
+}
+
+func (m *MerklePostgres) GetNodeHashes(ctx context.Context, treeID int, size int, indexes []int) ([]*entities.NodeHash, error) {
+	// One index scan per node on the primary key
+	rows, err := m.db.QueryContext(ctx, `
+	SELECT DISTINCT ON (node_index) node_index, size, hash
+	FROM merkle_node_hashes
+	WHERE tree_id = $1 AND node_index = ANY($2) AND size <= $3
+	ORDER BY node_index, size DESC
+	`, treeID, pq.Array(indexes), size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query node hashes: %w", err)
+	}
+	defer rows.Close()
+
+	return scanNodeHashes(rows, treeID)
+}
+
+// scanNodeHashes scans rows of node_index, size and hash of a tree
+func scanNodeHashes(rows *sql.Rows, treeID int) ([]*entities.NodeHash, error) {
+	var hashes []*entities.NodeHash
+	for rows.Next() {
+		hash := &entities.NodeHash{TreeID: treeID}
+		if err := rows.Scan(&hash.Index, &hash.Size, &hash.Hash); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		hashes = append(hashes, hash)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+	return hashes, nil
+}
+
+func (m *MerklePostgres) AddTreeRoots(ctx context.Context, roots []*entities.TreeRoot) error {
+	if len(roots) == 0 {
+		return nil
+	}
+
+	treeIDs := make([]int, len(roots))
+	sizes := make([]int, len(roots))
+	datas := make([][]byte, len(roots))
+	for i, root := range roots {
+		treeIDs[i], sizes[i], datas[i] = root.TreeID, root.Size, root.Root
+	}
+
+	_, err := m.db.ExecContext(ctx, `
+	INSERT INTO tree_roots (tree_id, size, root)
+	SELECT * FROM UNNEST($1::INT[], $2::INT[], $3::BYTEA[])
+	ON CONFLICT (tree_id, size) DO NOTHING
+	`, pq.Array(treeIDs), pq.Array(sizes), pq.Array(datas))
+	if err != nil {
+		return fmt.Errorf("failed to add tree roots: %w", err)
+	}
+	return nil
+}
+
+func (m *MerklePostgres) GetTreeRoot(ctx context.Context, treeID int, size int) (*entities.TreeRoot, error) {
+	root := &entities.TreeRoot{TreeID: treeID, Size: size}
+	err := m.db.QueryRowContext(ctx, `
+	SELECT root FROM tree_roots WHERE tree_id = $1 AND size = $2
+	`, treeID, size).Scan(&root.Root)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tree root: %w", err)
+	}
+	return root, nil
+}
+
+func (m *MerklePostgres) GetTreeRoots(ctx context.Context, treeID int) ([]*entities.TreeRoot, error) {
+	rows, err := m.db.QueryContext(ctx, `
+	SELECT size, root FROM tree_roots WHERE tree_id = $1 ORDER BY size
+	`, treeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tree roots: %w", err)
+	}
+	defer rows.Close()
+
+	return scanTreeRoots(rows, treeID)
+}
+
+// scanTreeRoots scans rows of size and root of a tree
+func scanTreeRoots(rows *sql.Rows, treeID int) ([]*entities.TreeRoot, error) {
+	var roots []*entities.TreeRoot
+	for rows.Next() {
+		root := &entities.TreeRoot{TreeID: treeID}
+		if err := rows.Scan(&root.Size, &root.Root); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		roots = append(roots, root)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+	return roots, nil
+}
+
+func (m *MerklePostgres) GetTreesToSeal(ctx context.Context, chainIDs []uint64) ([]*entities.MerkleTree, error) {
+	chainIDs = distinctChains(chainIDs)
+	if len(chainIDs) == 0 {
+		return nil, nil
+	}
+
+	// Every node is filled and confirmed on every chain, and no sync is pending
 	rows, err := m.db.QueryContext(ctx, `
+	SELECT mt.id, mt.issuer_did, mt.node_count
+	FROM merkle_trees mt
+	WHERE mt.node_count = $1 AND mt.sealed_at IS NULL
+	AND (SELECT COUNT(*) FROM merkle_nodes mn WHERE mn.tree_id = mt.id) = $1
+	AND (
+		SELECT COUNT(*) FROM merkle_tree_chains c
+		WHERE c.tree_id = mt.id AND c.chain_id = ANY($2) AND c.node_count_sync = $1 AND NOT c.need_sync
+	) = $3
+	AND NOT EXISTS (
+		SELECT 1 FROM merkle_tree_syncs s
+		WHERE s.tree_id = mt.id AND s.status = 'pending'
+	)
+	ORDER BY mt.id
+	`, utils.MAX_LEAFS, pq.Array(chainIDs), len(chainIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query trees to seal: %w", err)
+	}
+	defer rows.Close()
+
+	var trees []*entities.MerkleTree
+	for rows.Next() {
+		tree := &entities.MerkleTree{}
+		if err := rows.Scan(&tree.ID, &tree.IssuerDID, &tree.NodeCount); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		trees = append(trees, tree)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+	return trees, nil
+}
+
+func (m *MerklePostgres) SealTree(ctx context.Context, seal *entities.TreeSeal) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	// Lock the tree so that it is sealed once
+	var sealedAt sql.NullTime
+	err = tx.QueryRowContext(ctx, `
+	SELECT sealed_at FROM merkle_trees WHERE id = $1 FOR UPDATE
+	`, seal.TreeID).Scan(&sealedAt)
+	if err == sql.ErrNoRows {
+		err = fmt.Errorf("tree ID %d does not exist", seal.TreeID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to lock tree: %w", err)
+	}
+	if sealedAt.Valid {
+		err = fmt.Errorf("tree ID %d is already sealed", seal.TreeID)
+		return err
+	}
+
+	var leaves [][]byte
+	leaves, err = sealNodes(ctx, tx, seal.TreeID, `
+	SELECT node_id, data FROM merkle_nodes WHERE tree_id = $1 ORDER BY node_id
+	`)
+	if err != nil {
+		return err
+	}
+
+	var at time.Time
+	err = tx.QueryRowContext(ctx, `
+	INSERT INTO merkle_tree_seals (tree_id, root, chain_id, tx_hash, leaves)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING sealed_at
+	`, seal.TreeID, seal.Root, seal.ChainID, seal.TxHash, packLeaves(leaves)).Scan(&at)
+	if err != nil {
+		return fmt.Errorf("failed to record seal: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+	UPDATE merkle_trees SET sealed_at = $2, updated_at = NOW() WHERE id = $1
+	`, seal.TreeID, at)
+	if err != nil {
+		return fmt.Errorf("failed to mark tree sealed: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM merkle_nodes WHERE tree_id = $1`, seal.TreeID)
+	if err != nil {
+		return fmt.Errorf("failed to archive nodes: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM merkle_node_hashes WHERE tree_id = $1`, seal.TreeID)
+	if err != nil {
+		return fmt.Errorf("failed to drop node hashes: %w", err)
+	}
+
+	seal.SealedAt = at
+	return nil
+}
+
+func (m *MerklePostgres) GetTreeSeal(ctx context.Context, treeID int) (*entities.TreeSeal, error) {
+	return getTreeSeal(ctx, m.db, treeID, `
+	SELECT root, chain_id, tx_hash, sealed_at, leaves FROM merkle_tree_seals WHERE tree_id = $1
+	`)
+}
+
+func (m *MerklePostgres) SetNodeCount(ctx context.Context, treeID, observed, nodeCount int, idle time.Duration) (bool, error) {
+	result, err := m.db.ExecContext(ctx, `
 	UPDATE merkle_trees
-	SET need_sync = FALSE
-	WHERE need_sync = TRUE
-	RETURNING id
+	SET node_count = $3,
+		need_sync = TRUE,
+		updated_at = NOW()
+	WHERE id = $1 AND node_count = $2 AND sealed_at IS NULL
+		AND updated_at <= NOW() - make_interval(secs => $4)
+		AND NOT EXISTS (SELECT 1 FROM merkle_nodes WHERE tree_id = $1 AND node_id > $3)
+	`, treeID, observed, nodeCount, idle.Seconds())
+	if err != nil {
+		return false, fmt.Errorf("failed to set node count: %w", err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get updated rows: %w", err)
+	}
+	return updated == 1, nil
+}
+
+func (m *MerklePostgres) ReapReservations(ctx context.Context, now time.Time, leaf []byte) ([]*entities.MerkleNode, error) {
+	rows, err := m.db.QueryContext(ctx, `
+	WITH expired AS (
+		DELETE FROM merkle_node_reservations
+		WHERE expires_at <= $1
+		RETURNING tree_id, node_id
+	)
+	INSERT INTO merkle_nodes (tree_id, node_id, data)
+	SELECT e.tree_id, e.node_id, $2
+	FROM expired e
+	JOIN merkle_trees mt ON mt.id = e.tree_id
+	WHERE e.node_id <= mt.node_count AND mt.sealed_at IS NULL
+	ORDER BY e.tree_id, e.node_id
+	ON CONFLICT (tree_id, node_id) DO NOTHING
+	RETURNING tree_id, node_id
+	`, now.UTC(), leaf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reap node reservations: %w", err)
+	}
+	defer rows.Close()
+
+	return scanReapedNodes(rows, leaf)
+}
+
+func (m *MerklePostgres) GetIssuers(ctx context.Context) ([]*entities.IssuerTrees, error) {
+	rows, err := m.db.QueryContext(ctx, `
+	SELECT issuer_did, COUNT(*), SUM(node_count), MAX(id)
+	FROM merkle_trees
+	GROUP BY issuer_did
+	ORDER BY issuer_did
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query issuers: %w", err)
+	}
+	defer rows.Close()
+
+	var issuers []*entities.IssuerTrees
+	for rows.Next() {
+		issuer := &entities.IssuerTrees{}
+		if err := rows.Scan(&issuer.IssuerDID, &issuer.Trees, &issuer.NodeCount, &issuer.LastTreeID); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		issuers = append(issuers, issuer)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+	return issuers, nil
+}
+
+func (m *MerklePostgres) GetIssuerTrees(ctx context.Context, issuerDID string) ([]*entities.MerkleTree, error) {
+	rows, err := m.db.QueryContext(ctx, `
+	SELECT id, issuer_did, node_count
+	FROM merkle_trees
+	WHERE issuer_did = $1
+	ORDER BY id
+	`, issuerDID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query issuer trees: %w", err)
+	}
+	defer rows.Close()
+
+	var trees []*entities.MerkleTree
+	for rows.Next() {
+		tree := &entities.MerkleTree{}
+		if err := rows.Scan(&tree.ID, &tree.IssuerDID, &tree.NodeCount); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		trees = append(trees, tree)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+	return trees, nil
+}
+
+var pgSnapshotQueries = snapshotQueries{
+	tree:   `SELECT issuer_did, node_count FROM merkle_trees WHERE id = $1`,
+	nodes:  `SELECT node_id, data FROM merkle_nodes WHERE tree_id = $1 ORDER BY node_id`,
+	roots:  `SELECT size, root FROM tree_roots WHERE tree_id = $1 ORDER BY size`,
+	chains: `SELECT chain_id, need_sync, node_count_sync FROM merkle_tree_chains WHERE tree_id = $1 ORDER BY chain_id`,
+	syncs:  `SELECT ` + treeSyncColumns + ` FROM merkle_tree_syncs WHERE tree_id = $1 AND status = 'confirmed' ORDER BY id`,
+	seal:   `SELECT root, chain_id, tx_hash, sealed_at, leaves FROM merkle_tree_seals WHERE tree_id = $1`,
+}
+
+func (m *MerklePostgres) GetTreeSnapshot(ctx context.Context, treeID int) (*entities.TreeSnapshot, error) {
+	// A repeatable read sees the tables of the tree as of a single point in time
+	tx, err := m.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	return readSnapshot(ctx, tx, treeID, pgSnapshotQueries)
+}
+
+var pgImportStatements = importStatements{
+	exists: `SELECT 1 FROM merkle_trees WHERE id = $1`,
+	tree:   `INSERT INTO merkle_trees (id, issuer_did, node_count) VALUES ($1, $2, $3)`,
+	node:   `INSERT INTO merkle_nodes (tree_id, node_id, data) VALUES ($1, $2, $3)`,
+	root:   `INSERT INTO tree_roots (tree_id, size, root) VALUES ($1, $2, $3)`,
+	chain:  `INSERT INTO merkle_tree_chains (tree_id, chain_id, need_sync, node_count_sync) VALUES ($1, $2, $3, $4)`,
+	sync: `
+	INSERT INTO merkle_tree_syncs (tree_id, chain_id, node_count, root, status, tx_hash, nonce, block_number, error, issuer_address, checkpoint_id, checkpoint_position)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	`,
+	seal:   `INSERT INTO merkle_tree_seals (tree_id, root, chain_id, tx_hash, leaves, sealed_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+	sealed: `UPDATE merkle_trees SET sealed_at = $1 WHERE id = $2`,
+}
+
+func (m *MerklePostgres) ImportTrees(ctx context.Context, snapshots []*entities.TreeSnapshot) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	if err = importTrees(ctx, tx, snapshots, pgImportStatements); err != nil {
+		return fmt.Errorf("failed to import trees: %w", err)
+	}
+
+	// The trees created next get IDs after the imported ones
+	_, err = tx.ExecContext(ctx, `
+	SELECT setval(pg_get_serial_sequence('merkle_trees', 'id'), GREATEST((SELECT MAX(id) FROM merkle_trees), 1))
 	`)
 	if err != nil {
-		return nil, fmt.Errorf("failed to update and get tree IDs for sync: %w", err)
+		return fmt.Errorf("failed to advance tree IDs: %w", err)
+	}
+	return nil
+}
+
+func (m *MerklePostgres) GetTreesWithNodesForSync(ctx context.Context, chainID uint64) ([]*model.MerkleTreeWithNodes, error) {
+	// Get the tree IDs that need to be synced to the chain: nodes were added since the last
+	// confirmed sync there, or the tree was marked again, and no sync is pending on it
+	var treeIDs []int64
+	states := make(map[int]*entities.MerkleTree)
+	rows, err := m.db.QueryContext(ctx, `
+	SELECT mt.id, COALESCE(c.need_sync, FALSE), COALESCE(c.node_count_sync, 0)
+	FROM merkle_trees mt
+	LEFT JOIN merkle_tree_chains c ON c.tree_id = mt.id AND c.chain_id = $1
+	WHERE (mt.node_count > COALESCE(c.node_count_sync, 0) OR COALESCE(c.need_sync, FALSE))
+	AND NOT EXISTS (
+		SELECT 1 FROM merkle_tree_syncs s
+		WHERE s.tree_id = mt.id AND s.chain_id = $1 AND s.status = 'pending'
+	)
+	ORDER BY mt.id
+	`, chainID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tree IDs for sync: %w", err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
 		var id int64
-		if err := rows.Scan(&id); err != nil {
+		state := &entities.MerkleTree{}
+		if err := rows.Scan(&id, &state.NeedSync, &state.NodeCountSync); err != nil {
 			return nil, fmt.Errorf("failed to scan tree ID: %w", err)
 		}
 		treeIDs = append(treeIDs, id)
+		states[int(id)] = state
 	}
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("rows error after scanning: %w", err)
@@ -208,7 +685,7 @@ func (m *MerklePostgres) GetTreesWithNodesForSync(ctx context.Context) ([]*model
 
 	// Get the nodes for the trees that need to be synced
 	nodeRows, err := m.db.QueryContext(ctx, `
-	SELECT mt.id AS tree_id, mn.node_id, mn.data
+	SELECT mt.id AS tree_id, mt.issuer_did, mn.node_id, mn.data
 	FROM merkle_nodes mn
 	JOIN merkle_trees mt ON mn.tree_id = mt.id
 	WHERE mt.id = ANY($1) AND mn.node_id <= mt.node_count
@@ -224,8 +701,9 @@ func (m *MerklePostgres) GetTreesWithNodesForSync(ctx context.Context) ([]*model
 	var result []*model.MerkleTreeWithNodes
 	for nodeRows.Next() {
 		var treeID, nodeID int
+		var issuerDID string
 		var nodeData []byte
-		if err := nodeRows.Scan(&treeID, &nodeID, &nodeData); err != nil {
+		if err := nodeRows.Scan(&treeID, &issuerDID, &nodeID, &nodeData); err != nil {
 			return nil, fmt.Errorf("failed to scan row: %w", err)
 		}
 
@@ -238,8 +716,11 @@ func (m *MerklePostgres) GetTreesWithNodesForSync(ctx context.Context) ([]*model
 			currentTreeID = treeID
 			currentTree = &model.MerkleTreeWithNodes{
 				Tree: &entities.MerkleTree{
-					ID:        treeID,
-					NodeCount: 0,
+					ID:            treeID,
+					IssuerDID:     issuerDID,
+					NodeCount:     0,
+					NeedSync:      states[treeID].NeedSync,
+					NodeCountSync: states[treeID].NodeCountSync,
 				},
 				Nodes: []*entities.MerkleNode{},
 			}
@@ -260,36 +741,34 @@ func (m *MerklePostgres) GetTreesWithNodesForSync(ctx context.Context) ([]*model
 		return nil, fmt.Errorf("error iterating over nodeRows: %w", err)
 	}
 
-	// Update the node_count_sync for each tree using
-	stmt, err := m.db.PrepareContext(ctx, `
-	UPDATE merkle_trees
-	SET node_count_sync = $1
-	WHERE id = $2
-	`)
+	// The nodes of sealed trees are archived, these trees are only due when marked again
+	archived, err := m.db.QueryContext(ctx, `
+	SELECT mt.id, mt.issuer_did, mt.node_count, s.leaves
+	FROM merkle_trees mt
+	JOIN merkle_tree_seals s ON s.tree_id = mt.id
+	WHERE mt.id = ANY($1)
+	`, pq.Array(treeIDs))
 	if err != nil {
-		return nil, fmt.Errorf("failed to prepare update statement: %w", err)
+		return nil, fmt.Errorf("failed to query archives of trees: %w", err)
 	}
-	defer stmt.Close()
-
-	for _, tree := range result {
-		_, err := stmt.ExecContext(ctx, len(tree.Nodes), tree.Tree.ID)
-		if err != nil {
-			return nil, fmt.Errorf("failed to update node_count_sync for tree ID %d: %w", tree.Tree.ID, err)
-		}
+	defer archived.Close()
+	result, err = appendArchivedTrees(result, archived)
+	if err != nil {
+		return nil, err
 	}
 
 	return result, nil
 }
 
-func (m *MerklePostgres) GetNodesSyncedByTreeID(ctx context.Context, treeID int) ([]*entities.MerkleNode, error) {
-	// Get the nodes synced by tree ID
+func (m *MerklePostgres) GetNodesSyncedByTreeID(ctx context.Context, treeID int, chainID uint64) ([]*entities.MerkleNode, error) {
+	// Get the nodes synced by tree ID to the chain
 	rows, err := m.db.QueryContext(ctx, `
 	SELECT node_id, data
 	FROM merkle_nodes mn
-	JOIN merkle_trees mt ON mn.tree_id = mt.id
-	WHERE mn.tree_id = $1 and mn.node_id <= mt.node_count_sync
+	JOIN merkle_tree_chains c ON mn.tree_id = c.tree_id AND c.chain_id = $2
+	WHERE mn.tree_id = $1 and mn.node_id <= c.node_count_sync
 	ORDER BY mn.node_id
-	`, treeID)
+	`, treeID, chainID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query merkle nodes by tree ID: %w", err)
 	}
@@ -313,5 +792,334 @@ func (m *MerklePostgres) GetNodesSyncedByTreeID(ctx context.Context, treeID int)
 		return nil, fmt.Errorf("error iterating over rows: %w", err)
 	}
 
+	if len(nodes) == 0 {
+		// The nodes of a sealed tree are archived
+		var blob []byte
+		var nodeCountSync int
+		err := m.db.QueryRowContext(ctx, `
+		SELECT s.leaves, c.node_count_sync
+		FROM merkle_tree_seals s
+		JOIN merkle_tree_chains c ON c.tree_id = s.tree_id AND c.chain_id = $2
+		WHERE s.tree_id = $1
+		`, treeID, chainID).Scan(&blob, &nodeCountSync)
+		if err == sql.ErrNoRows {
+			return nodes, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to query archive: %w", err)
+		}
+		return archivedNodes(treeID, blob, nodeCountSync)
+	}
+
 	return nodes, nil
 }
+
+func (m *MerklePostgres) GetSyncedTrees(ctx context.Context, chainID uint64) ([]*entities.MerkleTree, error) {
+	rows, err := m.db.QueryContext(ctx, `
+	SELECT mt.id, mt.issuer_did, mt.node_count, c.need_sync, c.node_count_sync
+	FROM merkle_trees mt
+	JOIN merkle_tree_chains c ON c.tree_id = mt.id AND c.chain_id = $1
+	WHERE c.node_count_sync > 0
+	ORDER BY mt.id
+	`, chainID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query synced trees: %w", err)
+	}
+	defer rows.Close()
+
+	var trees []*entities.MerkleTree
+	for rows.Next() {
+		var tree entities.MerkleTree
+		if err := rows.Scan(&tree.ID, &tree.IssuerDID, &tree.NodeCount, &tree.NeedSync, &tree.NodeCountSync); err != nil {
+			return nil, fmt.Errorf("failed to scan tree: %w", err)
+		}
+		trees = append(trees, &tree)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	return trees, nil
+}
+
+func (m *MerklePostgres) MarkTreesForSync(ctx context.Context, chainID uint64, treeIDs []int) error {
+	if len(treeIDs) == 0 {
+		return nil
+	}
+
+	_, err := m.db.ExecContext(ctx, `
+	INSERT INTO merkle_tree_chains (tree_id, chain_id, need_sync)
+	SELECT UNNEST($1::INT[]), $2, TRUE
+	ON CONFLICT (tree_id, chain_id) DO UPDATE SET need_sync = TRUE, updated_at = NOW()
+	`, pq.Array(treeIDs), chainID)
+	if err != nil {
+		return fmt.Errorf("failed to mark trees for sync: %w", err)
+	}
+	return nil
+}
+
+func (m *MerklePostgres) CreatePendingSyncs(ctx context.Context, syncs []*entities.TreeSync) error {
+	// Begin a transaction
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	for _, treeSync := range syncs {
+		err = tx.QueryRowContext(ctx, `
+		INSERT INTO merkle_tree_syncs (tree_id, chain_id, node_count, root, status, issuer_address, checkpoint_id, checkpoint_position)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id
+		`, treeSync.TreeID, treeSync.ChainID, treeSync.NodeCount, treeSync.Root, entities.SyncStatusPending, treeSync.IssuerAddress, treeSync.CheckpointID, treeSync.CheckpointPosition).Scan(&treeSync.ID)
+		if err != nil {
+			return fmt.Errorf("failed to create pending sync for tree ID %d: %w", treeSync.TreeID, err)
+		}
+		treeSync.Status = entities.SyncStatusPending
+	}
+
+	return nil
+}
+
+func (m *MerklePostgres) SetSyncTransaction(ctx context.Context, syncIDs []int, txHash string, nonce uint64) error {
+	_, err := m.db.ExecContext(ctx, `
+	UPDATE merkle_tree_syncs
+	SET tx_hash = $1,
+		nonce = $2,
+		updated_at = NOW()
+	WHERE id = ANY($3) AND status = 'pending'
+	`, txHash, nonce, pq.Array(syncIDs))
+	if err != nil {
+		return fmt.Errorf("failed to set sync transaction: %w", err)
+	}
+	return nil
+}
+
+func (m *MerklePostgres) GetPendingSyncs(ctx context.Context, chainID uint64) ([]*entities.TreeSync, error) {
+	rows, err := m.db.QueryContext(ctx, `
+	SELECT `+treeSyncColumns+`
+	FROM merkle_tree_syncs
+	WHERE chain_id = $1 AND status = 'pending'
+	ORDER BY id
+	`, chainID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pending syncs: %w", err)
+	}
+	defer rows.Close()
+
+	var syncs []*entities.TreeSync
+	for rows.Next() {
+		treeSync, err := scanTreeSync(rows)
+		if err != nil {
+			return nil, err
+		}
+		syncs = append(syncs, treeSync)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	return syncs, nil
+}
+
+func (m *MerklePostgres) ConfirmSyncs(ctx context.Context, chainID uint64, txHash string, blockNumber uint64) error {
+	// Begin a transaction
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	rows, err := tx.QueryContext(ctx, `
+	UPDATE merkle_tree_syncs
+	SET status = 'confirmed',
+		block_number = $1,
+		updated_at = NOW()
+	WHERE chain_id = $2 AND tx_hash = $3 AND status = 'pending'
+	RETURNING tree_id, node_count
+	`, blockNumber, chainID, txHash)
+	if err != nil {
+		return fmt.Errorf("failed to confirm syncs: %w", err)
+	}
+
+	confirmed := make(map[int]int)
+	for rows.Next() {
+		var treeID, nodeCount int
+		if err = rows.Scan(&treeID, &nodeCount); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan confirmed sync: %w", err)
+		}
+		confirmed[treeID] = nodeCount
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	// The tree stays due for sync if nodes were added after the root was computed
+	for treeID, nodeCount := range confirmed {
+		_, err = tx.ExecContext(ctx, `
+		INSERT INTO merkle_tree_chains (tree_id, chain_id, need_sync, node_count_sync, synced_at)
+		VALUES ($1, $2, FALSE, $3, NOW())
+		ON CONFLICT (tree_id, chain_id) DO UPDATE
+		SET node_count_sync = GREATEST(merkle_tree_chains.node_count_sync, EXCLUDED.node_count_sync),
+			need_sync = FALSE,
+			synced_at = NOW(),
+			updated_at = NOW()
+		`, treeID, chainID, nodeCount)
+		if err != nil {
+			return fmt.Errorf("failed to update node_count_sync for tree ID %d: %w", treeID, err)
+		}
+	}
+
+	return nil
+}
+
+func (m *MerklePostgres) FailSyncs(ctx context.Context, syncIDs []int, status string, reason string) error {
+	_, err := m.db.ExecContext(ctx, `
+	UPDATE merkle_tree_syncs
+	SET status = $1,
+		error = $2,
+		updated_at = NOW()
+	WHERE id = ANY($3) AND status = 'pending'
+	`, status, reason, pq.Array(syncIDs))
+	if err != nil {
+		return fmt.Errorf("failed to close pending syncs: %w", err)
+	}
+	return nil
+}
+
+func (m *MerklePostgres) GetLastConfirmedSync(ctx context.Context, treeID int, chainID uint64) (*entities.TreeSync, error) {
+	treeSync, err := scanTreeSync(m.db.QueryRowContext(ctx, `
+	SELECT `+treeSyncColumns+`
+	FROM merkle_tree_syncs
+	WHERE tree_id = $1 AND chain_id = $2 AND status = 'confirmed'
+	ORDER BY node_count DESC, id DESC
+	LIMIT 1
+	`, treeID, chainID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last confirmed sync: %w", err)
+	}
+	return treeSync, nil
+}
+
+func (m *MerklePostgres) CreateCheckpoint(ctx context.Context, checkpoint *entities.Checkpoint) error {
+	err := m.db.QueryRowContext(ctx, `
+	INSERT INTO merkle_checkpoints (chain_id, issuer_address, root)
+	VALUES ($1, $2, $3)
+	RETURNING id
+	`, checkpoint.ChainID, checkpoint.IssuerAddress, checkpoint.Root).Scan(&checkpoint.ID)
+	if err != nil {
+		return fmt.Errorf("failed to create checkpoint: %w", err)
+	}
+	return nil
+}
+
+func (m *MerklePostgres) GetCheckpoint(ctx context.Context, checkpointID int) (*entities.Checkpoint, error) {
+	checkpoint := &entities.Checkpoint{ID: checkpointID}
+	err := m.db.QueryRowContext(ctx, `
+	SELECT chain_id, issuer_address, root
+	FROM merkle_checkpoints
+	WHERE id = $1
+	`, checkpointID).Scan(&checkpoint.ChainID, &checkpoint.IssuerAddress, &checkpoint.Root)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get checkpoint: %w", err)
+	}
+
+	rows, err := m.db.QueryContext(ctx, `
+	SELECT `+treeSyncColumns+`
+	FROM merkle_tree_syncs
+	WHERE checkpoint_id = $1
+	ORDER BY checkpoint_position
+	`, checkpointID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query checkpoint syncs: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		treeSync, err := scanTreeSync(rows)
+		if err != nil {
+			return nil, err
+		}
+		checkpoint.Syncs = append(checkpoint.Syncs, treeSync)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	return checkpoint, nil
+}
+
+// treeSyncColumns are the columns read by scanTreeSync
+const treeSyncColumns = "id, tree_id, chain_id, node_count, root, status, tx_hash, nonce, block_number, error, issuer_address, checkpoint_id, checkpoint_position"
+
+// scanTreeSync returns sql.ErrNoRows unwrapped so callers can tell a missing sync apart
+func scanTreeSync(row rowScanner) (*entities.TreeSync, error) {
+	var treeSync entities.TreeSync
+	err := row.Scan(&treeSync.ID, &treeSync.TreeID, &treeSync.ChainID, &treeSync.NodeCount, &treeSync.Root, &treeSync.Status, &treeSync.TxHash, &treeSync.Nonce, &treeSync.BlockNumber, &treeSync.Error, &treeSync.IssuerAddress, &treeSync.CheckpointID, &treeSync.CheckpointPosition)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan tree sync: %w", err)
+	}
+	return &treeSync, nil
+}
+
+// AdoptLegacySyncState assigns the sync state kept before roots were anchored to several chains,
+// node_count_sync of merkle_trees and the syncs without a chain, to the given chain. It is
+// idempotent and meant to be run at startup for the chain the deployment used to sync to.
+func AdoptLegacySyncState(ctx context.Context, db *sql.DB, chainID uint64) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO merkle_tree_chains (tree_id, chain_id, need_sync, node_count_sync)
+	SELECT id, $1, need_sync AND node_count <= node_count_sync, node_count_sync
+	FROM merkle_trees
+	WHERE node_count_sync > 0
+	ON CONFLICT (tree_id, chain_id) DO NOTHING
+	`, chainID)
+	if err != nil {
+		return fmt.Errorf("failed to adopt legacy tree sync state: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	UPDATE merkle_tree_syncs
+	SET chain_id = $1
+	WHERE chain_id = 0
+	`, chainID)
+	if err != nil {
+		return fmt.Errorf("failed to adopt legacy syncs: %w", err)
+	}
+
+	return nil
+}
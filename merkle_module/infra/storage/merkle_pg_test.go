@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"testing"
+
+	"merkle_module/domain/repo"
+	"merkle_module/infra/migrations"
+	"merkle_module/infra/storage/storagetest"
+)
+
+// openTestPostgres opens the database of TEST_DATABASE_URL after migrating it, the test is
+// skipped when the variable is not set
+func openTestPostgres(t *testing.T) *sql.DB {
+	t.Helper()
+	connStr := os.Getenv("TEST_DATABASE_URL")
+	if connStr == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	migrator, err := migrations.New(db, migrations.Postgres)
+	if err != nil {
+		t.Fatalf("Failed to load migrations: %v", err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+	return db
+}
+
+func TestMerklePostgres(t *testing.T) {
+	db := openTestPostgres(t)
+	storagetest.TestMerkle(t, func(t *testing.T) repo.Merkle {
+		return NewMerklePostgres(db)
+	})
+}
+
+func TestAdoptLegacySyncState(t *testing.T) {
+	db := openTestPostgres(t)
+	ctx := context.Background()
+	chainID := rand.Uint64N(1<<62) + 1
+	issuerDID := fmt.Sprintf("did:example:%s:%d", t.Name(), rand.Uint64())
+
+	// trees synced before the chains: up to date but marked again, behind, and never synced
+	legacyTree := func(nodeCount, nodeCountSync int) int {
+		t.Helper()
+		var treeID int
+		err := db.QueryRowContext(ctx, `
+		INSERT INTO merkle_trees (issuer_did, node_count, need_sync, node_count_sync)
+		VALUES ($1, $2, TRUE, $3) RETURNING id
+		`, issuerDID, nodeCount, nodeCountSync).Scan(&treeID)
+		if err != nil {
+			t.Fatalf("Failed to insert tree: %v", err)
+		}
+		return treeID
+	}
+	marked, behind, unsynced := legacyTree(3, 3), legacyTree(5, 3), legacyTree(2, 0)
+	var syncID int
+	err := db.QueryRowContext(ctx, `
+	INSERT INTO merkle_tree_syncs (tree_id, node_count, root, status)
+	VALUES ($1, 3, '\x01', 'confirmed') RETURNING id
+	`, behind).Scan(&syncID)
+	if err != nil {
+		t.Fatalf("Failed to insert sync: %v", err)
+	}
+
+	chainState := func(treeID int) (bool, int, bool) {
+		t.Helper()
+		var needSync bool
+		var nodeCountSync int
+		err := db.QueryRowContext(ctx, `
+		SELECT need_sync, node_count_sync FROM merkle_tree_chains WHERE tree_id = $1 AND chain_id = $2
+		`, treeID, chainID).Scan(&needSync, &nodeCountSync)
+		if err == sql.ErrNoRows {
+			return false, 0, false
+		}
+		if err != nil {
+			t.Fatalf("Failed to get chain state: %v", err)
+		}
+		return needSync, nodeCountSync, true
+	}
+
+	if err := AdoptLegacySyncState(ctx, db, chainID); err != nil {
+		t.Fatalf("Failed to adopt legacy sync state: %v", err)
+	}
+	// a tree behind is due by its node count, the mark only matters to an up to date tree
+	if needSync, nodeCountSync, exists := chainState(marked); !exists || !needSync || nodeCountSync != 3 {
+		t.Errorf("Expected Tree ID %d marked at 3 nodes, got %t at %d", marked, needSync, nodeCountSync)
+	}
+	if needSync, nodeCountSync, exists := chainState(behind); !exists || needSync || nodeCountSync != 3 {
+		t.Errorf("Expected Tree ID %d synced at 3 nodes without a mark, got %t at %d", behind, needSync, nodeCountSync)
+	}
+	if _, _, exists := chainState(unsynced); exists {
+		t.Errorf("Expected no chain state for the unsynced Tree ID %d", unsynced)
+	}
+	var syncChainID uint64
+	if err := db.QueryRowContext(ctx, `SELECT chain_id FROM merkle_tree_syncs WHERE id = $1`, syncID).Scan(&syncChainID); err != nil || syncChainID != chainID {
+		t.Errorf("Expected sync %d moved to chain %d, got %d and error %v", syncID, chainID, syncChainID, err)
+	}
+
+	// adopting again keeps the state synced since
+	if err := NewMerklePostgres(db).MarkTreesForSync(ctx, chainID, []int{behind}); err != nil {
+		t.Fatalf("Failed to mark tree for sync: %v", err)
+	}
+	if err := AdoptLegacySyncState(ctx, db, chainID); err != nil {
+		t.Fatalf("Failed to adopt legacy sync state again: %v", err)
+	}
+	if needSync, _, _ := chainState(behind); !needSync {
+		t.Errorf("Expected the mark of Tree ID %d kept", behind)
+	}
+}
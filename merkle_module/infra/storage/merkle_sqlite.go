@@ -0,0 +1,1089 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"merkle_module/domain/entities"
+	"merkle_module/domain/repo"
+	"merkle_module/infra/migrations"
+	"merkle_module/infra/model"
+	"merkle_module/utils"
+
+	_ "modernc.org/sqlite"
+)
+
+// OpenSQLite opens the SQLite database at path, ":memory:" for a private in-memory one, and
+// applies the pending sqlite migrations. The pool is limited to one connection: SQLite allows a
+// single writer anyway, and this serializes the reservations of GetActiveTreeForInserting.
+func OpenSQLite(ctx context.Context, path string) (*sql.DB, error) {
+	dsn := "file:" + path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)"
+	if path != ":memory:" {
+		dsn += "&_pragma=journal_mode(WAL)"
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	migrator, err := migrations.New(db, migrations.SQLite)
+	if err == nil {
+		_, err = migrator.Up(ctx)
+	}
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate sqlite database: %w", err)
+	}
+	return db, nil
+}
+
+type MerkleSQLite struct {
+	db *sql.DB
+}
+
+// NewMerkleSQLite returns a repository over a database opened with OpenSQLite
+func NewMerkleSQLite(db *sql.DB) repo.Merkle {
+	return &MerkleSQLite{db: db}
+}
+
+// placeholders returns the positional parameters of an IN list of n values
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// numbered returns the numbered parameters of an IN list of n values starting at ?first, for
+// queries which also reuse numbered parameters
+func numbered(first, n int) string {
+	params := make([]string, n)
+	for i := range params {
+		params[i] = fmt.Sprintf("?%d", first+i)
+	}
+	return strings.Join(params, ", ")
+}
+
+func intArgs(values []int) []any {
+	args := make([]any, len(values))
+	for i, value := range values {
+		args[i] = value
+	}
+	return args
+}
+
+func (m *MerkleSQLite) GetNodesByTreeID(ctx context.Context, treeID int) ([]*entities.MerkleNode, error) {
+	rows, err := m.db.QueryContext(ctx, `
+	SELECT node_id, data
+	FROM merkle_nodes
+	WHERE tree_id = ?
+	ORDER BY node_id
+	`, treeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query merkle nodes: %w", err)
+	}
+	defer rows.Close()
+
+	nodes := make([]*entities.MerkleNode, 0)
+	for rows.Next() {
+		var nodeID int
+		var nodeData []byte
+		if err := rows.Scan(&nodeID, &nodeData); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		nodes = append(nodes, &entities.MerkleNode{TreeID: treeID, NodeID: nodeID, Data: nodeData})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+	if len(nodes) == 0 {
+		// The nodes of a sealed tree are archived
+		seal, err := m.GetTreeSeal(ctx, treeID)
+		if err != nil || seal == nil {
+			return nodes, err
+		}
+		for i, leaf := range seal.Leaves {
+			nodes = append(nodes, &entities.MerkleNode{TreeID: treeID, NodeID: i + 1, Data: leaf})
+		}
+	}
+	return nodes, nil
+}
+
+func (m *MerkleSQLite) AddNode(ctx context.Context, treeID int, nodeID int, data []byte) (*entities.MerkleNode, error) {
+	// make a copy of the data
+	dataCopy := make([]byte, len(data))
+	copy(dataCopy, data)
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO merkle_nodes (tree_id, node_id, data)
+	VALUES (?, ?, ?)
+	`, treeID, nodeID, dataCopy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert merkle node: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	DELETE FROM merkle_node_reservations WHERE tree_id = ? AND node_id = ?
+	`, treeID, nodeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to release node reservation: %w", err)
+	}
+
+	return &entities.MerkleNode{
+		TreeID: treeID,
+		NodeID: nodeID,
+		Data:   dataCopy,
+	}, nil
+}
+
+func (m *MerkleSQLite) GetActiveTreeForInserting(ctx context.Context, issuerDID string) (*model.ActiveTree, error) {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	// The single connection of the pool makes the select and the update atomic
+	var treeID int
+	var nodeID int
+	err = tx.QueryRowContext(ctx, `
+	SELECT id
+	FROM merkle_trees
+	WHERE issuer_did = ? AND node_count < ?
+	ORDER BY id
+	LIMIT 1
+	`, issuerDID, utils.MAX_LEAFS).Scan(&treeID)
+
+	if err == sql.ErrNoRows {
+		// If not found, create a new one with node_count = 1
+		err = tx.QueryRowContext(ctx, `
+		INSERT INTO merkle_trees (issuer_did, node_count)
+		VALUES (?, 1)
+		RETURNING id, node_count
+		`, issuerDID).Scan(&treeID, &nodeID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create new merkle tree: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get active tree id: %w", err)
+	} else {
+		// If found, increase node_count by 1 and get the new value
+		err = tx.QueryRowContext(ctx, `
+		UPDATE merkle_trees
+		SET node_count = node_count + 1,
+			need_sync = 1,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+		RETURNING node_count
+		`, treeID).Scan(&nodeID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update and return node_count: %w", err)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO merkle_node_reservations (tree_id, node_id, expires_at)
+	VALUES (?, ?, ?)
+	ON CONFLICT (tree_id, node_id) DO UPDATE SET expires_at = excluded.expires_at
+	`, treeID, nodeID, reservationExpiry())
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve node: %w", err)
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+	SELECT data
+	FROM merkle_nodes
+	WHERE tree_id = ?
+	ORDER BY node_id
+	`, treeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get nodes by tree ID: %w", err)
+	}
+	defer rows.Close()
+	var nodes [][]byte
+	for rows.Next() {
+		var nodeData []byte
+		if err = rows.Scan(&nodeData); err != nil {
+			return nil, fmt.Errorf("failed to scan node data: %w", err)
+		}
+		nodes = append(nodes, nodeData)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	return &model.ActiveTree{
+		TreeID:    treeID,
+		IssuerDID: issuerDID,
+		NodeCount: nodeID, // Already reserved a slot for the next node
+		Nodes:     nodes,
+	}, nil
+}
+
+func (m *MerkleSQLite) CountNodes(ctx context.Context, treeID int) (int, int, error) {
+	var count, last int
+	err := m.db.QueryRowContext(ctx, `
+	SELECT COUNT(*), COALESCE(MAX(node_id), 0)
+	FROM merkle_nodes
+	WHERE tree_id = ?
+	`, treeID).Scan(&count, &last)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to count merkle nodes: %w", err)
+	}
+	return count, last, nil
+}
+
+func (m *MerkleSQLite) AddNodeAndIncrementNodeCount(ctx context.Context, treeID int, nodeID int, data []byte) (*entities.MerkleNode, error) {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO merkle_nodes (tree_id, node_id, data)
+	VALUES (?, ?, ?)
+	`, treeID, nodeID, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert merkle node: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	UPDATE merkle_trees
+	SET node_count = node_count + 1,
+		need_sync = 1,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`, treeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to increment node count: %w", err)
+	}
+
+	return &entities.MerkleNode{
+		TreeID: treeID,
+		NodeID: nodeID,
+	}, nil
+}
+
+func (m *MerkleSQLite) SaveNodeHashes(ctx context.Context, hashes []*entities.NodeHash) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	for _, hash := range hashes {
+		_, err = tx.ExecContext(ctx, `
+		INSERT INTO merkle_node_hashes (tree_id, node_index, size, hash)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (tree_id, node_index, size) DO UPDATE SET hash = excluded.hash
+		`, hash.TreeID, hash.Index, hash.Size, hash.Hash)
+		if err != nil {
+			return fmt.Errorf("failed to save node hashes: %w", err)
+		}
+	}
+	return nil
+}
+
+func (m *MerkleSQLite) GetNodeHashes(ctx context.Context, treeID int, size int, indexes []int) ([]*entities.NodeHash, error) {
+	if len(indexes) == 0 {
+		return nil, nil
+	}
+
+	// With MAX, SQLite reads hash from the row holding the largest size
+	args := append([]any{treeID, size}, intArgs(indexes)...)
+	rows, err := m.db.QueryContext(ctx, `
+	SELECT node_index, MAX(size), hash
+	FROM merkle_node_hashes
+	WHERE tree_id = ? AND size <= ? AND node_index IN (`+placeholders(len(indexes))+`)
+	GROUP BY node_index
+	ORDER BY node_index
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query node hashes: %w", err)
+	}
+	defer rows.Close()
+
+	return scanNodeHashes(rows, treeID)
+}
+
+func (m *MerkleSQLite) AddTreeRoots(ctx context.Context, roots []*entities.TreeRoot) error {
+	if len(roots) == 0 {
+		return nil
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	for _, root := range roots {
+		_, err = tx.ExecContext(ctx, `
+		INSERT INTO tree_roots (tree_id, size, root)
+		VALUES (?, ?, ?)
+		ON CONFLICT (tree_id, size) DO NOTHING
+		`, root.TreeID, root.Size, root.Root)
+		if err != nil {
+			return fmt.Errorf("failed to add tree roots: %w", err)
+		}
+	}
+	return nil
+}
+
+func (m *MerkleSQLite) GetTreeRoot(ctx context.Context, treeID int, size int) (*entities.TreeRoot, error) {
+	root := &entities.TreeRoot{TreeID: treeID, Size: size}
+	err := m.db.QueryRowContext(ctx, `
+	SELECT root FROM tree_roots WHERE tree_id = ? AND size = ?
+	`, treeID, size).Scan(&root.Root)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tree root: %w", err)
+	}
+	return root, nil
+}
+
+func (m *MerkleSQLite) GetTreeRoots(ctx context.Context, treeID int) ([]*entities.TreeRoot, error) {
+	rows, err := m.db.QueryContext(ctx, `
+	SELECT size, root FROM tree_roots WHERE tree_id = ? ORDER BY size
+	`, treeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tree roots: %w", err)
+	}
+	defer rows.Close()
+
+	return scanTreeRoots(rows, treeID)
+}
+
+func (m *MerkleSQLite) GetTreesToSeal(ctx context.Context, chainIDs []uint64) ([]*entities.MerkleTree, error) {
+	chainIDs = distinctChains(chainIDs)
+	if len(chainIDs) == 0 {
+		return nil, nil
+	}
+
+	// Same selection as MerklePostgres
+	args := []any{utils.MAX_LEAFS, len(chainIDs)}
+	for _, chainID := range chainIDs {
+		args = append(args, chainID)
+	}
+	rows, err := m.db.QueryContext(ctx, `
+	SELECT mt.id, mt.issuer_did, mt.node_count
+	FROM merkle_trees mt
+	WHERE mt.node_count = ?1 AND mt.sealed_at IS NULL
+	AND (SELECT COUNT(*) FROM merkle_nodes mn WHERE mn.tree_id = mt.id) = ?1
+	AND (
+		SELECT COUNT(*) FROM merkle_tree_chains c
+		WHERE c.tree_id = mt.id AND c.node_count_sync = ?1 AND NOT c.need_sync
+		AND c.chain_id IN (`+numbered(3, len(chainIDs))+`)
+	) = ?2
+	AND NOT EXISTS (
+		SELECT 1 FROM merkle_tree_syncs s
+		WHERE s.tree_id = mt.id AND s.status = 'pending'
+	)
+	ORDER BY mt.id
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query trees to seal: %w", err)
+	}
+	defer rows.Close()
+
+	var trees []*entities.MerkleTree
+	for rows.Next() {
+		tree := &entities.MerkleTree{}
+		if err := rows.Scan(&tree.ID, &tree.IssuerDID, &tree.NodeCount); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		trees = append(trees, tree)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+	return trees, nil
+}
+
+func (m *MerkleSQLite) SealTree(ctx context.Context, seal *entities.TreeSeal) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	// The single connection serializes the seals of a tree
+	var sealedAt sql.NullTime
+	err = tx.QueryRowContext(ctx, `SELECT sealed_at FROM merkle_trees WHERE id = ?`, seal.TreeID).Scan(&sealedAt)
+	if err == sql.ErrNoRows {
+		err = fmt.Errorf("tree ID %d does not exist", seal.TreeID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get tree: %w", err)
+	}
+	if sealedAt.Valid {
+		err = fmt.Errorf("tree ID %d is already sealed", seal.TreeID)
+		return err
+	}
+
+	var leaves [][]byte
+	leaves, err = sealNodes(ctx, tx, seal.TreeID, `
+	SELECT node_id, data FROM merkle_nodes WHERE tree_id = ? ORDER BY node_id
+	`)
+	if err != nil {
+		return err
+	}
+
+	var at time.Time
+	err = tx.QueryRowContext(ctx, `
+	INSERT INTO merkle_tree_seals (tree_id, root, chain_id, tx_hash, leaves)
+	VALUES (?, ?, ?, ?, ?)
+	RETURNING sealed_at
+	`, seal.TreeID, seal.Root, seal.ChainID, seal.TxHash, packLeaves(leaves)).Scan(&at)
+	if err != nil {
+		return fmt.Errorf("failed to record seal: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+	UPDATE merkle_trees SET sealed_at = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?
+	`, at, seal.TreeID)
+	if err != nil {
+		return fmt.Errorf("failed to mark tree sealed: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM merkle_nodes WHERE tree_id = ?`, seal.TreeID)
+	if err != nil {
+		return fmt.Errorf("failed to archive nodes: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM merkle_node_hashes WHERE tree_id = ?`, seal.TreeID)
+	if err != nil {
+		return fmt.Errorf("failed to drop node hashes: %w", err)
+	}
+
+	seal.SealedAt = at
+	return nil
+}
+
+func (m *MerkleSQLite) GetTreeSeal(ctx context.Context, treeID int) (*entities.TreeSeal, error) {
+	return getTreeSeal(ctx, m.db, treeID, `
+	SELECT root, chain_id, tx_hash, sealed_at, leaves FROM merkle_tree_seals WHERE tree_id = ?
+	`)
+}
+
+func (m *MerkleSQLite) SetNodeCount(ctx context.Context, treeID, observed, nodeCount int, idle time.Duration) (bool, error) {
+	result, err := m.db.ExecContext(ctx, `
+	UPDATE merkle_trees
+	SET node_count = ?,
+		need_sync = 1,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND node_count = ? AND sealed_at IS NULL
+		AND updated_at <= datetime('now', ?)
+		AND NOT EXISTS (SELECT 1 FROM merkle_nodes WHERE tree_id = ? AND node_id > ?)
+	`, nodeCount, treeID, observed, fmt.Sprintf("-%d seconds", int(idle.Seconds())), treeID, nodeCount)
+	if err != nil {
+		return false, fmt.Errorf("failed to set node count: %w", err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get updated rows: %w", err)
+	}
+	return updated == 1, nil
+}
+
+func (m *MerkleSQLite) ReapReservations(ctx context.Context, now time.Time, leaf []byte) ([]*entities.MerkleNode, error) {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	// Same as MerklePostgres, the nodes are inserted one by one to know which were filled
+	rows, err := tx.QueryContext(ctx, `
+	SELECT r.tree_id, r.node_id
+	FROM merkle_node_reservations r
+	JOIN merkle_trees mt ON mt.id = r.tree_id
+	WHERE r.expires_at <= ? AND r.node_id <= mt.node_count AND mt.sealed_at IS NULL
+	ORDER BY r.tree_id, r.node_id
+	`, now.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query expired reservations: %w", err)
+	}
+	expired, err := scanReapedNodes(rows, leaf)
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []*entities.MerkleNode
+	for _, node := range expired {
+		var result sql.Result
+		result, err = tx.ExecContext(ctx, `
+		INSERT INTO merkle_nodes (tree_id, node_id, data)
+		VALUES (?, ?, ?)
+		ON CONFLICT (tree_id, node_id) DO NOTHING
+		`, node.TreeID, node.NodeID, leaf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fill reserved node: %w", err)
+		}
+		var inserted int64
+		if inserted, err = result.RowsAffected(); err != nil {
+			return nil, fmt.Errorf("failed to get inserted rows: %w", err)
+		}
+		if inserted == 1 {
+			nodes = append(nodes, node)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	DELETE FROM merkle_node_reservations WHERE expires_at <= ?
+	`, now.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete expired reservations: %w", err)
+	}
+	return nodes, nil
+}
+
+func (m *MerkleSQLite) GetIssuers(ctx context.Context) ([]*entities.IssuerTrees, error) {
+	rows, err := m.db.QueryContext(ctx, `
+	SELECT issuer_did, COUNT(*), SUM(node_count), MAX(id)
+	FROM merkle_trees
+	GROUP BY issuer_did
+	ORDER BY issuer_did
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query issuers: %w", err)
+	}
+	defer rows.Close()
+
+	var issuers []*entities.IssuerTrees
+	for rows.Next() {
+		issuer := &entities.IssuerTrees{}
+		if err := rows.Scan(&issuer.IssuerDID, &issuer.Trees, &issuer.NodeCount, &issuer.LastTreeID); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		issuers = append(issuers, issuer)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+	return issuers, nil
+}
+
+func (m *MerkleSQLite) GetIssuerTrees(ctx context.Context, issuerDID string) ([]*entities.MerkleTree, error) {
+	rows, err := m.db.QueryContext(ctx, `
+	SELECT id, issuer_did, node_count
+	FROM merkle_trees
+	WHERE issuer_did = ?
+	ORDER BY id
+	`, issuerDID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query issuer trees: %w", err)
+	}
+	defer rows.Close()
+
+	var trees []*entities.MerkleTree
+	for rows.Next() {
+		tree := &entities.MerkleTree{}
+		if err := rows.Scan(&tree.ID, &tree.IssuerDID, &tree.NodeCount); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		trees = append(trees, tree)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+	return trees, nil
+}
+
+var sqliteSnapshotQueries = snapshotQueries{
+	tree:   `SELECT issuer_did, node_count FROM merkle_trees WHERE id = ?`,
+	nodes:  `SELECT node_id, data FROM merkle_nodes WHERE tree_id = ? ORDER BY node_id`,
+	roots:  `SELECT size, root FROM tree_roots WHERE tree_id = ? ORDER BY size`,
+	chains: `SELECT chain_id, need_sync, node_count_sync FROM merkle_tree_chains WHERE tree_id = ? ORDER BY chain_id`,
+	syncs:  `SELECT ` + treeSyncColumns + ` FROM merkle_tree_syncs WHERE tree_id = ? AND status = 'confirmed' ORDER BY id`,
+	seal:   `SELECT root, chain_id, tx_hash, sealed_at, leaves FROM merkle_tree_seals WHERE tree_id = ?`,
+}
+
+func (m *MerkleSQLite) GetTreeSnapshot(ctx context.Context, treeID int) (*entities.TreeSnapshot, error) {
+	tx, err := m.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	return readSnapshot(ctx, tx, treeID, sqliteSnapshotQueries)
+}
+
+var sqliteImportStatements = importStatements{
+	exists: `SELECT 1 FROM merkle_trees WHERE id = ?`,
+	tree:   `INSERT INTO merkle_trees (id, issuer_did, node_count) VALUES (?, ?, ?)`,
+	node:   `INSERT INTO merkle_nodes (tree_id, node_id, data) VALUES (?, ?, ?)`,
+	root:   `INSERT INTO tree_roots (tree_id, size, root) VALUES (?, ?, ?)`,
+	chain:  `INSERT INTO merkle_tree_chains (tree_id, chain_id, need_sync, node_count_sync) VALUES (?, ?, ?, ?)`,
+	sync: `
+	INSERT INTO merkle_tree_syncs (tree_id, chain_id, node_count, root, status, tx_hash, nonce, block_number, error, issuer_address, checkpoint_id, checkpoint_position)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+	seal:   `INSERT INTO merkle_tree_seals (tree_id, root, chain_id, tx_hash, leaves, sealed_at) VALUES (?, ?, ?, ?, ?, ?)`,
+	sealed: `UPDATE merkle_trees SET sealed_at = ? WHERE id = ?`,
+}
+
+func (m *MerkleSQLite) ImportTrees(ctx context.Context, snapshots []*entities.TreeSnapshot) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	// AUTOINCREMENT gives the trees created next IDs after the imported ones
+	if err = importTrees(ctx, tx, snapshots, sqliteImportStatements); err != nil {
+		return fmt.Errorf("failed to import trees: %w", err)
+	}
+	return nil
+}
+
+func (m *MerkleSQLite) GetTreesWithNodesForSync(ctx context.Context, chainID uint64) ([]*model.MerkleTreeWithNodes, error) {
+	// Same selection as MerklePostgres, the nodes of the due trees are read in the same query
+	rows, err := m.db.QueryContext(ctx, `
+	SELECT mt.id, mt.issuer_did, COALESCE(c.need_sync, 0), COALESCE(c.node_count_sync, 0), mn.node_id, mn.data
+	FROM merkle_trees mt
+	JOIN merkle_nodes mn ON mn.tree_id = mt.id AND mn.node_id <= mt.node_count
+	LEFT JOIN merkle_tree_chains c ON c.tree_id = mt.id AND c.chain_id = ?1
+	WHERE (mt.node_count > COALESCE(c.node_count_sync, 0) OR COALESCE(c.need_sync, 0))
+	AND NOT EXISTS (
+		SELECT 1 FROM merkle_tree_syncs s
+		WHERE s.tree_id = mt.id AND s.chain_id = ?1 AND s.status = 'pending'
+	)
+	ORDER BY mt.id, mn.node_id
+	`, chainID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query merkle nodes for trees: %w", err)
+	}
+	defer rows.Close()
+
+	var currentTree *model.MerkleTreeWithNodes
+	var result []*model.MerkleTreeWithNodes
+	for rows.Next() {
+		var treeID, nodeID, nodeCountSync int
+		var issuerDID string
+		var needSync bool
+		var nodeData []byte
+		if err := rows.Scan(&treeID, &issuerDID, &needSync, &nodeCountSync, &nodeID, &nodeData); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+
+		if currentTree == nil || currentTree.Tree.ID != treeID {
+			currentTree = &model.MerkleTreeWithNodes{
+				Tree:  &entities.MerkleTree{ID: treeID, IssuerDID: issuerDID, NeedSync: needSync, NodeCountSync: nodeCountSync},
+				Nodes: []*entities.MerkleNode{},
+			}
+			result = append(result, currentTree)
+		}
+		currentTree.Nodes = append(currentTree.Nodes, &entities.MerkleNode{
+			TreeID: treeID,
+			NodeID: nodeID,
+			Data:   nodeData,
+		})
+		currentTree.Tree.NodeCount++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	// The nodes of sealed trees are archived, these trees are only due when marked again
+	archived, err := m.db.QueryContext(ctx, `
+	SELECT mt.id, mt.issuer_did, mt.node_count, s.leaves
+	FROM merkle_trees mt
+	JOIN merkle_tree_seals s ON s.tree_id = mt.id
+	LEFT JOIN merkle_tree_chains c ON c.tree_id = mt.id AND c.chain_id = ?1
+	WHERE (mt.node_count > COALESCE(c.node_count_sync, 0) OR COALESCE(c.need_sync, 0))
+	AND NOT EXISTS (
+		SELECT 1 FROM merkle_tree_syncs s
+		WHERE s.tree_id = mt.id AND s.chain_id = ?1 AND s.status = 'pending'
+	)
+	`, chainID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query archives of trees: %w", err)
+	}
+	defer archived.Close()
+
+	return appendArchivedTrees(result, archived)
+}
+
+func (m *MerkleSQLite) GetNodesSyncedByTreeID(ctx context.Context, treeID int, chainID uint64) ([]*entities.MerkleNode, error) {
+	rows, err := m.db.QueryContext(ctx, `
+	SELECT mn.node_id, mn.data
+	FROM merkle_nodes mn
+	JOIN merkle_tree_chains c ON mn.tree_id = c.tree_id AND c.chain_id = ?
+	WHERE mn.tree_id = ? AND mn.node_id <= c.node_count_sync
+	ORDER BY mn.node_id
+	`, chainID, treeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query merkle nodes by tree ID: %w", err)
+	}
+	defer rows.Close()
+
+	var nodes []*entities.MerkleNode
+	for rows.Next() {
+		var nodeID int
+		var nodeData []byte
+		if err := rows.Scan(&nodeID, &nodeData); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		nodes = append(nodes, &entities.MerkleNode{
+			TreeID: treeID,
+			NodeID: nodeID,
+			Data:   nodeData,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	if len(nodes) == 0 {
+		// The nodes of a sealed tree are archived
+		var blob []byte
+		var nodeCountSync int
+		err := m.db.QueryRowContext(ctx, `
+		SELECT s.leaves, c.node_count_sync
+		FROM merkle_tree_seals s
+		JOIN merkle_tree_chains c ON c.tree_id = s.tree_id AND c.chain_id = ?
+		WHERE s.tree_id = ?
+		`, chainID, treeID).Scan(&blob, &nodeCountSync)
+		if err == sql.ErrNoRows {
+			return nodes, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to query archive: %w", err)
+		}
+		return archivedNodes(treeID, blob, nodeCountSync)
+	}
+
+	return nodes, nil
+}
+
+func (m *MerkleSQLite) GetSyncedTrees(ctx context.Context, chainID uint64) ([]*entities.MerkleTree, error) {
+	rows, err := m.db.QueryContext(ctx, `
+	SELECT mt.id, mt.issuer_did, mt.node_count, c.need_sync, c.node_count_sync
+	FROM merkle_trees mt
+	JOIN merkle_tree_chains c ON c.tree_id = mt.id AND c.chain_id = ?
+	WHERE c.node_count_sync > 0
+	ORDER BY mt.id
+	`, chainID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query synced trees: %w", err)
+	}
+	defer rows.Close()
+
+	var trees []*entities.MerkleTree
+	for rows.Next() {
+		var tree entities.MerkleTree
+		if err := rows.Scan(&tree.ID, &tree.IssuerDID, &tree.NodeCount, &tree.NeedSync, &tree.NodeCountSync); err != nil {
+			return nil, fmt.Errorf("failed to scan tree: %w", err)
+		}
+		trees = append(trees, &tree)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	return trees, nil
+}
+
+func (m *MerkleSQLite) MarkTreesForSync(ctx context.Context, chainID uint64, treeIDs []int) error {
+	if len(treeIDs) == 0 {
+		return nil
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	for _, treeID := range treeIDs {
+		_, err = tx.ExecContext(ctx, `
+		INSERT INTO merkle_tree_chains (tree_id, chain_id, need_sync)
+		VALUES (?, ?, 1)
+		ON CONFLICT (tree_id, chain_id) DO UPDATE SET need_sync = 1, updated_at = CURRENT_TIMESTAMP
+		`, treeID, chainID)
+		if err != nil {
+			return fmt.Errorf("failed to mark trees for sync: %w", err)
+		}
+	}
+	return nil
+}
+
+func (m *MerkleSQLite) CreatePendingSyncs(ctx context.Context, syncs []*entities.TreeSync) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	ids := make([]int, len(syncs))
+	for i, treeSync := range syncs {
+		err = tx.QueryRowContext(ctx, `
+		INSERT INTO merkle_tree_syncs (tree_id, chain_id, node_count, root, status, issuer_address, checkpoint_id, checkpoint_position)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+		`, treeSync.TreeID, treeSync.ChainID, treeSync.NodeCount, treeSync.Root, entities.SyncStatusPending, treeSync.IssuerAddress, treeSync.CheckpointID, treeSync.CheckpointPosition).Scan(&ids[i])
+		if err != nil {
+			return fmt.Errorf("failed to create pending sync for tree ID %d: %w", treeSync.TreeID, err)
+		}
+	}
+
+	// The syncs are only updated once all of them are stored
+	for i, treeSync := range syncs {
+		treeSync.ID = ids[i]
+		treeSync.Status = entities.SyncStatusPending
+	}
+	return nil
+}
+
+func (m *MerkleSQLite) SetSyncTransaction(ctx context.Context, syncIDs []int, txHash string, nonce uint64) error {
+	if len(syncIDs) == 0 {
+		return nil
+	}
+
+	_, err := m.db.ExecContext(ctx, `
+	UPDATE merkle_tree_syncs
+	SET tx_hash = ?,
+		nonce = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE status = 'pending' AND id IN (`+placeholders(len(syncIDs))+`)
+	`, append([]any{txHash, nonce}, intArgs(syncIDs)...)...)
+	if err != nil {
+		return fmt.Errorf("failed to set sync transaction: %w", err)
+	}
+	return nil
+}
+
+func (m *MerkleSQLite) GetPendingSyncs(ctx context.Context, chainID uint64) ([]*entities.TreeSync, error) {
+	return m.querySyncs(ctx, `
+	SELECT `+treeSyncColumns+`
+	FROM merkle_tree_syncs
+	WHERE chain_id = ? AND status = 'pending'
+	ORDER BY id
+	`, chainID)
+}
+
+func (m *MerkleSQLite) querySyncs(ctx context.Context, query string, args ...any) ([]*entities.TreeSync, error) {
+	rows, err := m.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query syncs: %w", err)
+	}
+	defer rows.Close()
+
+	var syncs []*entities.TreeSync
+	for rows.Next() {
+		treeSync, err := scanTreeSync(rows)
+		if err != nil {
+			return nil, err
+		}
+		syncs = append(syncs, treeSync)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	return syncs, nil
+}
+
+func (m *MerkleSQLite) ConfirmSyncs(ctx context.Context, chainID uint64, txHash string, blockNumber uint64) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	rows, err := tx.QueryContext(ctx, `
+	UPDATE merkle_tree_syncs
+	SET status = 'confirmed',
+		block_number = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE chain_id = ? AND tx_hash = ? AND status = 'pending'
+	RETURNING tree_id, node_count
+	`, blockNumber, chainID, txHash)
+	if err != nil {
+		return fmt.Errorf("failed to confirm syncs: %w", err)
+	}
+
+	confirmed := make(map[int]int)
+	for rows.Next() {
+		var treeID, nodeCount int
+		if err = rows.Scan(&treeID, &nodeCount); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan confirmed sync: %w", err)
+		}
+		confirmed[treeID] = max(confirmed[treeID], nodeCount)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	// The tree stays due for sync if nodes were added after the root was computed
+	for treeID, nodeCount := range confirmed {
+		_, err = tx.ExecContext(ctx, `
+		INSERT INTO merkle_tree_chains (tree_id, chain_id, need_sync, node_count_sync, synced_at)
+		VALUES (?, ?, 0, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (tree_id, chain_id) DO UPDATE
+		SET node_count_sync = MAX(merkle_tree_chains.node_count_sync, excluded.node_count_sync),
+			need_sync = 0,
+			synced_at = CURRENT_TIMESTAMP,
+			updated_at = CURRENT_TIMESTAMP
+		`, treeID, chainID, nodeCount)
+		if err != nil {
+			return fmt.Errorf("failed to update node_count_sync for tree ID %d: %w", treeID, err)
+		}
+	}
+
+	return nil
+}
+
+func (m *MerkleSQLite) FailSyncs(ctx context.Context, syncIDs []int, status string, reason string) error {
+	if len(syncIDs) == 0 {
+		return nil
+	}
+
+	_, err := m.db.ExecContext(ctx, `
+	UPDATE merkle_tree_syncs
+	SET status = ?,
+		error = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE status = 'pending' AND id IN (`+placeholders(len(syncIDs))+`)
+	`, append([]any{status, reason}, intArgs(syncIDs)...)...)
+	if err != nil {
+		return fmt.Errorf("failed to close pending syncs: %w", err)
+	}
+	return nil
+}
+
+func (m *MerkleSQLite) GetLastConfirmedSync(ctx context.Context, treeID int, chainID uint64) (*entities.TreeSync, error) {
+	treeSync, err := scanTreeSync(m.db.QueryRowContext(ctx, `
+	SELECT `+treeSyncColumns+`
+	FROM merkle_tree_syncs
+	WHERE tree_id = ? AND chain_id = ? AND status = 'confirmed'
+	ORDER BY node_count DESC, id DESC
+	LIMIT 1
+	`, treeID, chainID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last confirmed sync: %w", err)
+	}
+	return treeSync, nil
+}
+
+func (m *MerkleSQLite) CreateCheckpoint(ctx context.Context, checkpoint *entities.Checkpoint) error {
+	err := m.db.QueryRowContext(ctx, `
+	INSERT INTO merkle_checkpoints (chain_id, issuer_address, root)
+	VALUES (?, ?, ?)
+	RETURNING id
+	`, checkpoint.ChainID, checkpoint.IssuerAddress, checkpoint.Root).Scan(&checkpoint.ID)
+	if err != nil {
+		return fmt.Errorf("failed to create checkpoint: %w", err)
+	}
+	return nil
+}
+
+func (m *MerkleSQLite) GetCheckpoint(ctx context.Context, checkpointID int) (*entities.Checkpoint, error) {
+	checkpoint := &entities.Checkpoint{ID: checkpointID}
+	err := m.db.QueryRowContext(ctx, `
+	SELECT chain_id, issuer_address, root
+	FROM merkle_checkpoints
+	WHERE id = ?
+	`, checkpointID).Scan(&checkpoint.ChainID, &checkpoint.IssuerAddress, &checkpoint.Root)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get checkpoint: %w", err)
+	}
+
+	checkpoint.Syncs, err = m.querySyncs(ctx, `
+	SELECT `+treeSyncColumns+`
+	FROM merkle_tree_syncs
+	WHERE checkpoint_id = ?
+	ORDER BY checkpoint_position
+	`, checkpointID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get checkpoint syncs: %w", err)
+	}
+	return checkpoint, nil
+}
@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"merkle_module/domain/repo"
+	"merkle_module/infra/storage/storagetest"
+)
+
+func TestMerkleSQLite(t *testing.T) {
+	storagetest.TestMerkle(t, func(t *testing.T) repo.Merkle {
+		db, err := OpenSQLite(context.Background(), filepath.Join(t.TempDir(), "merkle.db"))
+		if err != nil {
+			t.Fatalf("Failed to open sqlite database: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+		return NewMerkleSQLite(db)
+	})
+}
+
+func TestMerkleSQLiteInMemory(t *testing.T) {
+	storagetest.TestMerkle(t, func(t *testing.T) repo.Merkle {
+		db, err := OpenSQLite(context.Background(), ":memory:")
+		if err != nil {
+			t.Fatalf("Failed to open sqlite database: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+		return NewMerkleSQLite(db)
+	})
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"merkle_module/domain/entities"
+)
+
+// ReservationTTL is the time a node reserved by GetActiveTreeForInserting is kept for AddNode.
+// AddLeaf fills its reservation right away, a reservation left past it was abandoned by a
+// process that died in between.
+const ReservationTTL = 5 * time.Minute
+
+// reservationExpiry returns the expiry of a node reserved now. Expiries are written and compared
+// in UTC by the repositories, so the database time zone does not matter.
+func reservationExpiry() time.Time {
+	return time.Now().UTC().Add(ReservationTTL)
+}
+
+// scanReapedNodes reads the tree_id and node_id of the nodes filled with leaf and orders them
+func scanReapedNodes(rows *sql.Rows, leaf []byte) ([]*entities.MerkleNode, error) {
+	var nodes []*entities.MerkleNode
+	for rows.Next() {
+		node := &entities.MerkleNode{Data: leaf}
+		if err := rows.Scan(&node.TreeID, &node.NodeID); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		nodes = append(nodes, node)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].TreeID != nodes[j].TreeID {
+			return nodes[i].TreeID < nodes[j].TreeID
+		}
+		return nodes[i].NodeID < nodes[j].NodeID
+	})
+	return nodes, nil
+}
@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"merkle_module/domain/entities"
+)
+
+// snapshotQueries read a tree snapshot in the placeholder style of a dialect, each with the tree
+// ID as only parameter
+type snapshotQueries struct {
+	tree   string // issuer_did and node_count of the tree
+	nodes  string // node_id and data of the nodes, ordered by node ID
+	roots  string // size and root of the roots, ordered by size
+	chains string // chain_id, need_sync and node_count_sync of the chains, ordered by chain ID
+	syncs  string // treeSyncColumns of the confirmed syncs, ordered by ID
+	seal   string // as read by getTreeSeal
+}
+
+// importStatements restore a tree snapshot in the placeholder style of a dialect
+type importStatements struct {
+	exists string // selects 1 when the tree ID exists
+	tree   string // inserts id, issuer_did and node_count
+	node   string // inserts tree_id, node_id and data
+	root   string // inserts tree_id, size and root
+	chain  string // inserts tree_id, chain_id, need_sync and node_count_sync
+	sync   string // inserts tree_id, chain_id, node_count, root, status, tx_hash, nonce, block_number, error, issuer_address, checkpoint_id and checkpoint_position
+	seal   string // inserts tree_id, root, chain_id, tx_hash, leaves and sealed_at
+	sealed string // sets sealed_at of the tree, then its ID
+}
+
+// readSnapshot reads a tree snapshot within tx, nil if the tree does not exist. The nodes of a
+// sealed tree are read from its archive.
+func readSnapshot(ctx context.Context, tx *sql.Tx, treeID int, q snapshotQueries) (*entities.TreeSnapshot, error) {
+	tree := &entities.MerkleTree{ID: treeID}
+	err := tx.QueryRowContext(ctx, q.tree, treeID).Scan(&tree.IssuerDID, &tree.NodeCount)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tree: %w", err)
+	}
+	snapshot := &entities.TreeSnapshot{Tree: tree}
+
+	err = queryEach(ctx, tx, q.nodes, treeID, func(rows *sql.Rows) error {
+		node := &entities.MerkleNode{TreeID: treeID}
+		snapshot.Nodes = append(snapshot.Nodes, node)
+		return rows.Scan(&node.NodeID, &node.Data)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get nodes: %w", err)
+	}
+	err = queryEach(ctx, tx, q.roots, treeID, func(rows *sql.Rows) error {
+		root := &entities.TreeRoot{TreeID: treeID}
+		snapshot.Roots = append(snapshot.Roots, root)
+		return rows.Scan(&root.Size, &root.Root)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tree roots: %w", err)
+	}
+	err = queryEach(ctx, tx, q.chains, treeID, func(rows *sql.Rows) error {
+		chain := &entities.TreeChain{TreeID: treeID}
+		snapshot.Chains = append(snapshot.Chains, chain)
+		return rows.Scan(&chain.ChainID, &chain.NeedSync, &chain.NodeCountSync)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tree chains: %w", err)
+	}
+	err = queryEach(ctx, tx, q.syncs, treeID, func(rows *sql.Rows) error {
+		treeSync, err := scanTreeSync(rows)
+		snapshot.Syncs = append(snapshot.Syncs, treeSync)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get confirmed syncs: %w", err)
+	}
+
+	seal := &entities.TreeSeal{TreeID: treeID}
+	var blob []byte
+	err = tx.QueryRowContext(ctx, q.seal, treeID).Scan(&seal.Root, &seal.ChainID, &seal.TxHash, &seal.SealedAt, &blob)
+	if err == sql.ErrNoRows {
+		return snapshot, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tree seal: %w", err)
+	}
+	snapshot.Seal = seal
+	if snapshot.Nodes, err = archivedNodes(treeID, blob, tree.NodeCount); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// queryEach calls scan for every row of the query of a tree, rows are read to the end before
+// the next query as SQLite holds a single connection
+func queryEach(ctx context.Context, tx *sql.Tx, query string, treeID int, scan func(rows *sql.Rows) error) error {
+	rows, err := tx.QueryContext(ctx, query, treeID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return fmt.Errorf("failed to scan row: %w", err)
+		}
+	}
+	return rows.Err()
+}
+
+// importTrees restores the trees of snapshots within tx, failing on a tree ID which exists
+func importTrees(ctx context.Context, tx *sql.Tx, snapshots []*entities.TreeSnapshot, s importStatements) error {
+	for _, snapshot := range snapshots {
+		treeID := snapshot.Tree.ID
+		var exists int
+		err := tx.QueryRowContext(ctx, s.exists, treeID).Scan(&exists)
+		if err == nil {
+			return fmt.Errorf("tree ID %d already exists", treeID)
+		}
+		if err != sql.ErrNoRows {
+			return fmt.Errorf("failed to check tree ID %d: %w", treeID, err)
+		}
+
+		if _, err := tx.ExecContext(ctx, s.tree, treeID, snapshot.Tree.IssuerDID, snapshot.Tree.NodeCount); err != nil {
+			return fmt.Errorf("failed to insert tree ID %d: %w", treeID, err)
+		}
+		if snapshot.Seal != nil {
+			leaves, err := sealedLeaves(treeID, snapshot.Nodes)
+			if err != nil {
+				return err
+			}
+			seal := snapshot.Seal
+			if _, err := tx.ExecContext(ctx, s.seal, treeID, seal.Root, seal.ChainID, seal.TxHash, packLeaves(leaves), seal.SealedAt); err != nil {
+				return fmt.Errorf("failed to insert seal of tree ID %d: %w", treeID, err)
+			}
+			if _, err := tx.ExecContext(ctx, s.sealed, seal.SealedAt, treeID); err != nil {
+				return fmt.Errorf("failed to mark tree ID %d sealed: %w", treeID, err)
+			}
+		} else {
+			for _, node := range snapshot.Nodes {
+				if _, err := tx.ExecContext(ctx, s.node, treeID, node.NodeID, node.Data); err != nil {
+					return fmt.Errorf("failed to insert node %d of tree ID %d: %w", node.NodeID, treeID, err)
+				}
+			}
+		}
+		for _, root := range snapshot.Roots {
+			if _, err := tx.ExecContext(ctx, s.root, treeID, root.Size, root.Root); err != nil {
+				return fmt.Errorf("failed to insert root of tree ID %d at size %d: %w", treeID, root.Size, err)
+			}
+		}
+		for _, chain := range snapshot.Chains {
+			if _, err := tx.ExecContext(ctx, s.chain, treeID, chain.ChainID, chain.NeedSync, chain.NodeCountSync); err != nil {
+				return fmt.Errorf("failed to insert sync state of tree ID %d on chain %d: %w", treeID, chain.ChainID, err)
+			}
+		}
+		for _, treeSync := range snapshot.Syncs {
+			_, err := tx.ExecContext(ctx, s.sync, treeID, treeSync.ChainID, treeSync.NodeCount, treeSync.Root, treeSync.Status, treeSync.TxHash, treeSync.Nonce, treeSync.BlockNumber, treeSync.Error, treeSync.IssuerAddress, treeSync.CheckpointID, treeSync.CheckpointPosition)
+			if err != nil {
+				return fmt.Errorf("failed to insert sync of tree ID %d: %w", treeID, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"merkle_module/domain/entities"
+	"merkle_module/domain/repo"
+	"merkle_module/statuslist"
+)
+
+// StatusListMemory keeps the status lists in memory with the semantics of StatusListPostgres.
+// It is meant for tests and local demos, its state is lost on exit.
+type StatusListMemory struct {
+	mu    sync.Mutex
+	lists []*entities.StatusList // status list ID i is lists[i-1]
+}
+
+func NewStatusListMemory() repo.StatusList {
+	return &StatusListMemory{}
+}
+
+// helper function returning a copy of a list, the caller must hold the lock
+func (s *StatusListMemory) get(statusListID int) (*entities.StatusList, error) {
+	if statusListID <= 0 || statusListID > len(s.lists) {
+		return nil, fmt.Errorf("status list not found: %d", statusListID)
+	}
+	list := *s.lists[statusListID-1]
+	list.Bits = append([]byte(nil), list.Bits...)
+	return &list, nil
+}
+
+func (s *StatusListMemory) AllocateIndex(ctx context.Context, issuerDID string, purpose string) (*entities.StatusEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, list := range s.lists {
+		if list.IssuerDID != issuerDID || list.Purpose != purpose {
+			continue
+		}
+		count++
+		if list.NextIndex < list.Size {
+			list.NextIndex++
+			return &entities.StatusEntry{StatusListID: list.ID, ListID: list.ListID, Purpose: purpose, Index: list.NextIndex - 1}, nil
+		}
+	}
+
+	// Create a new list with the first index reserved
+	bits := statuslist.NewBitstring(statuslist.MIN_SIZE)
+	list := &entities.StatusList{
+		ID:        len(s.lists) + 1,
+		ListID:    fmt.Sprintf("%s#%s-%d", issuerDID, purpose, count+1),
+		IssuerDID: issuerDID,
+		Purpose:   purpose,
+		Size:      bits.Size(),
+		NextIndex: 1,
+		Bits:      bits.Bytes(),
+	}
+	s.lists = append(s.lists, list)
+	return &entities.StatusEntry{StatusListID: list.ID, ListID: list.ListID, Purpose: purpose, Index: 0}, nil
+}
+
+func (s *StatusListMemory) SetStatus(ctx context.Context, statusListID int, index int, value bool) (*entities.StatusList, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list, err := s.get(statusListID)
+	if err != nil {
+		return nil, err
+	}
+	if index >= list.NextIndex {
+		return nil, fmt.Errorf("status index %d has not been allocated in status list %d", index, statusListID)
+	}
+
+	bits := statuslist.FromBytes(list.Bits)
+	current, err := bits.Get(index)
+	if err != nil {
+		return nil, err
+	}
+	if current == value {
+		return list, nil
+	}
+	if err := bits.Set(index, value); err != nil {
+		return nil, err
+	}
+
+	stored := s.lists[statusListID-1]
+	stored.Bits = bits.Bytes()
+	stored.Version++
+	return s.get(statusListID)
+}
+
+func (s *StatusListMemory) GetStatusList(ctx context.Context, statusListID int) (*entities.StatusList, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.get(statusListID)
+}
+
+func (s *StatusListMemory) GetStatusListByListID(ctx context.Context, listID string) (*entities.StatusList, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, list := range s.lists {
+		if list.ListID == listID {
+			return s.get(list.ID)
+		}
+	}
+	return nil, fmt.Errorf("status list not found: %s", listID)
+}
+
+func (s *StatusListMemory) GetStatusListsForPublish(ctx context.Context) ([]*entities.StatusList, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var lists []*entities.StatusList
+	for _, stored := range s.lists {
+		if stored.Version > stored.PublishedVersion {
+			list, _ := s.get(stored.ID)
+			lists = append(lists, list)
+		}
+	}
+	// The lists are ordered by ID already
+	sort.SliceStable(lists, func(i, j int) bool { return lists[i].IssuerDID < lists[j].IssuerDID })
+	return lists, nil
+}
+
+func (s *StatusListMemory) MarkStatusListPublished(ctx context.Context, statusListID int, version int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if statusListID <= 0 || statusListID > len(s.lists) {
+		return fmt.Errorf("failed to mark status list %d as published: not found", statusListID)
+	}
+	// Never move backwards if a newer version has been published meanwhile
+	list := s.lists[statusListID-1]
+	list.PublishedVersion = max(list.PublishedVersion, version)
+	return nil
+}
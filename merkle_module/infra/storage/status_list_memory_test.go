@@ -0,0 +1,14 @@
+package storage
+
+import (
+	"testing"
+
+	"merkle_module/domain/repo"
+	"merkle_module/infra/storage/storagetest"
+)
+
+func TestStatusListMemory(t *testing.T) {
+	storagetest.TestStatusList(t, func(t *testing.T) repo.StatusList {
+		return NewStatusListMemory()
+	})
+}
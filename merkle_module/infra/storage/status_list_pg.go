@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"merkle_module/domain/entities"
+	"merkle_module/domain/repo"
+	"merkle_module/statuslist"
+)
+
+type StatusListPostgres struct {
+	db *sql.DB
+}
+
+func NewStatusListPostgres(db *sql.DB) repo.StatusList {
+	return &StatusListPostgres{db: db}
+}
+
+func (s *StatusListPostgres) AllocateIndex(ctx context.Context, issuerDID string, purpose string) (*entities.StatusEntry, error) {
+	// Begin a transaction
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	entry := &entities.StatusEntry{Purpose: purpose}
+	err = tx.QueryRowContext(ctx, `
+	SELECT id, list_id, next_index
+	FROM status_lists
+	WHERE issuer_did = $1 AND purpose = $2 AND next_index < size
+	ORDER BY id
+	LIMIT 1
+	FOR UPDATE
+	`, issuerDID, purpose).Scan(&entry.StatusListID, &entry.ListID, &entry.Index)
+
+	if err == sql.ErrNoRows {
+		// If not found, create a new list with the first index reserved
+		var count int
+		err = tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM status_lists WHERE issuer_did = $1 AND purpose = $2
+		`, issuerDID, purpose).Scan(&count)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count status lists: %w", err)
+		}
+
+		bits := statuslist.NewBitstring(statuslist.MIN_SIZE)
+		entry.ListID = fmt.Sprintf("%s#%s-%d", issuerDID, purpose, count+1)
+		entry.Index = 0
+		err = tx.QueryRowContext(ctx, `
+		INSERT INTO status_lists (list_id, issuer_did, purpose, size, next_index, bits)
+		VALUES ($1, $2, $3, $4, 1, $5)
+		RETURNING id
+		`, entry.ListID, issuerDID, purpose, bits.Size(), bits.Bytes()).Scan(&entry.StatusListID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create new status list: %w", err)
+		}
+		return entry, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get active status list: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	UPDATE status_lists
+	SET next_index = next_index + 1
+	WHERE id = $1
+	`, entry.StatusListID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve status index: %w", err)
+	}
+
+	return entry, nil
+}
+
+func (s *StatusListPostgres) SetStatus(ctx context.Context, statusListID int, index int, value bool) (*entities.StatusList, error) {
+	// Begin a transaction
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	// Lock the row, the bits are flipped in Go to keep the spec bit order
+	var list *entities.StatusList
+	list, err = scanStatusList(tx.QueryRowContext(ctx, `
+	SELECT id, list_id, issuer_did, purpose, size, next_index, bits, version, published_version
+	FROM status_lists
+	WHERE id = $1
+	FOR UPDATE
+	`, statusListID))
+	if err != nil {
+		return nil, err
+	}
+
+	if index >= list.NextIndex {
+		err = fmt.Errorf("status index %d has not been allocated in status list %d", index, statusListID)
+		return nil, err
+	}
+
+	bits := statuslist.FromBytes(list.Bits)
+	current, err := bits.Get(index)
+	if err != nil {
+		return nil, err
+	}
+	if current == value {
+		return list, nil
+	}
+	if err = bits.Set(index, value); err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRowContext(ctx, `
+	UPDATE status_lists
+	SET bits = $1,
+		version = version + 1
+	WHERE id = $2
+	RETURNING version
+	`, bits.Bytes(), statusListID).Scan(&list.Version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update status list: %w", err)
+	}
+	list.Bits = bits.Bytes()
+
+	return list, nil
+}
+
+func (s *StatusListPostgres) GetStatusList(ctx context.Context, statusListID int) (*entities.StatusList, error) {
+	return scanStatusList(s.db.QueryRowContext(ctx, `
+	SELECT id, list_id, issuer_did, purpose, size, next_index, bits, version, published_version
+	FROM status_lists
+	WHERE id = $1
+	`, statusListID))
+}
+
+func (s *StatusListPostgres) GetStatusListByListID(ctx context.Context, listID string) (*entities.StatusList, error) {
+	return scanStatusList(s.db.QueryRowContext(ctx, `
+	SELECT id, list_id, issuer_did, purpose, size, next_index, bits, version, published_version
+	FROM status_lists
+	WHERE list_id = $1
+	`, listID))
+}
+
+func (s *StatusListPostgres) GetStatusListsForPublish(ctx context.Context) ([]*entities.StatusList, error) {
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT id, list_id, issuer_did, purpose, size, next_index, bits, version, published_version
+	FROM status_lists
+	WHERE version > published_version
+	ORDER BY issuer_did, id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query status lists for publish: %w", err)
+	}
+	defer rows.Close()
+
+	var lists []*entities.StatusList
+	for rows.Next() {
+		list, err := scanStatusList(rows)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, list)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	return lists, nil
+}
+
+func (s *StatusListPostgres) MarkStatusListPublished(ctx context.Context, statusListID int, version int) error {
+	// Never move backwards if a newer version has been published meanwhile
+	_, err := s.db.ExecContext(ctx, `
+	UPDATE status_lists
+	SET published_version = GREATEST(published_version, $1)
+	WHERE id = $2
+	`, version, statusListID)
+	if err != nil {
+		return fmt.Errorf("failed to mark status list %d as published: %w", statusListID, err)
+	}
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanStatusList(row rowScanner) (*entities.StatusList, error) {
+	var list entities.StatusList
+	err := row.Scan(&list.ID, &list.ListID, &list.IssuerDID, &list.Purpose, &list.Size, &list.NextIndex, &list.Bits, &list.Version, &list.PublishedVersion)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("status list not found: %w", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan status list: %w", err)
+	}
+	return &list, nil
+}
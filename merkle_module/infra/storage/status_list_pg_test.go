@@ -0,0 +1,15 @@
+package storage
+
+import (
+	"testing"
+
+	"merkle_module/domain/repo"
+	"merkle_module/infra/storage/storagetest"
+)
+
+func TestStatusListPostgres(t *testing.T) {
+	db := openTestPostgres(t)
+	storagetest.TestStatusList(t, func(t *testing.T) repo.StatusList {
+		return NewStatusListPostgres(db)
+	})
+}
@@ -0,0 +1,1039 @@
+// Package storagetest holds the conformance suites every repository implementation must pass.
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"merkle_module/domain/entities"
+	"merkle_module/domain/repo"
+	"merkle_module/infra/model"
+	"merkle_module/utils"
+)
+
+// TestMerkle runs the conformance suite of repo.Merkle against the repositories returned by
+// newRepo, called once per subtest. The suite only relies on the trees, chains and checkpoints
+// it creates, so a shared database which already holds data can be used.
+func TestMerkle(t *testing.T, newRepo func(t *testing.T) repo.Merkle) {
+	t.Run("Reservation", func(t *testing.T) { testReservation(t, newRepo(t)) })
+	t.Run("NodeCount", func(t *testing.T) { testNodeCount(t, newRepo(t)) })
+	t.Run("ReapReservations", func(t *testing.T) { testReapReservations(t, newRepo(t)) })
+	t.Run("ConcurrentReservation", func(t *testing.T) { testConcurrentReservation(t, newRepo(t)) })
+	t.Run("NodesOrder", func(t *testing.T) { testNodesOrder(t, newRepo(t)) })
+	t.Run("NodeHashes", func(t *testing.T) { testNodeHashes(t, newRepo(t)) })
+	t.Run("TreeRoots", func(t *testing.T) { testTreeRoots(t, newRepo(t)) })
+	t.Run("Sync", func(t *testing.T) { testSync(t, newRepo(t)) })
+	t.Run("SyncPerChain", func(t *testing.T) { testSyncPerChain(t, newRepo(t)) })
+	t.Run("FailedSync", func(t *testing.T) { testFailedSync(t, newRepo(t)) })
+	t.Run("Checkpoint", func(t *testing.T) { testCheckpoint(t, newRepo(t)) })
+	t.Run("Seal", func(t *testing.T) { testSeal(t, newRepo(t)) })
+	t.Run("Snapshot", func(t *testing.T) { testSnapshot(t, newRepo(t)) })
+}
+
+// uniqueIssuer returns an issuer DID no other run of the suite uses
+func uniqueIssuer(t *testing.T) string {
+	return fmt.Sprintf("did:example:%s:%d", t.Name(), rand.Uint64())
+}
+
+// uniqueChain returns a chain ID no other run of the suite uses
+func uniqueChain() uint64 {
+	return rand.Uint64N(1<<62) + 1
+}
+
+func leaf(i int) []byte {
+	return utils.Hash([]byte(fmt.Sprintf("leaf %d", i)))
+}
+
+// addLeaves reserves and fills the next n nodes of the issuer, returning them in order
+func addLeaves(t *testing.T, merkle repo.Merkle, issuerDID string, n int) []*entities.MerkleNode {
+	t.Helper()
+	ctx := context.Background()
+	var nodes []*entities.MerkleNode
+	for i := 0; i < n; i++ {
+		active, err := merkle.GetActiveTreeForInserting(ctx, issuerDID)
+		if err != nil {
+			t.Fatalf("Failed to reserve a node: %v", err)
+		}
+		node, err := merkle.AddNode(ctx, active.TreeID, active.NodeCount, leaf(active.NodeCount))
+		if err != nil {
+			t.Fatalf("Failed to add node %d: %v", active.NodeCount, err)
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// nodeIDsOf returns the node IDs of the nodes
+func nodeIDsOf(nodes []*entities.MerkleNode) []int {
+	nodeIDs := make([]int, len(nodes))
+	for i, node := range nodes {
+		nodeIDs[i] = node.NodeID
+	}
+	return nodeIDs
+}
+
+// treesForSync returns the trees due for sync on the chain among the tree IDs, by tree ID
+func treesForSync(t *testing.T, merkle repo.Merkle, chainID uint64, treeIDs ...int) map[int][]*entities.MerkleNode {
+	t.Helper()
+	trees, err := merkle.GetTreesWithNodesForSync(context.Background(), chainID)
+	if err != nil {
+		t.Fatalf("Failed to get trees for sync: %v", err)
+	}
+	due := make(map[int][]*entities.MerkleNode)
+	for _, tree := range trees {
+		if !slices.Contains(treeIDs, tree.Tree.ID) {
+			continue
+		}
+		if tree.Tree.NodeCount != len(tree.Nodes) {
+			t.Errorf("Tree ID %d has node count %d for %d nodes", tree.Tree.ID, tree.Tree.NodeCount, len(tree.Nodes))
+		}
+		due[tree.Tree.ID] = tree.Nodes
+	}
+	return due
+}
+
+// syncTree records and confirms the sync of the tree on the chain at the node count
+func syncTree(t *testing.T, merkle repo.Merkle, chainID uint64, treeID, nodeCount int, txHash string) *entities.TreeSync {
+	t.Helper()
+	ctx := context.Background()
+	treeSync := &entities.TreeSync{TreeID: treeID, ChainID: chainID, NodeCount: nodeCount, Root: leaf(-nodeCount)}
+	if err := merkle.CreatePendingSyncs(ctx, []*entities.TreeSync{treeSync}); err != nil {
+		t.Fatalf("Failed to create pending sync: %v", err)
+	}
+	if err := merkle.SetSyncTransaction(ctx, []int{treeSync.ID}, txHash, 1); err != nil {
+		t.Fatalf("Failed to set sync transaction: %v", err)
+	}
+	if err := merkle.ConfirmSyncs(ctx, chainID, txHash, 100); err != nil {
+		t.Fatalf("Failed to confirm syncs: %v", err)
+	}
+	return treeSync
+}
+
+func testReservation(t *testing.T, merkle repo.Merkle) {
+	ctx := context.Background()
+	issuerDID := uniqueIssuer(t)
+
+	first, err := merkle.GetActiveTreeForInserting(ctx, issuerDID)
+	if err != nil {
+		t.Fatalf("Failed to reserve a node: %v", err)
+	}
+	if first.NodeCount != 1 || len(first.Nodes) != 0 || first.IssuerDID != issuerDID {
+		t.Fatalf("Expected a new tree with node 1 reserved, got %+v", first)
+	}
+	if _, err := merkle.AddNode(ctx, first.TreeID, 1, leaf(1)); err != nil {
+		t.Fatalf("Failed to add node: %v", err)
+	}
+
+	// the next reservation returns the nodes inserted so far
+	second, err := merkle.GetActiveTreeForInserting(ctx, issuerDID)
+	if err != nil {
+		t.Fatalf("Failed to reserve a node: %v", err)
+	}
+	if second.TreeID != first.TreeID || second.NodeCount != 2 {
+		t.Fatalf("Expected node 2 of Tree ID %d reserved, got node %d of Tree ID %d", first.TreeID, second.NodeCount, second.TreeID)
+	}
+	if len(second.Nodes) != 1 || !bytes.Equal(second.Nodes[0], leaf(1)) {
+		t.Errorf("Expected the nodes of the tree to be returned with the reservation, got %x", second.Nodes)
+	}
+
+	// a node added with the count incremented takes a slot without a reservation
+	if _, err := merkle.AddNodeAndIncrementNodeCount(ctx, first.TreeID, 3, leaf(3)); err != nil {
+		t.Fatalf("Failed to add node and increment node count: %v", err)
+	}
+	third, err := merkle.GetActiveTreeForInserting(ctx, issuerDID)
+	if err != nil {
+		t.Fatalf("Failed to reserve a node: %v", err)
+	}
+	if third.NodeCount != 4 {
+		t.Errorf("Expected node 4 reserved, got %d", third.NodeCount)
+	}
+
+	// another issuer gets its own tree
+	other, err := merkle.GetActiveTreeForInserting(ctx, uniqueIssuer(t))
+	if err != nil {
+		t.Fatalf("Failed to reserve a node: %v", err)
+	}
+	if other.TreeID == first.TreeID || other.NodeCount != 1 {
+		t.Errorf("Expected a new tree for another issuer, got node %d of Tree ID %d", other.NodeCount, other.TreeID)
+	}
+
+	// a full tree is not reserved from anymore
+	for nodeID := 4; nodeID < utils.MAX_LEAFS; nodeID++ {
+		if _, err := merkle.GetActiveTreeForInserting(ctx, issuerDID); err != nil {
+			t.Fatalf("Failed to reserve a node: %v", err)
+		}
+	}
+	next, err := merkle.GetActiveTreeForInserting(ctx, issuerDID)
+	if err != nil {
+		t.Fatalf("Failed to reserve a node: %v", err)
+	}
+	if next.TreeID == first.TreeID || next.NodeCount != 1 {
+		t.Errorf("Expected a new tree once Tree ID %d is full, got node %d of Tree ID %d", first.TreeID, next.NodeCount, next.TreeID)
+	}
+}
+
+func testNodeCount(t *testing.T, merkle repo.Merkle) {
+	ctx := context.Background()
+	issuerDID := uniqueIssuer(t)
+
+	// node 4 is reserved and never filled
+	nodes := addLeaves(t, merkle, issuerDID, 3)
+	treeID := nodes[0].TreeID
+	if _, err := merkle.GetActiveTreeForInserting(ctx, issuerDID); err != nil {
+		t.Fatalf("Failed to reserve a node: %v", err)
+	}
+
+	for _, c := range []struct {
+		name                string
+		observed, nodeCount int
+		idle                time.Duration
+	}{
+		{"a tree reserved in lately", 4, 3, time.Hour},
+		{"a node count which changed", 5, 3, 0},
+		{"a node beyond the node count", 4, 2, 0},
+	} {
+		if set, err := merkle.SetNodeCount(ctx, treeID, c.observed, c.nodeCount, c.idle); err != nil || set {
+			t.Errorf("Expected the node count of %s not to be set, got %t and error %v", c.name, set, err)
+		}
+	}
+	set, err := merkle.SetNodeCount(ctx, treeID, 4, 3, 0)
+	if err != nil || !set {
+		t.Fatalf("Expected the node count to be set, got %t and error %v", set, err)
+	}
+
+	// the released node is reserved again
+	active, err := merkle.GetActiveTreeForInserting(ctx, issuerDID)
+	if err != nil {
+		t.Fatalf("Failed to reserve a node: %v", err)
+	}
+	if active.TreeID != treeID || active.NodeCount != 4 || len(active.Nodes) != 3 {
+		t.Errorf("Expected node 4 of Tree ID %d reserved again, got %+v", treeID, active)
+	}
+}
+
+func testReapReservations(t *testing.T, merkle repo.Merkle) {
+	ctx := context.Background()
+	issuerDID := uniqueIssuer(t)
+	chainID := uniqueChain()
+	empty := make([]byte, 32)
+
+	// node 3 is abandoned below node 4, node 5 is abandoned then released
+	nodes := addLeaves(t, merkle, issuerDID, 2)
+	treeID := nodes[0].TreeID
+	if _, err := merkle.GetActiveTreeForInserting(ctx, issuerDID); err != nil {
+		t.Fatalf("Failed to reserve a node: %v", err)
+	}
+	addLeaves(t, merkle, issuerDID, 1)
+	if _, err := merkle.GetActiveTreeForInserting(ctx, issuerDID); err != nil {
+		t.Fatalf("Failed to reserve a node: %v", err)
+	}
+	if set, err := merkle.SetNodeCount(ctx, treeID, 5, 4, 0); err != nil || !set {
+		t.Fatalf("Expected the node count to be set, got %t and error %v", set, err)
+	}
+
+	// the trees due for sync come with their sync state on the chain
+	syncTree(t, merkle, chainID, treeID, 2, "0xeee")
+	trees, err := merkle.GetTreesWithNodesForSync(ctx, chainID)
+	if err != nil {
+		t.Fatalf("Failed to get trees for sync: %v", err)
+	}
+	i := slices.IndexFunc(trees, func(tree *model.MerkleTreeWithNodes) bool { return tree.Tree.ID == treeID })
+	if i < 0 || trees[i].Tree.NodeCountSync != 2 || trees[i].Tree.NeedSync {
+		t.Fatalf("Expected Tree ID %d due for sync after its 2 synced nodes, got %v", treeID, trees)
+	}
+
+	// the unfilled node leaves a gap in the node IDs
+	gapped, err := merkle.GetNodesByTreeID(ctx, treeID)
+	if err != nil {
+		t.Fatalf("Failed to get nodes: %v", err)
+	}
+	if nodeIDs := nodeIDsOf(gapped); !slices.Equal(nodeIDs, []int{1, 2, 4}) {
+		t.Errorf("Expected nodes 1, 2 and 4, got %v", nodeIDs)
+	}
+	if count, last, err := merkle.CountNodes(ctx, treeID); err != nil || count != 3 || last != 4 {
+		t.Errorf("Expected 3 nodes up to node 4, got %d up to %d and error %v", count, last, err)
+	}
+
+	reaped := func(now time.Time) []int {
+		t.Helper()
+		filled, err := merkle.ReapReservations(ctx, now, empty)
+		if err != nil {
+			t.Fatalf("Failed to reap reservations: %v", err)
+		}
+		var nodeIDs []int
+		for _, node := range filled {
+			if node.TreeID == treeID {
+				if !bytes.Equal(node.Data, empty) {
+					t.Errorf("Expected node %d filled with the empty leaf, got %x", node.NodeID, node.Data)
+				}
+				nodeIDs = append(nodeIDs, node.NodeID)
+			}
+		}
+		return nodeIDs
+	}
+	if nodeIDs := reaped(time.Now()); len(nodeIDs) != 0 {
+		t.Errorf("Expected no reservation expired yet, got nodes %v", nodeIDs)
+	}
+	expired := time.Now().Add(24 * time.Hour)
+	if nodeIDs := reaped(expired); !slices.Equal(nodeIDs, []int{3}) {
+		t.Errorf("Expected node 3 filled, got nodes %v", nodeIDs)
+	}
+	if nodeIDs := reaped(expired); len(nodeIDs) != 0 {
+		t.Errorf("Expected the reservations dropped once reaped, got nodes %v", nodeIDs)
+	}
+
+	datas, err := merkle.GetNodesByTreeID(ctx, treeID)
+	if err != nil {
+		t.Fatalf("Failed to get nodes: %v", err)
+	}
+	expected := [][]byte{leaf(1), leaf(2), empty, leaf(4)}
+	if !reflect.DeepEqual(utils.NodesToBytes(datas), expected) || !slices.Equal(nodeIDsOf(datas), []int{1, 2, 3, 4}) {
+		t.Errorf("Expected the gap filled with the empty leaf, got %x at nodes %v", utils.NodesToBytes(datas), nodeIDsOf(datas))
+	}
+	if count, last, err := merkle.CountNodes(ctx, treeID); err != nil || count != 4 || last != 4 {
+		t.Errorf("Expected 4 nodes up to node 4, got %d up to %d and error %v", count, last, err)
+	}
+	if err := merkle.MarkTreesForSync(ctx, chainID, []int{treeID}); err != nil {
+		t.Fatalf("Failed to mark tree for sync: %v", err)
+	}
+	trees, err = merkle.GetTreesWithNodesForSync(ctx, chainID)
+	if err != nil {
+		t.Fatalf("Failed to get trees for sync: %v", err)
+	}
+	i = slices.IndexFunc(trees, func(tree *model.MerkleTreeWithNodes) bool { return tree.Tree.ID == treeID })
+	if i < 0 || len(trees[i].Nodes) != 4 || !trees[i].Tree.NeedSync {
+		t.Errorf("Expected Tree ID %d marked for sync with its 4 nodes, got %v", treeID, trees)
+	}
+}
+
+func testConcurrentReservation(t *testing.T, merkle repo.Merkle) {
+	ctx := context.Background()
+	issuerDID := uniqueIssuer(t)
+	reservations := 3*utils.MAX_LEAFS + 5
+
+	type slot struct{ treeID, nodeID int }
+	slots := make(chan slot, reservations)
+	var wg sync.WaitGroup
+	for i := 0; i < reservations; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			active, err := merkle.GetActiveTreeForInserting(ctx, issuerDID)
+			if err != nil {
+				t.Errorf("Failed to reserve a node: %v", err)
+				return
+			}
+			slots <- slot{active.TreeID, active.NodeCount}
+		}()
+	}
+	wg.Wait()
+	close(slots)
+
+	// every reservation gets its own slot and trees are filled up to MAX_LEAFS
+	seen := make(map[slot]bool)
+	perTree := make(map[int]int)
+	for s := range slots {
+		if seen[s] {
+			t.Errorf("Node %d of Tree ID %d reserved twice", s.nodeID, s.treeID)
+		}
+		if s.nodeID < 1 || s.nodeID > utils.MAX_LEAFS {
+			t.Errorf("Node %d of Tree ID %d is out of range", s.nodeID, s.treeID)
+		}
+		seen[s] = true
+		perTree[s.treeID]++
+	}
+	if len(seen) != reservations || len(perTree) != 4 {
+		t.Errorf("Expected %d slots over 4 trees, got %d slots over %d trees", reservations, len(seen), len(perTree))
+	}
+}
+
+func testNodesOrder(t *testing.T, merkle repo.Merkle) {
+	ctx := context.Background()
+	issuerDID := uniqueIssuer(t)
+	var treeID int
+	for i := 0; i < 3; i++ {
+		active, err := merkle.GetActiveTreeForInserting(ctx, issuerDID)
+		if err != nil {
+			t.Fatalf("Failed to reserve a node: %v", err)
+		}
+		treeID = active.TreeID
+	}
+
+	// nodes are returned by node ID whatever the insertion order
+	for _, nodeID := range []int{3, 1, 2} {
+		node, err := merkle.AddNode(ctx, treeID, nodeID, leaf(nodeID))
+		if err != nil {
+			t.Fatalf("Failed to add node %d: %v", nodeID, err)
+		}
+		if node.TreeID != treeID || node.NodeID != nodeID || !bytes.Equal(node.Data, leaf(nodeID)) {
+			t.Errorf("Unexpected node returned: %+v", node)
+		}
+	}
+	// a node ID is unique within its tree
+	if _, err := merkle.AddNode(ctx, treeID, 2, leaf(4)); err == nil {
+		t.Errorf("Expected adding node 2 twice to fail")
+	}
+	if _, err := merkle.AddNodeAndIncrementNodeCount(ctx, treeID, 3, leaf(4)); err == nil {
+		t.Errorf("Expected adding node 3 twice to fail")
+	}
+
+	datas, err := merkle.GetNodesByTreeID(ctx, treeID)
+	if err != nil {
+		t.Fatalf("Failed to get nodes: %v", err)
+	}
+	if len(datas) != 3 {
+		t.Fatalf("Expected 3 nodes, got %d", len(datas))
+	}
+	for i, node := range datas {
+		if node.TreeID != treeID || node.NodeID != i+1 || !bytes.Equal(node.Data, leaf(i+1)) {
+			t.Errorf("Node %d is out of order", i+1)
+		}
+	}
+
+	if datas, err := merkle.GetNodesByTreeID(ctx, treeID+1<<20); err != nil || len(datas) != 0 {
+		t.Errorf("Expected no nodes for an unknown tree, got %d nodes and error %v", len(datas), err)
+	}
+	if count, last, err := merkle.CountNodes(ctx, treeID+1<<20); err != nil || count != 0 || last != 0 {
+		t.Errorf("Expected no nodes counted for an unknown tree, got %d up to %d and error %v", count, last, err)
+	}
+}
+
+func testNodeHashes(t *testing.T, merkle repo.Merkle) {
+	ctx := context.Background()
+	treeID := addLeaves(t, merkle, uniqueIssuer(t), 1)[0].TreeID
+	hashOf := func(index, size int) *entities.NodeHash {
+		return &entities.NodeHash{TreeID: treeID, Index: index, Size: size, Hash: leaf(index*100 + size)}
+	}
+
+	// the root changes at every size, node 3 only at size 3
+	err := merkle.SaveNodeHashes(ctx, []*entities.NodeHash{hashOf(1, 1), hashOf(2, 1), hashOf(1, 2), hashOf(1, 3), hashOf(3, 3)})
+	if err != nil {
+		t.Fatalf("Failed to save node hashes: %v", err)
+	}
+	// saving the same node and size again replaces its hash
+	replaced := hashOf(1, 3)
+	replaced.Hash = leaf(0)
+	if err := merkle.SaveNodeHashes(ctx, []*entities.NodeHash{replaced}); err != nil {
+		t.Fatalf("Failed to replace node hash: %v", err)
+	}
+	if err := merkle.SaveNodeHashes(ctx, nil); err != nil {
+		t.Errorf("Expected saving no hashes to succeed, got %v", err)
+	}
+
+	for _, tc := range []struct {
+		size     int
+		expected []*entities.NodeHash
+	}{
+		{size: 0},
+		{size: 1, expected: []*entities.NodeHash{hashOf(1, 1), hashOf(2, 1)}},
+		{size: 2, expected: []*entities.NodeHash{hashOf(1, 2), hashOf(2, 1)}},
+		{size: 3, expected: []*entities.NodeHash{replaced, hashOf(2, 1), hashOf(3, 3)}},
+		{size: 1 << 30, expected: []*entities.NodeHash{replaced, hashOf(2, 1), hashOf(3, 3)}},
+	} {
+		hashes, err := merkle.GetNodeHashes(ctx, treeID, tc.size, []int{3, 1, 2, 4})
+		if err != nil {
+			t.Fatalf("Failed to get node hashes at size %d: %v", tc.size, err)
+		}
+		slices.SortFunc(hashes, func(a, b *entities.NodeHash) int { return a.Index - b.Index })
+		if len(hashes) != len(tc.expected) {
+			t.Errorf("Expected %d hashes at size %d, got %d", len(tc.expected), tc.size, len(hashes))
+			continue
+		}
+		for i, hash := range hashes {
+			expected := tc.expected[i]
+			if hash.TreeID != treeID || hash.Index != expected.Index || hash.Size != expected.Size || !bytes.Equal(hash.Hash, expected.Hash) {
+				t.Errorf("Expected %+v at size %d, got %+v", expected, tc.size, hash)
+			}
+		}
+	}
+
+	if hashes, err := merkle.GetNodeHashes(ctx, treeID, 3, nil); err != nil || len(hashes) != 0 {
+		t.Errorf("Expected no hashes without indexes, got %d and error %v", len(hashes), err)
+	}
+	if hashes, err := merkle.GetNodeHashes(ctx, treeID+1<<20, 3, []int{1}); err != nil || len(hashes) != 0 {
+		t.Errorf("Expected no hashes for an unknown tree, got %d and error %v", len(hashes), err)
+	}
+	if err := merkle.SaveNodeHashes(ctx, []*entities.NodeHash{{TreeID: treeID + 1<<20, Index: 1, Size: 1, Hash: leaf(1)}}); err == nil {
+		t.Errorf("Expected saving hashes of an unknown tree to fail")
+	}
+}
+
+func testTreeRoots(t *testing.T, merkle repo.Merkle) {
+	ctx := context.Background()
+	treeID := addLeaves(t, merkle, uniqueIssuer(t), 1)[0].TreeID
+
+	roots := []*entities.TreeRoot{
+		{TreeID: treeID, Size: 3, Root: leaf(3)},
+		{TreeID: treeID, Size: 1, Root: leaf(1)},
+	}
+	if err := merkle.AddTreeRoots(ctx, roots); err != nil {
+		t.Fatalf("Failed to add tree roots: %v", err)
+	}
+	// the history is not rewritten
+	if err := merkle.AddTreeRoots(ctx, []*entities.TreeRoot{{TreeID: treeID, Size: 1, Root: leaf(0)}, {TreeID: treeID, Size: 2, Root: leaf(2)}}); err != nil {
+		t.Fatalf("Failed to add tree roots: %v", err)
+	}
+	if err := merkle.AddTreeRoots(ctx, nil); err != nil {
+		t.Errorf("Expected adding no roots to succeed, got %v", err)
+	}
+
+	history, err := merkle.GetTreeRoots(ctx, treeID)
+	if err != nil {
+		t.Fatalf("Failed to get tree roots: %v", err)
+	}
+	if len(history) != 3 {
+		t.Fatalf("Expected 3 roots, got %d", len(history))
+	}
+	for i, root := range history {
+		if root.TreeID != treeID || root.Size != i+1 || !bytes.Equal(root.Root, leaf(i+1)) {
+			t.Errorf("Unexpected root %d: %+v", i, root)
+		}
+	}
+
+	root, err := merkle.GetTreeRoot(ctx, treeID, 2)
+	if err != nil {
+		t.Fatalf("Failed to get tree root: %v", err)
+	}
+	if root == nil || root.TreeID != treeID || root.Size != 2 || !bytes.Equal(root.Root, leaf(2)) {
+		t.Errorf("Unexpected root at size 2: %+v", root)
+	}
+	if root, err := merkle.GetTreeRoot(ctx, treeID, 4); err != nil || root != nil {
+		t.Errorf("Expected no root at size 4, got %+v and error %v", root, err)
+	}
+	if roots, err := merkle.GetTreeRoots(ctx, treeID+1<<20); err != nil || len(roots) != 0 {
+		t.Errorf("Expected no roots for an unknown tree, got %d and error %v", len(roots), err)
+	}
+	if err := merkle.AddTreeRoots(ctx, []*entities.TreeRoot{{TreeID: treeID + 1<<20, Size: 1, Root: leaf(1)}}); err == nil {
+		t.Errorf("Expected adding roots of an unknown tree to fail")
+	}
+}
+
+func testSync(t *testing.T, merkle repo.Merkle) {
+	ctx := context.Background()
+	chainID := uniqueChain()
+	issuerDID := uniqueIssuer(t)
+	nodes := addLeaves(t, merkle, issuerDID, 3)
+	treeID := nodes[0].TreeID
+
+	// a reserved node not inserted yet is not synced
+	if _, err := merkle.GetActiveTreeForInserting(ctx, issuerDID); err != nil {
+		t.Fatalf("Failed to reserve a node: %v", err)
+	}
+	due := treesForSync(t, merkle, chainID, treeID)
+	if len(due[treeID]) != 3 {
+		t.Fatalf("Expected Tree ID %d due with 3 nodes, got %d", treeID, len(due[treeID]))
+	}
+	for i, node := range due[treeID] {
+		if node.TreeID != treeID || node.NodeID != i+1 || !bytes.Equal(node.Data, leaf(i+1)) {
+			t.Errorf("Unexpected node to sync: %+v", node)
+		}
+	}
+
+	// a pending sync keeps the tree out of the next runs
+	treeSync := &entities.TreeSync{TreeID: treeID, ChainID: chainID, NodeCount: 3, Root: leaf(0), IssuerAddress: "0x01"}
+	if err := merkle.CreatePendingSyncs(ctx, []*entities.TreeSync{treeSync}); err != nil {
+		t.Fatalf("Failed to create pending sync: %v", err)
+	}
+	if treeSync.ID == 0 || treeSync.Status != entities.SyncStatusPending {
+		t.Errorf("Expected the pending sync to get an ID and the pending status, got %+v", treeSync)
+	}
+	if due := treesForSync(t, merkle, chainID, treeID); len(due) != 0 {
+		t.Errorf("Expected no tree due while its sync is pending")
+	}
+	if err := merkle.SetSyncTransaction(ctx, []int{treeSync.ID}, "0xabc", 7); err != nil {
+		t.Fatalf("Failed to set sync transaction: %v", err)
+	}
+	pending, err := merkle.GetPendingSyncs(ctx, chainID)
+	if err != nil {
+		t.Fatalf("Failed to get pending syncs: %v", err)
+	}
+	if len(pending) != 1 || pending[0].ID != treeSync.ID || pending[0].TxHash != "0xabc" || pending[0].Nonce != 7 ||
+		pending[0].NodeCount != 3 || !bytes.Equal(pending[0].Root, leaf(0)) || pending[0].IssuerAddress != "0x01" {
+		t.Fatalf("Unexpected pending syncs: %+v", pending)
+	}
+
+	// nothing is synced before the confirmation
+	if synced, err := merkle.GetNodesSyncedByTreeID(ctx, treeID, chainID); err != nil || len(synced) != 0 {
+		t.Errorf("Expected no synced nodes before the confirmation, got %d and error %v", len(synced), err)
+	}
+	if last, err := merkle.GetLastConfirmedSync(ctx, treeID, chainID); err != nil || last != nil {
+		t.Errorf("Expected no confirmed sync, got %+v and error %v", last, err)
+	}
+
+	if err := merkle.ConfirmSyncs(ctx, chainID, "0xabc", 42); err != nil {
+		t.Fatalf("Failed to confirm syncs: %v", err)
+	}
+	if pending, _ := merkle.GetPendingSyncs(ctx, chainID); len(pending) != 0 {
+		t.Errorf("Expected no pending syncs after the confirmation, got %d", len(pending))
+	}
+	synced, err := merkle.GetNodesSyncedByTreeID(ctx, treeID, chainID)
+	if err != nil {
+		t.Fatalf("Failed to get synced nodes: %v", err)
+	}
+	if len(synced) != 3 {
+		t.Errorf("Expected 3 synced nodes, got %d", len(synced))
+	}
+	last, err := merkle.GetLastConfirmedSync(ctx, treeID, chainID)
+	if err != nil {
+		t.Fatalf("Failed to get last confirmed sync: %v", err)
+	}
+	if last == nil || last.ID != treeSync.ID || last.Status != entities.SyncStatusConfirmed || last.BlockNumber != 42 {
+		t.Errorf("Unexpected last confirmed sync: %+v", last)
+	}
+	trees, err := merkle.GetSyncedTrees(ctx, chainID)
+	if err != nil {
+		t.Fatalf("Failed to get synced trees: %v", err)
+	}
+	if len(trees) != 1 || trees[0].ID != treeID || trees[0].NodeCountSync != 3 || trees[0].NodeCount != 4 || trees[0].NeedSync || trees[0].IssuerDID != issuerDID {
+		t.Errorf("Unexpected synced trees: %+v", trees)
+	}
+
+	// the reserved node is still due once inserted
+	if _, err := merkle.AddNode(ctx, treeID, 4, leaf(4)); err != nil {
+		t.Fatalf("Failed to add node: %v", err)
+	}
+	if due := treesForSync(t, merkle, chainID, treeID); len(due[treeID]) != 4 {
+		t.Errorf("Expected Tree ID %d due with 4 nodes, got %d", treeID, len(due[treeID]))
+	}
+	syncTree(t, merkle, chainID, treeID, 4, "0xdef")
+	if due := treesForSync(t, merkle, chainID, treeID); len(due) != 0 {
+		t.Errorf("Expected no tree due once fully synced")
+	}
+
+	// a tree marked for sync is due again without new nodes
+	if err := merkle.MarkTreesForSync(ctx, chainID, []int{treeID}); err != nil {
+		t.Fatalf("Failed to mark trees for sync: %v", err)
+	}
+	if due := treesForSync(t, merkle, chainID, treeID); len(due[treeID]) != 4 {
+		t.Errorf("Expected the marked Tree ID %d due with 4 nodes, got %d", treeID, len(due[treeID]))
+	}
+	if trees, _ := merkle.GetSyncedTrees(ctx, chainID); len(trees) != 1 || !trees[0].NeedSync {
+		t.Errorf("Expected the synced tree to be marked for sync, got %+v", trees)
+	}
+	syncTree(t, merkle, chainID, treeID, 4, "0x123")
+	if due := treesForSync(t, merkle, chainID, treeID); len(due) != 0 {
+		t.Errorf("Expected the mark to be cleared by the confirmation")
+	}
+
+	// an older sync confirmed late does not move the synced node count back
+	syncTree(t, merkle, chainID, treeID, 2, "0x456")
+	if synced, _ := merkle.GetNodesSyncedByTreeID(ctx, treeID, chainID); len(synced) != 4 {
+		t.Errorf("Expected 4 synced nodes after a late confirmation, got %d", len(synced))
+	}
+	if last, _ := merkle.GetLastConfirmedSync(ctx, treeID, chainID); last == nil || last.NodeCount != 4 {
+		t.Errorf("Expected the last confirmed sync to be the largest, got %+v", last)
+	}
+}
+
+func testSyncPerChain(t *testing.T, merkle repo.Merkle) {
+	ctx := context.Background()
+	primary, secondary := uniqueChain(), uniqueChain()
+	nodes := addLeaves(t, merkle, uniqueIssuer(t), 2)
+	treeID := nodes[0].TreeID
+
+	syncTree(t, merkle, primary, treeID, 2, "0xaaa")
+	if due := treesForSync(t, merkle, primary, treeID); len(due) != 0 {
+		t.Errorf("Expected no tree due on the synced chain")
+	}
+	if due := treesForSync(t, merkle, secondary, treeID); len(due[treeID]) != 2 {
+		t.Errorf("Expected Tree ID %d due on the other chain, got %d nodes", treeID, len(due[treeID]))
+	}
+	if synced, _ := merkle.GetNodesSyncedByTreeID(ctx, treeID, secondary); len(synced) != 0 {
+		t.Errorf("Expected no synced nodes on the other chain, got %d", len(synced))
+	}
+	if trees, _ := merkle.GetSyncedTrees(ctx, secondary); len(trees) != 0 {
+		t.Errorf("Expected no synced trees on the other chain, got %d", len(trees))
+	}
+
+	// a pending sync and a confirmation only apply to their chain
+	treeSync := &entities.TreeSync{TreeID: treeID, ChainID: secondary, NodeCount: 2, Root: leaf(0)}
+	if err := merkle.CreatePendingSyncs(ctx, []*entities.TreeSync{treeSync}); err != nil {
+		t.Fatalf("Failed to create pending sync: %v", err)
+	}
+	if err := merkle.SetSyncTransaction(ctx, []int{treeSync.ID}, "0xaaa", 1); err != nil {
+		t.Fatalf("Failed to set sync transaction: %v", err)
+	}
+	if pending, _ := merkle.GetPendingSyncs(ctx, primary); len(pending) != 0 {
+		t.Errorf("Expected no pending syncs on the primary chain, got %d", len(pending))
+	}
+	if err := merkle.MarkTreesForSync(ctx, primary, []int{treeID}); err != nil {
+		t.Fatalf("Failed to mark trees for sync: %v", err)
+	}
+	if due := treesForSync(t, merkle, primary, treeID); len(due[treeID]) != 2 {
+		t.Errorf("Expected the marked Tree ID %d due on the primary chain despite a sync pending on another", treeID)
+	}
+	if err := merkle.ConfirmSyncs(ctx, primary, "0xaaa", 1); err != nil {
+		t.Fatalf("Failed to confirm syncs: %v", err)
+	}
+	if pending, _ := merkle.GetPendingSyncs(ctx, secondary); len(pending) != 1 {
+		t.Errorf("Expected the sync of the other chain to stay pending, got %d pending", len(pending))
+	}
+}
+
+func testFailedSync(t *testing.T, merkle repo.Merkle) {
+	ctx := context.Background()
+	chainID := uniqueChain()
+	nodes := addLeaves(t, merkle, uniqueIssuer(t), 2)
+	treeID := nodes[0].TreeID
+	syncTree(t, merkle, chainID, treeID, 1, "0x111")
+
+	treeSync := &entities.TreeSync{TreeID: treeID, ChainID: chainID, NodeCount: 2, Root: leaf(0)}
+	if err := merkle.CreatePendingSyncs(ctx, []*entities.TreeSync{treeSync}); err != nil {
+		t.Fatalf("Failed to create pending sync: %v", err)
+	}
+	if err := merkle.SetSyncTransaction(ctx, []int{treeSync.ID}, "0x222", 2); err != nil {
+		t.Fatalf("Failed to set sync transaction: %v", err)
+	}
+	if err := merkle.FailSyncs(ctx, []int{treeSync.ID}, entities.SyncStatusReplaced, "nonce reused"); err != nil {
+		t.Fatalf("Failed to fail syncs: %v", err)
+	}
+
+	// the tree is due again and its sync state is untouched
+	if due := treesForSync(t, merkle, chainID, treeID); len(due[treeID]) != 2 {
+		t.Errorf("Expected Tree ID %d due again after a failed sync, got %d nodes", treeID, len(due[treeID]))
+	}
+	if synced, _ := merkle.GetNodesSyncedByTreeID(ctx, treeID, chainID); len(synced) != 1 {
+		t.Errorf("Expected 1 synced node after a failed sync, got %d", len(synced))
+	}
+	if last, _ := merkle.GetLastConfirmedSync(ctx, treeID, chainID); last == nil || last.NodeCount != 1 {
+		t.Errorf("Expected the last confirmed sync to stay at node 1, got %+v", last)
+	}
+
+	// a closed sync is neither confirmed nor updated anymore
+	if err := merkle.SetSyncTransaction(ctx, []int{treeSync.ID}, "0x333", 3); err != nil {
+		t.Fatalf("Failed to set sync transaction: %v", err)
+	}
+	if err := merkle.ConfirmSyncs(ctx, chainID, "0x222", 5); err != nil {
+		t.Fatalf("Failed to confirm syncs: %v", err)
+	}
+	if err := merkle.ConfirmSyncs(ctx, chainID, "0x333", 5); err != nil {
+		t.Fatalf("Failed to confirm syncs: %v", err)
+	}
+	if synced, _ := merkle.GetNodesSyncedByTreeID(ctx, treeID, chainID); len(synced) != 1 {
+		t.Errorf("Expected a failed sync not to be confirmed, got %d synced nodes", len(synced))
+	}
+	if err := merkle.FailSyncs(ctx, []int{treeSync.ID}, entities.SyncStatusFailed, "reverted"); err != nil {
+		t.Fatalf("Failed to fail syncs: %v", err)
+	}
+	if pending, _ := merkle.GetPendingSyncs(ctx, chainID); len(pending) != 0 {
+		t.Errorf("Expected no pending syncs, got %d", len(pending))
+	}
+}
+
+func testCheckpoint(t *testing.T, merkle repo.Merkle) {
+	ctx := context.Background()
+	chainID := uniqueChain()
+	first := addLeaves(t, merkle, uniqueIssuer(t), 1)[0]
+	second := addLeaves(t, merkle, uniqueIssuer(t), 1)[0]
+
+	checkpoint := &entities.Checkpoint{ChainID: chainID, IssuerAddress: "0x0c", Root: leaf(0)}
+	if err := merkle.CreateCheckpoint(ctx, checkpoint); err != nil {
+		t.Fatalf("Failed to create checkpoint: %v", err)
+	}
+	if checkpoint.ID == 0 {
+		t.Fatalf("Expected the checkpoint to get an ID")
+	}
+
+	// syncs are returned by checkpoint position
+	syncs := []*entities.TreeSync{
+		{TreeID: second.TreeID, ChainID: chainID, NodeCount: 1, Root: leaf(2), CheckpointID: checkpoint.ID, CheckpointPosition: 1},
+		{TreeID: first.TreeID, ChainID: chainID, NodeCount: 1, Root: leaf(1), CheckpointID: checkpoint.ID, CheckpointPosition: 0},
+	}
+	if err := merkle.CreatePendingSyncs(ctx, syncs); err != nil {
+		t.Fatalf("Failed to create pending syncs: %v", err)
+	}
+	stored, err := merkle.GetCheckpoint(ctx, checkpoint.ID)
+	if err != nil {
+		t.Fatalf("Failed to get checkpoint: %v", err)
+	}
+	if stored == nil || stored.ChainID != chainID || stored.IssuerAddress != "0x0c" || !bytes.Equal(stored.Root, leaf(0)) {
+		t.Fatalf("Unexpected checkpoint: %+v", stored)
+	}
+	if len(stored.Syncs) != 2 || stored.Syncs[0].TreeID != first.TreeID || stored.Syncs[1].TreeID != second.TreeID {
+		t.Fatalf("Expected the syncs ordered by checkpoint position, got %+v", stored.Syncs)
+	}
+	if stored.Syncs[0].ID != syncs[1].ID || stored.Syncs[1].CheckpointPosition != 1 {
+		t.Errorf("Unexpected checkpoint syncs: %+v", stored.Syncs)
+	}
+
+	missing, err := merkle.GetCheckpoint(ctx, checkpoint.ID+1<<20)
+	if err != nil || missing != nil {
+		t.Errorf("Expected no checkpoint for an unknown ID, got %+v and error %v", missing, err)
+	}
+}
+
+// treeToSeal returns whether the tree is ready to be sealed on the chains
+func treeToSeal(t *testing.T, merkle repo.Merkle, treeID int, chainIDs ...uint64) bool {
+	t.Helper()
+	trees, err := merkle.GetTreesToSeal(context.Background(), chainIDs)
+	if err != nil {
+		t.Fatalf("Failed to get trees to seal: %v", err)
+	}
+	return slices.ContainsFunc(trees, func(tree *entities.MerkleTree) bool { return tree.ID == treeID })
+}
+
+func testSeal(t *testing.T, merkle repo.Merkle) {
+	ctx := context.Background()
+	primary, secondary := uniqueChain(), uniqueChain()
+	nodes := addLeaves(t, merkle, uniqueIssuer(t), utils.MAX_LEAFS)
+	treeID := nodes[0].TreeID
+	if err := merkle.SaveNodeHashes(ctx, []*entities.NodeHash{{TreeID: treeID, Index: 1, Size: utils.MAX_LEAFS, Hash: leaf(0)}}); err != nil {
+		t.Fatalf("Failed to save node hashes: %v", err)
+	}
+
+	// a full tree is sealed once synced at its last node on every chain
+	syncTree(t, merkle, primary, treeID, utils.MAX_LEAFS-1, "0xa01")
+	syncTree(t, merkle, secondary, treeID, utils.MAX_LEAFS, "0xb01")
+	if treeToSeal(t, merkle, treeID, primary, secondary) {
+		t.Errorf("Expected Tree ID %d not to be sealed before its last node is synced on every chain", treeID)
+	}
+	treeSync := syncTree(t, merkle, primary, treeID, utils.MAX_LEAFS, "0xa02")
+	if !treeToSeal(t, merkle, treeID, primary, secondary) {
+		t.Fatalf("Expected Tree ID %d to be sealed", treeID)
+	}
+	if trees, err := merkle.GetTreesToSeal(ctx, nil); err != nil || len(trees) != 0 {
+		t.Errorf("Expected no trees to seal without chains, got %d and error %v", len(trees), err)
+	}
+
+	seal := &entities.TreeSeal{TreeID: treeID, Root: treeSync.Root, ChainID: primary, TxHash: "0xa02"}
+	if err := merkle.SealTree(ctx, seal); err != nil {
+		t.Fatalf("Failed to seal tree: %v", err)
+	}
+	if seal.SealedAt.IsZero() {
+		t.Errorf("Expected the seal to get its time")
+	}
+	if err := merkle.SealTree(ctx, &entities.TreeSeal{TreeID: treeID, Root: treeSync.Root, ChainID: primary, TxHash: "0xa02"}); err == nil {
+		t.Errorf("Expected sealing Tree ID %d twice to fail", treeID)
+	}
+	if treeToSeal(t, merkle, treeID, primary, secondary) {
+		t.Errorf("Expected the sealed Tree ID %d not to be sealed again", treeID)
+	}
+
+	stored, err := merkle.GetTreeSeal(ctx, treeID)
+	if err != nil {
+		t.Fatalf("Failed to get tree seal: %v", err)
+	}
+	if stored == nil || !bytes.Equal(stored.Root, treeSync.Root) || stored.ChainID != primary || stored.TxHash != "0xa02" || stored.SealedAt.IsZero() {
+		t.Fatalf("Unexpected seal: %+v", stored)
+	}
+	if len(stored.Leaves) != utils.MAX_LEAFS {
+		t.Fatalf("Expected %d sealed leaves, got %d", utils.MAX_LEAFS, len(stored.Leaves))
+	}
+	for i, node := range nodes {
+		if !bytes.Equal(stored.Leaves[i], node.Data) {
+			t.Errorf("Unexpected sealed leaf %d", i+1)
+		}
+	}
+
+	// the nodes are still read from the archive, the node hashes are dropped
+	datas, err := merkle.GetNodesByTreeID(ctx, treeID)
+	if err != nil || len(datas) != utils.MAX_LEAFS || datas[3].NodeID != 4 || !bytes.Equal(datas[3].Data, nodes[3].Data) {
+		t.Errorf("Expected the %d sealed nodes, got %d and error %v", utils.MAX_LEAFS, len(datas), err)
+	}
+	if count, last, err := merkle.CountNodes(ctx, treeID); err != nil || count != 0 || last != 0 {
+		t.Errorf("Expected no nodes counted once archived, got %d up to %d and error %v", count, last, err)
+	}
+	if synced, err := merkle.GetNodesSyncedByTreeID(ctx, treeID, primary); err != nil || len(synced) != utils.MAX_LEAFS || synced[0].NodeID != 1 {
+		t.Errorf("Expected the %d sealed nodes synced, got %d and error %v", utils.MAX_LEAFS, len(synced), err)
+	}
+	if hashes, err := merkle.GetNodeHashes(ctx, treeID, utils.MAX_LEAFS, []int{1}); err != nil || len(hashes) != 0 {
+		t.Errorf("Expected no node hashes of a sealed tree, got %d and error %v", len(hashes), err)
+	}
+
+	// a sealed tree marked for sync is synced from its archive
+	if err := merkle.MarkTreesForSync(ctx, primary, []int{treeID}); err != nil {
+		t.Fatalf("Failed to mark trees for sync: %v", err)
+	}
+	if due := treesForSync(t, merkle, primary, treeID); len(due[treeID]) != utils.MAX_LEAFS || due[treeID][31].NodeID != utils.MAX_LEAFS {
+		t.Errorf("Expected the sealed Tree ID %d due with its %d nodes, got %d", treeID, utils.MAX_LEAFS, len(due[treeID]))
+	}
+
+	// only full trees are sealed
+	partial := addLeaves(t, merkle, uniqueIssuer(t), 1)[0].TreeID
+	if err := merkle.SealTree(ctx, &entities.TreeSeal{TreeID: partial, Root: leaf(0), ChainID: primary, TxHash: "0xa03"}); err == nil {
+		t.Errorf("Expected sealing the partial Tree ID %d to fail", partial)
+	}
+	if missing, err := merkle.GetTreeSeal(ctx, partial); err != nil || missing != nil {
+		t.Errorf("Expected no seal of an unsealed tree, got %+v and error %v", missing, err)
+	}
+}
+
+// sameSnapshot reports the first difference between two snapshots of the tree, ignoring sync IDs
+func sameSnapshot(expected, actual *entities.TreeSnapshot) error {
+	tree, other := *expected.Tree, *actual.Tree
+	if tree != other {
+		return fmt.Errorf("tree %+v, expected %+v", other, tree)
+	}
+	if len(actual.Nodes) != len(expected.Nodes) || len(actual.Roots) != len(expected.Roots) || len(actual.Chains) != len(expected.Chains) || len(actual.Syncs) != len(expected.Syncs) {
+		return fmt.Errorf("%d nodes, %d roots, %d chains and %d syncs, expected %d, %d, %d and %d", len(actual.Nodes), len(actual.Roots), len(actual.Chains), len(actual.Syncs), len(expected.Nodes), len(expected.Roots), len(expected.Chains), len(expected.Syncs))
+	}
+	for i, node := range expected.Nodes {
+		if actual.Nodes[i].TreeID != tree.ID || actual.Nodes[i].NodeID != node.NodeID || !bytes.Equal(actual.Nodes[i].Data, node.Data) {
+			return fmt.Errorf("node %+v, expected %+v", actual.Nodes[i], node)
+		}
+	}
+	for i, root := range expected.Roots {
+		if actual.Roots[i].TreeID != tree.ID || actual.Roots[i].Size != root.Size || !bytes.Equal(actual.Roots[i].Root, root.Root) {
+			return fmt.Errorf("root %+v, expected %+v", actual.Roots[i], root)
+		}
+	}
+	for i, chain := range expected.Chains {
+		if *actual.Chains[i] != *chain {
+			return fmt.Errorf("chain %+v, expected %+v", actual.Chains[i], chain)
+		}
+	}
+	for i, treeSync := range expected.Syncs {
+		copied := *actual.Syncs[i]
+		copied.ID = treeSync.ID
+		if !reflect.DeepEqual(&copied, treeSync) {
+			return fmt.Errorf("sync %+v, expected %+v", actual.Syncs[i], treeSync)
+		}
+	}
+	if (actual.Seal == nil) != (expected.Seal == nil) {
+		return fmt.Errorf("seal %+v, expected %+v", actual.Seal, expected.Seal)
+	}
+	if seal := actual.Seal; seal != nil && (!bytes.Equal(seal.Root, expected.Seal.Root) || seal.TxHash != expected.Seal.TxHash || !seal.SealedAt.Equal(expected.Seal.SealedAt)) {
+		return fmt.Errorf("seal %+v, expected %+v", seal, expected.Seal)
+	}
+	return nil
+}
+
+// moveSnapshot returns a copy of the snapshot for another tree ID
+func moveSnapshot(snapshot *entities.TreeSnapshot, treeID int) *entities.TreeSnapshot {
+	moved := *snapshot
+	tree := *snapshot.Tree
+	tree.ID = treeID
+	moved.Tree = &tree
+	moved.Nodes, moved.Roots, moved.Chains, moved.Syncs = nil, nil, nil, nil
+	for _, node := range snapshot.Nodes {
+		copied := *node
+		copied.TreeID = treeID
+		moved.Nodes = append(moved.Nodes, &copied)
+	}
+	for _, root := range snapshot.Roots {
+		copied := *root
+		copied.TreeID = treeID
+		moved.Roots = append(moved.Roots, &copied)
+	}
+	for _, chain := range snapshot.Chains {
+		copied := *chain
+		copied.TreeID = treeID
+		moved.Chains = append(moved.Chains, &copied)
+	}
+	for _, treeSync := range snapshot.Syncs {
+		copied := *treeSync
+		copied.TreeID = treeID
+		moved.Syncs = append(moved.Syncs, &copied)
+	}
+	return &moved
+}
+
+func testSnapshot(t *testing.T, merkle repo.Merkle) {
+	ctx := context.Background()
+	chainID := uniqueChain()
+	issuerDID := uniqueIssuer(t)
+
+	// a sealed full tree and an active one with a node reserved but not filled yet
+	full := addLeaves(t, merkle, issuerDID, utils.MAX_LEAFS)
+	syncTree(t, merkle, chainID, full[0].TreeID, utils.MAX_LEAFS, "0xc01")
+	if err := merkle.SealTree(ctx, &entities.TreeSeal{TreeID: full[0].TreeID, Root: leaf(-utils.MAX_LEAFS), ChainID: chainID, TxHash: "0xc01"}); err != nil {
+		t.Fatalf("Failed to seal tree: %v", err)
+	}
+	active := addLeaves(t, merkle, issuerDID, 3)
+	syncTree(t, merkle, chainID, active[0].TreeID, 2, "0xc02")
+	if err := merkle.AddTreeRoots(ctx, []*entities.TreeRoot{{TreeID: active[0].TreeID, Size: 2, Root: leaf(2)}, {TreeID: active[0].TreeID, Size: 3, Root: leaf(3)}}); err != nil {
+		t.Fatalf("Failed to add tree roots: %v", err)
+	}
+	if _, err := merkle.GetActiveTreeForInserting(ctx, issuerDID); err != nil {
+		t.Fatalf("Failed to reserve a node: %v", err)
+	}
+
+	trees, err := merkle.GetIssuerTrees(ctx, issuerDID)
+	if err != nil {
+		t.Fatalf("Failed to get issuer trees: %v", err)
+	}
+	if len(trees) != 2 || trees[0].ID != full[0].TreeID || trees[1].ID != active[0].TreeID || trees[1].NodeCount != 4 {
+		t.Fatalf("Expected the full and active trees of the issuer, got %+v", trees)
+	}
+	issuers, err := merkle.GetIssuers(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get issuers: %v", err)
+	}
+	i := slices.IndexFunc(issuers, func(issuer *entities.IssuerTrees) bool { return issuer.IssuerDID == issuerDID })
+	if i < 0 || issuers[i].Trees != 2 || issuers[i].NodeCount != utils.MAX_LEAFS+4 || issuers[i].LastTreeID != active[0].TreeID {
+		t.Errorf("Expected the issuer with its 2 trees, got %+v", issuers)
+	}
+	if !slices.IsSortedFunc(issuers, func(a, b *entities.IssuerTrees) int { return strings.Compare(a.IssuerDID, b.IssuerDID) }) {
+		t.Errorf("Expected the issuers ordered by DID")
+	}
+
+	var snapshots []*entities.TreeSnapshot
+	for _, tree := range trees {
+		snapshot, err := merkle.GetTreeSnapshot(ctx, tree.ID)
+		if err != nil {
+			t.Fatalf("Failed to get snapshot of tree ID %d: %v", tree.ID, err)
+		}
+		if snapshot == nil || snapshot.Tree.IssuerDID != issuerDID || snapshot.Tree.NodeCount != tree.NodeCount {
+			t.Fatalf("Unexpected snapshot of tree ID %d: %+v", tree.ID, snapshot)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	sealed, partial := snapshots[0], snapshots[1]
+	if sealed.Seal == nil || len(sealed.Nodes) != utils.MAX_LEAFS || !bytes.Equal(sealed.Nodes[5].Data, full[5].Data) {
+		t.Errorf("Expected the sealed tree with its archived nodes, got seal %+v and %d nodes", sealed.Seal, len(sealed.Nodes))
+	}
+	if partial.Seal != nil || len(partial.Nodes) != 3 || len(partial.Roots) != 2 || len(partial.Syncs) != 1 || partial.Syncs[0].TxHash != "0xc02" {
+		t.Errorf("Unexpected snapshot of the active tree: %+v", partial)
+	}
+	if len(partial.Chains) != 1 || partial.Chains[0].ChainID != chainID || partial.Chains[0].NodeCountSync != 2 {
+		t.Errorf("Unexpected sync state of the active tree: %+v", partial.Chains)
+	}
+	if missing, err := merkle.GetTreeSnapshot(ctx, active[0].TreeID+1<<20); err != nil || missing != nil {
+		t.Errorf("Expected no snapshot of an unknown tree, got %+v and error %v", missing, err)
+	}
+
+	// the trees are restored under other IDs, all or none
+	sealedID := active[0].TreeID + 1000 + rand.IntN(1000)
+	partialID := sealedID + 1
+	if err := merkle.ImportTrees(ctx, []*entities.TreeSnapshot{moveSnapshot(sealed, sealedID), partial}); err == nil {
+		t.Fatalf("Expected the import of an existing tree ID to fail")
+	}
+	if snapshot, _ := merkle.GetTreeSnapshot(ctx, sealedID); snapshot != nil {
+		t.Fatalf("Expected no tree imported by a failed import")
+	}
+	if err := merkle.ImportTrees(ctx, []*entities.TreeSnapshot{moveSnapshot(sealed, sealedID), moveSnapshot(partial, partialID)}); err != nil {
+		t.Fatalf("Failed to import trees: %v", err)
+	}
+	for treeID, snapshot := range map[int]*entities.TreeSnapshot{sealedID: sealed, partialID: partial} {
+		imported, err := merkle.GetTreeSnapshot(ctx, treeID)
+		if err != nil || imported == nil {
+			t.Fatalf("Failed to get imported tree ID %d: %v", treeID, err)
+		}
+		if err := sameSnapshot(moveSnapshot(snapshot, treeID), imported); err != nil {
+			t.Errorf("Imported tree ID %d differs: %v", treeID, err)
+		}
+	}
+	if last, _ := merkle.GetLastConfirmedSync(ctx, partialID, chainID); last == nil || last.NodeCount != 2 || last.TxHash != "0xc02" {
+		t.Errorf("Expected the imported confirmed sync, got %+v", last)
+	}
+	if seal, _ := merkle.GetTreeSeal(ctx, sealedID); seal == nil || len(seal.Leaves) != utils.MAX_LEAFS {
+		t.Errorf("Expected the imported tree sealed with its leaves, got %+v", seal)
+	}
+
+	// the trees created next get IDs after the imported ones
+	created, err := merkle.GetActiveTreeForInserting(ctx, uniqueIssuer(t))
+	if err != nil {
+		t.Fatalf("Failed to reserve a node: %v", err)
+	}
+	if created.TreeID <= partialID {
+		t.Errorf("Expected a tree ID after the imported %d, got %d", partialID, created.TreeID)
+	}
+}
@@ -0,0 +1,207 @@
+package storagetest
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"merkle_module/domain/entities"
+	"merkle_module/domain/repo"
+	"merkle_module/statuslist"
+)
+
+// TestStatusList runs the conformance suite of repo.StatusList against the repositories returned
+// by newRepo, called once per subtest. Like TestMerkle, it only relies on the lists it creates.
+func TestStatusList(t *testing.T, newRepo func(t *testing.T) repo.StatusList) {
+	t.Run("AllocateIndex", func(t *testing.T) { testAllocateIndex(t, newRepo(t)) })
+	t.Run("ConcurrentAllocateIndex", func(t *testing.T) { testConcurrentAllocateIndex(t, newRepo(t)) })
+	t.Run("SetStatus", func(t *testing.T) { testSetStatus(t, newRepo(t)) })
+	t.Run("Publish", func(t *testing.T) { testPublish(t, newRepo(t)) })
+}
+
+// listsForPublish returns the lists of the issuers waiting to be published by status list ID
+func listsForPublish(t *testing.T, lists repo.StatusList, issuerDIDs ...string) map[int]*entities.StatusList {
+	t.Helper()
+	all, err := lists.GetStatusListsForPublish(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get status lists for publish: %v", err)
+	}
+	byID := make(map[int]*entities.StatusList)
+	for i, list := range all {
+		if i > 0 && all[i-1].IssuerDID > list.IssuerDID {
+			t.Errorf("Expected the lists ordered by issuer DID, got %s after %s", list.IssuerDID, all[i-1].IssuerDID)
+		}
+		for _, issuerDID := range issuerDIDs {
+			if list.IssuerDID == issuerDID {
+				byID[list.ID] = list
+			}
+		}
+	}
+	return byID
+}
+
+func testAllocateIndex(t *testing.T, lists repo.StatusList) {
+	ctx := context.Background()
+	issuerDID, otherDID := uniqueIssuer(t), uniqueIssuer(t)
+
+	// the first list of the issuer is created by its first allocation, indexes follow each other
+	var first *entities.StatusEntry
+	for i := 0; i < 3; i++ {
+		entry, err := lists.AllocateIndex(ctx, issuerDID, entities.StatusPurposeRevocation)
+		if err != nil {
+			t.Fatalf("Failed to allocate index: %v", err)
+		}
+		if first == nil {
+			first = entry
+		}
+		expected := entities.StatusEntry{StatusListID: first.StatusListID, ListID: issuerDID + "#revocation-1", Purpose: entities.StatusPurposeRevocation, Index: i}
+		if *entry != expected {
+			t.Errorf("Expected entry %+v, got %+v", expected, *entry)
+		}
+	}
+
+	// every issuer has its own lists
+	other, err := lists.AllocateIndex(ctx, otherDID, entities.StatusPurposeRevocation)
+	if err != nil {
+		t.Fatalf("Failed to allocate index: %v", err)
+	}
+	if other.StatusListID == first.StatusListID || other.ListID != otherDID+"#revocation-1" || other.Index != 0 {
+		t.Errorf("Expected the first index of a new list, got %+v", other)
+	}
+
+	list, err := lists.GetStatusList(ctx, first.StatusListID)
+	if err != nil {
+		t.Fatalf("Failed to get status list: %v", err)
+	}
+	if list.IssuerDID != issuerDID || list.NextIndex != 3 || list.Size != statuslist.MIN_SIZE || len(list.Bits) != statuslist.MIN_SIZE/8 || list.Version != 0 {
+		t.Errorf("Expected an empty list of %d bits with 3 indexes allocated, got %s with next index %d, size %d and version %d", statuslist.MIN_SIZE, list.IssuerDID, list.NextIndex, list.Size, list.Version)
+	}
+	byListID, err := lists.GetStatusListByListID(ctx, first.ListID)
+	if err != nil || byListID.ID != first.StatusListID {
+		t.Errorf("Expected status list %d by its list ID, got %+v and error %v", first.StatusListID, byListID, err)
+	}
+	if _, err := lists.GetStatusListByListID(ctx, issuerDID+"#revocation-2"); err == nil {
+		t.Errorf("Expected an unknown list ID to fail")
+	}
+}
+
+func testConcurrentAllocateIndex(t *testing.T, lists repo.StatusList) {
+	ctx := context.Background()
+	issuerDID := uniqueIssuer(t)
+	if _, err := lists.AllocateIndex(ctx, issuerDID, entities.StatusPurposeRevocation); err != nil {
+		t.Fatalf("Failed to allocate index: %v", err)
+	}
+
+	const workers = 8
+	var wg sync.WaitGroup
+	entries := make([]*entities.StatusEntry, workers)
+	errs := make([]error, workers)
+	for i := range entries {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			entries[i], errs[i] = lists.AllocateIndex(ctx, issuerDID, entities.StatusPurposeRevocation)
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[int]bool)
+	for i, entry := range entries {
+		if errs[i] != nil {
+			t.Fatalf("Failed to allocate index: %v", errs[i])
+		}
+		if seen[entry.Index] || entry.Index < 1 || entry.Index > workers {
+			t.Errorf("Expected distinct indexes from 1 to %d, got %d twice or out of range", workers, entry.Index)
+		}
+		seen[entry.Index] = true
+	}
+}
+
+func testSetStatus(t *testing.T, lists repo.StatusList) {
+	ctx := context.Background()
+	issuerDID := uniqueIssuer(t)
+	var entry *entities.StatusEntry
+	for i := 0; i < 10; i++ {
+		var err error
+		if entry, err = lists.AllocateIndex(ctx, issuerDID, entities.StatusPurposeRevocation); err != nil {
+			t.Fatalf("Failed to allocate index: %v", err)
+		}
+	}
+
+	// index 9 is bit 1 of byte 1, the left-most bit of a byte comes first
+	list, err := lists.SetStatus(ctx, entry.StatusListID, 9, true)
+	if err != nil {
+		t.Fatalf("Failed to set status: %v", err)
+	}
+	if list.Version != 1 || list.Bits[1] != 0x40 {
+		t.Errorf("Expected version 1 with bit 9 set, got version %d and byte %08b", list.Version, list.Bits[1])
+	}
+	if stored, err := lists.GetStatusList(ctx, entry.StatusListID); err != nil || stored.Version != 1 || stored.Bits[1] != 0x40 {
+		t.Errorf("Expected the stored list at version 1 with bit 9 set, got %+v and error %v", stored, err)
+	}
+
+	// setting the current value again does not change the list
+	if list, err := lists.SetStatus(ctx, entry.StatusListID, 9, true); err != nil || list.Version != 1 {
+		t.Errorf("Expected the list kept at version 1, got %+v and error %v", list, err)
+	}
+	list, err = lists.SetStatus(ctx, entry.StatusListID, 9, false)
+	if err != nil {
+		t.Fatalf("Failed to unset status: %v", err)
+	}
+	if list.Version != 2 || list.Bits[1] != 0 {
+		t.Errorf("Expected version 2 with bit 9 unset, got version %d and byte %08b", list.Version, list.Bits[1])
+	}
+
+	if _, err := lists.SetStatus(ctx, entry.StatusListID, 10, true); err == nil {
+		t.Errorf("Expected an index not allocated yet to fail")
+	}
+}
+
+func testPublish(t *testing.T, lists repo.StatusList) {
+	ctx := context.Background()
+	issuerDID, otherDID := uniqueIssuer(t), uniqueIssuer(t)
+	changed, err := lists.AllocateIndex(ctx, issuerDID, entities.StatusPurposeRevocation)
+	if err != nil {
+		t.Fatalf("Failed to allocate index: %v", err)
+	}
+	if _, err := lists.AllocateIndex(ctx, otherDID, entities.StatusPurposeRevocation); err != nil {
+		t.Fatalf("Failed to allocate index: %v", err)
+	}
+
+	// allocating an index does not change the published bits, a status does
+	if pending := listsForPublish(t, lists, issuerDID, otherDID); len(pending) != 0 {
+		t.Errorf("Expected no list to publish, got %d", len(pending))
+	}
+	if _, err := lists.SetStatus(ctx, changed.StatusListID, changed.Index, true); err != nil {
+		t.Fatalf("Failed to set status: %v", err)
+	}
+	pending := listsForPublish(t, lists, issuerDID, otherDID)
+	if len(pending) != 1 || pending[changed.StatusListID] == nil || pending[changed.StatusListID].Version != 1 {
+		t.Fatalf("Expected status list %d to publish at version 1, got %v", changed.StatusListID, pending)
+	}
+
+	// a change made while publishing stays pending
+	if _, err := lists.SetStatus(ctx, changed.StatusListID, changed.Index, false); err != nil {
+		t.Fatalf("Failed to set status: %v", err)
+	}
+	if err := lists.MarkStatusListPublished(ctx, changed.StatusListID, 1); err != nil {
+		t.Fatalf("Failed to mark status list published: %v", err)
+	}
+	if pending := listsForPublish(t, lists, issuerDID); len(pending) != 1 || pending[changed.StatusListID].Version != 2 {
+		t.Errorf("Expected version 2 still to publish, got %v", pending)
+	}
+	if err := lists.MarkStatusListPublished(ctx, changed.StatusListID, 2); err != nil {
+		t.Fatalf("Failed to mark status list published: %v", err)
+	}
+	if pending := listsForPublish(t, lists, issuerDID); len(pending) != 0 {
+		t.Errorf("Expected no list to publish, got %v", pending)
+	}
+
+	// the published version never moves back
+	if err := lists.MarkStatusListPublished(ctx, changed.StatusListID, 1); err != nil {
+		t.Fatalf("Failed to mark status list published: %v", err)
+	}
+	if list, err := lists.GetStatusList(ctx, changed.StatusListID); err != nil || list.PublishedVersion != 2 {
+		t.Errorf("Expected published version 2, got %+v and error %v", list, err)
+	}
+}
@@ -0,0 +1,160 @@
+package issuer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"merkle_module/did"
+	"merkle_module/domain/repo"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ErrUnknownIssuer is returned by a resolver which has no address for the DID, the next resolver of a chain is tried
+var ErrUnknownIssuer = errors.New("unknown issuer")
+
+// Resolver maps an issuer DID to the Ethereum address its roots and status lists are anchored under
+type Resolver interface {
+	Resolve(ctx context.Context, issuerDID string) (common.Address, error)
+}
+
+// ChainResolver tries each resolver in order until one knows the issuer
+type ChainResolver []Resolver
+
+func NewChainResolver(resolvers ...Resolver) ChainResolver {
+	return ChainResolver(resolvers)
+}
+
+func (c ChainResolver) Resolve(ctx context.Context, issuerDID string) (common.Address, error) {
+	for _, resolver := range c {
+		address, err := resolver.Resolve(ctx, issuerDID)
+		if errors.Is(err, ErrUnknownIssuer) {
+			continue
+		}
+		if err != nil {
+			return common.Address{}, err
+		}
+		return address, nil
+	}
+	return common.Address{}, fmt.Errorf("%w: %s", ErrUnknownIssuer, issuerDID)
+}
+
+// StaticResolver maps DIDs to addresses from configuration
+type StaticResolver map[string]common.Address
+
+// ParseStaticResolver parses a mapping of the form "did:example:a=0x...,did:example:b=0x..."
+func ParseStaticResolver(mapping string) (StaticResolver, error) {
+	resolver := make(StaticResolver)
+	for _, entry := range strings.Split(mapping, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		// DIDs contain colons, the address is after the last '='
+		idx := strings.LastIndex(entry, "=")
+		if idx <= 0 || !common.IsHexAddress(entry[idx+1:]) {
+			return nil, fmt.Errorf("invalid issuer mapping %q, expected did=0xaddress", entry)
+		}
+		resolver[entry[:idx]] = common.HexToAddress(entry[idx+1:])
+	}
+	return resolver, nil
+}
+
+func (s StaticResolver) Resolve(ctx context.Context, issuerDID string) (common.Address, error) {
+	if address, exists := s[issuerDID]; exists {
+		return address, nil
+	}
+	return common.Address{}, ErrUnknownIssuer
+}
+
+// DIDResolver derives the address from did:ethr and did:pkh (eip155) identifiers without any lookup
+type DIDResolver struct{}
+
+func (DIDResolver) Resolve(ctx context.Context, issuerDID string) (common.Address, error) {
+	parts := strings.Split(issuerDID, ":")
+	if len(parts) < 3 || parts[0] != "did" {
+		return common.Address{}, ErrUnknownIssuer
+	}
+
+	switch parts[1] {
+	case "ethr":
+		// the identity of the DID, without reading the registry as DocumentResolver does
+		parsed, err := did.ParseEthr(issuerDID)
+		if err != nil {
+			return common.Address{}, fmt.Errorf("invalid did:ethr %s: %w", issuerDID, err)
+		}
+		return parsed.Identity, nil
+	case "pkh":
+		return parsePkh(issuerDID, parts[2:])
+	default:
+		return common.Address{}, ErrUnknownIssuer
+	}
+}
+
+// did:pkh:eip155:<chain id>:<address>
+func parsePkh(issuerDID string, parts []string) (common.Address, error) {
+	if len(parts) != 3 || parts[0] != "eip155" {
+		return common.Address{}, ErrUnknownIssuer
+	}
+	if _, err := strconv.ParseUint(parts[1], 10, 64); err != nil {
+		return common.Address{}, fmt.Errorf("invalid did:pkh chain id in %s", issuerDID)
+	}
+	if !common.IsHexAddress(parts[2]) {
+		return common.Address{}, fmt.Errorf("invalid did:pkh address in %s", issuerDID)
+	}
+	return common.HexToAddress(parts[2]), nil
+}
+
+// DocumentResolver derives the controller address from the resolved DID document
+type DocumentResolver struct {
+	resolver did.Resolver
+}
+
+func NewDocumentResolver(resolver did.Resolver) *DocumentResolver {
+	return &DocumentResolver{resolver: resolver}
+}
+
+func (d *DocumentResolver) Resolve(ctx context.Context, issuerDID string) (common.Address, error) {
+	document, err := did.ResolveActive(ctx, d.resolver, issuerDID)
+	if errors.Is(err, did.ErrUnsupportedMethod) {
+		return common.Address{}, ErrUnknownIssuer
+	}
+	if err != nil {
+		return common.Address{}, fmt.Errorf("failed to resolve issuer %s: %w", issuerDID, err)
+	}
+
+	address, err := document.EthereumAddress()
+	if errors.Is(err, did.ErrNoEthereumAddress) {
+		return common.Address{}, ErrUnknownIssuer
+	}
+	if err != nil {
+		return common.Address{}, fmt.Errorf("failed to derive the address of issuer %s: %w", issuerDID, err)
+	}
+	return address, nil
+}
+
+// RegistryResolver reads the addresses registered in the issuers table
+type RegistryResolver struct {
+	repo repo.Issuer
+}
+
+func NewRegistryResolver(repo repo.Issuer) *RegistryResolver {
+	return &RegistryResolver{repo: repo}
+}
+
+func (r *RegistryResolver) Resolve(ctx context.Context, issuerDID string) (common.Address, error) {
+	issuer, err := r.repo.GetIssuer(ctx, issuerDID)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("failed to get issuer: %w", err)
+	}
+	if issuer == nil {
+		return common.Address{}, ErrUnknownIssuer
+	}
+	if !common.IsHexAddress(issuer.Address) {
+		return common.Address{}, fmt.Errorf("invalid address %q registered for issuer %s", issuer.Address, issuerDID)
+	}
+	return common.HexToAddress(issuer.Address), nil
+}
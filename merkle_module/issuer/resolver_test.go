@@ -0,0 +1,96 @@
+package issuer
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"merkle_module/did"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestDIDResolver(t *testing.T) {
+	ctx := context.Background()
+	address := common.HexToAddress("0x5A9cC578d5CC3Af41caf52c3818E77Af8Ff578D2")
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	compressed := common.Bytes2Hex(crypto.CompressPubkey(&key.PublicKey))
+
+	cases := []struct {
+		did      string
+		expected common.Address
+	}{
+		{"did:ethr:" + address.Hex(), address},
+		{"did:ethr:sepolia:" + address.Hex(), address},
+		{"did:ethr:0x" + compressed, crypto.PubkeyToAddress(key.PublicKey)},
+		{"did:pkh:eip155:1:" + address.Hex(), address},
+	}
+	for _, c := range cases {
+		got, err := DIDResolver{}.Resolve(ctx, c.did)
+		if err != nil {
+			t.Errorf("Failed to resolve %s: %v", c.did, err)
+			continue
+		}
+		if got != c.expected {
+			t.Errorf("Resolve(%s) = %s, expected %s", c.did, got.Hex(), c.expected.Hex())
+		}
+	}
+
+	if _, err := (DIDResolver{}).Resolve(ctx, "did:web:example.com"); !errors.Is(err, ErrUnknownIssuer) {
+		t.Errorf("Expected ErrUnknownIssuer for did:web, got %v", err)
+	}
+	if _, err := (DIDResolver{}).Resolve(ctx, "did:ethr:0x1234"); err == nil || errors.Is(err, ErrUnknownIssuer) {
+		t.Errorf("Expected an invalid identifier error, got %v", err)
+	}
+}
+
+func TestChainResolver(t *testing.T) {
+	ctx := context.Background()
+	override := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	derived := common.HexToAddress("0x5A9cC578d5CC3Af41caf52c3818E77Af8Ff578D2")
+
+	static, err := ParseStaticResolver("did:ethr:" + derived.Hex() + "=" + override.Hex() + ", did:web:example.com=" + derived.Hex())
+	if err != nil {
+		t.Fatalf("Failed to parse static mapping: %v", err)
+	}
+	resolver := NewChainResolver(static, DIDResolver{})
+
+	if got, _ := resolver.Resolve(ctx, "did:ethr:"+derived.Hex()); got != override {
+		t.Errorf("Expected the static mapping to take precedence, got %s", got.Hex())
+	}
+	if got, _ := resolver.Resolve(ctx, "did:web:example.com"); got != derived {
+		t.Errorf("Expected the static address for did:web, got %s", got.Hex())
+	}
+	if got, _ := resolver.Resolve(ctx, "did:pkh:eip155:1:"+derived.Hex()); got != derived {
+		t.Errorf("Expected the derived address for did:pkh, got %s", got.Hex())
+	}
+	if _, err := resolver.Resolve(ctx, "did:key:z6Mk"); !errors.Is(err, ErrUnknownIssuer) {
+		t.Errorf("Expected ErrUnknownIssuer, got %v", err)
+	}
+
+	if _, err := ParseStaticResolver("did:web:example.com=nope"); err == nil {
+		t.Errorf("Expected an error for an invalid mapping")
+	}
+}
+
+func TestDocumentResolver(t *testing.T) {
+	ctx := context.Background()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	resolver := NewDocumentResolver(did.NewResolver(did.KeyDriver{}))
+
+	keyDID := did.KeyDID(did.CodecSecp256k1, crypto.CompressPubkey(&key.PublicKey))
+	if got, err := resolver.Resolve(ctx, keyDID); err != nil || got != crypto.PubkeyToAddress(key.PublicKey) {
+		t.Errorf("Expected the address of the did:key, got %s (%v)", got.Hex(), err)
+	}
+	if _, err := resolver.Resolve(ctx, "did:web:example.com"); !errors.Is(err, ErrUnknownIssuer) {
+		t.Errorf("Expected ErrUnknownIssuer for an unsupported method, got %v", err)
+	}
+}
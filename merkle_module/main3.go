@@ -5,11 +5,10 @@ import (
 	"database/sql"
 	"log"
 	"merkle_module/app/services"
+	"merkle_module/cache"
 	"merkle_module/infra/storage"
-	"merkle_module/merkletree"
 	"os"
 
-	"github.com/ethereum/go-ethereum/common/lru"
 	_ "github.com/joho/godotenv/autoload"
 	_ "github.com/lib/pq"
 )
@@ -29,12 +28,15 @@ func main() {
 	}
 
 	merkleRepo := storage.NewMerklePostgres(db)
-	merkleCache := lru.NewCache[int, *merkletree.MerkleTree](10)
-	issuerCache := lru.NewCache[string, int](10)
-	merkleService := services.NewMerkleService(merkleRepo, merkleCache, issuerCache)
+	treeCache, err := cache.Open(getEnv("REDIS_URL", ""), 10)
+	if err != nil {
+		log.Fatalf("Failed to open tree cache: %v", err)
+	}
+	merkleService := services.NewMerkleService(merkleRepo, treeCache, nil)
 	ctx := context.Background()
-	treeID := 227 // Change this
-	root, err := merkleService.GetSyncedRoot(ctx, treeID)
+	treeID := 227        // Change this
+	chainID := uint64(1) // Change this
+	root, err := merkleService.GetSyncedRoot(ctx, treeID, chainID)
 	if err != nil {
 		log.Fatalf("Failed to get root: %v", err)
 	}
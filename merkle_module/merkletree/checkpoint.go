@@ -0,0 +1,118 @@
+package merkletree
+
+import (
+	"fmt"
+	"math/big"
+
+	"merkle_module/domain/entities"
+	"merkle_module/utils"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// TreeRoot is the root of an issuer tree committed into a checkpoint
+type TreeRoot struct {
+	Issuer common.Address
+	TreeID int
+	Root   []byte
+}
+
+// TreeKey binds a checkpoint leaf to its tree, keccak256(abi.encodePacked(issuer, uint256(treeID)))
+func TreeKey(issuer common.Address, treeID int) []byte {
+	return crypto.Keccak256(issuer.Bytes(), math.U256Bytes(big.NewInt(int64(treeID))))
+}
+
+// CheckpointLeaf is the sorted-pair hash of the tree root and its key, so the proof of a leaf in
+// the tree, the key and the proof in the checkpoint chain into a single sorted-pair proof
+func CheckpointLeaf(root TreeRoot) []byte {
+	return utils.MergeNodes(root.Root, TreeKey(root.Issuer, root.TreeID))
+}
+
+// CheckpointRoots returns the tree roots committed by the syncs of a stored checkpoint, in order
+func CheckpointRoots(checkpoint *entities.Checkpoint) ([]TreeRoot, error) {
+	roots := make([]TreeRoot, len(checkpoint.Syncs))
+	for i, treeSync := range checkpoint.Syncs {
+		if treeSync.CheckpointPosition != i {
+			return nil, fmt.Errorf("checkpoint %d has no tree root at position %d", checkpoint.ID, i)
+		}
+		if !common.IsHexAddress(treeSync.IssuerAddress) {
+			return nil, fmt.Errorf("invalid issuer address %q of Tree ID %d in checkpoint %d", treeSync.IssuerAddress, treeSync.TreeID, checkpoint.ID)
+		}
+		roots[i] = TreeRoot{
+			Issuer: common.HexToAddress(treeSync.IssuerAddress),
+			TreeID: treeSync.TreeID,
+			Root:   treeSync.Root,
+		}
+	}
+	return roots, nil
+}
+
+// Checkpoint is a Merkle tree over the roots of many trees, only its root is anchored. A node
+// without a sibling is carried up unchanged, so proofs never hold empty elements.
+//
+// There is no checkpoint contract. The super-root is anchored with updateTreeRoot of the deployed
+// NDACredential under a pseudo-issuer, the CHECKPOINT_ISSUER address of the chain, with the
+// checkpoint ID as tree index. The proof of a leaf, the TreeKey of its tree and the proof of the
+// tree root in the checkpoint chain into one sorted-pair proof, so verifyVC(checkpoint issuer,
+// checkpoint ID, leaf, chained proof) checks a two-level proof as it checks a single tree. The
+// pseudo-issuer must not be the address of a real issuer, whose tree indexes would collide.
+type Checkpoint struct {
+	levels [][][]byte // levels[0] holds the checkpoint leaves, the last level the root
+}
+
+func NewCheckpoint(roots []TreeRoot) (*Checkpoint, error) {
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("checkpoint needs at least one tree root")
+	}
+
+	leaves := make([][]byte, len(roots))
+	for i, root := range roots {
+		if len(root.Root) != 32 {
+			return nil, fmt.Errorf("invalid root length for Tree ID %d: expected 32 bytes, got %d bytes", root.TreeID, len(root.Root))
+		}
+		leaves[i] = CheckpointLeaf(root)
+	}
+
+	levels := [][][]byte{leaves}
+	for level := leaves; len(level) > 1; {
+		parents := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				parents = append(parents, level[i])
+				continue
+			}
+			parents = append(parents, utils.MergeNodes(level[i], level[i+1]))
+		}
+		levels = append(levels, parents)
+		level = parents
+	}
+
+	return &Checkpoint{levels: levels}, nil
+}
+
+func (c *Checkpoint) Root() []byte {
+	return c.levels[len(c.levels)-1][0]
+}
+
+// Size returns the number of tree roots in the checkpoint
+func (c *Checkpoint) Size() int {
+	return len(c.levels[0])
+}
+
+// GetProof returns the proof from the checkpoint leaf at the position, starting at 0, to the root
+func (c *Checkpoint) GetProof(pos int) ([][]byte, error) {
+	if pos < 0 || pos >= c.Size() {
+		return nil, fmt.Errorf("invalid position: %d, must be between 0 and %d", pos, c.Size()-1)
+	}
+
+	var proof [][]byte
+	for _, level := range c.levels[:len(c.levels)-1] {
+		if sibling := pos ^ 1; sibling < len(level) {
+			proof = append(proof, level[sibling])
+		}
+		pos >>= 1
+	}
+	return proof, nil
+}
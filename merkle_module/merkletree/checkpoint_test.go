@@ -0,0 +1,54 @@
+package merkletree
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"merkle_module/utils"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestCheckpoint(t *testing.T) {
+	for _, size := range []int{1, 2, 3, 5, 8} {
+		var roots []TreeRoot
+		for i := 0; i < size; i++ {
+			roots = append(roots, TreeRoot{
+				Issuer: common.BigToAddress(common.Big1),
+				TreeID: i + 1,
+				Root:   utils.Hash([]byte(fmt.Sprintf("root %d", i))),
+			})
+		}
+
+		checkpoint, err := NewCheckpoint(roots)
+		if err != nil {
+			t.Fatalf("Failed to create checkpoint: %v", err)
+		}
+		for i, root := range roots {
+			proof, err := checkpoint.GetProof(i)
+			if err != nil {
+				t.Fatalf("Failed to get proof of position %d: %v", i, err)
+			}
+
+			// the key and the checkpoint proof continue the proof of the tree
+			current := root.Root
+			for _, p := range append([][]byte{TreeKey(root.Issuer, root.TreeID)}, proof...) {
+				current = utils.MergeNodes(current, p)
+			}
+			if !bytes.Equal(current, checkpoint.Root()) {
+				t.Errorf("Proof of position %d in a checkpoint of %d roots does not lead to the root", i, size)
+			}
+		}
+	}
+
+	// a root is bound to its issuer and tree
+	roots := []TreeRoot{{TreeID: 1, Root: utils.Hash([]byte("root"))}, {TreeID: 2, Root: utils.Hash([]byte("root"))}}
+	if bytes.Equal(CheckpointLeaf(roots[0]), CheckpointLeaf(roots[1])) {
+		t.Errorf("Expected different checkpoint leaves for the same root of different trees")
+	}
+
+	if _, err := NewCheckpoint(nil); err == nil {
+		t.Errorf("Expected an error for an empty checkpoint")
+	}
+}
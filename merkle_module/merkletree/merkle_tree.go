@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"sync"
 
+	"merkle_module/domain/entities"
 	"merkle_module/utils"
 )
 
@@ -17,6 +18,73 @@ type MerkleTree struct {
 	mu       sync.Mutex // mutex to ensure thread safety
 }
 
+// EmptyLeaf is the value of the leaves not filled yet. It is the zero bytes32 so that proofs
+// through empty subtrees hash the same way in MergeNodes and in OpenZeppelin's MerkleProof.
+var EmptyLeaf = make([]byte, 32)
+
+// zeroHashes holds the hash of an empty subtree by its height, 0 for a leaf
+var zeroHashes = func() [][]byte {
+	hashes := [][]byte{EmptyLeaf}
+	for width := utils.MAX_LEAFS; width > 1; width >>= 1 {
+		last := hashes[len(hashes)-1]
+		hashes = append(hashes, utils.MergeNodes(last, last))
+	}
+	return hashes
+}()
+
+// LeafIndex returns the index of the node of the leaf at position pos. The root is at index 1
+// and the children of node i at 2i and 2i+1, in every tree of MAX_LEAFS leaves.
+func LeafIndex(pos int) int {
+	return utils.MAX_LEAFS + pos - 1
+}
+
+// ProofIndexes returns the indexes of the nodes making the proof of the leaf at position pos,
+// in the order of GetProof
+func ProofIndexes(pos int) []int {
+	var indexes []int
+	for nodeID := LeafIndex(pos); nodeID > 1; nodeID >>= 1 {
+		indexes = append(indexes, nodeID^1)
+	}
+	return indexes
+}
+
+// EmptyNode returns the hash of the node at index when no leaf of its subtree is filled
+func EmptyNode(index int) []byte {
+	height := 0
+	for first := utils.MAX_LEAFS; index < first; first >>= 1 {
+		height++
+	}
+	return zeroHashes[height]
+}
+
+// RootFromProof returns the root a proof leads to from a leaf
+func RootFromProof(leaf []byte, proof [][]byte) []byte {
+	hash := leaf
+	for _, sibling := range proof {
+		hash = utils.MergeNodes(hash, sibling)
+	}
+	return hash
+}
+
+// LegacyRoot returns the root NewMerkleTree gave the leaves before EmptyLeaf was the zero bytes32,
+// when an empty leaf was nil and merged as the hash of its sibling alone. Full trees have the same
+// root either way; roots of partial trees anchored with it are re-anchored by ReconcileJob.
+func LegacyRoot(leaves [][]byte) []byte {
+	if len(leaves) == 0 {
+		return []byte{}
+	}
+	level := make([][]byte, utils.MAX_LEAFS)
+	copy(level, leaves)
+	for len(level) > 1 {
+		parents := make([][]byte, len(level)/2)
+		for i := range parents {
+			parents[i] = utils.MergeNodes(level[2*i], level[2*i+1])
+		}
+		level = parents
+	}
+	return level[0]
+}
+
 func NewMerkleTree(datas [][]byte, treeID int) (*MerkleTree, error) {
 	tree := &MerkleTree{}
 	tree.init(utils.MAX_LEAFS)
@@ -33,6 +101,14 @@ func (tree *MerkleTree) init(maxLeafs int) {
 	tree.maxLeafs = maxLeafs
 	tree.nodes = make([][]byte, tree.maxLeafs<<1+2)
 	tree.leafMap = make(map[string]int, tree.maxLeafs)
+
+	// Empty subtrees hold their zero hash, so a tree built at once and a tree filled leaf
+	// by leaf have the same nodes
+	for first, height := tree.maxLeafs, 0; first >= 1; first, height = first>>1, height+1 {
+		for nodeID := first; nodeID < first<<1; nodeID++ {
+			tree.nodes[nodeID] = zeroHashes[height]
+		}
+	}
 }
 
 func (tree *MerkleTree) build(datas [][]byte) error {
@@ -41,9 +117,11 @@ func (tree *MerkleTree) build(datas [][]byte) error {
 		return nil
 	}
 
-	// build the leaf map
+	// build the leaf map, the reaped gaps all hold EmptyLeaf and are no credential to look up
 	for i, data := range datas {
-		tree.leafMap[string(data)] = i + 1 // store position starting from 1
+		if !bytes.Equal(data, EmptyLeaf) {
+			tree.leafMap[string(data)] = i + 1 // store position starting from 1
+		}
 		tree.nodes[tree.maxLeafs+i] = data
 	}
 
@@ -65,8 +143,10 @@ func (tree *MerkleTree) AddLeaf(data []byte) int {
 	tree.mu.Lock()
 	defer tree.mu.Unlock()
 	tree.numLeafs++
-	tree.leafMap[string(data)] = tree.numLeafs // store position starting from 1
-	tree.update(data, tree.leafMap[string(data)])
+	if !bytes.Equal(data, EmptyLeaf) {
+		tree.leafMap[string(data)] = tree.numLeafs // store position starting from 1
+	}
+	tree.update(data, tree.numLeafs)
 	return tree.numLeafs
 }
 
@@ -87,7 +167,7 @@ func (tree *MerkleTree) GetMerkleRoot() []byte {
 	tree.mu.Lock()
 	defer tree.mu.Unlock()
 
-	if len(tree.leafMap) == 0 {
+	if tree.numLeafs == 0 {
 		return []byte{}
 	}
 
@@ -113,28 +193,54 @@ func (tree *MerkleTree) GetProof(pos int) ([][]byte, error) {
 	return proof, nil
 }
 
-func (tree *MerkleTree) GetListNodesToSave() []int {
-	firstLeafID := tree.maxLeafs
-	lastLeafID := firstLeafID + tree.numLeafs - 1
-	nodesToSave := make([]int, 0, tree.numLeafs)
-	for depth := 1; depth <= tree.maxLeafs; depth++ {
-		for nodeID := firstLeafID; nodeID < lastLeafID; nodeID++ {
-			if bytes.Compare(tree.nodes[nodeID], []byte{}) != 0 {
-				nodesToSave = append(nodesToSave, nodeID)
-			}
+// GetListNodesToSave returns the indexes of the nodes holding a leaf from position from on in
+// their subtree, leaves first. These are all the non-empty nodes for position 1, and the path
+// of the leaf for the last position.
+func (tree *MerkleTree) GetListNodesToSave(from int) []int {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+	return tree.listNodesToSave(from)
+}
+
+func (tree *MerkleTree) listNodesToSave(from int) []int {
+	if from <= 0 {
+		from = 1
+	}
+	if from > tree.numLeafs {
+		return nil
+	}
+
+	nodesToSave := make([]int, 0, tree.numLeafs-from+1)
+	firstNodeID := tree.maxLeafs + from - 1
+	lastNodeID := tree.maxLeafs + tree.numLeafs - 1
+	for ; firstNodeID >= 1; firstNodeID, lastNodeID = firstNodeID>>1, lastNodeID>>1 {
+		for nodeID := firstNodeID; nodeID <= lastNodeID; nodeID++ {
+			nodesToSave = append(nodesToSave, nodeID)
 		}
-		firstLeafID >>= 1
-		lastLeafID >>= 1
 	}
 
 	return nodesToSave
 }
 
+// GetNodeHashes returns the hashes of the nodes listed by GetListNodesToSave, at the current
+// size of the tree
+func (tree *MerkleTree) GetNodeHashes(from int) []*entities.NodeHash {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+
+	nodeIDs := tree.listNodesToSave(from)
+	hashes := make([]*entities.NodeHash, len(nodeIDs))
+	for i, nodeID := range nodeIDs {
+		hashes[i] = &entities.NodeHash{TreeID: tree.treeID, Index: nodeID, Size: tree.numLeafs, Hash: tree.nodes[nodeID]}
+	}
+	return hashes
+}
+
 func (tree *MerkleTree) Contains(data []byte) bool {
 	tree.mu.Lock()
 	defer tree.mu.Unlock()
 
-	if len(tree.leafMap) == 0 {
+	if tree.numLeafs == 0 {
 		return false
 	}
 
@@ -153,3 +259,23 @@ func (tree *MerkleTree) IsFull() bool {
 	defer tree.mu.Unlock()
 	return tree.numLeafs >= tree.maxLeafs
 }
+
+// Size returns the number of leaves of the tree
+func (tree *MerkleTree) Size() int {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+	return tree.numLeafs
+}
+
+// Leaves returns copies of the leaves of the tree in order, NewMerkleTree rebuilds the tree
+// from them
+func (tree *MerkleTree) Leaves() [][]byte {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+
+	leaves := make([][]byte, tree.numLeafs)
+	for i := range leaves {
+		leaves[i] = append([]byte(nil), tree.nodes[tree.maxLeafs+i]...)
+	}
+	return leaves
+}
@@ -1,6 +1,7 @@
 package merkletree
 
 import (
+	"bytes"
 	"fmt"
 	"merkle_module/utils"
 	"sync"
@@ -174,3 +175,201 @@ func TestConcurrent(t *testing.T) {
 		}
 	}
 }
+
+func TestBuildMatchesAddLeaf(t *testing.T) {
+	// A partial tree built at once and filled leaf by leaf have the same root and proofs
+	for _, size := range []int{1, 3, 5, utils.MAX_LEAFS - 1} {
+		var leaves [][]byte
+		added, _ := NewMerkleTree(nil, 0)
+		for i := 0; i < size; i++ {
+			leaves = append(leaves, utils.Hash([]byte(fmt.Sprintf("data-%d", i))))
+			added.AddLeaf(leaves[i])
+		}
+		built, err := NewMerkleTree(leaves, 0)
+		if err != nil {
+			t.Fatalf("Failed to create Merkle Tree of %d leaves: %v", size, err)
+		}
+		if !bytes.Equal(built.GetMerkleRoot(), added.GetMerkleRoot()) {
+			t.Errorf("Root of %d leaves differs: built %x, added %x", size, built.GetMerkleRoot(), added.GetMerkleRoot())
+		}
+
+		for pos := 1; pos <= size; pos++ {
+			proof, err := built.GetProof(pos)
+			if err != nil {
+				t.Fatalf("Failed to get proof for position %d: %v", pos, err)
+			}
+			computed := leaves[pos-1]
+			for _, node := range proof {
+				if len(node) != 32 {
+					t.Fatalf("Proof for position %d of %d leaves has an empty node", pos, size)
+				}
+				computed = utils.MergeNodes(computed, node)
+			}
+			if !bytes.Equal(computed, added.GetMerkleRoot()) {
+				t.Errorf("Proof for position %d of %d leaves does not match the root", pos, size)
+			}
+		}
+	}
+}
+
+func TestStoredNodes(t *testing.T) {
+	// Proofs read from the saved nodes, with empty nodes left out, match those of the tree
+	size := 5
+	tree, _ := NewMerkleTree(nil, 0)
+	saved := make(map[int][]byte)
+	for i := 0; i < size; i++ {
+		pos := tree.AddLeaf(utils.Hash([]byte(fmt.Sprintf("data-%d", i))))
+		path := tree.GetNodeHashes(pos)
+		if len(path) != len(ProofIndexes(pos))+1 {
+			t.Fatalf("Expected the path of position %d, got %d nodes", pos, len(path))
+		}
+		for _, hash := range path {
+			if hash.Size != pos {
+				t.Errorf("Expected the nodes of position %d at size %d, got %d", pos, pos, hash.Size)
+			}
+			saved[hash.Index] = hash.Hash
+		}
+	}
+	if all := tree.GetListNodesToSave(1); len(all) != len(saved) {
+		t.Errorf("Expected %d non-empty nodes, got %d", len(saved), len(all))
+	}
+	if nodes := tree.GetListNodesToSave(size + 1); len(nodes) != 0 {
+		t.Errorf("Expected no nodes past the last leaf, got %v", nodes)
+	}
+
+	for pos := 1; pos <= size; pos++ {
+		expected, err := tree.GetProof(pos)
+		if err != nil {
+			t.Fatalf("Failed to get proof for position %d: %v", pos, err)
+		}
+		var proof [][]byte
+		for _, index := range ProofIndexes(pos) {
+			hash, ok := saved[index]
+			if !ok {
+				hash = EmptyNode(index)
+			}
+			proof = append(proof, hash)
+		}
+		for i := range expected {
+			if !bytes.Equal(proof[i], expected[i]) {
+				t.Errorf("Node %d of the proof for position %d differs", i, pos)
+			}
+		}
+		if !bytes.Equal(RootFromProof(saved[LeafIndex(pos)], proof), tree.GetMerkleRoot()) {
+			t.Errorf("Proof for position %d does not lead to the root", pos)
+		}
+	}
+}
+
+func TestRootFromProof(t *testing.T) {
+	tree, err := NewMerkleTree(nil, 1)
+	if err != nil {
+		t.Fatalf("Failed to create Merkle Tree: %v", err)
+	}
+	var data [][]byte
+	for i := 0; i < 5; i++ {
+		data = append(data, []byte(fmt.Sprintf("leaf %d", i)))
+		tree.AddLeaf(utils.Hash(data[i]))
+	}
+
+	// the sorted-pair Keccak of utils.Verify and of OpenZeppelin's MerkleProof
+	root := tree.GetMerkleRoot()
+	for i := range data {
+		proof, err := tree.GetProof(i + 1)
+		if err != nil {
+			t.Fatalf("Failed to get proof: %v", err)
+		}
+		if !utils.Verify(proof, root, data[i]) {
+			t.Fatalf("utils.Verify rejected leaf %d", i)
+		}
+		if computed := RootFromProof(utils.Hash(data[i]), proof); !bytes.Equal(computed, root) {
+			t.Errorf("Expected root %x for leaf %d, got %x", root, i, computed)
+		}
+	}
+}
+
+func TestLegacyRoot(t *testing.T) {
+	// Roots NewMerkleTree gave these leaves when empty leaves were nil
+	legacy := map[int]string{
+		1:               "df83bd37837a8fefb25bcd5123e058e00df39fd138fa52709f48501bed51a727",
+		3:               "d7b8b785c01df9d0c4a9053f72ed186b8561ceb3c47953014a23697ed39707e2",
+		5:               "7d4d3800660f451094da9e56e556790969be1809a186079194e484651b7e29cd",
+		utils.MAX_LEAFS: "7eb8ead2f5936fd0fc0d5f8eaf6d85f477278d31f6659c4316c91bfda7824387",
+	}
+	for size, expected := range legacy {
+		var leaves [][]byte
+		for i := 0; i < size; i++ {
+			leaves = append(leaves, utils.Hash([]byte(fmt.Sprintf("leaf %d", i))))
+		}
+		if root := fmt.Sprintf("%x", LegacyRoot(leaves)); root != expected {
+			t.Errorf("Expected legacy root %s of %d leaves, got %s", expected, size, root)
+		}
+
+		// only full trees keep their root
+		tree, err := NewMerkleTree(leaves, 1)
+		if err != nil {
+			t.Fatalf("Failed to create Merkle Tree of %d leaves: %v", size, err)
+		}
+		if root := fmt.Sprintf("%x", tree.GetMerkleRoot()); (root == expected) != (size == utils.MAX_LEAFS) {
+			t.Errorf("Expected the root of %d leaves to change only for a partial tree, got %s from %s", size, root, expected)
+		}
+	}
+	if root := LegacyRoot(nil); len(root) != 0 {
+		t.Errorf("Expected no legacy root without leaves, got %x", root)
+	}
+}
+
+func TestEmptyNodes(t *testing.T) {
+	tree, err := NewMerkleTree(nil, 1)
+	if err != nil {
+		t.Fatalf("Failed to create Merkle Tree: %v", err)
+	}
+	for nodeID := 1; nodeID < utils.MAX_LEAFS<<1; nodeID++ {
+		if !bytes.Equal(tree.nodes[nodeID], EmptyNode(nodeID)) {
+			t.Errorf("Expected node %d of an empty tree to be %x, got %x", nodeID, EmptyNode(nodeID), tree.nodes[nodeID])
+		}
+	}
+	if !bytes.Equal(EmptyNode(1), utils.MergeNodes(EmptyNode(2), EmptyNode(3))) {
+		t.Errorf("Expected the empty root to be the hash of its empty children")
+	}
+}
+
+func TestReapedGaps(t *testing.T) {
+	// Nodes whose reservation expired are all filled with EmptyLeaf
+	leaves := [][]byte{utils.Hash([]byte("first")), EmptyLeaf, EmptyLeaf, utils.Hash([]byte("after the gaps")), EmptyLeaf}
+	added, _ := NewMerkleTree(nil, 1)
+	for _, leaf := range leaves {
+		added.AddLeaf(leaf)
+	}
+	built, err := NewMerkleTree(leaves, 1)
+	if err != nil {
+		t.Fatalf("Failed to create Merkle Tree: %v", err)
+	}
+
+	for _, tree := range []*MerkleTree{built, added} {
+		if tree.Size() != len(leaves) || !bytes.Equal(tree.GetMerkleRoot(), built.GetMerkleRoot()) {
+			t.Fatalf("Expected %d leaves under root %x, got %d under %x", len(leaves), built.GetMerkleRoot(), tree.Size(), tree.GetMerkleRoot())
+		}
+		if tree.Contains(EmptyLeaf) || !tree.Contains(leaves[3]) || len(tree.leafMap) != 2 {
+			t.Errorf("Expected only the 2 credentials looked up, got %d", len(tree.leafMap))
+		}
+		if tree.leafMap[string(leaves[3])] != 4 {
+			t.Errorf("Expected the credential after the gaps at position 4, got %d", tree.leafMap[string(leaves[3])])
+		}
+		for pos := 1; pos <= len(leaves); pos++ {
+			proof, err := tree.GetProof(pos)
+			if err != nil {
+				t.Fatalf("Failed to get proof for position %d: %v", pos, err)
+			}
+			if !bytes.Equal(RootFromProof(leaves[pos-1], proof), tree.GetMerkleRoot()) {
+				t.Errorf("Proof for position %d does not lead to the root", pos)
+			}
+		}
+	}
+
+	// a tree holding gaps only still has a root
+	gaps, _ := NewMerkleTree([][]byte{EmptyLeaf, EmptyLeaf}, 2)
+	if root := gaps.GetMerkleRoot(); len(root) != 32 {
+		t.Errorf("Expected the root of a tree of gaps, got %x", root)
+	}
+}
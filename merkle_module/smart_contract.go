@@ -31,12 +31,15 @@ func main() {
 		log.Fatalf("Failed to get chain ID: %v", err)
 	}
 
-	auth, err := credential.NewTransactOpts(ethClient, getEnv("ACCOUNT_PRIVATE_KEY", ""), chainID)
+	auth, err := credential.NewTransactOpts(getEnv("ACCOUNT_PRIVATE_KEY", ""), chainID)
 	if err != nil {
 		log.Fatalf("Failed to create transaction options: %v", err)
 	}
 
-	smartContract := credential.NewSmartContract(ethClient, contract, contractAddress, auth)
+	smartContract, err := credential.NewSmartContract(ethClient, contract, contractAddress, auth)
+	if err != nil {
+		log.Fatalf("Failed to create smart contract: %v", err)
+	}
 
 	issuers := []common.Address{
 		common.HexToAddress("0x5A9cC578d5CC3Af41caf52c3818E77Af8Ff578D2"),
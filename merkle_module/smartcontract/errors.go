@@ -0,0 +1,91 @@
+package credential
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Errors matching the custom errors of the NDACredential contract
+var (
+	ErrTreeNotExists         = errors.New("tree does not exist")
+	ErrInvalidProof          = errors.New("invalid proof")
+	ErrEmptyRoot             = errors.New("empty root")
+	ErrArrayLengthMismatch   = errors.New("array length mismatch")
+	ErrEmptyStatusListID     = errors.New("empty status list id")
+	ErrEmptyRevokeStatusList = errors.New("empty revoke status list")
+	ErrUnauthorized          = errors.New("account is not authorized")
+)
+
+var contractErrors = map[string]error{
+	"TreeNotExists":                    ErrTreeNotExists,
+	"InvalidProof":                     ErrInvalidProof,
+	"EmptyRoot":                        ErrEmptyRoot,
+	"ArrayLengthMismatch":              ErrArrayLengthMismatch,
+	"EmptyStatusListId":                ErrEmptyStatusListID,
+	"EmptyRevokeStatusList":            ErrEmptyRevokeStatusList,
+	"AccessControlUnauthorizedAccount": ErrUnauthorized,
+}
+
+// ContractError is a revert of the contract decoded with its ABI, it matches the sentinel error of the
+// custom error with errors.Is
+type ContractError struct {
+	Name string // custom error name, or "Error" for a require message
+	Args []any
+	err  error
+}
+
+func (e *ContractError) Error() string {
+	if len(e.Args) == 0 {
+		return fmt.Sprintf("execution reverted: %s()", e.Name)
+	}
+	args := make([]string, len(e.Args))
+	for i, arg := range e.Args {
+		args[i] = fmt.Sprint(arg)
+	}
+	return fmt.Sprintf("execution reverted: %s(%s)", e.Name, strings.Join(args, ", "))
+}
+
+func (e *ContractError) Unwrap() error {
+	return e.err
+}
+
+// DecodeError decodes the revert data carried by a call error into a ContractError. Errors without
+// revert data, or with data the ABI does not know, are returned unchanged.
+func DecodeError(contractABI *abi.ABI, err error) error {
+	var dataErr rpc.DataError
+	if !errors.As(err, &dataErr) {
+		return err
+	}
+	encoded, ok := dataErr.ErrorData().(string)
+	if !ok {
+		return err
+	}
+	data, decodeErr := hexutil.Decode(encoded)
+	if decodeErr != nil || len(data) < 4 {
+		return err
+	}
+
+	if reason, unpackErr := abi.UnpackRevert(data); unpackErr == nil {
+		return &ContractError{Name: "Error", Args: []any{reason}, err: err}
+	}
+	for name, abiErr := range contractABI.Errors {
+		if string(abiErr.ID[:4]) != string(data[:4]) {
+			continue
+		}
+		args, unpackErr := abiErr.Inputs.Unpack(data[4:])
+		if unpackErr != nil {
+			return err
+		}
+		sentinel, known := contractErrors[name]
+		if !known {
+			sentinel = err
+		}
+		return &ContractError{Name: name, Args: args, err: sentinel}
+	}
+	return err
+}
@@ -0,0 +1,139 @@
+package credential
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// signTimeout bounds a signature by an external signer, the bind signer function has no context
+const signTimeout = 30 * time.Second
+
+// Signer signs the transactions of one account
+type Signer interface {
+	Address() common.Address
+	SignTx(ctx context.Context, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error)
+}
+
+// SignerConfig selects the signer, only the fields of the selected type are used
+type SignerConfig struct {
+	Type           string // key, keystore, clef or remote
+	PrivateKey     string // key: hex private key, for development only
+	KeystorePath   string // keystore: encrypted key file
+	PassphraseFile string // keystore: file holding the passphrase
+	Endpoint       string // clef and remote: URL of the signer
+	Address        string // clef and remote: account to sign with
+	AuthToken      string // remote: bearer token
+}
+
+func NewSigner(ctx context.Context, cfg SignerConfig) (Signer, error) {
+	switch cfg.Type {
+	case "", "key":
+		return NewPrivateKeySigner(cfg.PrivateKey)
+	case "keystore":
+		passphrase, err := os.ReadFile(cfg.PassphraseFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read keystore passphrase: %w", err)
+		}
+		return NewKeystoreSigner(cfg.KeystorePath, strings.TrimRight(string(passphrase), "\r\n"))
+	case "clef":
+		if !common.IsHexAddress(cfg.Address) {
+			return nil, fmt.Errorf("invalid signer address %q", cfg.Address)
+		}
+		return DialClefSigner(ctx, cfg.Endpoint, common.HexToAddress(cfg.Address))
+	case "remote":
+		if !common.IsHexAddress(cfg.Address) {
+			return nil, fmt.Errorf("invalid signer address %q", cfg.Address)
+		}
+		return NewRemoteSigner(cfg.Endpoint, common.HexToAddress(cfg.Address), cfg.AuthToken, nil), nil
+	default:
+		return nil, fmt.Errorf("unknown signer type %q", cfg.Type)
+	}
+}
+
+// NewSignerTransactOpts creates transaction options signing with the signer
+func NewSignerTransactOpts(signer Signer, chainID *big.Int) *bind.TransactOpts {
+	from := signer.Address()
+	return &bind.TransactOpts{
+		From: from,
+		Signer: func(address common.Address, tx *types.Transaction) (*types.Transaction, error) {
+			if address != from {
+				return nil, bind.ErrNotAuthorized
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), signTimeout)
+			defer cancel()
+			return signer.SignTx(ctx, tx, chainID)
+		},
+		Context: context.Background(),
+	}
+}
+
+// KeySigner signs with a private key held in memory
+type KeySigner struct {
+	key     *ecdsa.PrivateKey
+	address common.Address
+}
+
+func NewKeySigner(key *ecdsa.PrivateKey) *KeySigner {
+	return &KeySigner{key: key, address: crypto.PubkeyToAddress(key.PublicKey)}
+}
+
+// NewPrivateKeySigner loads a hex private key, keys in configuration should only be used for development
+func NewPrivateKeySigner(privateKey string) (*KeySigner, error) {
+	key, err := crypto.HexToECDSA(strings.TrimPrefix(privateKey, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load private key: %w", err)
+	}
+	return NewKeySigner(key), nil
+}
+
+// NewKeystoreSigner decrypts a go-ethereum keystore file
+func NewKeystoreSigner(path string, passphrase string) (*KeySigner, error) {
+	keyJSON, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keystore file: %w", err)
+	}
+	key, err := keystore.DecryptKey(keyJSON, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt keystore file: %w", err)
+	}
+	return NewKeySigner(key.PrivateKey), nil
+}
+
+func (k *KeySigner) Address() common.Address {
+	return k.address
+}
+
+func (k *KeySigner) SignTx(ctx context.Context, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	return types.SignTx(tx, types.LatestSignerForChainID(chainID), k.key)
+}
+
+// helper function to check that an external signer signed the requested transaction with the expected account
+func checkSignedTx(requested, signed *types.Transaction, chainID *big.Int, from common.Address) error {
+	if signed == nil {
+		return errors.New("signer returned no transaction")
+	}
+	signer := types.LatestSignerForChainID(chainID)
+	if signer.Hash(signed) != signer.Hash(requested) {
+		return errors.New("signer returned a different transaction")
+	}
+	sender, err := types.Sender(signer, signed)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	if sender != from {
+		return fmt.Errorf("transaction signed by %s, expected %s", sender.Hex(), from.Hex())
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package credential
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+// ClefSigner signs with an external signer speaking the Clef JSON-RPC API (account_signTransaction)
+type ClefSigner struct {
+	client  *rpc.Client
+	address common.Address
+}
+
+func DialClefSigner(ctx context.Context, endpoint string, address common.Address) (*ClefSigner, error) {
+	client, err := rpc.DialContext(ctx, endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to external signer: %w", err)
+	}
+	return NewClefSigner(client, address), nil
+}
+
+func NewClefSigner(client *rpc.Client, address common.Address) *ClefSigner {
+	return &ClefSigner{client: client, address: address}
+}
+
+func (c *ClefSigner) Address() common.Address {
+	return c.address
+}
+
+func (c *ClefSigner) SignTx(ctx context.Context, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	data := hexutil.Bytes(tx.Data())
+	args := &apitypes.SendTxArgs{
+		From:  common.NewMixedcaseAddress(c.address),
+		Gas:   hexutil.Uint64(tx.Gas()),
+		Value: hexutil.Big(*tx.Value()),
+		Nonce: hexutil.Uint64(tx.Nonce()),
+		Input: &data,
+	}
+	if tx.To() != nil {
+		to := common.NewMixedcaseAddress(*tx.To())
+		args.To = &to
+	}
+	switch tx.Type() {
+	case types.LegacyTxType:
+		args.GasPrice = (*hexutil.Big)(tx.GasPrice())
+	case types.DynamicFeeTxType:
+		args.MaxFeePerGas = (*hexutil.Big)(tx.GasFeeCap())
+		args.MaxPriorityFeePerGas = (*hexutil.Big)(tx.GasTipCap())
+		args.ChainID = (*hexutil.Big)(chainID)
+		accessList := tx.AccessList()
+		args.AccessList = &accessList
+	default:
+		return nil, fmt.Errorf("unsupported transaction type %d", tx.Type())
+	}
+
+	var result struct {
+		Raw hexutil.Bytes      `json:"raw"`
+		Tx  *types.Transaction `json:"tx"`
+	}
+	if err := c.client.CallContext(ctx, &result, "account_signTransaction", args); err != nil {
+		return nil, fmt.Errorf("external signer failed to sign: %w", err)
+	}
+	if err := checkSignedTx(tx, result.Tx, chainID, c.address); err != nil {
+		return nil, fmt.Errorf("external signer: %w", err)
+	}
+	return result.Tx, nil
+}
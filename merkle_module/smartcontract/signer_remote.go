@@ -0,0 +1,135 @@
+package credential
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// RemoteSignRequest asks a remote signer, such as a KMS gateway, to sign the digest of a transaction
+type RemoteSignRequest struct {
+	Address common.Address `json:"address"`
+	ChainID *hexutil.Big   `json:"chainId"`
+	Digest  common.Hash    `json:"digest"`
+}
+
+// RemoteSignResponse carries the 65 byte [R || S || V] signature, V is 0/1 or 27/28
+type RemoteSignResponse struct {
+	Signature hexutil.Bytes `json:"signature"`
+}
+
+// RemoteSigner signs with a remote HTTP service which only ever sees transaction digests
+type RemoteSigner struct {
+	endpoint  string
+	address   common.Address
+	authToken string
+	client    *http.Client
+}
+
+// NewRemoteSigner creates a remote signer, a nil client uses one with a 30 second timeout
+func NewRemoteSigner(endpoint string, address common.Address, authToken string, client *http.Client) *RemoteSigner {
+	if client == nil {
+		client = &http.Client{Timeout: signTimeout}
+	}
+	return &RemoteSigner{endpoint: endpoint, address: address, authToken: authToken, client: client}
+}
+
+func (r *RemoteSigner) Address() common.Address {
+	return r.address
+}
+
+func (r *RemoteSigner) SignTx(ctx context.Context, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	signer := types.LatestSignerForChainID(chainID)
+	body, err := json.Marshal(&RemoteSignRequest{
+		Address: r.address,
+		ChainID: (*hexutil.Big)(chainID),
+		Digest:  signer.Hash(tx),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode sign request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sign request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+r.authToken)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("remote signer failed to sign: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("remote signer failed to sign: %s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+
+	var result RemoteSignResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode remote signature: %w", err)
+	}
+	signature := result.Signature
+	if len(signature) != crypto.SignatureLength {
+		return nil, fmt.Errorf("remote signature has %d bytes, expected %d", len(signature), crypto.SignatureLength)
+	}
+	if signature[crypto.RecoveryIDOffset] >= 27 {
+		signature[crypto.RecoveryIDOffset] -= 27
+	}
+
+	signed, err := tx.WithSignature(signer, signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote signature: %w", err)
+	}
+	if err := checkSignedTx(tx, signed, chainID, r.address); err != nil {
+		return nil, fmt.Errorf("remote signer: %w", err)
+	}
+	return signed, nil
+}
+
+// RemoteSigningHandler serves the remote signing protocol with a local key. It stands in for the
+// signing service in tests and local development.
+func RemoteSigningHandler(key *ecdsa.PrivateKey, authToken string) http.Handler {
+	address := crypto.PubkeyToAddress(key.PublicKey)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if authToken != "" && r.Header.Get("Authorization") != "Bearer "+authToken {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req RemoteSignRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&req); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		if req.Address != address {
+			http.Error(w, "unknown address", http.StatusNotFound)
+			return
+		}
+
+		signature, err := crypto.Sign(req.Digest[:], key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&RemoteSignResponse{Signature: signature})
+	})
+}
@@ -0,0 +1,130 @@
+package credential
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+// helper function to anchor a root with the signer and check the transaction is mined
+func testSignerSendsRoot(t *testing.T, signer Signer) {
+	t.Helper()
+	ctx := context.Background()
+	chain := newTestChainWithSigner(t, signer, TxConfig{PollInterval: 10 * time.Millisecond})
+
+	tx := chain.submitRoot(t, 1)
+	chain.sim.Commit()
+	receipt, err := chain.contract.WaitConfirmed(ctx, tx, 1)
+	if err != nil {
+		t.Fatalf("Failed to wait for transaction: %v", err)
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		t.Fatalf("Transaction signed by %T reverted", signer)
+	}
+	root, err := chain.contract.GetTreeRoot(ctx, signer.Address(), 1)
+	if err != nil || root == ([32]byte{}) {
+		t.Errorf("Root was not anchored (%v)", err)
+	}
+}
+
+func TestKeystoreSigner(t *testing.T) {
+	dir := t.TempDir()
+	account, err := keystore.StoreKey(dir, "secret", keystore.LightScryptN, keystore.LightScryptP)
+	if err != nil {
+		t.Fatalf("Failed to create keystore: %v", err)
+	}
+	passphraseFile := filepath.Join(dir, "passphrase")
+	if err := os.WriteFile(passphraseFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatalf("Failed to write passphrase: %v", err)
+	}
+
+	signer, err := NewSigner(context.Background(), SignerConfig{Type: "keystore", KeystorePath: account.URL.Path, PassphraseFile: passphraseFile})
+	if err != nil {
+		t.Fatalf("Failed to create keystore signer: %v", err)
+	}
+	if signer.Address() != account.Address {
+		t.Fatalf("Expected address %s, got %s", account.Address.Hex(), signer.Address().Hex())
+	}
+	testSignerSendsRoot(t, signer)
+
+	if _, err := NewKeystoreSigner(account.URL.Path, "wrong"); err == nil {
+		t.Errorf("Expected an error for a wrong passphrase")
+	}
+}
+
+func TestRemoteSigner(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	server := httptest.NewServer(RemoteSigningHandler(key, "token"))
+	defer server.Close()
+
+	signer, err := NewSigner(context.Background(), SignerConfig{Type: "remote", Endpoint: server.URL, Address: crypto.PubkeyToAddress(key.PublicKey).Hex(), AuthToken: "token"})
+	if err != nil {
+		t.Fatalf("Failed to create remote signer: %v", err)
+	}
+	testSignerSendsRoot(t, signer)
+
+	tx := types.NewTx(&types.DynamicFeeTx{ChainID: big.NewInt(1337), Nonce: 1, Gas: 21000, GasFeeCap: big.NewInt(1), GasTipCap: big.NewInt(1)})
+	unauthorized := NewRemoteSigner(server.URL, signer.Address(), "other", nil)
+	if _, err := unauthorized.SignTx(context.Background(), tx, big.NewInt(1337)); err == nil {
+		t.Errorf("Expected an error for a wrong token")
+	}
+	// A signature of another key is rejected
+	other, _ := crypto.GenerateKey()
+	impostor := NewRemoteSigner(server.URL, crypto.PubkeyToAddress(other.PublicKey), "token", nil)
+	if _, err := impostor.SignTx(context.Background(), tx, big.NewInt(1337)); err == nil {
+		t.Errorf("Expected an error for an unknown address")
+	}
+}
+
+// fakeClef implements account_signTransaction of the Clef API with a local key
+type fakeClef struct {
+	key *ecdsa.PrivateKey
+}
+
+func (f *fakeClef) SignTransaction(ctx context.Context, args apitypes.SendTxArgs) (map[string]any, error) {
+	tx, err := args.ToTransaction()
+	if err != nil {
+		return nil, err
+	}
+	signed, err := types.SignTx(tx, types.LatestSignerForChainID((*big.Int)(args.ChainID)), f.key)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := signed.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"raw": hexutil.Bytes(raw), "tx": signed}, nil
+}
+
+func TestClefSigner(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	server := rpc.NewServer()
+	if err := server.RegisterName("account", &fakeClef{key: key}); err != nil {
+		t.Fatalf("Failed to register fake signer: %v", err)
+	}
+	defer server.Stop()
+
+	signer := NewClefSigner(rpc.DialInProc(server), crypto.PubkeyToAddress(key.PublicKey))
+	testSignerSendsRoot(t, signer)
+
+	// Signing for an account the signer does not hold fails the sender check
+	other := NewClefSigner(rpc.DialInProc(server), common.HexToAddress("0x00000000000000000000000000000000000000aa"))
+	tx := types.NewTx(&types.DynamicFeeTx{ChainID: big.NewInt(1337), Nonce: 1, Gas: 21000, GasFeeCap: big.NewInt(1), GasTipCap: big.NewInt(1)})
+	if _, err := other.SignTx(context.Background(), tx, big.NewInt(1337)); err == nil {
+		t.Errorf("Expected an error for a transaction signed by another account")
+	}
+}
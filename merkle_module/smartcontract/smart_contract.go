@@ -2,25 +2,26 @@ package credential
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
 	"math/big"
-	"os"
-	"strings"
+	"time"
 
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
 )
 
 type SmartContract struct {
-	client          *ethclient.Client
+	client          Backend
 	contract        *Credential
 	auth            *bind.TransactOpts
 	contractAddress common.Address
+	abi             *abi.ABI
+	txm             *TxManager
 }
 
 func NewEthClient(url string) (*ethclient.Client, error) {
@@ -31,108 +32,257 @@ func NewEthClient(url string) (*ethclient.Client, error) {
 	return client, nil
 }
 
-func NewTransactOpts(client *ethclient.Client, privateKey string, chainID *big.Int) (*bind.TransactOpts, error) {
-	key, err := crypto.HexToECDSA(privateKey)
+// NewTransactOpts creates the signer of a raw private key, use NewSigner to keep the key out of the configuration.
+// Nonces, gas and fees are set per transaction by the TxManager
+func NewTransactOpts(privateKey string, chainID *big.Int) (*bind.TransactOpts, error) {
+	signer, err := NewPrivateKeySigner(privateKey)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load private key: %w", err)
+		return nil, err
 	}
+	return NewSignerTransactOpts(signer, chainID), nil
+}
 
-	auth, err := bind.NewKeyedTransactorWithChainID(key, chainID)
+func NewSmartContract(client Backend, contract *Credential, contractAddress common.Address, auth *bind.TransactOpts) (*SmartContract, error) {
+	contractABI, err := CredentialMetaData.GetAbi()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create transactor: %w", err)
+		return nil, fmt.Errorf("failed to parse contract ABI: %w", err)
 	}
+	return &SmartContract{
+		client:          client,
+		contract:        contract,
+		auth:            auth,
+		contractAddress: contractAddress,
+		abi:             contractABI,
+		txm:             NewTxManager(client, auth, TxConfig{}),
+	}, nil
+}
 
-	nonce, err := client.PendingNonceAt(context.Background(), auth.From)
+// SetTxConfig sets the gas, fee and replacement settings of the transactions
+func (sc *SmartContract) SetTxConfig(cfg TxConfig) {
+	sc.txm = NewTxManager(sc.client, sc.auth, cfg)
+}
+
+// helper function to send a contract call through the transaction manager
+func (sc *SmartContract) transact(ctx context.Context, method string, args ...any) (*types.Transaction, error) {
+	data, err := sc.abi.Pack(method, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get nonce: %w", err)
+		return nil, fmt.Errorf("failed to pack %s: %w", method, err)
 	}
+	tx, err := sc.txm.Send(ctx, sc.contractAddress, data)
+	if err != nil {
+		// A revert during gas estimation carries the custom error of the contract
+		return nil, DecodeError(sc.abi, err)
+	}
+	return tx, nil
+}
 
-	gasPrice, err := client.SuggestGasPrice(context.Background())
+func (sc *SmartContract) SendRoot(issuers []common.Address, treeIDs []int, roots [][32]byte) error {
+	tx, err := sc.SubmitRoots(context.Background(), issuers, treeIDs, roots)
 	if err != nil {
-		return nil, fmt.Errorf("failed to suggest gas price: %w", err)
+		return err
 	}
 
-	auth.Nonce = big.NewInt(int64(nonce))
-	auth.Value = big.NewInt(0)      // no ether being sent
-	auth.GasLimit = uint64(3000000) // adjust as needed
-	auth.GasPrice = gasPrice
+	// Wait for the transaction to be mined
+	if _, err := sc.WaitConfirmed(context.Background(), tx, 1); err != nil {
+		return err
+	}
 
-	return auth, nil
+	return nil
 }
 
-func NewSmartContract(client *ethclient.Client, contract *Credential, contractAddress common.Address, auth *bind.TransactOpts) *SmartContract {
-	return &SmartContract{
-		client:          client,
-		contract:        contract,
-		auth:            auth,
-		contractAddress: contractAddress,
+// SubmitRoots sends the roots to the contract without waiting for the transaction to be mined
+func (sc *SmartContract) SubmitRoots(ctx context.Context, issuers []common.Address, treeIDs []int, roots [][32]byte) (*types.Transaction, error) {
+	// Send the Merkle root to the smart contract
+	tx, err := sc.transact(ctx, "batchUpdateTreeRoots", issuers, toBigInts(treeIDs), roots)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send root to contract: %w", err)
 	}
+	fmt.Printf("Transaction sent: %s\n", tx.Hash().Hex())
+
+	return tx, nil
 }
 
-func (sc *SmartContract) SendRoot(issuers []common.Address, treeIDs []int, roots [][32]byte) error {
-	// int to big.Int conversion for treeIDs
-	treeIDsBig := make([]*big.Int, len(treeIDs))
-	for i, id := range treeIDs {
-		treeIDsBig[i] = big.NewInt(int64(id))
+// Chunk is the range [Start, End) of the roots sent in one transaction
+type Chunk struct {
+	Start int
+	End   int
+}
+
+// ChunkRoots splits the roots into contiguous chunks whose batchUpdateTreeRoots call is estimated
+// under the gas budget. A chunk over the budget, or too large to be estimated, is halved.
+func (sc *SmartContract) ChunkRoots(ctx context.Context, issuers []common.Address, treeIDs []int, roots [][32]byte, gasBudget uint64) ([]Chunk, error) {
+	if len(roots) == 0 {
+		return nil, nil
 	}
+	return sc.chunkRoots(ctx, issuers, treeIDs, roots, gasBudget, Chunk{Start: 0, End: len(roots)})
+}
 
-	// Send the Merkle root to the smart contract
-	tx, err := sc.contract.BatchUpdateTreeRoots(
-		sc.auth,
-		issuers,
-		treeIDsBig,
-		roots,
-	)
+func (sc *SmartContract) chunkRoots(ctx context.Context, issuers []common.Address, treeIDs []int, roots [][32]byte, gasBudget uint64, chunk Chunk) ([]Chunk, error) {
+	gas, err := sc.EstimateRoots(ctx, issuers[chunk.Start:chunk.End], treeIDs[chunk.Start:chunk.End], roots[chunk.Start:chunk.End])
+	if err == nil && gas <= gasBudget {
+		return []Chunk{chunk}, nil
+	}
+	if chunk.End-chunk.Start == 1 {
+		if err != nil {
+			return nil, fmt.Errorf("failed to estimate root of tree %d: %w", treeIDs[chunk.Start], err)
+		}
+		return nil, fmt.Errorf("root of tree %d needs %d gas, above the budget of %d", treeIDs[chunk.Start], gas, gasBudget)
+	}
+
+	middle := chunk.Start + (chunk.End-chunk.Start)/2
+	left, err := sc.chunkRoots(ctx, issuers, treeIDs, roots, gasBudget, Chunk{Start: chunk.Start, End: middle})
 	if err != nil {
-		return fmt.Errorf("failed to send root to contract: %w", err)
+		return nil, err
 	}
-	fmt.Printf("Transaction sent: %s\n", tx.Hash().Hex())
+	right, err := sc.chunkRoots(ctx, issuers, treeIDs, roots, gasBudget, Chunk{Start: middle, End: chunk.End})
+	if err != nil {
+		return nil, err
+	}
+	return append(left, right...), nil
+}
 
-	// Wait for the transaction to be mined
-	receipt, err := bind.WaitMined(context.Background(), sc.client, tx)
+// EstimateRoots estimates the gas of a batchUpdateTreeRoots call
+func (sc *SmartContract) EstimateRoots(ctx context.Context, issuers []common.Address, treeIDs []int, roots [][32]byte) (uint64, error) {
+	data, err := sc.abi.Pack("batchUpdateTreeRoots", issuers, toBigInts(treeIDs), roots)
 	if err != nil {
-		return fmt.Errorf("failed to wait for transaction to be mined: %w", err)
+		return 0, fmt.Errorf("failed to pack batchUpdateTreeRoots: %w", err)
 	}
+	return sc.client.EstimateGas(ctx, ethereum.CallMsg{From: sc.auth.From, To: &sc.contractAddress, Data: data})
+}
+
+func toBigInts(values []int) []*big.Int {
+	bigInts := make([]*big.Int, len(values))
+	for i, value := range values {
+		bigInts[i] = big.NewInt(int64(value))
+	}
+	return bigInts
+}
 
+// WaitConfirmed waits until the transaction is mined and has the given number of confirmations,
+// the block including the transaction counts as the first one. The receipt may be the one of a
+// replacement with higher fees, its TxHash is the hash of the transaction which was mined.
+func (sc *SmartContract) WaitConfirmed(ctx context.Context, tx *types.Transaction, confirmations uint64) (*types.Receipt, error) {
+	receipt, err := sc.txm.WaitMined(ctx, tx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wait for transaction to be mined: %w", err)
+	}
 	fmt.Printf("Transaction mined! Block number: %d\n", receipt.BlockNumber)
 
-	return nil
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return receipt, fmt.Errorf("transaction %s reverted", receipt.TxHash.Hex())
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		head, err := sc.client.BlockNumber(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get block number: %w", err)
+		}
+		if head+1 >= receipt.BlockNumber.Uint64()+confirmations {
+			return receipt, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
 }
 
-func (sc *SmartContract) Verify(ctx context.Context, from common.Address, issuer common.Address, treeIndex int, leaf [32]byte, proof [][32]byte) (bool, error) {
-	abiBytes, err := os.ReadFile("./smartcontract/build/NDACredential.abi")
-	if err != nil {
-		log.Fatalf("Failed to read ABI file: %v", err)
+type TxState int
+
+const (
+	TxPending TxState = iota
+	TxConfirmed
+	TxFailed
+	TxReplaced
+)
+
+// CheckTransaction reports the state of a submitted transaction, used to resume pending syncs after a restart
+func (sc *SmartContract) CheckTransaction(ctx context.Context, txHash common.Hash, nonce uint64, confirmations uint64) (TxState, *types.Receipt, error) {
+	receipt, err := sc.client.TransactionReceipt(ctx, txHash)
+	if err == nil {
+		if receipt.Status != types.ReceiptStatusSuccessful {
+			return TxFailed, receipt, nil
+		}
+		head, err := sc.client.BlockNumber(ctx)
+		if err != nil {
+			return TxPending, nil, fmt.Errorf("failed to get block number: %w", err)
+		}
+		if head+1 >= receipt.BlockNumber.Uint64()+confirmations {
+			return TxConfirmed, receipt, nil
+		}
+		return TxPending, receipt, nil
+	}
+	if isTxIndexing(err) {
+		return TxPending, nil, nil
 	}
-	contractABI, err := abi.JSON(strings.NewReader(string(abiBytes)))
+	if !errors.Is(err, ethereum.NotFound) {
+		return TxPending, nil, fmt.Errorf("failed to get transaction receipt: %w", err)
+	}
+
+	// Not mined: replaced if another transaction of the account used the nonce
+	minedNonce, err := sc.client.NonceAt(ctx, sc.auth.From, nil)
 	if err != nil {
-		log.Fatalf("Failed to parse contract ABI: %v", err)
+		return TxPending, nil, fmt.Errorf("failed to get account nonce: %w", err)
+	}
+	if minedNonce > nonce {
+		return TxReplaced, nil, nil
 	}
 
-	var proofHash []common.Hash
-	for _, p := range proof {
-		proofHash = append(proofHash, common.BytesToHash(p[:]))
+	// Dropped if the node does not know the transaction anymore
+	if _, _, err := sc.client.TransactionByHash(ctx, txHash); errors.Is(err, ethereum.NotFound) {
+		return TxFailed, nil, nil
+	} else if err != nil {
+		return TxPending, nil, fmt.Errorf("failed to get transaction: %w", err)
 	}
 
-	callData, err := contractABI.Pack("verifyVC", issuer, big.NewInt(int64(treeIndex)), leaf, proofHash)
+	return TxPending, nil, nil
+}
+
+func (sc *SmartContract) SendStatusLists(issuer common.Address, listIDs []string, lists [][]byte) error {
+	// Send the status lists to the smart contract
+	tx, err := sc.transact(context.Background(), "batchUpdateRevokeStatusList", issuer, listIDs, lists)
 	if err != nil {
-		log.Fatalf("Failed to pack data for contract call: %v", err)
+		return fmt.Errorf("failed to send status lists to contract: %w", err)
 	}
+	fmt.Printf("Transaction sent: %s\n", tx.Hash().Hex())
 
-	result, err := sc.client.CallContract(context.Background(), ethereum.CallMsg{
-		From: sc.auth.From,
-		To:   &sc.contractAddress,
-		Data: callData,
-	}, nil)
-	if err != nil {
-		log.Fatalf("Failed to call contract: %v", err)
+	// Wait for the transaction to be mined
+	if _, err := sc.WaitConfirmed(context.Background(), tx, 1); err != nil {
+		return err
 	}
 
-	var isVerified bool
-	err = contractABI.UnpackIntoInterface(&isVerified, "verifyVC", result)
+	return nil
+}
+
+// GetTreeRoot reads the root anchored for the tree, a zero root means the tree does not exist on chain
+func (sc *SmartContract) GetTreeRoot(ctx context.Context, issuer common.Address, treeIndex int) ([32]byte, error) {
+	root, err := sc.contract.GetTreeRoot(&bind.CallOpts{Context: ctx}, issuer, big.NewInt(int64(treeIndex)))
 	if err != nil {
-		log.Fatalf("Failed to unpack contract result: %v", err)
+		return [32]byte{}, fmt.Errorf("failed to get tree root from contract: %w", DecodeError(sc.abi, err))
 	}
+	return root, nil
+}
 
-	return isVerified, nil
+// Verify checks the proof of the leaf against the latest root of the tree on chain.
+// A tree which has never been anchored returns an error matching ErrTreeNotExists.
+func (sc *SmartContract) Verify(ctx context.Context, from common.Address, issuer common.Address, treeIndex int, leaf [32]byte, proof [][32]byte) (bool, error) {
+	return sc.VerifyAt(ctx, nil, from, issuer, treeIndex, leaf, proof)
+}
+
+// VerifyAt checks the proof against the root of the tree at the given block, nil for the latest block
+func (sc *SmartContract) VerifyAt(ctx context.Context, blockNumber *big.Int, from common.Address, issuer common.Address, treeIndex int, leaf [32]byte, proof [][32]byte) (bool, error) {
+	opts := &bind.CallOpts{
+		Context:     ctx,
+		From:        from,
+		BlockNumber: blockNumber,
+	}
+	verified, err := sc.contract.VerifyVC(opts, issuer, big.NewInt(int64(treeIndex)), leaf, proof)
+	if err != nil {
+		return false, fmt.Errorf("failed to call verifyVC: %w", DecodeError(sc.abi, err))
+	}
+	return verified, nil
 }
@@ -0,0 +1,155 @@
+package credential
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestChunkRoots(t *testing.T) {
+	ctx := context.Background()
+	chain := newTestChain(t, TxConfig{PollInterval: 10 * time.Millisecond})
+
+	var issuers []common.Address
+	var treeIDs []int
+	var roots [][32]byte
+	for i := 1; i <= 40; i++ {
+		issuers = append(issuers, chain.auth.From)
+		treeIDs = append(treeIDs, i)
+		roots = append(roots, crypto.Keccak256Hash(big.NewInt(int64(i)).Bytes()))
+	}
+
+	all, err := chain.contract.EstimateRoots(ctx, issuers, treeIDs, roots)
+	if err != nil {
+		t.Fatalf("Failed to estimate roots: %v", err)
+	}
+	budget := all / 3
+	chunks, err := chain.contract.ChunkRoots(ctx, issuers, treeIDs, roots, budget)
+	if err != nil {
+		t.Fatalf("Failed to chunk roots: %v", err)
+	}
+	if len(chunks) < 3 {
+		t.Fatalf("Expected at least 3 chunks under a third of the gas, got %d", len(chunks))
+	}
+
+	// Chunks are contiguous, cover every root and stay under the budget
+	next := 0
+	var txs []*types.Transaction
+	for _, chunk := range chunks {
+		if chunk.Start != next || chunk.End <= chunk.Start {
+			t.Fatalf("Chunk %+v does not follow %d", chunk, next)
+		}
+		next = chunk.End
+		gas, err := chain.contract.EstimateRoots(ctx, issuers[chunk.Start:chunk.End], treeIDs[chunk.Start:chunk.End], roots[chunk.Start:chunk.End])
+		if err != nil || gas > budget {
+			t.Errorf("Chunk %+v needs %d gas, budget %d (%v)", chunk, gas, budget, err)
+		}
+
+		tx, err := chain.contract.SubmitRoots(ctx, issuers[chunk.Start:chunk.End], treeIDs[chunk.Start:chunk.End], roots[chunk.Start:chunk.End])
+		if err != nil {
+			t.Fatalf("Failed to submit chunk %+v: %v", chunk, err)
+		}
+		if len(txs) > 0 && tx.Nonce() != txs[len(txs)-1].Nonce()+1 {
+			t.Errorf("Expected sequential nonces, got %d after %d", tx.Nonce(), txs[len(txs)-1].Nonce())
+		}
+		txs = append(txs, tx)
+	}
+	if next != len(roots) {
+		t.Fatalf("Chunks cover %d of %d roots", next, len(roots))
+	}
+
+	// All chunks are mined in the same block
+	chain.sim.Commit()
+	for _, tx := range txs {
+		if _, err := chain.contract.WaitConfirmed(ctx, tx, 1); err != nil {
+			t.Fatalf("Failed to wait for chunk transaction: %v", err)
+		}
+	}
+	for i, treeID := range treeIDs {
+		root, err := chain.contract.GetTreeRoot(ctx, issuers[i], treeID)
+		if err != nil || root != roots[i] {
+			t.Errorf("Root of tree %d was not anchored (%v)", treeID, err)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	chain := newTestChain(t, TxConfig{PollInterval: 10 * time.Millisecond})
+	issuer := chain.auth.From
+
+	// Two leaf tree with sorted pairs, as OpenZeppelin's MerkleProof
+	a := crypto.Keccak256Hash([]byte("a"))
+	b := crypto.Keccak256Hash([]byte("b"))
+	pair := append(a.Bytes(), b.Bytes()...)
+	if bytes.Compare(a.Bytes(), b.Bytes()) > 0 {
+		pair = append(b.Bytes(), a.Bytes()...)
+	}
+	root := crypto.Keccak256Hash(pair)
+
+	if _, err := chain.contract.Verify(ctx, issuer, issuer, 1, a, [][32]byte{b}); !errors.Is(err, ErrTreeNotExists) {
+		t.Fatalf("Expected ErrTreeNotExists before anchoring, got %v", err)
+	}
+	var contractErr *ContractError
+	if _, err := chain.contract.Verify(ctx, issuer, issuer, 1, a, [][32]byte{b}); !errors.As(err, &contractErr) || contractErr.Name != "TreeNotExists" {
+		t.Errorf("Expected a decoded TreeNotExists error, got %v", err)
+	}
+
+	tx, err := chain.contract.SubmitRoots(ctx, []common.Address{issuer}, []int{1}, [][32]byte{root})
+	if err != nil {
+		t.Fatalf("Failed to submit root: %v", err)
+	}
+	chain.sim.Commit()
+	receipt, err := chain.contract.WaitConfirmed(ctx, tx, 1)
+	if err != nil {
+		t.Fatalf("Failed to wait for transaction: %v", err)
+	}
+
+	verified, err := chain.contract.Verify(ctx, common.Address{}, issuer, 1, a, [][32]byte{b})
+	if err != nil || !verified {
+		t.Fatalf("Expected the proof to verify, got %v (%v)", verified, err)
+	}
+	if verified, _ := chain.contract.Verify(ctx, issuer, issuer, 1, a, [][32]byte{a}); verified {
+		t.Errorf("Expected a wrong proof to fail")
+	}
+
+	// Replace the root, the proof still verifies at the block of the first root
+	tx, err = chain.contract.SubmitRoots(ctx, []common.Address{issuer}, []int{1}, [][32]byte{{1}})
+	if err != nil {
+		t.Fatalf("Failed to submit root: %v", err)
+	}
+	chain.sim.Commit()
+	if _, err := chain.contract.WaitConfirmed(ctx, tx, 1); err != nil {
+		t.Fatalf("Failed to wait for transaction: %v", err)
+	}
+	if verified, _ := chain.contract.Verify(ctx, issuer, issuer, 1, a, [][32]byte{b}); verified {
+		t.Errorf("Expected the proof to fail against the new root")
+	}
+	verified, err = chain.contract.VerifyAt(ctx, receipt.BlockNumber, issuer, issuer, 1, a, [][32]byte{b})
+	if err != nil || !verified {
+		t.Errorf("Expected the proof to verify at block %d, got %v (%v)", receipt.BlockNumber, verified, err)
+	}
+	before := new(big.Int).Sub(receipt.BlockNumber, big.NewInt(1))
+	if _, err := chain.contract.VerifyAt(ctx, before, issuer, issuer, 1, a, [][32]byte{b}); !errors.Is(err, ErrTreeNotExists) {
+		t.Errorf("Expected ErrTreeNotExists before the first root, got %v", err)
+	}
+
+	// Reverts while estimating a transaction are decoded too
+	if _, err := chain.contract.SubmitRoots(ctx, []common.Address{issuer}, []int{2}, [][32]byte{{}}); !errors.Is(err, ErrEmptyRoot) {
+		t.Errorf("Expected ErrEmptyRoot, got %v", err)
+	}
+
+	// A cancelled context is honored
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := chain.contract.Verify(cancelled, issuer, issuer, 1, a, [][32]byte{b}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
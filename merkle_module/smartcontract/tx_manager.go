@@ -0,0 +1,373 @@
+package credential
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+var (
+	ErrFeeCapTooLow   = errors.New("base fee is above the max fee per gas")
+	ErrFeeCapReached  = errors.New("fees can not be bumped above the max fee per gas")
+	ErrTxDropped      = errors.New("transaction was dropped")
+	ErrUnknownTxNonce = errors.New("transaction was not sent by the transaction manager")
+)
+
+// txIndexingData is the error data of a receipt request while geth indexes transactions
+const txIndexingData = "transaction indexing is in progress"
+
+// Backend is the part of the Ethereum client used to send and track transactions
+type Backend interface {
+	bind.ContractBackend
+	bind.DeployBackend
+	BlockNumber(ctx context.Context) (uint64, error)
+	ChainID(ctx context.Context) (*big.Int, error)
+	NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error)
+	TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error)
+}
+
+type TxConfig struct {
+	GasMarginPercent uint64        // added to the estimated gas, defaults to 20
+	MaxFeePerGas     *big.Int      // cap of the fee per gas, nil for no cap
+	MaxTipPerGas     *big.Int      // cap of the priority fee per gas, nil for no cap
+	FeeBumpPercent   uint64        // fee increase of a replacement, at least 10 as required by the tx pool
+	StuckAfter       time.Duration // time without inclusion before the fees are bumped, defaults to 2 minutes
+	MaxBumps         int           // replacements sent before waiting without bumping, defaults to 5
+	PollInterval     time.Duration // receipt polling interval, defaults to 1 second
+}
+
+// TxManager sends the transactions of one account. It hands out nonces locally so several transactions
+// can be in flight, estimates the gas of every transaction, prices it with EIP-1559 fees under the
+// configured caps and replaces transactions which are not mined in time with higher fees.
+type TxManager struct {
+	backend Backend
+	auth    *bind.TransactOpts
+	cfg     TxConfig
+
+	mu        sync.Mutex
+	chainID   *big.Int
+	nonce     *uint64                         // next nonce, nil until synced with the node
+	attempts  map[uint64][]*types.Transaction // transactions sent per nonce, the last one has the highest fees
+	firstSent map[uint64]time.Time
+}
+
+func NewTxManager(backend Backend, auth *bind.TransactOpts, cfg TxConfig) *TxManager {
+	if cfg.GasMarginPercent == 0 {
+		cfg.GasMarginPercent = 20
+	}
+	if cfg.FeeBumpPercent < 10 {
+		cfg.FeeBumpPercent = 10
+	}
+	if cfg.StuckAfter == 0 {
+		cfg.StuckAfter = 2 * time.Minute
+	}
+	if cfg.MaxBumps == 0 {
+		cfg.MaxBumps = 5
+	}
+	if cfg.PollInterval == 0 {
+		cfg.PollInterval = time.Second
+	}
+	return &TxManager{
+		backend:   backend,
+		auth:      auth,
+		cfg:       cfg,
+		attempts:  make(map[uint64][]*types.Transaction),
+		firstSent: make(map[uint64]time.Time),
+	}
+}
+
+func (m *TxManager) From() common.Address {
+	return m.auth.From
+}
+
+// Resync drops the local nonce, the next transaction uses the pending nonce of the node
+func (m *TxManager) Resync() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nonce = nil
+}
+
+// Send estimates, prices, signs and sends a call of the contract at to with the given data
+func (m *TxManager) Send(ctx context.Context, to common.Address, data []byte) (*types.Transaction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Estimate before taking a nonce, a reverting call never consumes one
+	estimated, err := m.backend.EstimateGas(ctx, ethereum.CallMsg{From: m.auth.From, To: &to, Data: data})
+	if err != nil {
+		return nil, fmt.Errorf("failed to estimate gas: %w", err)
+	}
+	gas := estimated + estimated*m.cfg.GasMarginPercent/100
+
+	nonce, err := m.nextNonce(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tipCap, feeCap, err := m.suggestFees(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := m.signAndSend(ctx, &types.DynamicFeeTx{
+		Nonce:     nonce,
+		GasTipCap: tipCap,
+		GasFeeCap: feeCap,
+		Gas:       gas,
+		To:        &to,
+		Data:      data,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m.nonce = new(uint64)
+	*m.nonce = nonce + 1
+	m.attempts[nonce] = []*types.Transaction{tx}
+	m.firstSent[nonce] = time.Now()
+
+	return tx, nil
+}
+
+// Bump replaces the transaction with one with the same nonce and data and higher fees
+func (m *TxManager) Bump(ctx context.Context, tx *types.Transaction) (*types.Transaction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempts := m.attempts[tx.Nonce()]
+	if len(attempts) == 0 {
+		return nil, fmt.Errorf("%w: nonce %d", ErrUnknownTxNonce, tx.Nonce())
+	}
+	latest := attempts[len(attempts)-1]
+
+	// The replacement pays the bumped fees, or the current market fees if they are higher
+	tipCap := bumpFee(latest.GasTipCap(), m.cfg.FeeBumpPercent)
+	feeCap := bumpFee(latest.GasFeeCap(), m.cfg.FeeBumpPercent)
+	suggestedTip, suggestedFee, err := m.suggestFees(ctx)
+	if err != nil && !errors.Is(err, ErrFeeCapTooLow) {
+		return nil, err
+	}
+	if err == nil {
+		tipCap = maxBig(tipCap, suggestedTip)
+		feeCap = maxBig(feeCap, suggestedFee)
+	}
+	if m.cfg.MaxFeePerGas != nil && feeCap.Cmp(m.cfg.MaxFeePerGas) > 0 {
+		return nil, fmt.Errorf("%w: nonce %d", ErrFeeCapReached, tx.Nonce())
+	}
+	if m.cfg.MaxTipPerGas != nil && tipCap.Cmp(m.cfg.MaxTipPerGas) > 0 {
+		return nil, fmt.Errorf("%w: nonce %d", ErrFeeCapReached, tx.Nonce())
+	}
+
+	replacement, err := m.signAndSend(ctx, &types.DynamicFeeTx{
+		Nonce:     latest.Nonce(),
+		GasTipCap: tipCap,
+		GasFeeCap: feeCap,
+		Gas:       latest.Gas(),
+		To:        latest.To(),
+		Value:     latest.Value(),
+		Data:      latest.Data(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	m.attempts[tx.Nonce()] = append(attempts, replacement)
+	log.Printf("Replaced transaction %s with %s, fee cap %s, tip cap %s", latest.Hash().Hex(), replacement.Hash().Hex(), feeCap, tipCap)
+
+	return replacement, nil
+}
+
+// WaitMined waits until the transaction or one of its replacements is mined and returns its receipt.
+// Transactions which are not mined after StuckAfter are replaced with higher fees.
+func (m *TxManager) WaitMined(ctx context.Context, tx *types.Transaction) (*types.Receipt, error) {
+	ticker := time.NewTicker(m.cfg.PollInterval)
+	defer ticker.Stop()
+
+	nonce := tx.Nonce()
+	defer m.forget(nonce)
+
+	for {
+		indexing := false
+		for _, attempt := range m.attemptsOf(tx) {
+			receipt, err := m.backend.TransactionReceipt(ctx, attempt.Hash())
+			if err == nil {
+				return receipt, nil
+			}
+			if isTxIndexing(err) {
+				indexing = true
+				continue
+			}
+			if !errors.Is(err, ethereum.NotFound) {
+				return nil, fmt.Errorf("failed to get transaction receipt: %w", err)
+			}
+		}
+
+		// Another transaction of the account took the nonce, unless the receipt is not indexed yet
+		mined, err := m.backend.NonceAt(ctx, m.auth.From, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get account nonce: %w", err)
+		}
+		if mined > nonce && !indexing {
+			// The receipt may not be available right after the block
+			if receipt := m.minedAttempt(ctx, tx); receipt != nil {
+				return receipt, nil
+			}
+			return nil, fmt.Errorf("%w: nonce %d was used by another transaction", ErrTxDropped, nonce)
+		}
+
+		if m.isStuck(nonce) {
+			if _, err := m.Bump(ctx, tx); err != nil {
+				log.Printf("Failed to bump transaction with nonce %d: %v", nonce, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// isTxIndexing tells whether the node has not indexed the transactions of the latest blocks yet,
+// their receipts are then served a little later
+func isTxIndexing(err error) bool {
+	var dataErr rpc.DataError
+	return errors.As(err, &dataErr) && dataErr.ErrorData() == txIndexingData
+}
+
+// helper function to get the next nonce, the node's pending nonce wins if another sender used the account
+func (m *TxManager) nextNonce(ctx context.Context) (uint64, error) {
+	pending, err := m.backend.PendingNonceAt(ctx, m.auth.From)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get pending nonce: %w", err)
+	}
+	if m.nonce != nil && *m.nonce > pending {
+		return *m.nonce, nil
+	}
+	return pending, nil
+}
+
+// helper function to price a transaction, the fee cap covers a doubled base fee as the clients do
+func (m *TxManager) suggestFees(ctx context.Context) (*big.Int, *big.Int, error) {
+	head, err := m.backend.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get head: %w", err)
+	}
+	if head.BaseFee == nil {
+		return nil, nil, fmt.Errorf("chain does not support EIP-1559 transactions")
+	}
+
+	tipCap, err := m.backend.SuggestGasTipCap(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to suggest gas tip cap: %w", err)
+	}
+	if m.cfg.MaxTipPerGas != nil && tipCap.Cmp(m.cfg.MaxTipPerGas) > 0 {
+		tipCap = new(big.Int).Set(m.cfg.MaxTipPerGas)
+	}
+
+	feeCap := new(big.Int).Add(new(big.Int).Mul(head.BaseFee, big.NewInt(2)), tipCap)
+	if m.cfg.MaxFeePerGas != nil && feeCap.Cmp(m.cfg.MaxFeePerGas) > 0 {
+		if head.BaseFee.Cmp(m.cfg.MaxFeePerGas) >= 0 {
+			return nil, nil, fmt.Errorf("%w: base fee %s, max fee %s", ErrFeeCapTooLow, head.BaseFee, m.cfg.MaxFeePerGas)
+		}
+		feeCap = new(big.Int).Set(m.cfg.MaxFeePerGas)
+		if tipCap.Cmp(feeCap) > 0 {
+			tipCap = new(big.Int).Set(feeCap)
+		}
+	}
+
+	return tipCap, feeCap, nil
+}
+
+func (m *TxManager) signAndSend(ctx context.Context, inner *types.DynamicFeeTx) (*types.Transaction, error) {
+	if m.chainID == nil {
+		chainID, err := m.backend.ChainID(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get chain ID: %w", err)
+		}
+		m.chainID = chainID
+	}
+	inner.ChainID = m.chainID
+
+	tx, err := m.auth.Signer(m.auth.From, types.NewTx(inner))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign transaction: %w", err)
+	}
+
+	if err := m.backend.SendTransaction(ctx, tx); err != nil {
+		// The local nonce is out of sync with the node, take the pending nonce next time
+		if isNonceError(err) {
+			m.nonce = nil
+		}
+		return nil, fmt.Errorf("failed to send transaction with nonce %d: %w", inner.Nonce, err)
+	}
+
+	return tx, nil
+}
+
+func (m *TxManager) attemptsOf(tx *types.Transaction) []*types.Transaction {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	attempts := m.attempts[tx.Nonce()]
+	if len(attempts) == 0 {
+		return []*types.Transaction{tx}
+	}
+	return append([]*types.Transaction(nil), attempts...)
+}
+
+func (m *TxManager) minedAttempt(ctx context.Context, tx *types.Transaction) *types.Receipt {
+	for _, attempt := range m.attemptsOf(tx) {
+		if receipt, err := m.backend.TransactionReceipt(ctx, attempt.Hash()); err == nil {
+			return receipt
+		}
+	}
+	return nil
+}
+
+func (m *TxManager) isStuck(nonce uint64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sent, exists := m.firstSent[nonce]
+	if !exists {
+		return false
+	}
+	// Every replacement gets another StuckAfter to be mined
+	attempts := len(m.attempts[nonce])
+	return attempts <= m.cfg.MaxBumps && time.Since(sent) > time.Duration(attempts)*m.cfg.StuckAfter
+}
+
+func (m *TxManager) forget(nonce uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.attempts, nonce)
+	delete(m.firstSent, nonce)
+}
+
+func isNonceError(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "nonce too low") || strings.Contains(msg, "nonce too high") || strings.Contains(msg, "already known") || strings.Contains(msg, "replacement transaction underpriced")
+}
+
+func bumpFee(fee *big.Int, percent uint64) *big.Int {
+	bumped := new(big.Int).Mul(fee, big.NewInt(int64(100+percent)))
+	bumped.Add(bumped, big.NewInt(99)) // round up, the tx pool rejects replacements below the exact bump
+	return bumped.Div(bumped, big.NewInt(100))
+}
+
+func maxBig(a, b *big.Int) *big.Int {
+	if a.Cmp(b) >= 0 {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,211 @@
+package credential
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient/simulated"
+)
+
+type testChain struct {
+	sim      *simulated.Backend
+	auth     *bind.TransactOpts
+	address  common.Address
+	contract *SmartContract
+}
+
+func newTestChain(t *testing.T, cfg TxConfig) *testChain {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	return newTestChainWithSigner(t, NewKeySigner(key), cfg)
+}
+
+// newTestChainWithSigner funds the signer's account and deploys the contract with it
+func newTestChainWithSigner(t *testing.T, signer Signer, cfg TxConfig) *testChain {
+	t.Helper()
+	balance, _ := new(big.Int).SetString("1000000000000000000000", 10)
+	sim := simulated.NewBackend(types.GenesisAlloc{signer.Address(): {Balance: balance}})
+	t.Cleanup(func() { sim.Close() })
+
+	chainID, err := sim.Client().ChainID(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get chain ID: %v", err)
+	}
+	auth := NewSignerTransactOpts(signer, chainID)
+
+	address, _, contract, err := DeployCredential(auth, sim.Client())
+	if err != nil {
+		t.Fatalf("Failed to deploy contract: %v", err)
+	}
+	sim.Commit()
+
+	smartContract, err := NewSmartContract(sim.Client(), contract, address, auth)
+	if err != nil {
+		t.Fatalf("Failed to create smart contract: %v", err)
+	}
+	smartContract.SetTxConfig(cfg)
+	return &testChain{sim: sim, auth: auth, address: address, contract: smartContract}
+}
+
+func (c *testChain) submitRoot(t *testing.T, treeID int) *types.Transaction {
+	t.Helper()
+	root := crypto.Keccak256Hash(big.NewInt(int64(treeID)).Bytes())
+	tx, err := c.contract.SubmitRoots(context.Background(), []common.Address{c.auth.From}, []int{treeID}, [][32]byte{root})
+	if err != nil {
+		t.Fatalf("Failed to submit root of tree %d: %v", treeID, err)
+	}
+	return tx
+}
+
+func TestTxManagerTracksNonces(t *testing.T) {
+	ctx := context.Background()
+	chain := newTestChain(t, TxConfig{PollInterval: 10 * time.Millisecond})
+
+	// Several transactions in flight get consecutive nonces
+	first := chain.submitRoot(t, 1)
+	second := chain.submitRoot(t, 2)
+	if second.Nonce() != first.Nonce()+1 {
+		t.Fatalf("Expected consecutive nonces, got %d and %d", first.Nonce(), second.Nonce())
+	}
+	if first.Type() != types.DynamicFeeTxType {
+		t.Errorf("Expected an EIP-1559 transaction, got type %d", first.Type())
+	}
+	if first.Gas() >= 3000000 || first.Gas() < 21000 {
+		t.Errorf("Expected an estimated gas limit, got %d", first.Gas())
+	}
+	chain.sim.Commit()
+
+	for _, tx := range []*types.Transaction{first, second} {
+		receipt, err := chain.contract.WaitConfirmed(ctx, tx, 1)
+		if err != nil {
+			t.Fatalf("Failed to wait for transaction: %v", err)
+		}
+		if receipt.TxHash != tx.Hash() {
+			t.Errorf("Unexpected receipt for %s", tx.Hash().Hex())
+		}
+	}
+
+	// A transaction sent outside of the manager is picked up from the pending nonce
+	if _, err := chain.contract.contract.UpdateTreeRoot(chain.auth, chain.auth.From, big.NewInt(3), [32]byte{1}); err != nil {
+		t.Fatalf("Failed to send external transaction: %v", err)
+	}
+	third := chain.submitRoot(t, 4)
+	if third.Nonce() != second.Nonce()+2 {
+		t.Errorf("Expected nonce %d after the external transaction, got %d", second.Nonce()+2, third.Nonce())
+	}
+}
+
+func TestTxManagerBumpsStuckTransaction(t *testing.T) {
+	ctx := context.Background()
+	chain := newTestChain(t, TxConfig{PollInterval: 10 * time.Millisecond, StuckAfter: 20 * time.Millisecond})
+
+	tx := chain.submitRoot(t, 1)
+
+	// Mine once the replacement has been sent
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			chain.contract.txm.mu.Lock()
+			attempts := len(chain.contract.txm.attempts[tx.Nonce()])
+			chain.contract.txm.mu.Unlock()
+			if attempts > 1 {
+				chain.sim.Commit()
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+
+	waitCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	receipt, err := chain.contract.WaitConfirmed(waitCtx, tx, 1)
+	<-done
+	if err != nil {
+		t.Fatalf("Failed to wait for transaction: %v", err)
+	}
+	if receipt.TxHash == tx.Hash() {
+		t.Fatalf("Expected the replacement to be mined")
+	}
+
+	mined, _, err := chain.sim.Client().TransactionByHash(ctx, receipt.TxHash)
+	if err != nil {
+		t.Fatalf("Failed to get replacement: %v", err)
+	}
+	if mined.Nonce() != tx.Nonce() || mined.GasFeeCap().Cmp(tx.GasFeeCap()) <= 0 || mined.GasTipCap().Cmp(tx.GasTipCap()) <= 0 {
+		t.Errorf("Expected a replacement with the same nonce and higher fees")
+	}
+}
+
+func TestTxManagerFeeCaps(t *testing.T) {
+	ctx := context.Background()
+	maxFee := big.NewInt(2_000_000_000)
+	chain := newTestChain(t, TxConfig{MaxFeePerGas: maxFee})
+
+	tx := chain.submitRoot(t, 1)
+	if tx.GasFeeCap().Cmp(maxFee) > 0 {
+		t.Errorf("Fee cap %s is above the max fee %s", tx.GasFeeCap(), maxFee)
+	}
+
+	// Bumping stops at the max fee
+	var err error
+	for i := 0; i < 20 && err == nil; i++ {
+		tx, err = chain.contract.txm.Bump(ctx, tx)
+	}
+	if !errors.Is(err, ErrFeeCapReached) {
+		t.Errorf("Expected ErrFeeCapReached, got %v", err)
+	}
+
+	// Below the base fee nothing is sent
+	chain.contract.SetTxConfig(TxConfig{MaxFeePerGas: big.NewInt(1)})
+	if _, err := chain.contract.SubmitRoots(ctx, []common.Address{chain.auth.From}, []int{2}, [][32]byte{{2}}); !errors.Is(err, ErrFeeCapTooLow) {
+		t.Errorf("Expected ErrFeeCapTooLow, got %v", err)
+	}
+}
+
+// txIndexingError is the error geth returns for receipts while it indexes transactions
+type txIndexingError struct{}
+
+func (txIndexingError) Error() string          { return txIndexingData }
+func (txIndexingError) ErrorData() interface{} { return txIndexingData }
+
+// indexingBackend serves no receipt until it was asked for receipts a number of times
+type indexingBackend struct {
+	Backend
+	indexing int
+}
+
+func (b *indexingBackend) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	if b.indexing > 0 {
+		b.indexing--
+		return nil, txIndexingError{}
+	}
+	return b.Backend.TransactionReceipt(ctx, txHash)
+}
+
+func TestTxManagerWaitsForIndexing(t *testing.T) {
+	ctx := context.Background()
+	chain := newTestChain(t, TxConfig{PollInterval: 10 * time.Millisecond})
+	tx := chain.submitRoot(t, 1)
+	chain.sim.Commit()
+
+	// the nonce is already used, the transaction is still not taken for dropped
+	backend := &indexingBackend{Backend: chain.sim.Client(), indexing: 3}
+	receipt, err := NewTxManager(backend, chain.auth, TxConfig{PollInterval: 10 * time.Millisecond}).WaitMined(ctx, tx)
+	if err != nil {
+		t.Fatalf("Failed to wait for transaction: %v", err)
+	}
+	if receipt.TxHash != tx.Hash() || backend.indexing != 0 {
+		t.Errorf("Expected the receipt of %s once indexed, got %s with %d requests left", tx.Hash().Hex(), receipt.TxHash.Hex(), backend.indexing)
+	}
+}
@@ -0,0 +1,476 @@
+// Package snapshot reads and writes the portable snapshot files of issuer trees.
+//
+// A snapshot starts with the magic "MRKLSNAP" and the format version as a uvarint, followed by
+// records: a kind byte, the payload length as a uvarint and the payload. A tree record starts
+// every tree and is followed by its node, root, chain, sync and seal records. The end record
+// holds the number of trees and is followed by the SHA-256 of every byte before it, so a
+// truncated or altered snapshot is rejected once read to the end.
+//
+// Integers are uvarints, byte strings and strings are prefixed by their length as a uvarint.
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"time"
+
+	"merkle_module/domain/entities"
+	"merkle_module/merkletree"
+	"merkle_module/utils"
+)
+
+const Version = 1
+
+const magic = "MRKLSNAP"
+
+// maxRecord bounds the payload of a record, well above the largest one
+const maxRecord = 1 << 20
+
+const (
+	kindTree  byte = 1 // id, issuer DID, node count
+	kindNode  byte = 2 // node ID, data
+	kindRoot  byte = 3 // size, root
+	kindChain byte = 4 // chain ID, need sync, node count synced
+	kindSync  byte = 5 // chain ID, node count, root, status, tx hash, nonce, block number, error, issuer address, checkpoint ID and position
+	kindSeal  byte = 6 // root, chain ID, tx hash, sealed at in unix nanoseconds
+	kindEnd   byte = 0xff
+)
+
+// Writer streams tree snapshots into a snapshot file
+type Writer struct {
+	w     *bufio.Writer
+	hash  hash.Hash
+	trees int
+	err   error
+}
+
+// NewWriter writes the header of a snapshot to w
+func NewWriter(w io.Writer) (*Writer, error) {
+	sw := &Writer{w: bufio.NewWriter(w), hash: sha256.New()}
+	header := binary.AppendUvarint([]byte(magic), Version)
+	if err := sw.write(header); err != nil {
+		return nil, err
+	}
+	return sw, nil
+}
+
+func (w *Writer) write(data []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.hash.Write(data)
+	if _, err := w.w.Write(data); err != nil {
+		w.err = fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return w.err
+}
+
+func (w *Writer) record(kind byte, payload *encoder) error {
+	header := binary.AppendUvarint([]byte{kind}, uint64(len(payload.buf)))
+	if err := w.write(header); err != nil {
+		return err
+	}
+	return w.write(payload.buf)
+}
+
+// WriteTree appends a tree to the snapshot
+func (w *Writer) WriteTree(snapshot *entities.TreeSnapshot) error {
+	tree := &encoder{}
+	tree.int(snapshot.Tree.ID)
+	tree.string(snapshot.Tree.IssuerDID)
+	tree.int(snapshot.Tree.NodeCount)
+	if err := w.record(kindTree, tree); err != nil {
+		return err
+	}
+
+	for _, node := range snapshot.Nodes {
+		payload := &encoder{}
+		payload.int(node.NodeID)
+		payload.bytes(node.Data)
+		if err := w.record(kindNode, payload); err != nil {
+			return err
+		}
+	}
+	for _, root := range snapshot.Roots {
+		payload := &encoder{}
+		payload.int(root.Size)
+		payload.bytes(root.Root)
+		if err := w.record(kindRoot, payload); err != nil {
+			return err
+		}
+	}
+	for _, chain := range snapshot.Chains {
+		payload := &encoder{}
+		payload.uint(chain.ChainID)
+		payload.bool(chain.NeedSync)
+		payload.int(chain.NodeCountSync)
+		if err := w.record(kindChain, payload); err != nil {
+			return err
+		}
+	}
+	for _, treeSync := range snapshot.Syncs {
+		payload := &encoder{}
+		payload.uint(treeSync.ChainID)
+		payload.int(treeSync.NodeCount)
+		payload.bytes(treeSync.Root)
+		payload.string(treeSync.Status)
+		payload.string(treeSync.TxHash)
+		payload.uint(treeSync.Nonce)
+		payload.uint(treeSync.BlockNumber)
+		payload.string(treeSync.Error)
+		payload.string(treeSync.IssuerAddress)
+		payload.int(treeSync.CheckpointID)
+		payload.int(treeSync.CheckpointPosition)
+		if err := w.record(kindSync, payload); err != nil {
+			return err
+		}
+	}
+	if seal := snapshot.Seal; seal != nil {
+		payload := &encoder{}
+		payload.bytes(seal.Root)
+		payload.uint(seal.ChainID)
+		payload.string(seal.TxHash)
+		payload.uint(uint64(seal.SealedAt.UnixNano()))
+		if err := w.record(kindSeal, payload); err != nil {
+			return err
+		}
+	}
+
+	w.trees++
+	return nil
+}
+
+// Close writes the end record and the checksum and flushes the snapshot, it does not close the
+// underlying writer
+func (w *Writer) Close() error {
+	end := &encoder{}
+	end.int(w.trees)
+	if err := w.record(kindEnd, end); err != nil {
+		return err
+	}
+	if _, err := w.w.Write(w.hash.Sum(nil)); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := w.w.Flush(); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return nil
+}
+
+// Reader streams the tree snapshots of a snapshot file. The trees it returns are only known
+// to be intact once Next returned io.EOF, after the checksum was checked.
+type Reader struct {
+	r    *bufio.Reader
+	hash hash.Hash
+	// record read ahead, the first record after the last tree returned
+	kind    byte
+	payload []byte
+	trees   int
+	done    bool
+}
+
+// NewReader reads the header of a snapshot from r
+func NewReader(r io.Reader) (*Reader, error) {
+	sr := &Reader{r: bufio.NewReader(r), hash: sha256.New()}
+	header := make([]byte, len(magic))
+	if _, err := io.ReadFull(sr.r, header); err != nil || string(header) != magic {
+		return nil, fmt.Errorf("not a snapshot file")
+	}
+	sr.hash.Write(header)
+	version, err := sr.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	if version != Version {
+		return nil, fmt.Errorf("unsupported snapshot version %d, expected %d", version, Version)
+	}
+	if err := sr.next(); err != nil {
+		return nil, err
+	}
+	return sr, nil
+}
+
+// uvarint reads a uvarint outside of a payload, adding it to the checksum
+func (r *Reader) uvarint() (uint64, error) {
+	var buf []byte
+	for {
+		b, err := r.r.ReadByte()
+		if err != nil {
+			return 0, truncated(err)
+		}
+		buf = append(buf, b)
+		if b < 0x80 || len(buf) == binary.MaxVarintLen64 {
+			break
+		}
+	}
+	r.hash.Write(buf)
+	value, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return 0, fmt.Errorf("invalid snapshot: malformed integer")
+	}
+	return value, nil
+}
+
+// next reads the next record ahead
+func (r *Reader) next() error {
+	kind, err := r.r.ReadByte()
+	if err != nil {
+		return truncated(err)
+	}
+	r.hash.Write([]byte{kind})
+	size, err := r.uvarint()
+	if err != nil {
+		return err
+	}
+	if size > maxRecord {
+		return fmt.Errorf("invalid snapshot: record of %d bytes", size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r.r, payload); err != nil {
+		return truncated(err)
+	}
+	r.hash.Write(payload)
+	r.kind, r.payload = kind, payload
+	return nil
+}
+
+func truncated(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("invalid snapshot: truncated")
+	}
+	return fmt.Errorf("failed to read snapshot: %w", err)
+}
+
+// Next returns the next tree of the snapshot, io.EOF once the snapshot was read to its end and
+// its checksum matched
+func (r *Reader) Next() (*entities.TreeSnapshot, error) {
+	if r.done {
+		return nil, io.EOF
+	}
+	if r.kind == kindEnd {
+		return nil, r.end()
+	}
+	if r.kind != kindTree {
+		return nil, fmt.Errorf("invalid snapshot: record of kind %d outside of a tree", r.kind)
+	}
+
+	d := &decoder{buf: r.payload}
+	snapshot := &entities.TreeSnapshot{Tree: &entities.MerkleTree{ID: d.int(), IssuerDID: d.string(), NodeCount: d.int()}}
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	treeID := snapshot.Tree.ID
+	for {
+		if err := r.next(); err != nil {
+			return nil, err
+		}
+		d := &decoder{buf: r.payload}
+		switch r.kind {
+		case kindNode:
+			snapshot.Nodes = append(snapshot.Nodes, &entities.MerkleNode{TreeID: treeID, NodeID: d.int(), Data: d.bytes()})
+		case kindRoot:
+			snapshot.Roots = append(snapshot.Roots, &entities.TreeRoot{TreeID: treeID, Size: d.int(), Root: d.bytes()})
+		case kindChain:
+			snapshot.Chains = append(snapshot.Chains, &entities.TreeChain{TreeID: treeID, ChainID: d.uint(), NeedSync: d.bool(), NodeCountSync: d.int()})
+		case kindSync:
+			snapshot.Syncs = append(snapshot.Syncs, &entities.TreeSync{
+				TreeID:             treeID,
+				ChainID:            d.uint(),
+				NodeCount:          d.int(),
+				Root:               d.bytes(),
+				Status:             d.string(),
+				TxHash:             d.string(),
+				Nonce:              d.uint(),
+				BlockNumber:        d.uint(),
+				Error:              d.string(),
+				IssuerAddress:      d.string(),
+				CheckpointID:       d.int(),
+				CheckpointPosition: d.int(),
+			})
+		case kindSeal:
+			if snapshot.Seal != nil {
+				return nil, fmt.Errorf("invalid snapshot: tree ID %d sealed twice", treeID)
+			}
+			snapshot.Seal = &entities.TreeSeal{TreeID: treeID, Root: d.bytes(), ChainID: d.uint(), TxHash: d.string()}
+			snapshot.Seal.SealedAt = time.Unix(0, int64(d.uint())).UTC()
+		case kindTree, kindEnd:
+			r.trees++
+			return snapshot, nil
+		default:
+			return nil, fmt.Errorf("invalid snapshot: unknown record kind %d", r.kind)
+		}
+		if err := d.finish(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// end checks the end record and the checksum following it
+func (r *Reader) end() error {
+	d := &decoder{buf: r.payload}
+	trees := d.int()
+	if err := d.finish(); err != nil {
+		return err
+	}
+	if trees != r.trees {
+		return fmt.Errorf("invalid snapshot: %d trees read, %d written", r.trees, trees)
+	}
+
+	expected := r.hash.Sum(nil)
+	checksum := make([]byte, len(expected))
+	if _, err := io.ReadFull(r.r, checksum); err != nil {
+		return truncated(err)
+	}
+	if !bytes.Equal(checksum, expected) {
+		return fmt.Errorf("invalid snapshot: checksum mismatch")
+	}
+	if _, err := r.r.ReadByte(); err != io.EOF {
+		return fmt.Errorf("invalid snapshot: data after the checksum")
+	}
+	r.done = true
+	return io.EOF
+}
+
+// Verify checks that the leaves of a tree lead to every root recorded for it: the roots of its
+// history, of its confirmed syncs and of its seal
+func Verify(snapshot *entities.TreeSnapshot) error {
+	tree := snapshot.Tree
+	if tree.ID <= 0 || tree.IssuerDID == "" {
+		return fmt.Errorf("tree ID %d of issuer %q is invalid", tree.ID, tree.IssuerDID)
+	}
+	if tree.NodeCount > utils.MAX_LEAFS || len(snapshot.Nodes) > tree.NodeCount {
+		return fmt.Errorf("tree ID %d holds %d nodes for a node count of %d", tree.ID, len(snapshot.Nodes), tree.NodeCount)
+	}
+	leaves := make([][]byte, len(snapshot.Nodes))
+	for i, node := range snapshot.Nodes {
+		if node.NodeID <= 0 || node.NodeID > tree.NodeCount || (i > 0 && node.NodeID <= snapshot.Nodes[i-1].NodeID) {
+			return fmt.Errorf("tree ID %d has node %d out of order or beyond its node count", tree.ID, node.NodeID)
+		}
+		leaves[i] = node.Data
+	}
+
+	rootAt := func(size int) ([]byte, error) {
+		if size <= 0 || size > len(leaves) {
+			return nil, fmt.Errorf("tree ID %d has no root at size %d with %d leaves", tree.ID, size, len(leaves))
+		}
+		built, err := merkletree.NewMerkleTree(leaves[:size], tree.ID)
+		if err != nil {
+			return nil, err
+		}
+		return built.GetMerkleRoot(), nil
+	}
+	check := func(what string, size int, recorded []byte) error {
+		root, err := rootAt(size)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(root, recorded) {
+			return fmt.Errorf("tree ID %d: %s %x at size %d differs from the recomputed root %x", tree.ID, what, recorded, size, root)
+		}
+		return nil
+	}
+
+	for _, root := range snapshot.Roots {
+		if err := check("root", root.Size, root.Root); err != nil {
+			return err
+		}
+	}
+	for _, treeSync := range snapshot.Syncs {
+		if err := check(fmt.Sprintf("root synced on chain %d", treeSync.ChainID), treeSync.NodeCount, treeSync.Root); err != nil {
+			return err
+		}
+	}
+	for _, chain := range snapshot.Chains {
+		if chain.NodeCountSync > len(leaves) {
+			return fmt.Errorf("tree ID %d is synced at %d nodes on chain %d with %d leaves", tree.ID, chain.NodeCountSync, chain.ChainID, len(leaves))
+		}
+	}
+	if seal := snapshot.Seal; seal != nil {
+		if len(leaves) != utils.MAX_LEAFS {
+			return fmt.Errorf("tree ID %d is sealed with %d leaves", tree.ID, len(leaves))
+		}
+		if err := check("sealed root", len(leaves), seal.Root); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) uint(value uint64) { e.buf = binary.AppendUvarint(e.buf, value) }
+func (e *encoder) int(value int)     { e.uint(uint64(value)) }
+func (e *encoder) bytes(value []byte) {
+	e.uint(uint64(len(value)))
+	e.buf = append(e.buf, value...)
+}
+func (e *encoder) string(value string) { e.bytes([]byte(value)) }
+func (e *encoder) bool(value bool) {
+	if value {
+		e.uint(1)
+	} else {
+		e.uint(0)
+	}
+}
+
+// decoder reads a payload, the first error is kept and returned by finish
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) uint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	value, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = fmt.Errorf("invalid snapshot: malformed record")
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return value
+}
+
+func (d *decoder) int() int {
+	value := d.uint()
+	if value > math.MaxInt32 {
+		d.err = fmt.Errorf("invalid snapshot: integer %d out of range", value)
+		return 0
+	}
+	return int(value)
+}
+
+func (d *decoder) bytes() []byte {
+	size := d.uint()
+	if d.err != nil {
+		return nil
+	}
+	if size > uint64(len(d.buf)) {
+		d.err = fmt.Errorf("invalid snapshot: malformed record")
+		return nil
+	}
+	value := bytes.Clone(d.buf[:size])
+	d.buf = d.buf[size:]
+	return value
+}
+
+func (d *decoder) string() string { return string(d.bytes()) }
+func (d *decoder) bool() bool     { return d.uint() != 0 }
+
+// finish returns the first error of the payload, or an error if bytes are left
+func (d *decoder) finish() error {
+	if d.err == nil && len(d.buf) > 0 {
+		d.err = fmt.Errorf("invalid snapshot: %d unexpected bytes in record", len(d.buf))
+	}
+	return d.err
+}
@@ -0,0 +1,168 @@
+package snapshot
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"merkle_module/domain/entities"
+	"merkle_module/merkletree"
+	"merkle_module/utils"
+)
+
+// testTree returns the snapshot of a tree holding n leaves, synced at its last leaf
+func testTree(t *testing.T, treeID, n int) *entities.TreeSnapshot {
+	t.Helper()
+	snapshot := &entities.TreeSnapshot{Tree: &entities.MerkleTree{ID: treeID, IssuerDID: "did:example:issuer", NodeCount: n}}
+	var leaves [][]byte
+	for i := 1; i <= n; i++ {
+		leaf := utils.Hash([]byte(fmt.Sprintf("leaf %d of tree %d", i, treeID)))
+		leaves = append(leaves, leaf)
+		snapshot.Nodes = append(snapshot.Nodes, &entities.MerkleNode{TreeID: treeID, NodeID: i, Data: leaf})
+
+		tree, err := merkletree.NewMerkleTree(leaves, treeID)
+		if err != nil {
+			t.Fatalf("Failed to build tree: %v", err)
+		}
+		snapshot.Roots = append(snapshot.Roots, &entities.TreeRoot{TreeID: treeID, Size: i, Root: tree.GetMerkleRoot()})
+	}
+	root := snapshot.Roots[n-1].Root
+	snapshot.Chains = []*entities.TreeChain{{TreeID: treeID, ChainID: 1337, NodeCountSync: n}}
+	snapshot.Syncs = []*entities.TreeSync{{
+		TreeID:        treeID,
+		ChainID:       1337,
+		NodeCount:     n,
+		Root:          root,
+		Status:        entities.SyncStatusConfirmed,
+		TxHash:        "0xabc",
+		Nonce:         7,
+		BlockNumber:   42,
+		IssuerAddress: "0x0c",
+	}}
+	if n == utils.MAX_LEAFS {
+		snapshot.Seal = &entities.TreeSeal{TreeID: treeID, Root: root, ChainID: 1337, TxHash: "0xabc", SealedAt: time.Unix(1700000000, 5).UTC()}
+	}
+	return snapshot
+}
+
+func writeSnapshot(t *testing.T, snapshots ...*entities.TreeSnapshot) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	for _, snapshot := range snapshots {
+		if err := w.WriteTree(snapshot); err != nil {
+			t.Fatalf("Failed to write tree: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// readSnapshot reads every tree of data, returning the error which ended the read
+func readSnapshot(data []byte) ([]*entities.TreeSnapshot, error) {
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var snapshots []*entities.TreeSnapshot
+	for {
+		snapshot, err := r.Next()
+		if err == io.EOF {
+			return snapshots, nil
+		}
+		if err != nil {
+			return snapshots, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	trees := []*entities.TreeSnapshot{
+		testTree(t, 1, utils.MAX_LEAFS),
+		{Tree: &entities.MerkleTree{ID: 2, IssuerDID: "did:example:issuer", NodeCount: 1}},
+		testTree(t, 5, 3),
+	}
+	read, err := readSnapshot(writeSnapshot(t, trees...))
+	if err != nil {
+		t.Fatalf("Failed to read snapshot: %v", err)
+	}
+	if len(read) != len(trees) {
+		t.Fatalf("Expected %d trees, got %d", len(trees), len(read))
+	}
+	for i, tree := range trees {
+		if !reflect.DeepEqual(read[i], tree) {
+			t.Errorf("Tree ID %d differs after a round trip: %+v", tree.Tree.ID, read[i])
+		}
+		if err := Verify(read[i]); err != nil {
+			t.Errorf("Expected tree ID %d to verify: %v", tree.Tree.ID, err)
+		}
+	}
+
+	if read, err := readSnapshot(writeSnapshot(t)); err != nil || len(read) != 0 {
+		t.Errorf("Expected an empty snapshot, got %d trees and error %v", len(read), err)
+	}
+}
+
+func TestCorruptSnapshot(t *testing.T) {
+	data := writeSnapshot(t, testTree(t, 1, 4), testTree(t, 2, 2))
+
+	flipped := bytes.Clone(data)
+	flipped[len(data)/2] ^= 1
+	altered := bytes.Clone(data)
+	altered[len(data)-1] ^= 1
+	version := bytes.Clone(data)
+	version[len(magic)] = Version + 1
+
+	for name, corrupt := range map[string][]byte{
+		"flipped":   flipped,
+		"checksum":  altered,
+		"truncated": data[:len(data)-10],
+		"trailing":  append(bytes.Clone(data), 0),
+		"version":   version,
+		"magic":     []byte("PK\x03\x04"),
+	} {
+		if _, err := readSnapshot(corrupt); err == nil {
+			t.Errorf("Expected the %s snapshot to be rejected", name)
+		}
+	}
+
+	_, err := NewReader(strings.NewReader("not a snapshot"))
+	if err == nil {
+		t.Errorf("Expected a file which is not a snapshot to be rejected")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	for name, alter := range map[string]func(s *entities.TreeSnapshot){
+		"root":         func(s *entities.TreeSnapshot) { s.Roots[1].Root = s.Roots[2].Root },
+		"sync":         func(s *entities.TreeSnapshot) { s.Syncs[0].NodeCount = 2 },
+		"missing leaf": func(s *entities.TreeSnapshot) { s.Nodes = s.Nodes[:2] },
+		"leaf order":   func(s *entities.TreeSnapshot) { s.Nodes[0], s.Nodes[1] = s.Nodes[1], s.Nodes[0] },
+		"node count":   func(s *entities.TreeSnapshot) { s.Tree.NodeCount = 2 },
+		"chain":        func(s *entities.TreeSnapshot) { s.Chains[0].NodeCountSync = 4 },
+		"partial seal": func(s *entities.TreeSnapshot) { s.Seal = &entities.TreeSeal{Root: s.Syncs[0].Root} },
+		"issuer":       func(s *entities.TreeSnapshot) { s.Tree.IssuerDID = "" },
+	} {
+		snapshot := testTree(t, 1, 3)
+		alter(snapshot)
+		if err := Verify(snapshot); err == nil {
+			t.Errorf("Expected the snapshot with an altered %s to fail verification", name)
+		}
+	}
+
+	sealed := testTree(t, 1, utils.MAX_LEAFS)
+	sealed.Seal.Root = sealed.Roots[0].Root
+	if err := Verify(sealed); err == nil {
+		t.Errorf("Expected a wrong sealed root to fail verification")
+	}
+}
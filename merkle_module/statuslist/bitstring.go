@@ -0,0 +1,105 @@
+package statuslist
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+)
+
+const (
+	// MIN_SIZE is the minimum bitstring length (16KB) required by the Bitstring Status List spec for herd privacy
+	MIN_SIZE = 131072
+
+	// multibase prefix of base64url without padding
+	multibaseBase64URL = 'u'
+)
+
+// Bitstring is a W3C Bitstring Status List where index 0 is the left-most bit of the first byte
+type Bitstring struct {
+	bits []byte
+	size int
+}
+
+func NewBitstring(size int) *Bitstring {
+	if size < MIN_SIZE {
+		size = MIN_SIZE
+	}
+	size = (size + 7) / 8 * 8
+	return &Bitstring{bits: make([]byte, size/8), size: size}
+}
+
+// FromBytes wraps the raw bits of a stored status list
+func FromBytes(bits []byte) *Bitstring {
+	bitsCopy := make([]byte, len(bits))
+	copy(bitsCopy, bits)
+	return &Bitstring{bits: bitsCopy, size: len(bits) * 8}
+}
+
+func (b *Bitstring) Size() int {
+	return b.size
+}
+
+func (b *Bitstring) Bytes() []byte {
+	return b.bits
+}
+
+func (b *Bitstring) Get(index int) (bool, error) {
+	if index < 0 || index >= b.size {
+		return false, fmt.Errorf("invalid status index: %d, must be between 0 and %d", index, b.size-1)
+	}
+	return b.bits[index/8]&(0x80>>(index%8)) != 0, nil
+}
+
+func (b *Bitstring) Set(index int, value bool) error {
+	if index < 0 || index >= b.size {
+		return fmt.Errorf("invalid status index: %d, must be between 0 and %d", index, b.size-1)
+	}
+	if value {
+		b.bits[index/8] |= 0x80 >> (index % 8)
+	} else {
+		b.bits[index/8] &^= 0x80 >> (index % 8)
+	}
+	return nil
+}
+
+// Encode returns the encodedList value: the multibase base64url encoding of the GZIP-compressed bitstring
+func (b *Bitstring) Encode() (string, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(b.bits); err != nil {
+		return "", fmt.Errorf("failed to compress bitstring: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return "", fmt.Errorf("failed to compress bitstring: %w", err)
+	}
+	return string(multibaseBase64URL) + base64.RawURLEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// Decode parses an encodedList value
+func Decode(encoded string) (*Bitstring, error) {
+	if len(encoded) == 0 {
+		return nil, fmt.Errorf("empty encoded list")
+	}
+	// the multibase prefix is optional in earlier versions of the spec
+	if encoded[0] == multibaseBase64URL {
+		encoded = encoded[1:]
+	}
+
+	compressed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode base64url: %w", err)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress bitstring: %w", err)
+	}
+	defer reader.Close()
+
+	bits, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress bitstring: %w", err)
+	}
+	return &Bitstring{bits: bits, size: len(bits) * 8}, nil
+}
@@ -0,0 +1,113 @@
+package statuslist
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBitstringSetGet(t *testing.T) {
+	b := NewBitstring(0)
+	if b.Size() != MIN_SIZE {
+		t.Fatalf("Expected minimum size %d, got %d", MIN_SIZE, b.Size())
+	}
+
+	indexes := []int{0, 7, 8, 1000, MIN_SIZE - 1}
+	for _, index := range indexes {
+		if err := b.Set(index, true); err != nil {
+			t.Fatalf("Failed to set index %d: %v", index, err)
+		}
+	}
+	for _, index := range indexes {
+		value, err := b.Get(index)
+		if err != nil || !value {
+			t.Errorf("Expected index %d to be set, got %v (%v)", index, value, err)
+		}
+	}
+	if value, _ := b.Get(1); value {
+		t.Errorf("Expected index 1 to be unset")
+	}
+
+	// index 0 is the left-most bit of the first byte
+	if b.Bytes()[0] != 0x81 {
+		t.Errorf("Unexpected first byte: %08b", b.Bytes()[0])
+	}
+
+	if err := b.Set(0, false); err != nil {
+		t.Fatalf("Failed to unset index 0: %v", err)
+	}
+	if value, _ := b.Get(0); value {
+		t.Errorf("Expected index 0 to be unset")
+	}
+
+	if err := b.Set(MIN_SIZE, true); err == nil {
+		t.Errorf("Expected error for out of range index")
+	}
+}
+
+func TestBitstringEncodeDecode(t *testing.T) {
+	b := NewBitstring(MIN_SIZE)
+	for _, index := range []int{3, 42, 94567} {
+		_ = b.Set(index, true)
+	}
+
+	encoded, err := b.Encode()
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	if encoded[0] != 'u' {
+		t.Errorf("Expected multibase base64url prefix, got %q", encoded[0])
+	}
+
+	decoded, err := Decode(encoded)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if decoded.Size() != b.Size() {
+		t.Fatalf("Size mismatch: got %d, want %d", decoded.Size(), b.Size())
+	}
+	for _, index := range []int{3, 42, 94567} {
+		if value, _ := decoded.Get(index); !value {
+			t.Errorf("Expected index %d to be set after decoding", index)
+		}
+	}
+	if value, _ := decoded.Get(4); value {
+		t.Errorf("Expected index 4 to be unset after decoding")
+	}
+}
+
+func TestDecodeSpecExample(t *testing.T) {
+	// encodedList of the example status list credential of the Bitstring Status List spec,
+	// a list of 131072 bits none of which is set
+	const specExample = "uH4sIAAAAAAAAA-3BMQEAAADCoPVPbQwfoAAAAAAAAAAAAAAAAAAAAIC3AYbSVKsAQAAA"
+	decoded, err := Decode(specExample)
+	if err != nil {
+		t.Fatalf("Failed to decode the spec example: %v", err)
+	}
+	if decoded.Size() != MIN_SIZE {
+		t.Fatalf("Expected %d bits, got %d", MIN_SIZE, decoded.Size())
+	}
+	for i, b := range decoded.Bytes() {
+		if b != 0 {
+			t.Fatalf("Expected no status set, got byte %d %08b", i, b)
+		}
+	}
+	if _, err := Decode(specExample[1:]); err != nil {
+		t.Errorf("Failed to decode the spec example without its multibase prefix: %v", err)
+	}
+
+	// the list of the same size is encoded as a GZIP member in base64url without padding
+	encoded, err := NewBitstring(MIN_SIZE).Encode()
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	if encoded[:5] != specExample[:5] {
+		t.Errorf("Expected the multibase prefix and the GZIP header of the spec example %q, got %q", specExample[:5], encoded[:5])
+	}
+	if strings.ContainsAny(encoded, "+/=") {
+		t.Errorf("Expected base64url without padding, got %q", encoded)
+	}
+	reencoded, err := decoded.Encode()
+	if err != nil || reencoded != encoded {
+		t.Errorf("Expected the spec example encoded as %q, got %q and error %v", encoded, reencoded, err)
+	}
+}
@@ -56,6 +56,19 @@ func NodesToBytes(nodes []*entities.MerkleNode) [][]byte {
 	return data
 }
 
+// LeadingLeaves returns the data of the nodes ordered by node ID from node 1 up to the first
+// missing node, and whether no node lies past it
+func LeadingLeaves(nodes []*entities.MerkleNode) ([][]byte, bool) {
+	var leaves [][]byte
+	for _, node := range nodes {
+		if node.NodeID != len(leaves)+1 {
+			break
+		}
+		leaves = append(leaves, node.Data)
+	}
+	return leaves, len(leaves) == len(nodes)
+}
+
 func ToByte32(data []byte) [32]byte {
 	var byte32 [32]byte
 	copy(byte32[:], data)
@@ -0,0 +1,290 @@
+package vc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"merkle_module/app/interfaces"
+	"merkle_module/domain/entities"
+	"merkle_module/utils"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+const (
+	ProofType    = "MerkleProof2025"
+	ProofPurpose = "assertionMethod"
+)
+
+var ErrNoMerkleProof = errors.New("credential has no merkle proof")
+
+// MerkleProof is the proof object embedded in a credential to show its inclusion in an anchored Merkle tree
+type MerkleProof struct {
+	Type               string   `json:"type"`
+	Created            string   `json:"created,omitempty"`
+	ProofPurpose       string   `json:"proofPurpose"`
+	VerificationMethod string   `json:"verificationMethod,omitempty"`
+	MerkleRoot         string   `json:"merkleRoot"`
+	ProofPath          []string `json:"proofPath"`
+	TreeIndex          int      `json:"treeIndex"`
+	LeafIndex          int      `json:"leafIndex"`
+	ChainID            uint64   `json:"chainId,omitempty"`
+	ContractAddress    string   `json:"contractAddress,omitempty"`
+	IssuerAddress      string   `json:"issuerAddress,omitempty"`
+	// Set when the merkleRoot is not anchored itself but committed into a checkpoint
+	Checkpoint *CheckpointProof `json:"checkpoint,omitempty"`
+}
+
+// CheckpointProof is the second level of a proof, from the merkleRoot of the tree to the
+// super-root anchored as tree checkpointIndex of the checkpoint issuerAddress
+type CheckpointProof struct {
+	CheckpointIndex int      `json:"checkpointIndex"`
+	IssuerAddress   string   `json:"issuerAddress"`
+	MerkleRoot      string   `json:"merkleRoot"`
+	ProofPath       []string `json:"proofPath"`
+}
+
+// ProofOptions holds the anchoring details that are not stored in the Merkle tree itself,
+// the proof is built against the root synced to ChainID
+type ProofOptions struct {
+	IssuerDID       string
+	IssuerAddress   common.Address
+	ChainID         uint64
+	ContractAddress common.Address
+}
+
+// LeafData returns the canonical form of the credential without its proofs, the value hashed into the tree
+func LeafData(credential []byte) ([]byte, error) {
+	value, err := decode(credential)
+	if err != nil {
+		return nil, err
+	}
+	doc, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("credential must be a json object")
+	}
+
+	// proofs are never part of the secured document
+	unsecured := make(map[string]interface{}, len(doc))
+	for key, v := range doc {
+		if key != "proof" {
+			unsecured[key] = v
+		}
+	}
+
+	raw, err := json.Marshal(unsecured)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal credential: %w", err)
+	}
+	return Canonicalize(raw)
+}
+
+// LeafHash returns the leaf hash of the credential as fed to MerkleService.AddLeaf
+func LeafHash(credential []byte) ([]byte, error) {
+	data, err := LeafData(credential)
+	if err != nil {
+		return nil, err
+	}
+	return utils.Hash(data), nil
+}
+
+// Anchor adds the credential's leaf hash to the active tree of the issuer
+func Anchor(ctx context.Context, merkle interfaces.Merkle, issuerDID string, credential []byte) (*entities.MerkleNode, error) {
+	leaf, err := LeafHash(credential)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute leaf hash: %w", err)
+	}
+
+	node, err := merkle.AddLeaf(ctx, issuerDID, leaf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add leaf: %w", err)
+	}
+	return node, nil
+}
+
+// BuildProof builds the proof of a node against the root of its tree synced to the chain of the options
+func BuildProof(ctx context.Context, merkle interfaces.Merkle, treeID, nodeID int, opts ProofOptions) (*MerkleProof, error) {
+	root, err := merkle.GetSyncedRoot(ctx, treeID, opts.ChainID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get synced root: %w", err)
+	}
+
+	path, err := merkle.GetSyncedProof(ctx, treeID, nodeID, opts.ChainID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get synced proof: %w", err)
+	}
+
+	proof := &MerkleProof{
+		Type:               ProofType,
+		Created:            time.Now().UTC().Format(time.RFC3339),
+		ProofPurpose:       ProofPurpose,
+		VerificationMethod: opts.IssuerDID,
+		MerkleRoot:         hexutil.Encode(root),
+		ProofPath:          encodePath(path),
+		TreeIndex:          treeID,
+		LeafIndex:          nodeID,
+		ChainID:            opts.ChainID,
+	}
+
+	// Trees synced in a checkpoint are only anchored through its super-root
+	checkpoint, err := merkle.GetCheckpointProof(ctx, treeID, opts.ChainID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get checkpoint proof: %w", err)
+	}
+	if checkpoint != nil {
+		proof.Checkpoint = &CheckpointProof{
+			CheckpointIndex: checkpoint.CheckpointID,
+			IssuerAddress:   checkpoint.IssuerAddress,
+			MerkleRoot:      hexutil.Encode(checkpoint.Root),
+			ProofPath:       encodePath(checkpoint.Path),
+		}
+	}
+
+	if opts.ContractAddress != (common.Address{}) {
+		proof.ContractAddress = opts.ContractAddress.Hex()
+	}
+	if opts.IssuerAddress != (common.Address{}) {
+		proof.IssuerAddress = opts.IssuerAddress.Hex()
+	}
+
+	return proof, nil
+}
+
+// AttachProof adds the Merkle proof to the credential, replacing any previous Merkle proof
+// and keeping the other proofs (e.g. signatures) next to it
+func AttachProof(credential []byte, proof *MerkleProof) ([]byte, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(credential, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode credential: %w", err)
+	}
+
+	rawProof, err := json.Marshal(proof)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal proof: %w", err)
+	}
+
+	existing, err := otherProofs(doc["proof"])
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) == 0 {
+		doc["proof"] = rawProof
+	} else {
+		doc["proof"], err = json.Marshal(append(existing, rawProof))
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal proofs: %w", err)
+		}
+	}
+
+	return json.Marshal(doc)
+}
+
+// ExtractProof returns the Merkle proof embedded in the credential
+func ExtractProof(credential []byte) (*MerkleProof, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(credential, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode credential: %w", err)
+	}
+
+	proofs, err := splitProofs(doc["proof"])
+	if err != nil {
+		return nil, err
+	}
+	for _, raw := range proofs {
+		var proof MerkleProof
+		if err := json.Unmarshal(raw, &proof); err != nil {
+			continue // not a Merkle proof
+		}
+		if proof.Type == ProofType {
+			return &proof, nil
+		}
+	}
+	return nil, ErrNoMerkleProof
+}
+
+// helper function to get the proofs which are not Merkle proofs
+func otherProofs(raw json.RawMessage) ([]json.RawMessage, error) {
+	proofs, err := splitProofs(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	others := make([]json.RawMessage, 0, len(proofs))
+	for _, p := range proofs {
+		var header struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(p, &header); err == nil && header.Type == ProofType {
+			continue
+		}
+		others = append(others, p)
+	}
+	return others, nil
+}
+
+// helper function to normalize the proof property which can be a single object or a set of proofs
+func splitProofs(raw json.RawMessage) ([]json.RawMessage, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	if raw[0] == '[' {
+		var proofs []json.RawMessage
+		if err := json.Unmarshal(raw, &proofs); err != nil {
+			return nil, fmt.Errorf("failed to decode proofs: %w", err)
+		}
+		return proofs, nil
+	}
+	return []json.RawMessage{raw}, nil
+}
+
+// Path decodes the proof path into raw sibling hashes
+func (p *MerkleProof) Path() ([][]byte, error) {
+	return decodePath(p.ProofPath)
+}
+
+// Root decodes the Merkle root of the proof
+func (p *MerkleProof) Root() ([]byte, error) {
+	root, err := hexutil.Decode(p.MerkleRoot)
+	if err != nil {
+		return nil, fmt.Errorf("invalid merkle root: %w", err)
+	}
+	return root, nil
+}
+
+// Path decodes the checkpoint proof path, which starts after the key of the tree
+func (c *CheckpointProof) Path() ([][]byte, error) {
+	return decodePath(c.ProofPath)
+}
+
+// Root decodes the super-root of the checkpoint
+func (c *CheckpointProof) Root() ([]byte, error) {
+	root, err := hexutil.Decode(c.MerkleRoot)
+	if err != nil {
+		return nil, fmt.Errorf("invalid checkpoint root: %w", err)
+	}
+	return root, nil
+}
+
+func encodePath(path [][]byte) []string {
+	encoded := make([]string, len(path))
+	for i, p := range path {
+		encoded[i] = hexutil.Encode(p)
+	}
+	return encoded
+}
+
+func decodePath(encoded []string) ([][]byte, error) {
+	path := make([][]byte, len(encoded))
+	for i, s := range encoded {
+		b, err := hexutil.Decode(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proof path element %d: %w", i, err)
+		}
+		path[i] = b
+	}
+	return path, nil
+}
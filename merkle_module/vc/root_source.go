@@ -0,0 +1,120 @@
+package vc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"merkle_module/app/interfaces"
+	"merkle_module/merkletree"
+	credential "merkle_module/smartcontract"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ErrTreeMissing is returned by a root source when the tree has no anchored root
+var ErrTreeMissing = errors.New("tree has no anchored root")
+
+// RootSource returns the trusted root a proof must match, or ErrTreeMissing when none is anchored.
+// The root of a proof with a checkpoint is the super-root of the checkpoint.
+type RootSource interface {
+	Root(ctx context.Context, proof *MerkleProof) ([]byte, error)
+}
+
+// TrustedRoot is a root obtained out of band, it is trusted for any tree
+type TrustedRoot []byte
+
+func (t TrustedRoot) Root(ctx context.Context, proof *MerkleProof) ([]byte, error) {
+	if len(t) == 0 {
+		return nil, ErrTreeMissing
+	}
+	return t, nil
+}
+
+// SyncedRootSource trusts the roots the Merkle service synced to the chain of the proof, and the
+// super-roots of the checkpoints it confirmed there
+type SyncedRootSource struct {
+	Merkle interfaces.Merkle
+}
+
+func (s *SyncedRootSource) Root(ctx context.Context, proof *MerkleProof) ([]byte, error) {
+	if proof.Checkpoint != nil {
+		root, err := s.Merkle.GetCheckpointRoot(ctx, proof.Checkpoint.CheckpointIndex, proof.ChainID)
+		if err != nil {
+			return nil, err
+		}
+		if len(root) == 0 {
+			return nil, ErrTreeMissing
+		}
+		return root, nil
+	}
+
+	root, err := s.Merkle.GetSyncedRoot(ctx, proof.TreeIndex, proof.ChainID)
+	if err != nil {
+		return nil, err
+	}
+	// A tree none of whose leaves are synced has the root of the empty tree
+	if len(root) == 0 || bytes.Equal(root, merkletree.EmptyNode(1)) {
+		return nil, ErrTreeMissing
+	}
+	return root, nil
+}
+
+// ContractRootSource reads the root with getTreeRoot of the NDACredential contract, optionally
+// pinned to a block
+type ContractRootSource struct {
+	backend     bind.ContractCaller
+	contract    common.Address // used when the proof has no contract address
+	blockNumber *big.Int
+	chainID     uint64 // when set, proofs anchored on another chain are rejected
+}
+
+func NewContractRootSource(backend bind.ContractCaller, contract common.Address, blockNumber *big.Int) *ContractRootSource {
+	return &ContractRootSource{backend: backend, contract: contract, blockNumber: blockNumber}
+}
+
+// SetChainID sets the chain the backend is connected to
+func (s *ContractRootSource) SetChainID(chainID uint64) {
+	s.chainID = chainID
+}
+
+func (s *ContractRootSource) Root(ctx context.Context, proof *MerkleProof) ([]byte, error) {
+	if s.chainID != 0 && proof.ChainID != 0 && proof.ChainID != s.chainID {
+		return nil, fmt.Errorf("proof is anchored on chain %d, the backend is connected to chain %d", proof.ChainID, s.chainID)
+	}
+	if !common.IsHexAddress(proof.IssuerAddress) {
+		return nil, fmt.Errorf("proof has no valid issuer address: %q", proof.IssuerAddress)
+	}
+	address := s.contract
+	if address == (common.Address{}) {
+		if !common.IsHexAddress(proof.ContractAddress) {
+			return nil, fmt.Errorf("proof has no valid contract address: %q", proof.ContractAddress)
+		}
+		address = common.HexToAddress(proof.ContractAddress)
+	}
+
+	// A checkpoint is anchored as tree checkpointIndex of its own issuer
+	issuer, index := proof.IssuerAddress, proof.TreeIndex
+	if proof.Checkpoint != nil {
+		if !common.IsHexAddress(proof.Checkpoint.IssuerAddress) {
+			return nil, fmt.Errorf("checkpoint has no valid issuer address: %q", proof.Checkpoint.IssuerAddress)
+		}
+		issuer, index = proof.Checkpoint.IssuerAddress, proof.Checkpoint.CheckpointIndex
+	}
+
+	caller, err := credential.NewCredentialCaller(address, s.backend)
+	if err != nil {
+		return nil, fmt.Errorf("failed to bind contract: %w", err)
+	}
+	root, err := caller.GetTreeRoot(&bind.CallOpts{Context: ctx, BlockNumber: s.blockNumber}, common.HexToAddress(issuer), big.NewInt(int64(index)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tree root: %w", err)
+	}
+	if root == ([32]byte{}) {
+		return nil, ErrTreeMissing
+	}
+	return root[:], nil
+}
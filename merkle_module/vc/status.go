@@ -0,0 +1,81 @@
+package vc
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"merkle_module/domain/entities"
+)
+
+const StatusEntryType = "BitstringStatusListEntry"
+
+// StatusListEntry is the credentialStatus entry pointing to a Bitstring Status List credential
+type StatusListEntry struct {
+	ID                   string `json:"id,omitempty"`
+	Type                 string `json:"type"`
+	StatusPurpose        string `json:"statusPurpose"`
+	StatusListIndex      string `json:"statusListIndex"`
+	StatusListCredential string `json:"statusListCredential"`
+}
+
+// NewStatusListEntry builds the credentialStatus entry of an allocated status index,
+// statusListCredential is the URL the status list credential is served from
+func NewStatusListEntry(entry *entities.StatusEntry, statusListCredential string) *StatusListEntry {
+	index := strconv.Itoa(entry.Index)
+	return &StatusListEntry{
+		ID:                   statusListCredential + "#" + index,
+		Type:                 StatusEntryType,
+		StatusPurpose:        entry.Purpose,
+		StatusListIndex:      index,
+		StatusListCredential: statusListCredential,
+	}
+}
+
+// AttachStatus sets the credentialStatus of the credential, it must be called before the credential is anchored
+func AttachStatus(credential []byte, status *StatusListEntry) ([]byte, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(credential, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode credential: %w", err)
+	}
+
+	rawStatus, err := json.Marshal(status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal credential status: %w", err)
+	}
+	doc["credentialStatus"] = rawStatus
+
+	return json.Marshal(doc)
+}
+
+// ExtractStatus returns the Bitstring Status List entry of the credential, or nil if there is none
+func ExtractStatus(credential []byte) (*StatusListEntry, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(credential, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode credential: %w", err)
+	}
+
+	entries, err := splitProofs(doc["credentialStatus"])
+	if err != nil {
+		return nil, err
+	}
+	for _, raw := range entries {
+		var entry StatusListEntry
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			continue
+		}
+		if entry.Type == StatusEntryType {
+			return &entry, nil
+		}
+	}
+	return nil, nil
+}
+
+// Index parses the status list index of the entry
+func (e *StatusListEntry) Index() (int, error) {
+	index, err := strconv.Atoi(e.StatusListIndex)
+	if err != nil {
+		return 0, fmt.Errorf("invalid status list index %q: %w", e.StatusListIndex, err)
+	}
+	return index, nil
+}
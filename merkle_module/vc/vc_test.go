@@ -0,0 +1,198 @@
+package vc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"merkle_module/domain/entities"
+	"merkle_module/merkletree"
+)
+
+func TestCanonicalize(t *testing.T) {
+	// example from RFC 8785 section 3.2.2
+	input := `{
+		"numbers": [333333333.33333329, 1E30, 4.50, 2e-3, 0.000000000000000000000000001],
+		"string": "\u20ac$\u000F\u000aA'\u0042\u0022\u005c\\\"\/",
+		"literals": [null, true, false]
+	}`
+	expected := `{"literals":[null,true,false],"numbers":[333333333.3333333,1e+30,4.5,0.002,1e-27],"string":"€$\u000f\nA'B\"\\\\\"/"}`
+
+	output, err := Canonicalize([]byte(input))
+	if err != nil {
+		t.Fatalf("Failed to canonicalize: %v", err)
+	}
+	if string(output) != expected {
+		t.Errorf("Unexpected canonical form:\n got: %s\nwant: %s", output, expected)
+	}
+}
+
+func TestCanonicalizeSortsByUTF16(t *testing.T) {
+	// U+1F600 is encoded as a surrogate pair (0xD83D...) which sorts before U+FB33
+	output, err := Canonicalize([]byte("{\"\uFB33\":1,\"\U0001F600\":2,\"a\":3}"))
+	if err != nil {
+		t.Fatalf("Failed to canonicalize: %v", err)
+	}
+	expected := "{\"a\":3,\"\U0001F600\":2,\"\uFB33\":1}"
+	if string(output) != expected {
+		t.Errorf("Unexpected key order: got %s, want %s", output, expected)
+	}
+}
+
+// fakeMerkle serves synced proofs from a single in-memory tree
+type fakeMerkle struct {
+	tree   *merkletree.MerkleTree
+	leaves [][]byte
+}
+
+func (f *fakeMerkle) AddLeaf(ctx context.Context, issuerDID string, hashValue []byte) (*entities.MerkleNode, error) {
+	nodeID := f.tree.AddLeaf(hashValue)
+	f.leaves = append(f.leaves, hashValue)
+	return &entities.MerkleNode{TreeID: f.tree.GetTreeID(), NodeID: nodeID, Data: hashValue}, nil
+}
+
+func (f *fakeMerkle) GetProof(ctx context.Context, treeID, nodeID int) ([][]byte, error) {
+	return f.tree.GetProof(nodeID)
+}
+
+func (f *fakeMerkle) GetRoot(ctx context.Context, treeID int) ([]byte, error) {
+	return f.tree.GetMerkleRoot(), nil
+}
+
+func (f *fakeMerkle) GetRootAt(ctx context.Context, treeID, size int) ([]byte, error) {
+	tree, err := merkletree.NewMerkleTree(f.leaves[:size], treeID)
+	if err != nil {
+		return nil, err
+	}
+	return tree.GetMerkleRoot(), nil
+}
+
+func (f *fakeMerkle) GetProofAt(ctx context.Context, treeID, nodeID, size int) ([][]byte, error) {
+	tree, err := merkletree.NewMerkleTree(f.leaves[:size], treeID)
+	if err != nil {
+		return nil, err
+	}
+	return tree.GetProof(nodeID)
+}
+
+func (f *fakeMerkle) GetSyncedProof(ctx context.Context, treeID, nodeID int, chainID uint64) ([][]byte, error) {
+	return f.tree.GetProof(nodeID)
+}
+
+func (f *fakeMerkle) GetSyncedRoot(ctx context.Context, treeID int, chainID uint64) ([]byte, error) {
+	return f.tree.GetMerkleRoot(), nil
+}
+
+func (f *fakeMerkle) GetCheckpointProof(ctx context.Context, treeID int, chainID uint64) (*entities.CheckpointProof, error) {
+	return nil, nil
+}
+
+func (f *fakeMerkle) GetCheckpointRoot(ctx context.Context, checkpointID int, chainID uint64) ([]byte, error) {
+	return nil, nil
+}
+
+func TestAnchorAndVerify(t *testing.T) {
+	ctx := context.Background()
+	tree, err := merkletree.NewMerkleTree(nil, 7)
+	if err != nil {
+		t.Fatalf("Failed to create Merkle Tree: %v", err)
+	}
+	merkle := &fakeMerkle{tree: tree}
+
+	var credentials [][]byte
+	var nodes []*entities.MerkleNode
+	for i := 0; i < 3; i++ {
+		credential := []byte(fmt.Sprintf(`{
+			"@context": ["https://www.w3.org/ns/credentials/v2"],
+			"type": ["VerifiableCredential"],
+			"issuer": "did:example:issuer",
+			"credentialSubject": {"id": "did:example:holder-%d", "score": %d.0}
+		}`, i, i))
+		node, err := Anchor(ctx, merkle, "did:example:issuer", credential)
+		if err != nil {
+			t.Fatalf("Failed to anchor credential %d: %v", i, err)
+		}
+		credentials = append(credentials, credential)
+		nodes = append(nodes, node)
+	}
+
+	source := &SyncedRootSource{Merkle: merkle}
+	for i, credential := range credentials {
+		proof, err := BuildProof(ctx, merkle, nodes[i].TreeID, nodes[i].NodeID, ProofOptions{IssuerDID: "did:example:issuer"})
+		if err != nil {
+			t.Fatalf("Failed to build proof for credential %d: %v", i, err)
+		}
+
+		secured, err := AttachProof(credential, proof)
+		if err != nil {
+			t.Fatalf("Failed to attach proof: %v", err)
+		}
+
+		// the leaf hash must not change once the proof is attached
+		before, _ := LeafHash(credential)
+		after, _ := LeafHash(secured)
+		if string(before) != string(after) {
+			t.Errorf("Leaf hash changed after attaching proof for credential %d", i)
+		}
+
+		// the embedded proof leads from the leaf hash to the synced root
+		embedded, err := ExtractProof(secured)
+		if err != nil {
+			t.Fatalf("Failed to extract proof: %v", err)
+		}
+		path, _ := embedded.Path()
+		root, _ := embedded.Root()
+		trusted, err := source.Root(ctx, embedded)
+		if err != nil {
+			t.Fatalf("Failed to get synced root: %v", err)
+		}
+		if !bytes.Equal(merkletree.RootFromProof(after, path), root) || !bytes.Equal(root, trusted) {
+			t.Errorf("Expected the proof of credential %d to lead to the synced root %x", i, trusted)
+		}
+	}
+}
+
+func TestSyncedRootSourceMissingTree(t *testing.T) {
+	tree, err := merkletree.NewMerkleTree(nil, 7)
+	if err != nil {
+		t.Fatalf("Failed to create Merkle Tree: %v", err)
+	}
+	source := &SyncedRootSource{Merkle: &fakeMerkle{tree: tree}}
+	if _, err := source.Root(context.Background(), &MerkleProof{TreeIndex: 7}); !errors.Is(err, ErrTreeMissing) {
+		t.Errorf("Expected ErrTreeMissing for a tree without synced leaves, got %v", err)
+	}
+	if _, err := TrustedRoot(nil).Root(context.Background(), &MerkleProof{}); !errors.Is(err, ErrTreeMissing) {
+		t.Errorf("Expected ErrTreeMissing for an empty trusted root, got %v", err)
+	}
+}
+
+func TestAttachProofKeepsOtherProofs(t *testing.T) {
+	credential := []byte(`{"type":["VerifiableCredential"],"proof":{"type":"DataIntegrityProof","proofValue":"z123"}}`)
+	secured, err := AttachProof(credential, &MerkleProof{Type: ProofType, MerkleRoot: "0x01"})
+	if err != nil {
+		t.Fatalf("Failed to attach proof: %v", err)
+	}
+
+	proofs, err := otherProofs(mustProofProperty(t, secured))
+	if err != nil {
+		t.Fatalf("Failed to read proofs: %v", err)
+	}
+	if len(proofs) != 1 {
+		t.Errorf("Expected the signature proof to be kept, got %d other proofs", len(proofs))
+	}
+	if _, err := ExtractProof(secured); err != nil {
+		t.Errorf("Failed to extract merkle proof: %v", err)
+	}
+}
+
+func mustProofProperty(t *testing.T, credential []byte) json.RawMessage {
+	t.Helper()
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(credential, &doc); err != nil {
+		t.Fatalf("Failed to decode credential: %v", err)
+	}
+	return doc["proof"]
+}
@@ -0,0 +1,78 @@
+package verifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"merkle_module/statuslist"
+	"merkle_module/vc"
+)
+
+// maxStatusListSize bounds a fetched status list credential
+const maxStatusListSize = 4 << 20
+
+// FetchFunc returns the status list credential served at the URL
+type FetchFunc func(ctx context.Context, url string) ([]byte, error)
+
+// HTTPFetch fetches the status list credential with the default HTTP client
+func HTTPFetch(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: unexpected status %s", url, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxStatusListSize))
+}
+
+// StatusListChecker reads the bit of a credential from its Bitstring Status List credential
+type StatusListChecker struct {
+	fetch FetchFunc
+}
+
+// NewStatusListChecker creates a checker, a nil fetch uses HTTPFetch
+func NewStatusListChecker(fetch FetchFunc) *StatusListChecker {
+	if fetch == nil {
+		fetch = HTTPFetch
+	}
+	return &StatusListChecker{fetch: fetch}
+}
+
+func (s *StatusListChecker) IsRevoked(ctx context.Context, entry *vc.StatusListEntry) (bool, error) {
+	index, err := entry.Index()
+	if err != nil {
+		return false, err
+	}
+
+	document, err := s.fetch(ctx, entry.StatusListCredential)
+	if err != nil {
+		return false, err
+	}
+	var statusList struct {
+		CredentialSubject struct {
+			StatusPurpose string `json:"statusPurpose"`
+			EncodedList   string `json:"encodedList"`
+		} `json:"credentialSubject"`
+	}
+	if err := json.Unmarshal(document, &statusList); err != nil {
+		return false, fmt.Errorf("failed to decode status list credential: %w", err)
+	}
+	if purpose := statusList.CredentialSubject.StatusPurpose; purpose != "" && purpose != entry.StatusPurpose {
+		return false, fmt.Errorf("status list purpose is %q, the entry expects %q", purpose, entry.StatusPurpose)
+	}
+
+	bits, err := statuslist.Decode(statusList.CredentialSubject.EncodedList)
+	if err != nil {
+		return false, fmt.Errorf("failed to decode status list: %w", err)
+	}
+	return bits.Get(index)
+}
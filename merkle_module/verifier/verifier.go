@@ -0,0 +1,216 @@
+package verifier
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"merkle_module/merkletree"
+	"merkle_module/vc"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+type Verdict string
+
+const (
+	VerdictValid        Verdict = "valid"         // the proof leads to the trusted root
+	VerdictInvalidProof Verdict = "invalid_proof" // the proof does not lead to the root of the envelope
+	VerdictRootMismatch Verdict = "root_mismatch" // the root differs from the trusted root
+	VerdictTreeMissing  Verdict = "tree_missing"  // no root has been anchored for the tree
+	VerdictRevoked      Verdict = "revoked"       // the proof is valid but the credential has been revoked
+)
+
+// Result is the structured outcome of a verification
+type Result struct {
+	Verdict      Verdict `json:"verdict"`
+	Leaf         string  `json:"leaf"`
+	ComputedRoot string  `json:"computedRoot"`
+	TrustedRoot  string  `json:"trustedRoot,omitempty"`
+	TreeIndex    int     `json:"treeIndex"`
+	LeafIndex    int     `json:"leafIndex"`
+	Issuer       string  `json:"issuer,omitempty"`
+	ChainID      uint64  `json:"chainId,omitempty"`
+	Contract     string  `json:"contract,omitempty"`
+	Reason       string  `json:"reason,omitempty"`
+	// Set for two-level proofs, the computed and trusted roots are then those of the checkpoint
+	TreeRoot        string `json:"treeRoot,omitempty"`
+	CheckpointIndex int    `json:"checkpointIndex,omitempty"`
+}
+
+func (r *Result) Valid() bool {
+	return r.Verdict == VerdictValid
+}
+
+// StatusChecker reports whether the credential of a status list entry has been revoked
+type StatusChecker interface {
+	IsRevoked(ctx context.Context, entry *vc.StatusListEntry) (bool, error)
+}
+
+// Verifier checks proof envelopes against the roots of a vc.RootSource: the roots synced by the
+// Merkle service, those anchored in the contract or a root trusted out of band
+type Verifier struct {
+	roots  vc.RootSource
+	status StatusChecker
+}
+
+// New creates a verifier, a nil status checker skips the revocation check
+func New(roots vc.RootSource, status StatusChecker) *Verifier {
+	return &Verifier{roots: roots, status: status}
+}
+
+// AnchoredProof returns the single sorted-pair proof of a leaf against the root anchored on chain,
+// with the issuer and tree index it is anchored under: those of the envelope, or for a two-level
+// proof those of the checkpoint, the proof then chaining the tree proof, the tree key and the
+// checkpoint proof. verifyVC of the contract accepts it as is.
+func AnchoredProof(envelope *vc.MerkleProof) (common.Address, int, [][]byte, error) {
+	path, err := envelope.Path()
+	if err != nil {
+		return common.Address{}, 0, nil, err
+	}
+	if envelope.Checkpoint == nil {
+		return common.HexToAddress(envelope.IssuerAddress), envelope.TreeIndex, path, nil
+	}
+
+	if !common.IsHexAddress(envelope.IssuerAddress) {
+		return common.Address{}, 0, nil, fmt.Errorf("proof has no valid issuer address: %q", envelope.IssuerAddress)
+	}
+	if !common.IsHexAddress(envelope.Checkpoint.IssuerAddress) {
+		return common.Address{}, 0, nil, fmt.Errorf("checkpoint has no valid issuer address: %q", envelope.Checkpoint.IssuerAddress)
+	}
+	checkpointPath, err := envelope.Checkpoint.Path()
+	if err != nil {
+		return common.Address{}, 0, nil, err
+	}
+	// The key is derived from the envelope so the tree root can not be claimed for another tree
+	path = append(path, merkletree.TreeKey(common.HexToAddress(envelope.IssuerAddress), envelope.TreeIndex))
+	path = append(path, checkpointPath...)
+	return common.HexToAddress(envelope.Checkpoint.IssuerAddress), envelope.Checkpoint.CheckpointIndex, path, nil
+}
+
+// VerifyLeaf checks the proof envelope of a leaf hash, the value the credential was anchored with
+func (v *Verifier) VerifyLeaf(ctx context.Context, leaf []byte, envelope *vc.MerkleProof) (*Result, error) {
+	result := &Result{
+		Leaf:      hexutil.Encode(leaf),
+		TreeIndex: envelope.TreeIndex,
+		LeafIndex: envelope.LeafIndex,
+		Issuer:    envelope.IssuerAddress,
+		ChainID:   envelope.ChainID,
+		Contract:  envelope.ContractAddress,
+	}
+
+	path, err := envelope.Path()
+	if err != nil {
+		return nil, err
+	}
+	computed := merkletree.RootFromProof(leaf, path)
+	result.ComputedRoot = hexutil.Encode(computed)
+
+	if envelope.MerkleRoot != "" {
+		root, err := envelope.Root()
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(root, computed) {
+			result.Verdict = VerdictInvalidProof
+			result.Reason = fmt.Sprintf("proof leads to %s, the envelope states %s", result.ComputedRoot, envelope.MerkleRoot)
+			return result, nil
+		}
+	}
+
+	// A two-level proof goes on from the tree root to the super-root of the checkpoint,
+	// which is the root anchored on chain
+	anchor := envelope
+	if envelope.Checkpoint != nil {
+		issuer, index, anchoredPath, err := AnchoredProof(envelope)
+		if err != nil {
+			return nil, err
+		}
+		result.TreeRoot = result.ComputedRoot
+		result.CheckpointIndex = index
+		computed = merkletree.RootFromProof(leaf, anchoredPath)
+		result.ComputedRoot = hexutil.Encode(computed)
+
+		if envelope.Checkpoint.MerkleRoot != "" {
+			root, err := envelope.Checkpoint.Root()
+			if err != nil {
+				return nil, err
+			}
+			if !bytes.Equal(root, computed) {
+				result.Verdict = VerdictInvalidProof
+				result.Reason = fmt.Sprintf("checkpoint proof leads to %s, the envelope states %s", result.ComputedRoot, envelope.Checkpoint.MerkleRoot)
+				return result, nil
+			}
+		}
+
+		anchor = &vc.MerkleProof{
+			TreeIndex:       index,
+			ChainID:         envelope.ChainID,
+			ContractAddress: envelope.ContractAddress,
+			IssuerAddress:   issuer.Hex(),
+			Checkpoint:      &vc.CheckpointProof{CheckpointIndex: index, IssuerAddress: issuer.Hex()},
+		}
+	}
+
+	trusted, err := v.roots.Root(ctx, anchor)
+	if errors.Is(err, vc.ErrTreeMissing) {
+		result.Verdict = VerdictTreeMissing
+		result.Reason = fmt.Sprintf("no root anchored for tree %d of issuer %s", anchor.TreeIndex, anchor.IssuerAddress)
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	result.TrustedRoot = hexutil.Encode(trusted)
+
+	if !bytes.Equal(trusted, computed) {
+		result.Verdict = VerdictRootMismatch
+		result.Reason = "the computed root differs from the trusted root"
+		return result, nil
+	}
+
+	result.Verdict = VerdictValid
+	return result, nil
+}
+
+// VerifyCredential checks the MerkleProof2025 proof embedded in the credential, and its revocation
+// status when the credential has a status list entry
+func (v *Verifier) VerifyCredential(ctx context.Context, document []byte) (*Result, error) {
+	envelope, err := vc.ExtractProof(document)
+	if err != nil {
+		return nil, err
+	}
+	return v.VerifyCredentialWithProof(ctx, document, envelope)
+}
+
+// VerifyCredentialWithProof checks a credential against a proof envelope kept apart from it
+func (v *Verifier) VerifyCredentialWithProof(ctx context.Context, document []byte, envelope *vc.MerkleProof) (*Result, error) {
+	leaf, err := vc.LeafHash(document)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute leaf hash: %w", err)
+	}
+
+	result, err := v.VerifyLeaf(ctx, leaf, envelope)
+	if err != nil || !result.Valid() || v.status == nil {
+		return result, err
+	}
+
+	entry, err := vc.ExtractStatus(document)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil || entry.StatusPurpose != "revocation" {
+		return result, nil
+	}
+	revoked, err := v.status.IsRevoked(ctx, entry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check revocation status: %w", err)
+	}
+	if revoked {
+		result.Verdict = VerdictRevoked
+		result.Reason = fmt.Sprintf("revoked in %s at index %s", entry.StatusListCredential, entry.StatusListIndex)
+	}
+	return result, nil
+}
@@ -0,0 +1,405 @@
+package verifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"merkle_module/app/interfaces"
+	"merkle_module/merkletree"
+	credential "merkle_module/smartcontract"
+	"merkle_module/statuslist"
+	"merkle_module/utils"
+	"merkle_module/vc"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient/simulated"
+)
+
+const statusListURL = "https://issuer.example/status/1"
+
+func testCredential(i int, status bool) []byte {
+	credential := fmt.Sprintf(`{
+		"@context": ["https://www.w3.org/ns/credentials/v2"],
+		"type": ["VerifiableCredential"],
+		"issuer": "did:example:issuer",
+		"credentialSubject": {"id": "did:example:holder-%d"}`, i)
+	if status {
+		credential += fmt.Sprintf(`,
+		"credentialStatus": {"type": "BitstringStatusListEntry", "statusPurpose": "revocation", "statusListIndex": "%d", "statusListCredential": %q}`, i, statusListURL)
+	}
+	return []byte(credential + "}")
+}
+
+// anchor adds the credentials to a tree and returns it with the proof envelope of each credential
+func anchor(t *testing.T, treeID int, issuer common.Address, credentials [][]byte) (*merkletree.MerkleTree, []*vc.MerkleProof) {
+	t.Helper()
+	tree, err := merkletree.NewMerkleTree(nil, treeID)
+	if err != nil {
+		t.Fatalf("Failed to create Merkle Tree: %v", err)
+	}
+	positions := make([]int, len(credentials))
+	for i, credential := range credentials {
+		leaf, err := vc.LeafHash(credential)
+		if err != nil {
+			t.Fatalf("Failed to hash credential %d: %v", i, err)
+		}
+		positions[i] = tree.AddLeaf(leaf)
+	}
+
+	envelopes := make([]*vc.MerkleProof, len(credentials))
+	for i, pos := range positions {
+		path, err := tree.GetProof(pos)
+		if err != nil {
+			t.Fatalf("Failed to get proof of leaf %d: %v", pos, err)
+		}
+		envelope := &vc.MerkleProof{
+			Type:          vc.ProofType,
+			ProofPurpose:  vc.ProofPurpose,
+			MerkleRoot:    hexutil.Encode(tree.GetMerkleRoot()),
+			TreeIndex:     treeID,
+			LeafIndex:     pos,
+			IssuerAddress: issuer.Hex(),
+		}
+		for _, p := range path {
+			envelope.ProofPath = append(envelope.ProofPath, hexutil.Encode(p))
+		}
+		envelopes[i] = envelope
+	}
+	return tree, envelopes
+}
+
+func TestVerifyTrustedRoot(t *testing.T) {
+	ctx := context.Background()
+	issuer := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	credentials := [][]byte{testCredential(0, false), testCredential(1, false), testCredential(2, false)}
+	tree, envelopes := anchor(t, 3, issuer, credentials)
+
+	verifier := New(vc.TrustedRoot(tree.GetMerkleRoot()), nil)
+	for i, credential := range credentials {
+		result, err := verifier.VerifyCredentialWithProof(ctx, credential, envelopes[i])
+		if err != nil {
+			t.Fatalf("Failed to verify credential %d: %v", i, err)
+		}
+		if result.Verdict != VerdictValid {
+			t.Errorf("Expected credential %d to be valid, got %s (%s)", i, result.Verdict, result.Reason)
+		}
+	}
+
+	// a proof of another credential does not lead to the stated root
+	result, err := verifier.VerifyCredentialWithProof(ctx, credentials[1], envelopes[0])
+	if err != nil {
+		t.Fatalf("Failed to verify credential: %v", err)
+	}
+	if result.Verdict != VerdictInvalidProof {
+		t.Errorf("Expected %s for a foreign proof, got %s", VerdictInvalidProof, result.Verdict)
+	}
+
+	// the proof is consistent but the root is not the trusted one
+	stale := New(vc.TrustedRoot(crypto.Keccak256([]byte("stale"))), nil)
+	result, err = stale.VerifyCredentialWithProof(ctx, credentials[0], envelopes[0])
+	if err != nil {
+		t.Fatalf("Failed to verify credential: %v", err)
+	}
+	if result.Verdict != VerdictRootMismatch {
+		t.Errorf("Expected %s against a stale root, got %s", VerdictRootMismatch, result.Verdict)
+	}
+
+	// the embedded proof is used when there is no separate envelope
+	secured, err := vc.AttachProof(credentials[2], envelopes[2])
+	if err != nil {
+		t.Fatalf("Failed to attach proof: %v", err)
+	}
+	if result, err = verifier.VerifyCredential(ctx, secured); err != nil || !result.Valid() {
+		t.Errorf("Expected the secured credential to be valid, got %v, %v", result, err)
+	}
+}
+
+// syncedRoots serves the synced roots of a Merkle service by tree ID
+type syncedRoots struct {
+	interfaces.Merkle
+	roots map[int][]byte
+}
+
+func (s syncedRoots) GetSyncedRoot(ctx context.Context, treeID int, chainID uint64) ([]byte, error) {
+	if root, exists := s.roots[treeID]; exists {
+		return root, nil
+	}
+	// the root of the tree rebuilt from no synced node
+	return merkletree.EmptyNode(1), nil
+}
+
+func TestVerifySyncedRoot(t *testing.T) {
+	ctx := context.Background()
+	issuer := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	credentials := [][]byte{testCredential(0, false), testCredential(1, false)}
+	tree, envelopes := anchor(t, 6, issuer, credentials)
+	merkle := syncedRoots{roots: map[int][]byte{6: tree.GetMerkleRoot()}}
+
+	verifier := New(&vc.SyncedRootSource{Merkle: merkle}, nil)
+	for i, credential := range credentials {
+		if result, err := verifier.VerifyCredentialWithProof(ctx, credential, envelopes[i]); err != nil || result.Verdict != VerdictValid {
+			t.Errorf("Expected credential %d to be valid, got %v and error %v", i, result, err)
+		}
+	}
+	if result, err := verifier.VerifyCredentialWithProof(ctx, credentials[1], envelopes[0]); err != nil || result.Verdict != VerdictInvalidProof {
+		t.Errorf("Expected %s for a tampered credential, got %v and error %v", VerdictInvalidProof, result, err)
+	}
+
+	// a root which is no longer the synced one is rejected
+	tree.AddLeaf(utils.Hash([]byte("another leaf")))
+	merkle.roots[6] = tree.GetMerkleRoot()
+	if result, err := verifier.VerifyCredentialWithProof(ctx, credentials[0], envelopes[0]); err != nil || result.Verdict != VerdictRootMismatch {
+		t.Errorf("Expected %s after the root changed, got %v and error %v", VerdictRootMismatch, result, err)
+	}
+
+	// a tree none of whose leaves are synced is missing, like a tree without root on chain
+	delete(merkle.roots, 6)
+	if result, err := verifier.VerifyCredentialWithProof(ctx, credentials[0], envelopes[0]); err != nil || result.Verdict != VerdictTreeMissing {
+		t.Errorf("Expected %s before the tree is synced, got %v and error %v", VerdictTreeMissing, result, err)
+	}
+}
+
+type testChain struct {
+	sim      *simulated.Backend
+	auth     *bind.TransactOpts
+	chainID  *big.Int
+	address  common.Address
+	contract *credential.Credential
+}
+
+// newTestChain deploys the contract on a simulated backend
+func newTestChain(t *testing.T) *testChain {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	balance, _ := new(big.Int).SetString("1000000000000000000000", 10)
+	sim := simulated.NewBackend(types.GenesisAlloc{from: {Balance: balance}})
+	t.Cleanup(func() { sim.Close() })
+
+	chainID, err := sim.Client().ChainID(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get chain ID: %v", err)
+	}
+	auth, err := bind.NewKeyedTransactorWithChainID(key, chainID)
+	if err != nil {
+		t.Fatalf("Failed to create transactor: %v", err)
+	}
+	address, _, contract, err := credential.DeployCredential(auth, sim.Client())
+	if err != nil {
+		t.Fatalf("Failed to deploy contract: %v", err)
+	}
+	sim.Commit()
+
+	return &testChain{sim: sim, auth: auth, chainID: chainID, address: address, contract: contract}
+}
+
+func (c *testChain) updateTreeRoot(t *testing.T, issuer common.Address, treeIndex int, root []byte) {
+	t.Helper()
+	if _, err := c.contract.UpdateTreeRoot(c.auth, issuer, big.NewInt(int64(treeIndex)), utils.ToByte32(root)); err != nil {
+		t.Fatalf("Failed to update tree root: %v", err)
+	}
+	c.sim.Commit()
+}
+
+func TestVerifyContractRoot(t *testing.T) {
+	ctx := context.Background()
+	chain := newTestChain(t)
+	sim, address, chainID, from := chain.sim, chain.address, chain.chainID, chain.auth.From
+
+	credentials := [][]byte{testCredential(0, false), testCredential(1, false)}
+	tree, envelopes := anchor(t, 4, from, credentials)
+	for _, envelope := range envelopes {
+		envelope.ContractAddress = address.Hex()
+	}
+
+	// the contract address comes from the envelope
+	verifier := New(vc.NewContractRootSource(sim.Client(), common.Address{}, nil), nil)
+	result, err := verifier.VerifyCredentialWithProof(ctx, credentials[0], envelopes[0])
+	if err != nil {
+		t.Fatalf("Failed to verify credential: %v", err)
+	}
+	if result.Verdict != VerdictTreeMissing {
+		t.Errorf("Expected %s before the root is anchored, got %s", VerdictTreeMissing, result.Verdict)
+	}
+
+	chain.updateTreeRoot(t, from, 4, tree.GetMerkleRoot())
+
+	for i, credential := range credentials {
+		result, err := verifier.VerifyCredentialWithProof(ctx, credential, envelopes[i])
+		if err != nil {
+			t.Fatalf("Failed to verify credential %d: %v", i, err)
+		}
+		if result.Verdict != VerdictValid {
+			t.Errorf("Expected credential %d to be valid, got %s (%s)", i, result.Verdict, result.Reason)
+		}
+		if result.TrustedRoot != envelopes[i].MerkleRoot {
+			t.Errorf("Expected trusted root %s, got %s", envelopes[i].MerkleRoot, result.TrustedRoot)
+		}
+	}
+
+	// an envelope anchored on another chain is rejected
+	onChain := vc.NewContractRootSource(sim.Client(), address, nil)
+	onChain.SetChainID(chainID.Uint64())
+	foreign := *envelopes[0]
+	foreign.ChainID = chainID.Uint64() + 1
+	if _, err := New(onChain, nil).VerifyCredentialWithProof(ctx, credentials[0], &foreign); err == nil {
+		t.Errorf("Expected an error for a proof anchored on chain %d", foreign.ChainID)
+	}
+
+	// pinned to the block before the update the tree is still missing
+	pinned := New(vc.NewContractRootSource(sim.Client(), address, big.NewInt(1)), nil)
+	if result, err = pinned.VerifyCredentialWithProof(ctx, credentials[1], envelopes[1]); err != nil || result.Verdict != VerdictTreeMissing {
+		t.Errorf("Expected %s at block 1, got %v, %v", VerdictTreeMissing, result, err)
+	}
+}
+
+func TestVerifyRevoked(t *testing.T) {
+	ctx := context.Background()
+	issuer := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	credentials := [][]byte{testCredential(0, true), testCredential(1, true)}
+	tree, envelopes := anchor(t, 5, issuer, credentials)
+
+	bits := statuslist.NewBitstring(1024)
+	if err := bits.Set(1, true); err != nil {
+		t.Fatalf("Failed to set status bit: %v", err)
+	}
+	encoded, err := bits.Encode()
+	if err != nil {
+		t.Fatalf("Failed to encode status list: %v", err)
+	}
+	fetch := func(ctx context.Context, url string) ([]byte, error) {
+		if url != statusListURL {
+			return nil, fmt.Errorf("unexpected url %s", url)
+		}
+		return json.Marshal(map[string]interface{}{
+			"credentialSubject": map[string]string{"statusPurpose": "revocation", "encodedList": encoded},
+		})
+	}
+
+	verifier := New(vc.TrustedRoot(tree.GetMerkleRoot()), NewStatusListChecker(fetch))
+	expected := []Verdict{VerdictValid, VerdictRevoked}
+	for i, credential := range credentials {
+		result, err := verifier.VerifyCredentialWithProof(ctx, credential, envelopes[i])
+		if err != nil {
+			t.Fatalf("Failed to verify credential %d: %v", i, err)
+		}
+		if result.Verdict != expected[i] {
+			t.Errorf("Expected credential %d to be %s, got %s", i, expected[i], result.Verdict)
+		}
+	}
+}
+
+func TestVerifyCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	chain := newTestChain(t)
+	checkpointIssuer := common.HexToAddress("0x00000000000000000000000000000000000c0de")
+	issuers := []common.Address{chain.auth.From, chain.auth.From, common.HexToAddress("0x00000000000000000000000000000000000000bb")}
+
+	// a full tree and two partial ones committed into checkpoint 1
+	var credentials [][][]byte
+	var trees []*merkletree.MerkleTree
+	var envelopes [][]*vc.MerkleProof
+	var roots []merkletree.TreeRoot
+	for i, size := range []int{32, 3, 1} {
+		var treeCredentials [][]byte
+		for j := 0; j < size; j++ {
+			treeCredentials = append(treeCredentials, testCredential(100*i+j, false))
+		}
+		tree, treeEnvelopes := anchor(t, 10+i, issuers[i], treeCredentials)
+		credentials = append(credentials, treeCredentials)
+		trees = append(trees, tree)
+		envelopes = append(envelopes, treeEnvelopes)
+		roots = append(roots, merkletree.TreeRoot{Issuer: issuers[i], TreeID: 10 + i, Root: tree.GetMerkleRoot()})
+	}
+	checkpoint, err := merkletree.NewCheckpoint(roots)
+	if err != nil {
+		t.Fatalf("Failed to create checkpoint: %v", err)
+	}
+	for i := range trees {
+		path, err := checkpoint.GetProof(i)
+		if err != nil {
+			t.Fatalf("Failed to get checkpoint proof: %v", err)
+		}
+		for _, envelope := range envelopes[i] {
+			envelope.ContractAddress = chain.address.Hex()
+			envelope.Checkpoint = &vc.CheckpointProof{
+				CheckpointIndex: 1,
+				IssuerAddress:   checkpointIssuer.Hex(),
+				MerkleRoot:      hexutil.Encode(checkpoint.Root()),
+			}
+			for _, p := range path {
+				envelope.Checkpoint.ProofPath = append(envelope.Checkpoint.ProofPath, hexutil.Encode(p))
+			}
+		}
+	}
+	chain.updateTreeRoot(t, checkpointIssuer, 1, checkpoint.Root())
+
+	verifier := New(vc.NewContractRootSource(chain.sim.Client(), common.Address{}, nil), nil)
+	for i := range trees {
+		for _, j := range []int{0, len(credentials[i]) - 1} {
+			result, err := verifier.VerifyCredentialWithProof(ctx, credentials[i][j], envelopes[i][j])
+			if err != nil {
+				t.Fatalf("Failed to verify credential %d of tree %d: %v", j, i, err)
+			}
+			if result.Verdict != VerdictValid {
+				t.Errorf("Expected credential %d of tree %d to be valid, got %s (%s)", j, i, result.Verdict, result.Reason)
+			}
+			if result.TreeRoot != envelopes[i][j].MerkleRoot || result.TrustedRoot != hexutil.Encode(checkpoint.Root()) {
+				t.Errorf("Expected tree root %s under super-root %x, got %s under %s", envelopes[i][j].MerkleRoot, checkpoint.Root(), result.TreeRoot, result.TrustedRoot)
+			}
+		}
+	}
+
+	// the chained proof is accepted by verifyVC of the deployed contract
+	caller, err := credential.NewCredentialCaller(chain.address, chain.sim.Client())
+	if err != nil {
+		t.Fatalf("Failed to bind contract: %v", err)
+	}
+	leaf, _ := vc.LeafHash(credentials[0][5])
+	issuer, index, path, err := AnchoredProof(envelopes[0][5])
+	if err != nil {
+		t.Fatalf("Failed to build anchored proof: %v", err)
+	}
+	if issuer != checkpointIssuer || index != 1 {
+		t.Errorf("Expected the proof to be anchored as tree 1 of %s, got tree %d of %s", checkpointIssuer.Hex(), index, issuer.Hex())
+	}
+	proof := make([][32]byte, len(path))
+	for i, p := range path {
+		proof[i] = utils.ToByte32(p)
+	}
+	valid, err := caller.VerifyVC(&bind.CallOpts{Context: ctx}, issuer, big.NewInt(int64(index)), utils.ToByte32(leaf), proof)
+	if err != nil {
+		t.Fatalf("Failed to call verifyVC: %v", err)
+	}
+	if !valid {
+		t.Errorf("Expected verifyVC to accept the chained proof")
+	}
+
+	// the root of a tree can not be claimed for another tree of the checkpoint
+	claimed := *envelopes[1][0]
+	claimed.TreeIndex = 12
+	result, err := verifier.VerifyCredentialWithProof(ctx, credentials[1][0], &claimed)
+	if err != nil {
+		t.Fatalf("Failed to verify credential: %v", err)
+	}
+	if result.Verdict != VerdictInvalidProof {
+		t.Errorf("Expected %s for a root claimed for another tree, got %s", VerdictInvalidProof, result.Verdict)
+	}
+
+	// a checkpoint that was never anchored
+	missing := *envelopes[2][0]
+	missing.Checkpoint = &vc.CheckpointProof{CheckpointIndex: 2, IssuerAddress: checkpointIssuer.Hex(), ProofPath: envelopes[2][0].Checkpoint.ProofPath}
+	if result, err = verifier.VerifyCredentialWithProof(ctx, credentials[2][0], &missing); err != nil || result.Verdict != VerdictTreeMissing {
+		t.Errorf("Expected %s for checkpoint 2, got %v, %v", VerdictTreeMissing, result, err)
+	}
+}